@@ -0,0 +1,36 @@
+// Command mockidp runs a fake identity provider for local testing of key rotation,
+// upstream errors, slow responses and Cache-Control handling.
+//
+//	go run ./cmd/mockidp -addr :9000 -rotate 5m -error-rate 0.1 -cache-control "max-age=300"
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/kiquetal/go-idp-caller/internal/mockidp"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	var opts mockidp.Options
+	flag.StringVar(&opts.Issuer, "issuer", "", "issuer in the discovery document (default: http://<host>)")
+	flag.StringVar(&opts.Algorithm, "alg", "RS256", "key algorithm: RS256 or ES256")
+	flag.IntVar(&opts.KeyCount, "keys", 2, "number of published keys")
+	flag.DurationVar(&opts.RotationInterval, "rotate", 0, "rotate keys every interval (0 disables)")
+	flag.DurationVar(&opts.Latency, "latency", 0, "delay before every response")
+	flag.Float64Var(&opts.ErrorRate, "error-rate", 0, "fraction of JWKS requests answered with -error-status")
+	flag.IntVar(&opts.ErrorStatus, "error-status", http.StatusServiceUnavailable, "status code for injected errors")
+	flag.StringVar(&opts.CacheControl, "cache-control", "", "Cache-Control header on JWKS responses")
+	flag.StringVar(&opts.ContentType, "content-type", "application/json", "Content-Type of JWKS responses")
+	flag.Parse()
+
+	idp, err := mockidp.New(opts)
+	if err != nil {
+		log.Fatalf("Failed to create mock IDP: %v", err)
+	}
+
+	log.Printf("Mock IDP listening on %s (alg=%s keys=%d rotate=%s)", *addr, opts.Algorithm, opts.KeyCount, opts.RotationInterval)
+	log.Fatal(http.ListenAndServe(*addr, idp))
+}
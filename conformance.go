@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// errSkip marks a check that doesn't apply to the instance under test
+var errSkip = errors.New("skipped")
+
+func skipf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errSkip, fmt.Sprintf(format, args...))
+}
+
+// conformanceCheck is one black-box check against a running instance
+type conformanceCheck struct {
+	name string
+	run  func(c *conformanceClient) error
+}
+
+// conformanceResult is the outcome of one check
+type conformanceResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // pass, fail or skip
+	DurationMs int64  `json:"duration_ms"`
+	Message    string `json:"message,omitempty"`
+}
+
+var conformanceChecks = []conformanceCheck{
+	{"health", checkHealth},
+	{"merged_jwks", checkMergedJWKS},
+	{"merged_cache_headers", checkCacheHeaders},
+	{"head_request", checkHead},
+	{"method_not_allowed", checkMethodNotAllowed},
+	{"unknown_idp", checkUnknownIDP},
+	{"conditional_request", checkConditional},
+	{"per_idp_jwks", checkPerIDP},
+	{"merged_consistency", checkMergedConsistency},
+}
+
+// runConformance runs the conformance checks against a running instance and reports the results.
+// Exit codes: 0 all checks passed or were skipped, 1 at least one failed, 2 invalid usage.
+func runConformance(args []string) int {
+	fs := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	baseURL := fs.String("url", "http://localhost:8080", "base URL of the instance under test")
+	timeout := fs.Duration("timeout", 10*time.Second, "per-request timeout")
+	format := fs.String("format", "text", "report format: text, json or junit")
+	output := fs.String("output", "", "write the report to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" && *format != "junit" {
+		fmt.Fprintf(os.Stderr, "conformance: unknown format %q\n", *format)
+		return 2
+	}
+	base, err := url.Parse(strings.TrimRight(*baseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		fmt.Fprintf(os.Stderr, "conformance: invalid url %q\n", *baseURL)
+		return 2
+	}
+
+	client := &conformanceClient{base: base.String(), http: &http.Client{Timeout: *timeout}}
+	start := time.Now()
+	results := make([]conformanceResult, 0, len(conformanceChecks))
+	failed := 0
+	for _, check := range conformanceChecks {
+		checkStart := time.Now()
+		err := check.run(client)
+		r := conformanceResult{Name: check.name, Status: "pass", DurationMs: time.Since(checkStart).Milliseconds()}
+		switch {
+		case errors.Is(err, errSkip):
+			r.Status = "skip"
+			r.Message = strings.TrimPrefix(err.Error(), errSkip.Error()+": ")
+		case err != nil:
+			r.Status = "fail"
+			r.Message = err.Error()
+			failed++
+		}
+		results = append(results, r)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "conformance: %v\n", err)
+			return 2
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]any{
+			"url":     client.base,
+			"ok":      failed == 0,
+			"failed":  failed,
+			"results": results,
+		})
+	case "junit":
+		writeJUnit(out, results, time.Since(start))
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHECK\tRESULT\tDURATION\tMESSAGE")
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%dms\t%s\n", r.Name, strings.ToUpper(r.Status), r.DurationMs, r.Message)
+		}
+		tw.Flush()
+		fmt.Fprintf(out, "\n%d/%d checks ok against %s\n", len(results)-failed, len(results), client.base)
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// writeJUnit writes the results as a JUnit XML report for CI systems
+func writeJUnit(w io.Writer, results []conformanceResult, elapsed time.Duration) {
+	type message struct {
+		Message string `xml:"message,attr"`
+	}
+	type testcase struct {
+		Name      string   `xml:"name,attr"`
+		Classname string   `xml:"classname,attr"`
+		Time      string   `xml:"time,attr"`
+		Failure   *message `xml:"failure,omitempty"`
+		Skipped   *message `xml:"skipped,omitempty"`
+	}
+	type testsuite struct {
+		XMLName  xml.Name   `xml:"testsuite"`
+		Name     string     `xml:"name,attr"`
+		Tests    int        `xml:"tests,attr"`
+		Failures int        `xml:"failures,attr"`
+		Skipped  int        `xml:"skipped,attr"`
+		Time     string     `xml:"time,attr"`
+		Cases    []testcase `xml:"testcase"`
+	}
+
+	suite := testsuite{Name: "idp-caller conformance", Tests: len(results), Time: seconds(elapsed)}
+	for _, r := range results {
+		tc := testcase{Name: r.Name, Classname: "conformance", Time: seconds(time.Duration(r.DurationMs) * time.Millisecond)}
+		switch r.Status {
+		case "fail":
+			tc.Failure = &message{Message: r.Message}
+			suite.Failures++
+		case "skip":
+			tc.Skipped = &message{Message: r.Message}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(suite)
+	io.WriteString(w, "\n")
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// conformanceClient issues requests against the instance under test
+type conformanceClient struct {
+	base string
+	http *http.Client
+}
+
+func (c *conformanceClient) do(method, path string, header http.Header) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// getJSON requests path, requiring a 200 JSON response decoded into v
+func (c *conformanceClient) getJSON(path string, v any) (*http.Response, error) {
+	resp, body, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("GET %s: status %d, want 200", path, resp.StatusCode)
+	}
+	if err := checkJSONContentType(resp); err != nil {
+		return resp, fmt.Errorf("GET %s: %w", path, err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return resp, fmt.Errorf("GET %s: invalid JSON: %w", path, err)
+	}
+	return resp, nil
+}
+
+func checkJSONContentType(resp *http.Response) error {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return fmt.Errorf("content type %q is not JSON", resp.Header.Get("Content-Type"))
+	}
+	return nil
+}
+
+// keySet is a JWKS decoded without dropping unknown members
+type keySet struct {
+	Keys []map[string]any `json:"keys"`
+}
+
+func (k *keySet) validate() error {
+	if k.Keys == nil {
+		return fmt.Errorf("no \"keys\" array")
+	}
+	for i, key := range k.Keys {
+		if kty, _ := key["kty"].(string); kty == "" {
+			return fmt.Errorf("key %d has no kty", i)
+		}
+	}
+	return nil
+}
+
+func checkHealth(c *conformanceClient) error {
+	var health map[string]any
+	_, err := c.getJSON("/health", &health)
+	return err
+}
+
+func checkMergedJWKS(c *conformanceClient) error {
+	var merged keySet
+	resp, err := c.getJSON("/.well-known/jwks.json", &merged)
+	if err != nil {
+		return err
+	}
+	if err := merged.validate(); err != nil {
+		return err
+	}
+	if total := resp.Header.Get("X-Total-Keys"); total != strconv.Itoa(len(merged.Keys)) {
+		return fmt.Errorf("X-Total-Keys is %q, response has %d keys", total, len(merged.Keys))
+	}
+	return nil
+}
+
+var maxAgePattern = regexp.MustCompile(`(?i)(^|[,\s])max-age=(\d+)`)
+
+func checkCacheHeaders(c *conformanceClient) error {
+	resp, _, err := c.do(http.MethodGet, "/.well-known/jwks.json", nil)
+	if err != nil {
+		return err
+	}
+	cacheControl := resp.Header.Get("Cache-Control")
+	if !strings.Contains(strings.ToLower(cacheControl), "public") || !maxAgePattern.MatchString(cacheControl) {
+		return fmt.Errorf("Cache-Control %q lacks public and max-age", cacheControl)
+	}
+	if _, err := strconv.Atoi(resp.Header.Get("Age")); err != nil {
+		return fmt.Errorf("Age %q is not a number of seconds", resp.Header.Get("Age"))
+	}
+	if _, err := http.ParseTime(resp.Header.Get("Expires")); err != nil {
+		return fmt.Errorf("Expires %q is not an HTTP date", resp.Header.Get("Expires"))
+	}
+	return nil
+}
+
+func checkHead(c *conformanceClient) error {
+	resp, body, err := c.do(http.MethodHead, "/.well-known/jwks.json", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HEAD: status %d, want 200", resp.StatusCode)
+	}
+	if len(body) > 0 {
+		return fmt.Errorf("HEAD returned a %d byte body", len(body))
+	}
+	return checkJSONContentType(resp)
+}
+
+func checkMethodNotAllowed(c *conformanceClient) error {
+	resp, _, err := c.do(http.MethodPost, "/.well-known/jwks.json", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("POST: status %d, want 405", resp.StatusCode)
+	}
+	if !strings.Contains(resp.Header.Get("Allow"), http.MethodGet) {
+		return fmt.Errorf("Allow %q doesn't include GET", resp.Header.Get("Allow"))
+	}
+	return nil
+}
+
+func checkUnknownIDP(c *conformanceClient) error {
+	resp, _, err := c.do(http.MethodGet, "/jwks/conformance-unknown-idp", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("status %d, want 404", resp.StatusCode)
+	}
+	return nil
+}
+
+func checkConditional(c *conformanceClient) error {
+	resp, _, err := c.do(http.MethodGet, "/.well-known/jwks.json", nil)
+	if err != nil {
+		return err
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return skipf("no ETag served")
+	}
+	resp, body, err := c.do(http.MethodGet, "/.well-known/jwks.json", http.Header{"If-None-Match": {etag}})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		return fmt.Errorf("If-None-Match %s: status %d, want 304", etag, resp.StatusCode)
+	}
+	if len(body) > 0 {
+		return fmt.Errorf("304 response has a %d byte body", len(body))
+	}
+	return nil
+}
+
+// allKeySets returns the key set of every IDP from GET /jwks
+func (c *conformanceClient) allKeySets() (map[string]keySet, error) {
+	var all map[string]keySet
+	if _, err := c.getJSON("/jwks", &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func checkPerIDP(c *conformanceClient) error {
+	all, err := c.allKeySets()
+	if err != nil {
+		return err
+	}
+	if len(all) == 0 {
+		return skipf("no IDP has keys")
+	}
+	for name, want := range all {
+		var got keySet
+		if _, err := c.getJSON("/jwks/"+url.PathEscape(name), &got); err != nil {
+			return err
+		}
+		if err := got.validate(); err != nil {
+			return fmt.Errorf("IDP %q: %w", name, err)
+		}
+		if len(got.Keys) != len(want.Keys) {
+			return fmt.Errorf("IDP %q: /jwks/%s has %d keys, /jwks has %d", name, name, len(got.Keys), len(want.Keys))
+		}
+	}
+	return nil
+}
+
+// checkMergedConsistency verifies that the merged set only contains keys of configured IDPs,
+// and all of them unless duplicates were removed
+func checkMergedConsistency(c *conformanceClient) error {
+	all, err := c.allKeySets()
+	if err != nil {
+		return err
+	}
+	var merged keySet
+	if _, err := c.getJSON("/.well-known/jwks.json", &merged); err != nil {
+		return err
+	}
+
+	var union []map[string]any
+	for _, ks := range all {
+		union = append(union, ks.Keys...)
+	}
+	for _, key := range merged.Keys {
+		if !containsKey(union, key) {
+			return fmt.Errorf("merged key %v is not published by any IDP", key["kid"])
+		}
+	}
+	if len(merged.Keys) == len(union) {
+		for _, key := range union {
+			if !containsKey(merged.Keys, key) {
+				return fmt.Errorf("key %v of an IDP is missing from the merged set", key["kid"])
+			}
+		}
+	} else if len(merged.Keys) > len(union) {
+		return fmt.Errorf("merged set has %d keys, IDPs publish %d", len(merged.Keys), len(union))
+	}
+	return nil
+}
+
+func containsKey(keys []map[string]any, key map[string]any) bool {
+	for _, k := range keys {
+		if reflect.DeepEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// maxDiffSourceBytes bounds a key set read by diff
+const maxDiffSourceBytes = 5 << 20
+
+// keySource is a key set as read, with every key's parameters kept as published, unknown ones included
+type keySource struct {
+	keySet *jwks.JWKS
+	params map[string]map[string]json.RawMessage // by kid, the first key with the kid
+	kids   []string                              // in published order
+}
+
+// jwksDiff is the difference between two key sets, from A to B
+type jwksDiff struct {
+	A         string    `json:"a"`
+	B         string    `json:"b"`
+	Identical bool      `json:"identical"`
+	Added     []string  `json:"added"`   // kids only in B
+	Removed   []string  `json:"removed"` // kids only in A
+	Changed   []keyDiff `json:"changed"` // kids in both with different parameters
+	Reordered bool      `json:"reordered"`
+}
+
+// keyDiff lists the parameters of a key that differ
+type keyDiff struct {
+	Kid    string      `json:"kid"`
+	Params []paramDiff `json:"params"`
+}
+
+// paramDiff is a parameter's value in A and in B, absent where the key doesn't have it
+type paramDiff struct {
+	Name string          `json:"name"`
+	A    json.RawMessage `json:"a,omitempty"`
+	B    json.RawMessage `json:"b,omitempty"`
+}
+
+// runDiff compares the key sets of two sources, each a URL or a file ("-" for stdin).
+// Exit codes: 0 identical, 1 different, 2 invalid usage or a source that can't be read.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "text", "report format: text or json")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for fetching a URL")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: idp-caller diff [-format text|json] [-timeout 10s] <urlA|fileA> <urlB|fileB>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "diff: unknown format %q, formats are text, json\n", *format)
+		return 2
+	}
+
+	sources := make([]*keySource, 2)
+	for i, src := range fs.Args() {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		ks, err := readKeySource(ctx, src)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "diff: %s: %v\n", src, err)
+			return 2
+		}
+		sources[i] = ks
+	}
+
+	d := diffKeySets(sources[0], sources[1])
+	d.A, d.B = fs.Arg(0), fs.Arg(1)
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d)
+	default:
+		printDiff(os.Stdout, d)
+	}
+	if !d.Identical {
+		return 1
+	}
+	return 0
+}
+
+// readKeySource reads a key set from an http(s) URL, a file or stdin
+func readKeySource(ctx context.Context, src string) (*keySource, error) {
+	var body []byte
+	var err error
+	switch {
+	case strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://"):
+		body, err = fetchKeySource(ctx, src)
+	case src == "-":
+		body, err = io.ReadAll(io.LimitReader(os.Stdin, maxDiffSourceBytes+1))
+	default:
+		body, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDiffSourceBytes {
+		return nil, fmt.Errorf("key set exceeds %d bytes", maxDiffSourceBytes)
+	}
+
+	var raw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	ks := &keySource{keySet: &jwks.JWKS{}, params: make(map[string]map[string]json.RawMessage)}
+	for i, rawKey := range raw.Keys {
+		var key jwks.JWK
+		var params map[string]json.RawMessage
+		if err := json.Unmarshal(rawKey, &key); err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		if err := json.Unmarshal(rawKey, &params); err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		ks.keySet.Keys = append(ks.keySet.Keys, key)
+		ks.kids = append(ks.kids, key.Kid)
+		if _, dup := ks.params[key.Kid]; !dup {
+			ks.params[key.Kid] = params
+		}
+	}
+	return ks, nil
+}
+
+func fetchKeySource(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDiffSourceBytes+1))
+}
+
+// diffKeySets compares the keys of a and b by kid, and their parameters as published
+func diffKeySets(a, b *keySource) jwksDiff {
+	d := jwksDiff{Added: []string{}, Removed: []string{}, Changed: []keyDiff{}}
+	added, removed := jwks.DiffKids(a.keySet, b.keySet)
+	d.Added = append(d.Added, added...)
+	d.Removed = append(d.Removed, removed...)
+
+	var common []string
+	for _, kid := range a.kids {
+		pb, ok := b.params[kid]
+		if !ok || slices.Contains(common, kid) {
+			continue
+		}
+		common = append(common, kid)
+		if params := diffParams(a.params[kid], pb); len(params) > 0 {
+			d.Changed = append(d.Changed, keyDiff{Kid: kid, Params: params})
+		}
+	}
+
+	// Same keys in another order, which matters to clients picking the first matching key
+	var orderB []string
+	for _, kid := range b.kids {
+		if slices.Contains(common, kid) && !slices.Contains(orderB, kid) {
+			orderB = append(orderB, kid)
+		}
+	}
+	d.Reordered = !slices.Equal(common, orderB)
+
+	d.Identical = len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && !d.Reordered &&
+		len(a.kids) == len(b.kids)
+	return d
+}
+
+// diffParams returns the parameters whose values differ, in name order. Values are compared as
+// compacted JSON, so formatting doesn't count.
+func diffParams(a, b map[string]json.RawMessage) []paramDiff {
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var diffs []paramDiff
+	for _, name := range names {
+		va, vb := compactJSON(a[name]), compactJSON(b[name])
+		if !bytes.Equal(va, vb) {
+			diffs = append(diffs, paramDiff{Name: name, A: va, B: vb})
+		}
+	}
+	return diffs
+}
+
+func compactJSON(v json.RawMessage) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, v) != nil {
+		return v
+	}
+	return buf.Bytes()
+}
+
+func printDiff(w io.Writer, d jwksDiff) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, kid := range d.Removed {
+		fmt.Fprintf(tw, "-\t%s\tonly in %s\n", displayKid(kid), d.A)
+	}
+	for _, kid := range d.Added {
+		fmt.Fprintf(tw, "+\t%s\tonly in %s\n", displayKid(kid), d.B)
+	}
+	for _, k := range d.Changed {
+		fmt.Fprintf(tw, "~\t%s\t%d parameters differ\n", displayKid(k.Kid), len(k.Params))
+		for _, p := range k.Params {
+			fmt.Fprintf(tw, "\t  %s:\t%s -> %s\n", p.Name, displayValue(p.A), displayValue(p.B))
+		}
+	}
+	tw.Flush()
+	if d.Reordered {
+		fmt.Fprintln(w, "keys are published in a different order")
+	}
+
+	if d.Identical {
+		fmt.Fprintln(w, "key sets are identical")
+		return
+	}
+	fmt.Fprintf(w, "\n%d added, %d removed, %d changed\n", len(d.Added), len(d.Removed), len(d.Changed))
+}
+
+func displayKid(kid string) string {
+	if kid == "" {
+		return "(no kid)"
+	}
+	return kid
+}
+
+// displayValue shortens long values such as RSA moduli for the text report
+func displayValue(v json.RawMessage) string {
+	if v == nil {
+		return "(absent)"
+	}
+	if s := string(v); len(s) > 48 {
+		return s[:40] + "…" + s[len(s)-6:]
+	}
+	return string(v)
+}
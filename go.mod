@@ -1,5 +1,10 @@
 module github.com/kiquetal/go-idp-caller
 
-go 1.24
+go 1.24.0
 
 require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	golang.org/x/crypto v0.42.0
+	golang.org/x/sys v0.36.0 // indirect
+)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// runHealthcheck calls the local health endpoint, for Docker HEALTHCHECK in images without curl.
+// Exits 0 when healthy and 1 otherwise, including invalid usage: Docker reserves exit code 2.
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "configuration used to find the listener when -url is not set")
+	baseURL := fs.String("url", "", "base URL of the instance (default: first listener serving health routes)")
+	ready := fs.Bool("ready", false, "check /ready instead of /health")
+	timeout := fs.Duration("timeout", 3*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	base := strings.TrimRight(*baseURL, "/")
+	if base == "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "healthcheck: failed to load configuration: %v\n", err)
+			return 1
+		}
+		base, err = localURL(cfg.Server.GetListeners(), client)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+			return 1
+		}
+	}
+
+	path := "/health"
+	if *ready {
+		path = "/ready"
+	}
+	resp, err := client.Get(base + path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "healthcheck: %s returned %d: %s\n", path, resp.StatusCode, strings.TrimSpace(string(body)))
+		return 1
+	}
+	fmt.Println(strings.TrimSpace(string(body)))
+	return 0
+}
+
+// localURL returns the base URL of the first listener serving the health routes, reached over loopback.
+// Unix socket listeners are dialed through client's transport; TLS certificates are not verified,
+// they are issued for public names rather than the loopback address.
+func localURL(listeners []config.ListenerConfig, client *http.Client) (string, error) {
+	for _, lc := range listeners {
+		if !slices.Contains(lc.GetRoutes(), config.RoutesHealth) {
+			continue
+		}
+
+		scheme := "http"
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if lc.TLS != nil {
+			scheme = "https"
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+
+		addr := strings.TrimPrefix(lc.Listen, "tcp://")
+		switch {
+		case strings.HasPrefix(addr, "unix:"):
+			path := strings.TrimPrefix(addr, "unix:")
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			}
+			client.Transport = transport
+			return scheme + "://localhost", nil
+		case strings.HasPrefix(addr, "systemd"):
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", fmt.Errorf("listener %q: %w", lc.GetName(), err)
+		}
+		switch host {
+		case "", "0.0.0.0":
+			host = "127.0.0.1"
+		case "::":
+			host = "::1"
+		}
+		client.Transport = transport
+		return scheme + "://" + net.JoinHostPort(host, port), nil
+	}
+	return "", fmt.Errorf("no TCP or unix listener serves the health routes, set -url")
+}
@@ -0,0 +1,182 @@
+// Package alert notifies Slack and PagerDuty when an IDP keeps failing, goes stale or is served differently
+// through its vantages, and again on recovery
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// notifyTimeout bounds one notification to one notifier
+const notifyTimeout = 10 * time.Second
+
+// Alert is the state of one IDP as sent to notifiers
+type Alert struct {
+	IDP         string
+	Firing      bool   // false when the IDP recovered
+	Reason      string // why the alert fires, empty on recovery
+	Failures    int
+	LastError   string
+	LastSuccess time.Time // zero when the IDP never succeeded
+	Time        time.Time
+}
+
+// Notifier delivers alerts to one destination
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, a Alert) error
+}
+
+// idpState tracks what was last notified for an IDP
+type idpState struct {
+	firing   bool      // last notified state
+	notified time.Time // when it was notified
+}
+
+// Alerter periodically evaluates every IDP against the thresholds.
+// Notifications are sent only when the state differs from the last notified state,
+// and at most once per min_interval per IDP, so flapping IDPs don't flood the channel.
+type Alerter struct {
+	config    config.AlertsConfig
+	manager   *jwks.Manager
+	notifiers []Notifier
+	logger    *slog.Logger
+	started   time.Time
+	states    map[string]*idpState
+}
+
+// New creates an alerter for the configured notifiers
+func New(cfg config.AlertsConfig, manager *jwks.Manager, logger *slog.Logger) *Alerter {
+	a := &Alerter{
+		config:  cfg,
+		manager: manager,
+		logger:  logger,
+		started: time.Now(),
+		states:  make(map[string]*idpState),
+	}
+	if cfg.Slack != nil {
+		a.notifiers = append(a.notifiers, newSlackNotifier(*cfg.Slack))
+	}
+	if cfg.PagerDuty != nil {
+		a.notifiers = append(a.notifiers, newPagerDutyNotifier(*cfg.PagerDuty))
+	}
+	return a
+}
+
+// Run evaluates the IDPs every check_interval until ctx is done
+func (a *Alerter) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.config.GetCheckInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.check(ctx, now)
+		}
+	}
+}
+
+// check notifies every IDP whose state changed since it was last notified
+func (a *Alerter) check(ctx context.Context, now time.Time) {
+	all := a.manager.GetAll()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		alert := a.evaluate(all[name], now)
+
+		state, ok := a.states[name]
+		if !ok {
+			state = &idpState{}
+			a.states[name] = state
+		}
+		if alert.Firing == state.firing {
+			continue
+		}
+		if now.Sub(state.notified) < a.config.GetMinInterval() {
+			a.logger.Debug("Alert rate limited", "idp", name, "firing", alert.Firing)
+			continue
+		}
+
+		if a.notify(ctx, alert) {
+			state.firing = alert.Firing
+			state.notified = now
+		}
+	}
+}
+
+// evaluate decides whether an IDP is failing or stale
+func (a *Alerter) evaluate(data *jwks.IDPData, now time.Time) Alert {
+	alert := Alert{
+		IDP:         data.Name,
+		Failures:    data.Failures,
+		LastError:   data.LastError,
+		LastSuccess: data.LastSuccess,
+		Time:        now,
+	}
+
+	var reasons []string
+	if data.Failures >= a.config.GetFailureThreshold() {
+		reasons = append(reasons, fmt.Sprintf("%d consecutive failed fetches", data.Failures))
+	}
+	if staleAfter := a.config.GetStaleAfter(); staleAfter > 0 {
+		// IDPs that never succeeded count from startup
+		since := data.LastSuccess
+		if since.IsZero() {
+			since = a.started
+		}
+		if age := now.Sub(since); age >= staleAfter {
+			reasons = append(reasons, fmt.Sprintf("no successful fetch for %s", age.Truncate(time.Second)))
+		}
+	}
+
+	if vantages := data.DisagreeingVantages(); len(vantages) > 0 {
+		reasons = append(reasons, fmt.Sprintf("vantages %s serve a different key set", strings.Join(vantages, ", ")))
+	}
+
+	alert.Firing = len(reasons) > 0
+	alert.Reason = strings.Join(reasons, ", ")
+	return alert
+}
+
+// notify sends the alert to every notifier, reporting whether any accepted it
+func (a *Alerter) notify(ctx context.Context, alert Alert) bool {
+	delivered := false
+	for _, n := range a.notifiers {
+		notifyCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		err := n.Notify(notifyCtx, alert)
+		cancel()
+		if err != nil {
+			a.logger.Error("Failed to send alert", "notifier", n.Name(), "idp", alert.IDP, "firing", alert.Firing, "error", err)
+			continue
+		}
+		delivered = true
+		a.logger.Info("Alert sent", "notifier", n.Name(), "idp", alert.IDP, "firing", alert.Firing, "reason", alert.Reason)
+	}
+	return delivered
+}
+
+// secret returns the file content when file is set, the inline value otherwise
+func secret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,90 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyNotifier triggers an incident per IDP and resolves it on recovery.
+// The dedup key is stable per IDP, so PagerDuty groups repeated triggers into one incident.
+type pagerDutyNotifier struct {
+	config config.PagerDutyConfig
+	client *http.Client
+	url    string
+}
+
+func newPagerDutyNotifier(cfg config.PagerDutyConfig) *pagerDutyNotifier {
+	return &pagerDutyNotifier{
+		config: cfg,
+		client: &http.Client{Timeout: notifyTimeout},
+		url:    pagerDutyEventsURL,
+	}
+}
+
+func (n *pagerDutyNotifier) Name() string {
+	return "pagerduty"
+}
+
+func (n *pagerDutyNotifier) Notify(ctx context.Context, a Alert) error {
+	routingKey, err := secret(n.config.RoutingKey, n.config.RoutingKeyFile)
+	if err != nil {
+		return err
+	}
+
+	event := map[string]any{
+		"routing_key":  routingKey,
+		"event_action": "resolve",
+		"dedup_key":    "idp-caller/" + a.IDP,
+	}
+	if a.Firing {
+		details := map[string]any{
+			"consecutive_failures": a.Failures,
+			"last_error":           a.LastError,
+		}
+		if !a.LastSuccess.IsZero() {
+			details["last_success"] = a.LastSuccess.UTC().Format(time.RFC3339)
+		}
+		event["event_action"] = "trigger"
+		event["payload"] = map[string]any{
+			"summary":        fmt.Sprintf("IDP %s is failing: %s", a.IDP, a.Reason),
+			"source":         "idp-caller",
+			"component":      a.IDP,
+			"severity":       n.config.GetSeverity(),
+			"timestamp":      a.Time.UTC().Format(time.RFC3339),
+			"custom_details": details,
+		}
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
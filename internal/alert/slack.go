@@ -0,0 +1,83 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// slackNotifier posts alerts to a Slack incoming webhook
+type slackNotifier struct {
+	config config.SlackConfig
+	client *http.Client
+}
+
+func newSlackNotifier(cfg config.SlackConfig) *slackNotifier {
+	return &slackNotifier{
+		config: cfg,
+		client: &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+func (n *slackNotifier) Name() string {
+	return "slack"
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, a Alert) error {
+	webhook, err := secret(n.config.WebhookURL, n.config.WebhookURLFile)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"text": slackText(a)})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The webhook URL is a credential, keep it out of the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// slackText renders the alert as a one-line message
+func slackText(a Alert) string {
+	if !a.Firing {
+		return fmt.Sprintf(":white_check_mark: IDP *%s* recovered", a.IDP)
+	}
+
+	text := fmt.Sprintf(":rotating_light: IDP *%s* is failing: %s", a.IDP, a.Reason)
+	if a.LastError != "" {
+		text += fmt.Sprintf("\nLast error: `%s`", a.LastError)
+	}
+	if !a.LastSuccess.IsZero() {
+		text += "\nLast success: " + a.LastSuccess.UTC().Format(time.RFC3339)
+	}
+	return text
+}
@@ -0,0 +1,173 @@
+// Package audit records administrative actions to a dedicated sink and keeps the latest for queries
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// Record is one administrative action
+type Record struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`            // "token:<fingerprint>", "<idp>:<sub>" or "signal:<name>"
+	Action   string    `json:"action"`           // e.g. token.sign, logging.reload
+	Target   string    `json:"target,omitempty"` // what the action applied to
+	Before   any       `json:"before,omitempty"`
+	After    any       `json:"after,omitempty"`
+	ClientIP string    `json:"client_ip,omitempty"`
+}
+
+// Query selects records, empty fields match everything
+type Query struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Limit  int // newest records returned, 0 for all
+}
+
+// Log appends records to the configured file and keeps the latest in memory
+type Log struct {
+	mu      sync.Mutex
+	file    *os.File // nil when records are kept in memory only
+	records []Record // oldest first, at most retain
+	retain  int
+	logger  *slog.Logger
+	stored  []func(Record)
+}
+
+// New opens the audit file and loads its latest records
+func New(cfg config.AuditConfig, logger *slog.Logger) (*Log, error) {
+	l := &Log{retain: cfg.GetRetain(), logger: logger}
+	if cfg.File == "" {
+		return l, nil
+	}
+
+	if err := l.load(cfg.File); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	l.file = f
+	return l, nil
+}
+
+// load reads the records of a previous run, skipping lines that don't parse (e.g. cut off by a crash)
+func (l *Log) load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read audit file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var rec Record
+		if json.Unmarshal(scanner.Bytes(), &rec) == nil {
+			l.keep(rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read audit file: %w", err)
+	}
+	return nil
+}
+
+// Record stores an action. A failed write is logged, the action itself has already happened.
+func (l *Log) Record(rec Record) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keep(rec)
+	for _, fn := range l.stored {
+		fn(rec)
+	}
+	if l.file == nil {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err == nil {
+		_, err = l.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		l.logger.Error("Failed to write audit record", "action", rec.Action, "actor", rec.Actor, "error", err)
+	}
+}
+
+// OnRecord registers fn to be called with every new record, in order, to store it elsewhere as well.
+// Must be called before records are added; fn runs with the log locked.
+func (l *Log) OnRecord(fn func(Record)) {
+	l.stored = append(l.stored, fn)
+}
+
+// Restore adds the records of a previous run, oldest first, without storing them again
+func (l *Log) Restore(records []Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, rec := range records {
+		l.keep(rec)
+	}
+}
+
+// Retain returns how many records are kept for queries
+func (l *Log) Retain() int {
+	return l.retain
+}
+
+// keep adds rec to the in-memory records, dropping the oldest beyond retain
+func (l *Log) keep(rec Record) {
+	l.records = append(l.records, rec)
+	if over := len(l.records) - l.retain; over > 0 {
+		l.records = slices.Delete(l.records, 0, over)
+	}
+}
+
+// Query returns the matching records, newest first
+func (l *Log) Query(q Query) []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := []Record{}
+	for i := len(l.records) - 1; i >= 0; i-- {
+		rec := l.records[i]
+		if rec.Time.Before(q.Since) {
+			break
+		}
+		if (q.Actor != "" && rec.Actor != q.Actor) || (q.Action != "" && rec.Action != q.Action) ||
+			(q.Target != "" && rec.Target != q.Target) {
+			continue
+		}
+		result = append(result, rec)
+		if q.Limit > 0 && len(result) == q.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// Close closes the audit file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
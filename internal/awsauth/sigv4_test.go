@@ -0,0 +1,114 @@
+package awsauth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Credentials, scope and date of the AWS Signature Version 4 test suite
+const (
+	suiteAccessKey = "AKIDEXAMPLE"
+	suiteSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	suiteDate      = "20150830T123600Z"
+	suiteToken     = "AQoDYXdzEPT//////////wEXAMPLEtc764bNrC9SAPBSM22wDOk4x4HIZ8j4FZTwdQWLWsKWHGBuFqwAeMicRXmxfpSPfIeoIYRqTflfKD8YUuwthAx7mSEI/qkPpKPi/kMcGdQrmGdeehM4IC1NtBmUpp2wUE8phUZampKsburEDy0KPkyQDYwT7WZ0wq5VSXDvp75YU9HFvlRd8Tx6q6fE8YQcHNVXAkiY9q6d+xo0rKwT38xVqr7ZD0u0iPPkUL64lIZbqBAz+scqKmlzm8FDrypNC9Yjc8fPOLn9FX9KSYvKTr4rvx3iSIlTJabIQwj2ICCR/oLxBA=="
+)
+
+func TestSuiteVectors(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		token       string
+		body        string
+		signed      string
+		signature   string
+	}{
+		{name: "get-vanilla", method: "GET", url: "/",
+			signed: "host;x-amz-date", signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{name: "get-vanilla-empty-query-key", method: "GET", url: "/?Param1=value1",
+			signed: "host;x-amz-date", signature: "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{name: "get-vanilla-query-order-key-case", method: "GET", url: "/?Param2=value2&Param1=value1",
+			signed: "host;x-amz-date", signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{name: "get-vanilla-query-order-key", method: "GET", url: "/?Param1=value2&Param1=Value1",
+			signed: "host;x-amz-date", signature: "eedbc4e291e521cf13422ffca22be7d2eb8146eecf653089df300a15b2382bd1"},
+		{name: "get-vanilla-query-order-value", method: "GET", url: "/?Param1=value2&Param1=value1",
+			signed: "host;x-amz-date", signature: "5772eed61e12b33fae39ee5e7012498b51d56abc0abb7c60486157bd471c4694"},
+		{name: "get-vanilla-query-unreserved", method: "GET",
+			url:    "/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			signed: "host;x-amz-date", signature: "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197"},
+		{name: "get-vanilla-utf8-query", method: "GET", url: "/?ሴ=bar",
+			signed: "host;x-amz-date", signature: "2cdec8eed098649ff3a119c94853b13c643bcf08f8b0a1d91e12c9027818dd04"},
+		{name: "get-utf8", method: "GET", url: "/ሴ",
+			signed: "host;x-amz-date", signature: "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85"},
+		{name: "post-vanilla", method: "POST", url: "/",
+			signed: "host;x-amz-date", signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{name: "post-vanilla-query", method: "POST", url: "/?Param1=value1",
+			signed: "host;x-amz-date", signature: "28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11"},
+		{name: "post-x-www-form-urlencoded", method: "POST", url: "/",
+			contentType: "application/x-www-form-urlencoded", body: "Param1=value1",
+			signed: "content-type;host;x-amz-date", signature: "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+		{name: "post-x-www-form-urlencoded-parameters", method: "POST", url: "/",
+			contentType: "application/x-www-form-urlencoded; charset=utf8", body: "Param1=value1",
+			signed: "content-type;host;x-amz-date", signature: "1a72ec8f64bd914b0e42e42607c7fbce7fb2c7465f63e3092b3b0d39fa77a6fe"},
+		{name: "post-sts-header-before", method: "POST", url: "/", token: suiteToken,
+			signed: "host;x-amz-date;x-amz-security-token", signature: "85d96828115b5dc0cfc3bd16ad9e210dd772bbebba041836c64533a82be05ead"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "https://example.amazonaws.com"+tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Amz-Date", suiteDate)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.token != "" {
+				req.Header.Set("X-Amz-Security-Token", tt.token)
+			}
+
+			got := authorization(req, sha256Hex([]byte(tt.body)), suiteAccessKey, suiteSecretKey, "us-east-1", "service")
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" +
+				tt.signed + ", Signature=" + tt.signature
+			if got != want {
+				t.Fatalf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", suiteAccessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", suiteSecretKey)
+	t.Setenv("AWS_SESSION_TOKEN", suiteToken)
+	body := []byte(`{"KeyId":"alias/jwks"}`)
+	req, err := http.NewRequest("POST", "https://kms.eu-west-1.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.GetPublicKey")
+
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	if err := Sign(req, body, "eu-west-1", "kms", now); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("X-Amz-Date") != suiteDate || req.Header.Get("X-Amz-Security-Token") != suiteToken ||
+		req.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+		t.Fatalf("signing headers not set: %v", req.Header)
+	}
+	auth := req.Header.Get("Authorization")
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/eu-west-1/kms/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token;x-amz-target, Signature="
+	if !strings.HasPrefix(auth, want) {
+		t.Fatalf("Authorization %s", auth)
+	}
+
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if err := Sign(req, body, "eu-west-1", "kms", now); err == nil {
+		t.Fatal("signed without a secret key")
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Admin roles, each includes the permissions of the roles before it
+const (
+	RoleViewer   = "viewer"   // read configuration, audit records and diagnostics
+	RoleOperator = "operator" // operational actions: refreshing, staging and draining IDPs
+	RoleAdmin    = "admin"    // everything, including minting tokens
+
+	// RoleClient only obtains access tokens from POST /token/{idp}. It is outside the ladder above,
+	// so services fetching tokens see no admin routes and viewers can't fetch tokens.
+	RoleClient = "client"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ValidRole reports whether role is one of the admin roles
+func ValidRole(role string) bool {
+	return roleRank[role] > 0 || role == RoleClient
+}
+
+// RoleAllows reports whether role includes the permissions of required
+func RoleAllows(role, required string) bool {
+	if required == RoleClient {
+		return role == RoleClient || role == RoleAdmin
+	}
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[required]
+}
+
+// AdminConfig protects administrative endpoints
+type AdminConfig struct {
+	TokenFile string          `yaml:"token_file"` // bearer tokens, one per line, re-read on every request
+	JWT       *AdminJWTConfig `yaml:"jwt"`        // also accept JWTs issued by configured IDPs
+	Audit     AuditConfig     `yaml:"audit"`
+
+	// TombstoneRetention is how long IDPs removed by PUT /admin/state can be restored, in seconds (default: 86400, -1 disables)
+	TombstoneRetention int `yaml:"tombstone_retention"`
+}
+
+// AuditConfig is where records of admin actions go, they are always kept in memory for GET /audit/admin
+type AuditConfig struct {
+	File   string `yaml:"file"`   // JSON lines appended per action, reloaded on startup (default: memory only)
+	Retain int    `yaml:"retain"` // records kept for queries (default: 1000)
+}
+
+// AdminJWTConfig accepts admin requests carrying a JWT of one of our IDPs, verified with the cached keys
+type AdminJWTConfig struct {
+	IDPs       []string `yaml:"idps"`        // IDPs whose tokens are accepted, each needs an issuer
+	Audiences  []string `yaml:"audiences"`   // aud must contain one of these, on top of the IDP's own validation settings
+	Roles      []string `yaml:"roles"`       // the token must carry one of these roles
+	RolesClaim string   `yaml:"roles_claim"` // claim holding the roles, dotted for nested claims (default: roles)
+	Scopes     []string `yaml:"scopes"`      // the token must carry one of these scopes in scope or scp
+
+	// RoleMapping maps admin roles to roles or scopes of the token, the highest match wins (default: admin for every token)
+	RoleMapping map[string][]string `yaml:"role_mapping"`
+}
+
+// Enabled reports whether admin requests can authenticate at all
+func (c *AdminConfig) Enabled() bool {
+	return c.TokenFile != "" || c.JWT != nil
+}
+
+// GetRolesClaim returns the claim holding the roles with a default of "roles"
+func (c *AdminJWTConfig) GetRolesClaim() string {
+	if c.RolesClaim == "" {
+		return "roles"
+	}
+	return c.RolesClaim
+}
+
+// GetTombstoneRetention returns how long removed IDPs are kept with a default of 24 hours, 0 when disabled
+func (c *AdminConfig) GetTombstoneRetention() time.Duration {
+	if c.TombstoneRetention < 0 {
+		return 0
+	}
+	if c.TombstoneRetention == 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(c.TombstoneRetention) * time.Second
+}
+
+// GetRetain returns how many audit records are kept with a default of 1000
+func (c *AuditConfig) GetRetain() int {
+	if c.Retain <= 0 {
+		return 1000
+	}
+	return c.Retain
+}
+
+func (c *AdminConfig) validate(idps []IDPConfig) error {
+	if c.Audit.Retain < 0 {
+		return fmt.Errorf("audit: retain must not be negative")
+	}
+	if c.TombstoneRetention < -1 {
+		return fmt.Errorf("tombstone_retention must be -1 or more")
+	}
+	if c.JWT == nil {
+		return nil
+	}
+	if len(c.JWT.IDPs) == 0 {
+		return fmt.Errorf("jwt: idps is required")
+	}
+	for _, name := range c.JWT.IDPs {
+		i := slices.IndexFunc(idps, func(idp IDPConfig) bool { return idp.Name == name })
+		if i < 0 {
+			return fmt.Errorf("jwt: unknown idp %q", name)
+		}
+		if idps[i].Issuer == "" {
+			return fmt.Errorf("jwt: idp %q has no issuer, its tokens can't be validated", name)
+		}
+	}
+	for role := range c.JWT.RoleMapping {
+		if !ValidRole(role) {
+			return fmt.Errorf("jwt: unknown role %q in role_mapping (use %s, %s, %s or %s)", role, RoleViewer, RoleOperator, RoleAdmin, RoleClient)
+		}
+	}
+	// Any valid token of a shared IDP would otherwise be an admin token
+	if len(c.JWT.Audiences) == 0 && len(c.JWT.Roles) == 0 && len(c.JWT.Scopes) == 0 && len(c.JWT.RoleMapping) == 0 {
+		return fmt.Errorf("jwt: at least one of audiences, roles, scopes or role_mapping is required")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// AlertsConfig notifies Slack and/or PagerDuty when an IDP keeps failing or goes stale, and again on recovery
+type AlertsConfig struct {
+	FailureThreshold int `yaml:"failure_threshold"` // consecutive failed fetches before alerting (default: 3)
+	StaleAfter       int `yaml:"stale_after"`       // seconds without a successful fetch before alerting, 0 disables
+	CheckInterval    int `yaml:"check_interval"`    // how often IDPs are evaluated in seconds (default: 30)
+	MinInterval      int `yaml:"min_interval"`      // minimum seconds between notifications per IDP (default: 300)
+
+	Slack     *SlackConfig     `yaml:"slack"`
+	PagerDuty *PagerDutyConfig `yaml:"pagerduty"`
+}
+
+// SlackConfig posts alerts to a Slack incoming webhook
+type SlackConfig struct {
+	WebhookURL     string `yaml:"webhook_url"`
+	WebhookURLFile string `yaml:"webhook_url_file"` // read on every notification, takes precedence
+}
+
+// PagerDutyConfig triggers and resolves PagerDuty incidents through the Events API v2
+type PagerDutyConfig struct {
+	RoutingKey     string `yaml:"routing_key"`
+	RoutingKeyFile string `yaml:"routing_key_file"` // read on every notification, takes precedence
+	Severity       string `yaml:"severity"`         // critical, error (default), warning or info
+}
+
+// GetFailureThreshold returns the failure threshold with a default of 3 if not set
+func (c *AlertsConfig) GetFailureThreshold() int {
+	if c.FailureThreshold <= 0 {
+		return 3
+	}
+	return c.FailureThreshold
+}
+
+// GetStaleAfter returns the staleness threshold, 0 when disabled
+func (c *AlertsConfig) GetStaleAfter() time.Duration {
+	return time.Duration(c.StaleAfter) * time.Second
+}
+
+// GetCheckInterval returns the evaluation interval with a default of 30 seconds if not set
+func (c *AlertsConfig) GetCheckInterval() time.Duration {
+	if c.CheckInterval <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.CheckInterval) * time.Second
+}
+
+// GetMinInterval returns the per-IDP notification interval with a default of 5 minutes if not set
+func (c *AlertsConfig) GetMinInterval() time.Duration {
+	if c.MinInterval <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(c.MinInterval) * time.Second
+}
+
+// GetSeverity returns the incident severity with a default of "error"
+func (c *PagerDutyConfig) GetSeverity() string {
+	if c.Severity == "" {
+		return "error"
+	}
+	return c.Severity
+}
+
+func (c *AlertsConfig) validate() error {
+	if c.Slack == nil && c.PagerDuty == nil {
+		return fmt.Errorf("at least one of slack or pagerduty is required")
+	}
+	if c.StaleAfter < 0 {
+		return fmt.Errorf("stale_after must not be negative")
+	}
+	if c.Slack != nil && c.Slack.WebhookURL == "" && c.Slack.WebhookURLFile == "" {
+		return fmt.Errorf("slack.webhook_url or slack.webhook_url_file is required")
+	}
+	if c.PagerDuty != nil {
+		if c.PagerDuty.RoutingKey == "" && c.PagerDuty.RoutingKeyFile == "" {
+			return fmt.Errorf("pagerduty.routing_key or pagerduty.routing_key_file is required")
+		}
+		switch c.PagerDuty.GetSeverity() {
+		case "critical", "error", "warning", "info":
+		default:
+			return fmt.Errorf("pagerduty.severity must be critical, error, warning or info")
+		}
+	}
+	return nil
+}
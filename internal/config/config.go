@@ -1,28 +1,309 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/kiquetal/go-idp-caller/internal/schedule"
+	"github.com/kiquetal/go-idp-caller/internal/secrets"
 )
 
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	IDPs    []IDPConfig   `yaml:"idps"`
-	Logging LoggingConfig `yaml:"logging"`
+	Profile  string         `yaml:"profile"`  // dev (default), prod or strict
+	Features FeaturesConfig `yaml:"features"` // overrides of single profile behaviors
+	FIPS     bool           `yaml:"fips"`     // only serve and accept FIPS-approved keys and algorithms
+
+	Server  ServerConfig   `yaml:"server"`
+	IDPs    []IDPConfig    `yaml:"idps"`
+	Logging LoggingConfig  `yaml:"logging"`
+	Startup StartupConfig  `yaml:"startup"`
+	Client  ClientConfig   `yaml:"client"`
+	Signing *SigningConfig `yaml:"signing"`
+	Admin   AdminConfig    `yaml:"admin"`
+
+	Validation ValidationConfig `yaml:"validation"`
+	Publish    *PublishConfig   `yaml:"publish"`
+	Events     *EventsConfig    `yaml:"events"`
+	Alerts     *AlertsConfig    `yaml:"alerts"`
+	SLO        SLOConfig        `yaml:"slo"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	Outbound   OutboundConfig   `yaml:"outbound"`
+	Merged     MergedConfig     `yaml:"merged"`
+	Faults     *FaultsConfig    `yaml:"faults"`
+	Watchdog   *WatchdogConfig  `yaml:"watchdog"`
+	Replicas   *ReplicasConfig  `yaml:"replicas"`
+	Storage    *StorageConfig   `yaml:"storage"`
+	Recording  *RecordingConfig `yaml:"recording"`
 }
 
-type ServerConfig struct {
-	Port int    `yaml:"port"`
-	Host string `yaml:"host"`
+// ClientConfig holds defaults for outbound requests to IDPs
+type ClientConfig struct {
+	UserAgent string            `yaml:"user_agent"` // default: idp-caller/<version>
+	Headers   map[string]string `yaml:"headers"`    // static headers sent to every IDP
+
+	RequestIDHeader string `yaml:"request_id_header"` // header carrying a per-fetch correlation id (default: X-Request-ID, "-" disables)
+	Traceparent     bool   `yaml:"traceparent"`       // also send a W3C traceparent built from the correlation id
 }
 
+// Key sources an IDP can be fetched from
+const (
+	SourceHTTP       = "http"       // JWKS endpoint at url (default)
+	SourceFile       = "file"       // JWKS document at path
+	SourceExec       = "exec"       // command printing a JWKS document on stdout
+	SourceAWSKMS     = "aws-kms"    // public keys of AWS KMS signing keys
+	SourceGCPKMS     = "gcp-kms"    // public keys of GCP KMS signing key versions
+	SourceKubernetes = "kubernetes" // keys stored in labeled Secrets or ConfigMaps
+)
+
 type IDPConfig struct {
-	Name            string `yaml:"name"`
-	URL             string `yaml:"url"`
-	RefreshInterval int    `yaml:"refresh_interval"` // in seconds
-	MaxKeys         int    `yaml:"max_keys"`         // maximum keys to maintain (default: 10)
-	CacheDuration   int    `yaml:"cache_duration"`   // cache duration in seconds (default: 900)
+	Name                string            `yaml:"name"`
+	URL                 string            `yaml:"url"`
+	Type                string            `yaml:"type"`                 // preset expanding into concrete entries: azure-ad, auth0, okta, keycloak
+	Tenants             []string          `yaml:"tenants"`              // azure-ad: tenant ids or domains
+	Cloud               string            `yaml:"cloud"`                // azure-ad: public (default), usgov or china
+	TokenVersion        int               `yaml:"token_version"`        // azure-ad: access token version 1 or 2 (default: 2)
+	Issuer              string            `yaml:"issuer"`               // expected iss claim of tokens from this IDP
+	Audiences           []string          `yaml:"audiences"`            // /validate: overrides validation.audiences
+	ClockSkew           int               `yaml:"clock_skew"`           // /validate: overrides validation.clock_skew
+	RequiredClaims      []string          `yaml:"required_claims"`      // /validate: overrides validation.required_claims
+	MaxTokenAge         int               `yaml:"max_token_age"`        // /validate: overrides validation.max_token_age
+	DiscoveryURL        string            `yaml:"discovery_url"`        // OpenID configuration whose issuer must match issuer
+	Domain              string            `yaml:"domain"`               // auth0, okta, keycloak: tenant or server domain
+	Realm               string            `yaml:"realm"`                // keycloak: realm name
+	AuthorizationServer string            `yaml:"authorization_server"` // okta: custom authorization server id (default: default)
+	Source              string            `yaml:"source"`               // http (default), file, exec, aws-kms, gcp-kms or kubernetes
+	Path                string            `yaml:"path"`                 // file source: path of the JWKS document
+	Command             []string          `yaml:"command"`              // exec source: command and arguments
+	KMS                 *KMSConfig        `yaml:"kms"`                  // aws-kms and gcp-kms sources
+	Kubernetes          *KubernetesConfig `yaml:"kubernetes"`           // kubernetes source
+	RefreshInterval     int               `yaml:"refresh_interval"`     // in seconds
+	AllowFastRefresh    bool              `yaml:"allow_fast_refresh"`   // allow a refresh_interval below outbound.min_refresh_interval
+	MaxKeys             int               `yaml:"max_keys"`             // maximum keys to maintain (default: 10)
+	MaxKeyBytes         int               `yaml:"max_key_bytes"`        // maximum bytes of key material to maintain (default: 1 MiB)
+	CacheDuration       int               `yaml:"cache_duration"`       // cache duration in seconds (default: 900)
+	Schedules           []string          `yaml:"schedules"`            // cron expressions, override refresh_interval when set
+	BlackoutWindows     []WindowConfig    `yaml:"blackout_windows"`     // periods during which no fetch is made
+	Timezone            string            `yaml:"timezone"`             // timezone for schedules and windows (default: local)
+	StartJitter         int               `yaml:"start_jitter"`         // max random delay added to the first scheduled refresh, in seconds
+	RefreshJitter       int               `yaml:"refresh_jitter"`       // max random delay added to every scheduled fetch, in seconds
+	MaxResponseBytes    int64             `yaml:"max_response_bytes"`   // maximum accepted response body size (default: 5 MiB)
+	RequireSigningKey   bool              `yaml:"require_signing_key"`  // reject key sets without a use=sig key
+	AllowEmptyJWKS      bool              `yaml:"allow_empty_jwks"`     // accept an empty key set instead of keeping the previous keys
+	MaxRetryAfter       int               `yaml:"max_retry_after"`      // upper bound in seconds on an honored Retry-After (default: 3600)
+	FetchTimeout        int               `yaml:"fetch_timeout"`        // seconds a fetch may take, never past the next refresh (default: 10)
+
+	MaxRedirects            *int `yaml:"max_redirects"`              // redirects to follow (default: 10, 0 disables)
+	AllowCrossHostRedirects bool `yaml:"allow_cross_host_redirects"` // follow redirects to other hosts
+	RequireSameHost         bool `yaml:"require_same_host"`          // final URL must be on the configured host
+
+	UserAgent string            `yaml:"user_agent"` // overrides client.user_agent
+	Headers   map[string]string `yaml:"headers"`    // merged over client.headers
+
+	RequestIDHeader string `yaml:"request_id_header"` // overrides client.request_id_header
+	Traceparent     bool   `yaml:"traceparent"`       // send a W3C traceparent, also enabled by client.traceparent
+
+	ClientCredentials *ClientCredentialsConfig `yaml:"client_credentials"` // enables POST /token/{idp}
+
+	ServePath string           `yaml:"serve_path"` // extra path serving this IDP's keys, merged with IDPs sharing it
+	Transform *TransformConfig `yaml:"transform"`  // rewrites of the upstream key metadata
+	Normalize bool             `yaml:"normalize"`  // canonicalize kty, crv and base64url values, infer missing alg
+	DNS       *DNSConfig       `yaml:"dns"`        // static addresses, resolver and address family for the IDP's host
+
+	HostHeader    string `yaml:"host_header"`     // Host sent when url points at an internal VIP instead of the public hostname
+	TLSServerName string `yaml:"tls_server_name"` // SNI and certificate name (default: host of host_header)
+
+	Vantages []VantageConfig `yaml:"vantages"` // egress routes whose key set is compared with the primary fetch
+}
+
+// KMSConfig selects the KMS keys whose public keys are published
+type KMSConfig struct {
+	Region      string   `yaml:"region"`       // aws: region (default: AWS_REGION)
+	AliasPrefix string   `yaml:"alias_prefix"` // aws: publish keys with an alias starting with this
+	KeyIDs      []string `yaml:"key_ids"`      // aws: key ids or ARNs to publish
+	KeyRing     string   `yaml:"key_ring"`     // gcp: projects/{p}/locations/{l}/keyRings/{r}
+	Filter      string   `yaml:"filter"`       // gcp: cryptoKeys list filter, e.g. labels.jwks=true
+	Endpoint    string   `yaml:"endpoint"`     // override the API endpoint (VPC endpoints, emulators)
+}
+
+// KubernetesConfig selects the Secrets or ConfigMaps holding keys
+type KubernetesConfig struct {
+	Kind          string `yaml:"kind"`           // secret (default) or configmap
+	Namespace     string `yaml:"namespace"`      // default: the pod's namespace
+	LabelSelector string `yaml:"label_selector"` // e.g. app=token-signer
+}
+
+// GetKind returns the object kind with secret as default
+func (c *KubernetesConfig) GetKind() string {
+	if c.Kind == "" {
+		return "secret"
+	}
+	return c.Kind
+}
+
+// Client authentication methods at the token endpoint
+const (
+	AuthMethodBasic = "client_secret_basic"
+	AuthMethodPost  = "client_secret_post"
+)
+
+// ClientCredentialsConfig holds the credentials used for the client_credentials grant
+type ClientCredentialsConfig struct {
+	TokenURL         string   `yaml:"token_url"` // derived by presets when not set
+	ClientID         string   `yaml:"client_id"`
+	ClientSecret     string   `yaml:"client_secret"`
+	ClientSecretFile string   `yaml:"client_secret_file"` // read on every token request, takes precedence
+	Scopes           []string `yaml:"scopes"`
+	Audience         string   `yaml:"audience"`    // Auth0 style audience parameter
+	Resource         string   `yaml:"resource"`    // RFC 8707 resource indicator
+	AuthMethod       string   `yaml:"auth_method"` // client_secret_basic (default) or client_secret_post
+}
+
+// GetAuthMethod returns the client authentication method with client_secret_basic as default
+func (c *ClientCredentialsConfig) GetAuthMethod() string {
+	if c.AuthMethod == "" {
+		return AuthMethodBasic
+	}
+	return c.AuthMethod
+}
+
+func (c *ClientCredentialsConfig) validate() error {
+	if c.TokenURL == "" {
+		return fmt.Errorf("client_credentials.token_url is required")
+	}
+	if c.ClientID == "" {
+		return fmt.Errorf("client_credentials.client_id is required")
+	}
+	if c.ClientSecret == "" && c.ClientSecretFile == "" {
+		return fmt.Errorf("client_credentials.client_secret or client_secret_file is required")
+	}
+	if m := c.GetAuthMethod(); m != AuthMethodBasic && m != AuthMethodPost {
+		return fmt.Errorf("client_credentials.auth_method must be %s or %s", AuthMethodBasic, AuthMethodPost)
+	}
+	return nil
+}
+
+// WindowConfig describes a recurring daily time range
+type WindowConfig struct {
+	Start string   `yaml:"start"` // HH:MM
+	End   string   `yaml:"end"`   // HH:MM, may be earlier than start to wrap midnight
+	Days  []string `yaml:"days"`  // optional weekdays (mon or monday, tue, ...), default every day
+}
+
+// namePattern is the form of IDP names: URL-safe, usable as a path segment without escaping
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]{0,62}[A-Za-z0-9])?$`)
+
+// validateName checks that name can be used in /jwks/{idp} and similar routes
+func validateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("name %q must be 1 to 64 letters, digits, '.', '_' or '-', starting and ending with a letter or digit", name)
+	}
+	return nil
+}
+
+// reservedPaths are served by the service itself and can't be used as serve_path
+var reservedPaths = []string{
+	"/", "/.well-known/jwks.json", "/.well-known/webfinger", "/jwks", "/export",
+	"/status", "/slo", "/dashboard", "/health", "/ready", "/version",
+	"/sign", "/validate", "/validate/batch", "/debug/vars", "/debug/manager", "/admin/config", "/admin/state", "/admin/tombstones", "/audit/admin",
+	"/replicas", "/graphql",
+}
+
+// reservedPrefixes are path subtrees keyed by IDP name
+var reservedPrefixes = []string{"/jwks/", "/status/", "/slo/", "/token/", "/admin/idps/", "/audiences/"}
+
+// validateHostOverride checks host_header and tls_server_name are bare hostnames of an http source
+func (c *IDPConfig) validateHostOverride() error {
+	if c.HostHeader == "" && c.TLSServerName == "" {
+		return nil
+	}
+	if c.GetSource() != SourceHTTP {
+		return fmt.Errorf("host_header and tls_server_name only apply to source %q", SourceHTTP)
+	}
+	if h := c.HostHeader; h != "" {
+		if u, err := url.Parse("//" + h); err != nil || u.Host != h || u.User != nil {
+			return fmt.Errorf("host_header must be a host or host:port, got %q", h)
+		}
+	}
+	if name := c.TLSServerName; name != "" && strings.ContainsAny(name, ":/ ") {
+		return fmt.Errorf("tls_server_name must be a hostname without port, got %q", name)
+	}
+	return nil
+}
+
+func (c *IDPConfig) validateServePath() error {
+	p := c.ServePath
+	if p == "" {
+		return nil
+	}
+	if !strings.HasPrefix(p, "/") || path.Clean(p) != p || strings.ContainsAny(p, "{} \t?#") {
+		return fmt.Errorf("serve_path must be a clean absolute path without wildcards, got %q", p)
+	}
+	if slices.Contains(reservedPaths, p) {
+		return fmt.Errorf("serve_path %q is served by the service itself", p)
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return fmt.Errorf("serve_path %q must not be under %s", p, prefix)
+		}
+	}
+	return nil
+}
+
+// ServePaths maps each serve_path to the IDPs served on it, in configuration order
+func (c *Config) ServePaths() map[string][]string {
+	paths := make(map[string][]string)
+	for _, idp := range c.IDPs {
+		if idp.ServePath != "" {
+			paths[idp.ServePath] = append(paths[idp.ServePath], idp.Name)
+		}
+	}
+	return paths
+}
+
+// AudienceIDPs maps each accepted audience to the IDPs whose tokens carry it, in configuration order:
+// an IDP's own audiences, or validation.audiences when it has none
+func (c *Config) AudienceIDPs() map[string][]string {
+	audiences := make(map[string][]string)
+	for _, idp := range c.IDPs {
+		accepted := idp.Audiences
+		if len(accepted) == 0 {
+			accepted = c.Validation.Audiences
+		}
+		for _, aud := range accepted {
+			if !slices.Contains(audiences[aud], idp.Name) {
+				audiences[aud] = append(audiences[aud], idp.Name)
+			}
+		}
+	}
+	return audiences
+}
+
+// mergedPaths returns the paths serving keys merged from several IDPs
+func (c *Config) mergedPaths() []string {
+	paths := []string{"/.well-known/jwks.json", "/jwks"}
+	for path, idps := range c.ServePaths() {
+		if len(idps) > 1 {
+			paths = append(paths, path)
+		}
+	}
+	for aud := range c.AudienceIDPs() {
+		paths = append(paths, AudiencePath(aud))
+	}
+	return paths
+}
+
+// AudiencePath returns the path serving the keys of an audience's IDPs
+func AudiencePath(aud string) string {
+	return "/audiences/" + url.PathEscape(aud) + "/jwks.json"
 }
 
 // GetMaxKeys returns the max keys with a default of 10 if not set
@@ -33,6 +314,14 @@ func (c *IDPConfig) GetMaxKeys() int {
 	return c.MaxKeys
 }
 
+// GetMaxKeyBytes returns the key material quota with a default of 1 MiB if not set
+func (c *IDPConfig) GetMaxKeyBytes() int {
+	if c.MaxKeyBytes <= 0 {
+		return 1 << 20
+	}
+	return c.MaxKeyBytes
+}
+
 // GetCacheDuration returns the cache duration with a default of 900 seconds if not set
 func (c *IDPConfig) GetCacheDuration() int {
 	if c.CacheDuration <= 0 {
@@ -41,9 +330,137 @@ func (c *IDPConfig) GetCacheDuration() int {
 	return c.CacheDuration
 }
 
+// GetSource returns the key source with http as default
+func (c *IDPConfig) GetSource() string {
+	if c.Source == "" {
+		return SourceHTTP
+	}
+	return c.Source
+}
+
+// GetMaxResponseBytes returns the response size limit with a default of 5 MiB if not set
+func (c *IDPConfig) GetMaxResponseBytes() int64 {
+	if c.MaxResponseBytes <= 0 {
+		return 5 << 20
+	}
+	return c.MaxResponseBytes
+}
+
+// GetRequestIDHeader returns the correlation id header with X-Request-ID as default, empty when disabled
+func (c *IDPConfig) GetRequestIDHeader() string {
+	switch c.RequestIDHeader {
+	case "":
+		return "X-Request-ID"
+	case "-":
+		return ""
+	}
+	return c.RequestIDHeader
+}
+
+// GetTLSServerName returns the TLS server name override, the host of host_header by default, empty if neither is set
+func (c *IDPConfig) GetTLSServerName() string {
+	if c.TLSServerName != "" || c.HostHeader == "" {
+		return c.TLSServerName
+	}
+	if host, _, err := net.SplitHostPort(c.HostHeader); err == nil {
+		return host
+	}
+	return c.HostHeader
+}
+
+// GetMaxRetryAfter returns the longest Retry-After honored on 429 and 503 responses with a default of one hour
+func (c *IDPConfig) GetMaxRetryAfter() time.Duration {
+	if c.MaxRetryAfter <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.MaxRetryAfter) * time.Second
+}
+
+// GetFetchTimeout returns how long a fetch may take with a default of 10 seconds
+func (c *IDPConfig) GetFetchTimeout() time.Duration {
+	if c.FetchTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.FetchTimeout) * time.Second
+}
+
+// GetMaxRedirects returns the redirect limit with a default of 10 if not set
+func (c *IDPConfig) GetMaxRedirects() int {
+	if c.MaxRedirects == nil || *c.MaxRedirects < 0 {
+		return 10
+	}
+	return *c.MaxRedirects
+}
+
+// GetClockSkew returns the IDP's token time tolerance, 0 if not set
+func (c *IDPConfig) GetClockSkew() time.Duration {
+	return time.Duration(max(c.ClockSkew, 0)) * time.Second
+}
+
+// GetMaxTokenAge returns the IDP's maximum token age, 0 if not set
+func (c *IDPConfig) GetMaxTokenAge() time.Duration {
+	return time.Duration(max(c.MaxTokenAge, 0)) * time.Second
+}
+
+// Plan builds the fetch schedule from refresh_interval, schedules and blackout windows
+func (c *IDPConfig) Plan() (*schedule.Plan, error) {
+	loc := time.Local
+	if c.Timezone != "" {
+		l, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", c.Timezone, err)
+		}
+		loc = l
+	}
+
+	crons := make([]*schedule.Cron, 0, len(c.Schedules))
+	for _, expr := range c.Schedules {
+		cron, err := schedule.ParseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		crons = append(crons, cron)
+	}
+
+	windows := make([]*schedule.Window, 0, len(c.BlackoutWindows))
+	for _, wc := range c.BlackoutWindows {
+		w, err := schedule.ParseWindow(wc.Start, wc.End, wc.Days)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blackout window: %w", err)
+		}
+		windows = append(windows, w)
+	}
+
+	interval := time.Duration(c.RefreshInterval) * time.Second
+	return schedule.NewPlan(interval, crons, windows, loc), nil
+}
+
+// StartupConfig controls the initial fetch of all IDPs
+type StartupConfig struct {
+	Concurrency int `yaml:"concurrency"` // maximum parallel initial fetches (default: 10)
+	Timeout     int `yaml:"timeout"`     // overall deadline for the initial fetches in seconds (default: 30)
+}
+
+// GetConcurrency returns the startup concurrency with a default of 10 if not set
+func (c *StartupConfig) GetConcurrency() int {
+	if c.Concurrency <= 0 {
+		return 10
+	}
+	return c.Concurrency
+}
+
+// GetTimeout returns the startup deadline with a default of 30 seconds if not set
+func (c *StartupConfig) GetTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
 type LoggingConfig struct {
 	Level  string `yaml:"level"`
 	Format string `yaml:"format"`
+	Output string `yaml:"output"` // stdout (default), stderr or a file path, appended to
 }
 
 func Load(path string) (*Config, error) {
@@ -52,10 +469,235 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	// Encrypted values are decrypted on the parsed document, before anything reads them
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := secrets.Decrypt(&doc); err != nil {
+		return nil, fmt.Errorf("decrypt configuration: %w", err)
+	}
+
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if doc.Kind != 0 {
+		if err := doc.Decode(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.expandPresets(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
+	cfg.applyClientDefaults()
+
 	return &cfg, nil
 }
+
+// applyClientDefaults copies the global client settings into each IDP unless overridden
+func (c *Config) applyClientDefaults() {
+	for i := range c.IDPs {
+		idp := &c.IDPs[i]
+		if idp.UserAgent == "" {
+			idp.UserAgent = c.Client.UserAgent
+		}
+		if idp.RequestIDHeader == "" {
+			idp.RequestIDHeader = c.Client.RequestIDHeader
+		}
+		idp.Traceparent = idp.Traceparent || c.Client.Traceparent
+		if len(c.Client.Headers) == 0 {
+			continue
+		}
+		headers := make(map[string]string, len(c.Client.Headers)+len(idp.Headers))
+		for k, v := range c.Client.Headers {
+			headers[k] = v
+		}
+		for k, v := range idp.Headers {
+			headers[k] = v
+		}
+		idp.Headers = headers
+	}
+}
+
+// Validate checks the configuration for values that would break the updaters
+func (c *Config) Validate() error {
+	if err := c.validateProfile(); err != nil {
+		return err
+	}
+	if err := c.Server.validate(); err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+	if err := c.Admin.validate(c.IDPs); err != nil {
+		return fmt.Errorf("admin: %w", err)
+	}
+	if c.Signing != nil {
+		if err := c.Signing.validate(c.Admin, c.IDPs); err != nil {
+			return fmt.Errorf("signing: %w", err)
+		}
+	}
+	if c.Publish != nil {
+		if err := c.Publish.validate(); err != nil {
+			return fmt.Errorf("publish: %w", err)
+		}
+	}
+	if c.Events != nil {
+		if err := c.Events.validate(); err != nil {
+			return fmt.Errorf("events: %w", err)
+		}
+	}
+	if err := c.SLO.validate(); err != nil {
+		return fmt.Errorf("slo: %w", err)
+	}
+	if c.Alerts != nil {
+		if err := c.Alerts.validate(); err != nil {
+			return fmt.Errorf("alerts: %w", err)
+		}
+	}
+	if err := c.Limits.validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	if err := c.Outbound.validate(); err != nil {
+		return fmt.Errorf("outbound: %w", err)
+	}
+	if err := c.Merged.validate(c.mergedPaths()); err != nil {
+		return fmt.Errorf("merged: %w", err)
+	}
+	if c.Faults != nil {
+		if err := c.Faults.validate(); err != nil {
+			return fmt.Errorf("faults: %w", err)
+		}
+	}
+	if c.Watchdog != nil {
+		if err := c.Watchdog.validate(); err != nil {
+			return fmt.Errorf("watchdog: %w", err)
+		}
+	}
+	if c.Replicas != nil {
+		if err := c.Replicas.validate(); err != nil {
+			return fmt.Errorf("replicas: %w", err)
+		}
+	}
+	if c.Storage != nil {
+		if err := c.Storage.validate(); err != nil {
+			return fmt.Errorf("storage: %w", err)
+		}
+	}
+	if c.Recording != nil {
+		if err := c.Recording.validate(); err != nil {
+			return fmt.Errorf("recording: %w", err)
+		}
+	}
+
+	names := make(map[string]bool, len(c.IDPs))
+	for i := range c.IDPs {
+		idp := &c.IDPs[i]
+		if err := validateName(idp.Name); err != nil {
+			return fmt.Errorf("idp %d: %w", i, err)
+		}
+		if names[idp.Name] {
+			return fmt.Errorf("idp %q: duplicate name", idp.Name)
+		}
+		names[idp.Name] = true
+		if idp.Name == "consumers" && c.Server.Consumers != nil {
+			return fmt.Errorf("idp %q: name is taken by /status/consumers", idp.Name)
+		}
+		if err := idp.validateSource(); err != nil {
+			return fmt.Errorf("idp %q: %w", idp.Name, err)
+		}
+		if idp.RefreshInterval <= 0 && len(idp.Schedules) == 0 {
+			return fmt.Errorf("idp %q: refresh_interval must be positive when no schedules are set", idp.Name)
+		}
+		if err := idp.validateRefreshInterval(c.Outbound.GetMinRefreshInterval()); err != nil {
+			return fmt.Errorf("idp %q: %w", idp.Name, err)
+		}
+		if err := idp.validateServePath(); err != nil {
+			return fmt.Errorf("idp %q: %w", idp.Name, err)
+		}
+		if idp.Transform != nil {
+			if err := idp.Transform.validate(); err != nil {
+				return fmt.Errorf("idp %q: transform: %w", idp.Name, err)
+			}
+		}
+		if err := idp.validateHostOverride(); err != nil {
+			return fmt.Errorf("idp %q: %w", idp.Name, err)
+		}
+		if idp.DNS != nil {
+			if err := idp.DNS.validate(); err != nil {
+				return fmt.Errorf("idp %q: dns: %w", idp.Name, err)
+			}
+		}
+		if err := idp.validateVantages(); err != nil {
+			return fmt.Errorf("idp %q: %w", idp.Name, err)
+		}
+		if idp.StartJitter < 0 || idp.RefreshJitter < 0 {
+			return fmt.Errorf("idp %q: start_jitter and refresh_jitter must not be negative", idp.Name)
+		}
+		if idp.FetchTimeout < 0 {
+			return fmt.Errorf("idp %q: fetch_timeout must not be negative", idp.Name)
+		}
+		if _, err := idp.Plan(); err != nil {
+			return fmt.Errorf("idp %q: %w", idp.Name, err)
+		}
+		if idp.ClientCredentials != nil {
+			if err := idp.ClientCredentials.validate(); err != nil {
+				return fmt.Errorf("idp %q: %w", idp.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateRefreshInterval rejects a refresh_interval below the floor unless allow_fast_refresh is set,
+// so a typo like refresh_interval: 1 can't hammer the IDP. Schedules replace the interval and aren't checked.
+func (c *IDPConfig) validateRefreshInterval(floor time.Duration) error {
+	if len(c.Schedules) > 0 || c.AllowFastRefresh {
+		return nil
+	}
+	if interval := time.Duration(c.RefreshInterval) * time.Second; interval < floor {
+		return fmt.Errorf("refresh_interval %s is below outbound.min_refresh_interval %s, set allow_fast_refresh: true to allow it", interval, floor)
+	}
+	return nil
+}
+
+// validateSource checks that the settings required by the IDP's source are present
+func (c *IDPConfig) validateSource() error {
+	switch c.GetSource() {
+	case SourceHTTP:
+		if c.URL == "" {
+			return fmt.Errorf("url is required")
+		}
+	case SourceFile:
+		if c.Path == "" {
+			return fmt.Errorf("path is required for source %q", SourceFile)
+		}
+	case SourceExec:
+		if len(c.Command) == 0 {
+			return fmt.Errorf("command is required for source %q", SourceExec)
+		}
+	case SourceAWSKMS:
+		if c.KMS == nil || (c.KMS.AliasPrefix == "" && len(c.KMS.KeyIDs) == 0) {
+			return fmt.Errorf("kms.alias_prefix or kms.key_ids is required for source %q", SourceAWSKMS)
+		}
+		if c.KMS.Region == "" && os.Getenv("AWS_REGION") == "" {
+			return fmt.Errorf("kms.region or AWS_REGION is required for source %q", SourceAWSKMS)
+		}
+	case SourceGCPKMS:
+		if c.KMS == nil || c.KMS.KeyRing == "" {
+			return fmt.Errorf("kms.key_ring is required for source %q", SourceGCPKMS)
+		}
+	case SourceKubernetes:
+		if c.Kubernetes == nil || c.Kubernetes.LabelSelector == "" {
+			return fmt.Errorf("kubernetes.label_selector is required for source %q", SourceKubernetes)
+		}
+		if kind := c.Kubernetes.GetKind(); kind != "secret" && kind != "configmap" {
+			return fmt.Errorf("kubernetes.kind must be secret or configmap, got %q", kind)
+		}
+	default:
+		return fmt.Errorf("unknown source %q", c.Source)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ConsumersConfig tracks who calls the key endpoints, reported on /status/consumers, so the
+// consumers of an endpoint are known before it changes
+type ConsumersConfig struct {
+	Window       int `yaml:"window"`        // seconds a consumer is reported after its last request (default: 86400)
+	MaxConsumers int `yaml:"max_consumers"` // distinct consumers kept, the least recently seen are dropped (default: 10000)
+}
+
+// GetWindow returns how long consumers are remembered with a default of 24 hours
+func (c *ConsumersConfig) GetWindow() time.Duration {
+	if c.Window <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(c.Window) * time.Second
+}
+
+// GetMaxConsumers returns the consumer limit with a default of 10000
+func (c *ConsumersConfig) GetMaxConsumers() int {
+	if c.MaxConsumers <= 0 {
+		return 10000
+	}
+	return c.MaxConsumers
+}
+
+func (c *ConsumersConfig) validate() error {
+	if c.Window < 0 {
+		return fmt.Errorf("window must not be negative")
+	}
+	if c.MaxConsumers < 0 {
+		return fmt.Errorf("max_consumers must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// DNS address family preferences
+const (
+	PreferIPv4 = "ipv4"
+	PreferIPv6 = "ipv6"
+)
+
+// DNSConfig controls how an IDP's hostname is resolved and dialed
+type DNSConfig struct {
+	Hosts         map[string][]string `yaml:"hosts"`          // static addresses per hostname, bypassing DNS
+	Resolver      string              `yaml:"resolver"`       // "ip:port" of a DNS server used instead of the system resolver
+	Prefer        string              `yaml:"prefer"`         // ipv4 or ipv6 addresses first (default: resolver order)
+	CacheTTL      int                 `yaml:"cache_ttl"`      // seconds resolved addresses are reused, 0 resolves on every dial
+	FallbackDelay int                 `yaml:"fallback_delay"` // milliseconds before racing the other address family (default: 300, -1 disables)
+}
+
+// GetCacheTTL returns how long resolved addresses are reused, 0 if not cached
+func (c *DNSConfig) GetCacheTTL() time.Duration {
+	return time.Duration(max(c.CacheTTL, 0)) * time.Second
+}
+
+// GetFallbackDelay returns the happy eyeballs delay with a default of 300ms, 0 when disabled
+func (c *DNSConfig) GetFallbackDelay() time.Duration {
+	if c.FallbackDelay < 0 {
+		return 0
+	}
+	if c.FallbackDelay == 0 {
+		return 300 * time.Millisecond
+	}
+	return time.Duration(c.FallbackDelay) * time.Millisecond
+}
+
+func (c *DNSConfig) validate() error {
+	for host, addrs := range c.Hosts {
+		if len(addrs) == 0 {
+			return fmt.Errorf("hosts: %q has no addresses", host)
+		}
+		for _, addr := range addrs {
+			if net.ParseIP(addr) == nil {
+				return fmt.Errorf("hosts: %q is not an IP address", addr)
+			}
+		}
+	}
+	if c.Resolver != "" {
+		host, _, err := net.SplitHostPort(c.Resolver)
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("resolver must be ip:port, got %q", c.Resolver)
+		}
+	}
+	if c.Prefer != "" && c.Prefer != PreferIPv4 && c.Prefer != PreferIPv6 {
+		return fmt.Errorf("prefer must be %s or %s", PreferIPv4, PreferIPv6)
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl must not be negative")
+	}
+	return nil
+}
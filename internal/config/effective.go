@@ -0,0 +1,261 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secret values in the echoed configuration
+const redacted = "[REDACTED]"
+
+// secretKeys are configuration keys whose values are never echoed
+var secretKeys = map[string]bool{
+	"client_secret": true,
+	"token":         true,
+	"password":      true,
+	"routing_key":   true,
+	"webhook_url":   true,
+}
+
+// sensitiveHeaders are header name fragments whose values are redacted
+var sensitiveHeaders = []string{"authorization", "cookie", "token", "secret", "key", "password", "signature"}
+
+// Effective returns the loaded configuration with the defaults applied and secrets redacted,
+// as generic values for the startup log and GET /admin/config. Unset options are omitted.
+func (c *Config) Effective() (map[string]any, error) {
+	effective := *c
+	effective.applyDefaults()
+
+	data, err := yaml.Marshal(&effective)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+
+	redact(values)
+	prune(values)
+	return values, nil
+}
+
+// Redacted returns the IDP configuration as generic values with secrets redacted like Effective,
+// e.g. for audit records. Unset options are omitted, defaults are not applied.
+func (c IDPConfig) Redacted() (map[string]any, error) {
+	data, err := yaml.Marshal(&c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode IDP configuration: %w", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to decode IDP configuration: %w", err)
+	}
+
+	redact(values)
+	prune(values)
+	return values, nil
+}
+
+// applyDefaults fills the options of a copy of the configuration with what the getters resolve to.
+// Slices are replaced rather than modified, they are shared with the original.
+func (c *Config) applyDefaults() {
+	c.Server.Listeners = c.Server.GetListeners()
+	c.Server.Listen, c.Server.Host, c.Server.Port = "", "", 0
+	c.Server.SocketMode, c.Server.Protocols, c.Server.Socket = "", nil, SocketOptions{}
+	listeners := make([]ListenerConfig, len(c.Server.Listeners))
+	for i, l := range c.Server.Listeners {
+		l.Name, l.Protocols, l.Routes = l.GetName(), l.GetProtocols(), l.GetRoutes()
+		listeners[i] = l
+	}
+	c.Server.Listeners = listeners
+	c.Server.ShutdownTimeout = int(c.Server.GetShutdownTimeout().Seconds())
+	c.Server.StaleIfError = c.Server.GetStaleIfError()
+	c.Server.Requests = RequestLimits{
+		MaxBodyBytes:      c.Server.Requests.GetMaxBodyBytes(),
+		MaxHeaderBytes:    c.Server.Requests.GetMaxHeaderBytes(),
+		MaxHeaderCount:    c.Server.Requests.GetMaxHeaderCount(),
+		ReadHeaderTimeout: int(c.Server.Requests.GetReadHeaderTimeout().Seconds()),
+	}
+	if c.Server.Memcached != nil {
+		memcached := *c.Server.Memcached
+		memcached.MaxConnections = memcached.GetMaxConnections()
+		memcached.IdleTimeout = int(memcached.GetIdleTimeout().Seconds())
+		c.Server.Memcached = &memcached
+	}
+	if c.Server.Consumers != nil {
+		c.Server.Consumers = &ConsumersConfig{
+			Window:       int(c.Server.Consumers.GetWindow().Seconds()),
+			MaxConsumers: c.Server.Consumers.GetMaxConsumers(),
+		}
+	}
+
+	f := c.GetFeatures()
+	c.Profile = cmp.Or(c.Profile, ProfileDev)
+	c.Features = FeaturesConfig{StrictTLS: &f.StrictTLS, DebugEndpoints: &f.DebugEndpoints, VerboseHeaders: &f.VerboseHeaders, FailClosed: &f.FailClosed}
+
+	c.Startup.Concurrency = c.Startup.GetConcurrency()
+	if c.Admin.TombstoneRetention >= 0 {
+		c.Admin.TombstoneRetention = int(c.Admin.GetTombstoneRetention().Seconds())
+	}
+	c.Startup.Timeout = int(c.Startup.GetTimeout().Seconds())
+	c.Logging.Level = strings.ToLower(cmp.Or(c.Logging.Level, "info"))
+	c.Logging.Format = strings.ToLower(cmp.Or(c.Logging.Format, "text"))
+	c.Logging.Output = cmp.Or(c.Logging.Output, "stdout")
+	c.Merged.Dedup, c.Merged.DedupKeep = c.Merged.GetDedup(), c.Merged.GetDedupKeep()
+	cacheControl := MergedCacheControl{Policy: c.Merged.CacheControl.GetPolicy(), MaxAge: c.Merged.CacheControl.GetMaxAge()}
+	for path, group := range c.Merged.CacheControl.Groups {
+		if cacheControl.Groups == nil {
+			cacheControl.Groups = make(map[string]MergedCacheControl)
+		}
+		cacheControl.Groups[path] = MergedCacheControl{Policy: group.GetPolicy(), MaxAge: group.GetMaxAge()}
+	}
+	c.Merged.CacheControl = cacheControl
+	c.Outbound.MinRefreshInterval = int(c.Outbound.GetMinRefreshInterval().Seconds())
+	if c.Outbound.MaxFetchesPerSecond > 0 {
+		c.Outbound.Burst, c.Outbound.RetryBudget = c.Outbound.GetBurst(), c.Outbound.GetRetryBudget()
+	}
+
+	if c.Storage != nil {
+		storage := *c.Storage
+		storage.Interval = int(storage.GetInterval().Seconds())
+		switch {
+		case storage.Type == StorageEmbedded:
+			storage.Embedded = &EmbeddedConfig{Directory: storage.GetDirectory()}
+		case storage.Postgres != nil:
+			postgres := *storage.Postgres
+			postgres.Table, postgres.History = postgres.GetTable(), postgres.GetHistory()
+			storage.Postgres = &postgres
+		}
+		c.Storage = &storage
+	}
+
+	idps := make([]IDPConfig, len(c.IDPs))
+	for i, idp := range c.IDPs {
+		idp.Source = idp.GetSource()
+		idp.MaxKeys = idp.GetMaxKeys()
+		idp.MaxKeyBytes = idp.GetMaxKeyBytes()
+		idp.CacheDuration = idp.GetCacheDuration()
+		idp.MaxResponseBytes = idp.GetMaxResponseBytes()
+		idp.MaxRetryAfter = int(idp.GetMaxRetryAfter().Seconds())
+		idp.FetchTimeout = int(idp.GetFetchTimeout().Seconds())
+		maxRedirects := idp.GetMaxRedirects()
+		idp.MaxRedirects = &maxRedirects
+		idp.RequestIDHeader = idp.GetRequestIDHeader()
+		idp.TLSServerName = idp.GetTLSServerName()
+		idps[i] = idp
+	}
+	c.IDPs = idps
+}
+
+// redact replaces secret values, sensitive header values and URL passwords in place
+func redact(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			switch {
+			case secretKeys[key] && child != nil && child != "":
+				v[key] = redacted
+			case key == "headers":
+				if headers, ok := child.(map[string]any); ok {
+					for name := range headers {
+						if sensitiveHeader(name) {
+							headers[name] = redacted
+						}
+					}
+				}
+			default:
+				if s, ok := child.(string); ok {
+					v[key] = redactURL(s)
+				} else {
+					redact(child)
+				}
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if s, ok := child.(string); ok {
+				v[i] = redactURL(s)
+			} else {
+				redact(child)
+			}
+		}
+	}
+}
+
+func sensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, fragment := range sensitiveHeaders {
+		if strings.Contains(name, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL hides the password of URLs with credentials, other strings are returned as is
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// prune removes unset options (null, "", 0, false and empty collections) in place,
+// returning whether value itself is unset
+func prune(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]any:
+		for key, child := range v {
+			if prune(child) {
+				delete(v, key)
+			}
+		}
+		return len(v) == 0
+	case []any:
+		for _, child := range v {
+			prune(child)
+		}
+		return len(v) == 0
+	case string:
+		return v == ""
+	case int:
+		return v == 0
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	}
+	return false
+}
+
+// LogAttrs converts echoed configuration values into slog attributes, maps becoming groups
+func LogAttrs(values map[string]any) []any {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]any, 0, len(keys))
+	for _, key := range keys {
+		if m, ok := values[key].(map[string]any); ok {
+			attrs = append(attrs, slog.Group(key, LogAttrs(m)...))
+			continue
+		}
+		attrs = append(attrs, slog.Any(key, values[key]))
+	}
+	return attrs
+}
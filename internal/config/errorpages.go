@@ -0,0 +1,105 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// ErrorPagesConfig renders the plain-text errors this service generates (unknown path 404, 405,
+// handler errors) with templates, so they match the error shape the API gateway promises clients
+type ErrorPagesConfig struct {
+	Templates       map[int]string `yaml:"templates"`         // by status, e.g. 404: '{"error": {{json .Message}}}'
+	ContentType     string         `yaml:"content_type"`      // default: application/json
+	DocsURL         string         `yaml:"docs_url"`          // available to templates as .DocsURL
+	RequestIDHeader string         `yaml:"request_id_header"` // request header with the id of the request, also sent back (default: X-Request-ID)
+}
+
+// ErrorPage is what an error page template is rendered with
+type ErrorPage struct {
+	Status    int    // e.g. 404
+	Error     string // status text, e.g. "Not Found"
+	Message   string // what the handler reported, e.g. "IDP 'foo' not found"
+	Method    string
+	Path      string
+	RequestID string // from request_id_header, generated when the request has none
+	DocsURL   string
+}
+
+// errorPageFuncs are the functions templates can call; json quotes a value so it can be inserted anywhere
+var errorPageFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// GetContentType returns the content type of rendered pages with a default of application/json
+func (c *ErrorPagesConfig) GetContentType() string {
+	if c.ContentType == "" {
+		return "application/json"
+	}
+	return c.ContentType
+}
+
+// GetRequestIDHeader returns the request id header with a default of X-Request-ID
+func (c *ErrorPagesConfig) GetRequestIDHeader() string {
+	if c.RequestIDHeader == "" {
+		return "X-Request-ID"
+	}
+	return c.RequestIDHeader
+}
+
+// ParseTemplates returns the templates by status
+func (c *ErrorPagesConfig) ParseTemplates() (map[int]*template.Template, error) {
+	templates := make(map[int]*template.Template, len(c.Templates))
+	for status, text := range c.Templates {
+		t, err := template.New(fmt.Sprint(status)).Funcs(errorPageFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template %d: %w", status, err)
+		}
+		templates[status] = t
+	}
+	return templates, nil
+}
+
+func (c *ErrorPagesConfig) validate() error {
+	if len(c.Templates) == 0 {
+		return fmt.Errorf("templates must not be empty")
+	}
+	for status := range c.Templates {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("template %d: status must be 4xx or 5xx", status)
+		}
+	}
+	templates, err := c.ParseTemplates()
+	if err != nil {
+		return err
+	}
+
+	// Render every template once, so a template producing broken JSON fails at load time. Path and
+	// RequestID come from the client, so every string holds what a value inserted unquoted breaks on.
+	jsonType := strings.HasSuffix(strings.SplitN(c.GetContentType(), ";", 2)[0], "json")
+	sample := func(s string) string { return s + " \"quoted\" \\ \n\t\x00" }
+	for status, t := range templates {
+		var out strings.Builder
+		page := ErrorPage{
+			Status:    status,
+			Error:     sample(http.StatusText(status)),
+			Message:   sample("message"),
+			Method:    sample(http.MethodGet),
+			Path:      sample("/sample"),
+			RequestID: sample("0123456789abcdef"),
+			DocsURL:   sample(c.DocsURL),
+		}
+		if err := t.Execute(&out, page); err != nil {
+			return fmt.Errorf("template %d: %w", status, err)
+		}
+		if jsonType && !json.Valid([]byte(out.String())) {
+			return fmt.Errorf("template %d does not render valid JSON, quote every value with json, e.g. {{json .Path}}", status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import "fmt"
+
+// Event serializations, chosen per sink
+const (
+	EventFormatCloudEvents = "cloudevents" // CloudEvents 1.0 envelope in structured JSON mode (default)
+	EventFormatJSON        = "json"        // the event data with its type, id and time, no envelope
+)
+
+// EventsConfig publishes key-change events to message buses
+type EventsConfig struct {
+	NATS  *NATSConfig  `yaml:"nats"`
+	Kafka *KafkaConfig `yaml:"kafka"`
+}
+
+// NATSConfig publishes events to a NATS subject
+type NATSConfig struct {
+	URL          string `yaml:"url"`        // nats://host:4222, tls://host:4222 for TLS
+	Subject      string `yaml:"subject"`    // default: idp.keys
+	Token        string `yaml:"token"`      // auth token
+	TokenFile    string `yaml:"token_file"` // read on every publish, takes precedence
+	User         string `yaml:"user"`       // user/password auth
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"` // read on every publish, takes precedence
+	Format       string `yaml:"format"`        // cloudevents (default) or json
+}
+
+// KafkaConfig publishes events to a Kafka topic through a Kafka REST Proxy (v2 API)
+type KafkaConfig struct {
+	RestProxy    string `yaml:"rest_proxy"` // e.g. http://kafka-rest:8082
+	Topic        string `yaml:"topic"`      // default: idp.keys
+	Username     string `yaml:"username"`   // basic auth
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"` // read on every publish, takes precedence
+	Format       string `yaml:"format"`        // cloudevents (default) or json
+}
+
+// GetSubject returns the NATS subject with a default of "idp.keys"
+func (c *NATSConfig) GetSubject() string {
+	if c.Subject == "" {
+		return "idp.keys"
+	}
+	return c.Subject
+}
+
+// GetTopic returns the Kafka topic with a default of "idp.keys"
+func (c *KafkaConfig) GetTopic() string {
+	if c.Topic == "" {
+		return "idp.keys"
+	}
+	return c.Topic
+}
+
+// GetFormat returns the event serialization with a default of cloudevents
+func (c *NATSConfig) GetFormat() string {
+	return eventFormat(c.Format)
+}
+
+// GetFormat returns the event serialization with a default of cloudevents
+func (c *KafkaConfig) GetFormat() string {
+	return eventFormat(c.Format)
+}
+
+func eventFormat(format string) string {
+	if format == "" {
+		return EventFormatCloudEvents
+	}
+	return format
+}
+
+func (c *EventsConfig) validate() error {
+	if c.NATS == nil && c.Kafka == nil {
+		return fmt.Errorf("at least one of nats or kafka is required")
+	}
+	if c.NATS != nil {
+		if c.NATS.URL == "" {
+			return fmt.Errorf("nats.url is required")
+		}
+		if err := validateEventFormat(c.NATS.GetFormat()); err != nil {
+			return fmt.Errorf("nats.%w", err)
+		}
+	}
+	if c.Kafka != nil {
+		if c.Kafka.RestProxy == "" {
+			return fmt.Errorf("kafka.rest_proxy is required")
+		}
+		if err := validateEventFormat(c.Kafka.GetFormat()); err != nil {
+			return fmt.Errorf("kafka.%w", err)
+		}
+	}
+	return nil
+}
+
+func validateEventFormat(format string) error {
+	if format != EventFormatCloudEvents && format != EventFormatJSON {
+		return fmt.Errorf("format must be %s or %s", EventFormatCloudEvents, EventFormatJSON)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// FaultsConfig injects failures into the JWKS endpoints so consumers can test how they cope
+// with a degraded service. Never enable it in production.
+type FaultsConfig struct {
+	Latency       int     `yaml:"latency"`        // milliseconds added to every response
+	LatencyJitter int     `yaml:"latency_jitter"` // maximum random milliseconds added on top
+	ErrorRate     float64 `yaml:"error_rate"`     // fraction of requests answered with error_status
+	ErrorStatus   int     `yaml:"error_status"`   // status of injected errors (default: 503)
+	TruncateRate  float64 `yaml:"truncate_rate"`  // fraction of responses whose body is cut in half
+}
+
+// GetLatency returns the injected latency, 0 if not set
+func (c *FaultsConfig) GetLatency() time.Duration {
+	return time.Duration(max(c.Latency, 0)) * time.Millisecond
+}
+
+// GetLatencyJitter returns the maximum random latency, 0 if not set
+func (c *FaultsConfig) GetLatencyJitter() time.Duration {
+	return time.Duration(max(c.LatencyJitter, 0)) * time.Millisecond
+}
+
+// GetErrorStatus returns the status of injected errors with a default of 503
+func (c *FaultsConfig) GetErrorStatus() int {
+	if c.ErrorStatus == 0 {
+		return 503
+	}
+	return c.ErrorStatus
+}
+
+func (c *FaultsConfig) validate() error {
+	if c.ErrorRate < 0 || c.ErrorRate > 1 || c.TruncateRate < 0 || c.TruncateRate > 1 {
+		return fmt.Errorf("error_rate and truncate_rate must be between 0 and 1")
+	}
+	if s := c.GetErrorStatus(); s < 400 || s > 599 {
+		return fmt.Errorf("error_status must be a 4xx or 5xx status")
+	}
+	return nil
+}
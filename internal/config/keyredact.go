@@ -0,0 +1,53 @@
+package config
+
+import (
+	"encoding/json"
+	"log/slog"
+	"regexp"
+)
+
+// keyMaterialMembers are the JWK members holding key material or certificates (RFC 7517, 7518).
+// e, kid, kty, alg, use and crv identify a key without revealing it and are kept.
+var keyMaterialMembers = map[string]bool{
+	"n": true, "x": true, "y": true, "k": true, "x5c": true,
+	"d": true, "p": true, "q": true, "dp": true, "dq": true, "qi": true, "oth": true,
+}
+
+// keyMaterialJSON matches key material members inside JSON, e.g. a response body quoted in an error
+var keyMaterialJSON = regexp.MustCompile(`"(n|x|y|k|x5c|d|p|q|dp|dq|qi|oth)"(\s*:\s*)("(?:[^"\\]|\\.)*"|\[[^\]]*\])`)
+
+// RedactKeyMaterial is the ReplaceAttr of every log handler: no level logs JWK contents, whether an
+// attribute is named like a key member or a string or error carries a JSON key set
+func RedactKeyMaterial(_ []string, a slog.Attr) slog.Attr {
+	if keyMaterialMembers[a.Key] {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if s := a.Value.String(); keyMaterialJSON.MatchString(s) {
+			return slog.String(a.Key, redactKeyJSON(s))
+		}
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			if keyMaterialJSON.MatchString(err.Error()) {
+				return slog.String(a.Key, redactKeyJSON(err.Error()))
+			}
+			return a
+		}
+		// Structs and maps, a JWK for one, are written as JSON: redact their members the same way
+		if data, err := json.Marshal(a.Value.Any()); err == nil && keyMaterialJSON.Match(data) {
+			var v any
+			if err := json.Unmarshal([]byte(redactKeyJSON(string(data))), &v); err == nil {
+				return slog.Any(a.Key, v)
+			}
+			return slog.String(a.Key, redactKeyJSON(string(data)))
+		}
+	}
+	return a
+}
+
+// redactKeyJSON replaces the values of key material members in JSON text
+func redactKeyJSON(s string) string {
+	return keyMaterialJSON.ReplaceAllString(s, `"$1"$2"`+redacted+`"`)
+}
@@ -0,0 +1,16 @@
+package config
+
+import "fmt"
+
+// LimitsConfig bounds the keys held across all IDPs; per-IDP quotas are max_keys and max_key_bytes
+type LimitsConfig struct {
+	MaxTotalKeys     int `yaml:"max_total_keys"`      // keys across all IDPs, 0 means no limit
+	MaxTotalKeyBytes int `yaml:"max_total_key_bytes"` // bytes of key material across all IDPs, 0 means no limit
+}
+
+func (c *LimitsConfig) validate() error {
+	if c.MaxTotalKeys < 0 || c.MaxTotalKeyBytes < 0 {
+		return fmt.Errorf("max_total_keys and max_total_key_bytes must not be negative")
+	}
+	return nil
+}
@@ -1,12 +1,136 @@
 package config
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-func InitLogger(cfg LoggingConfig) *slog.Logger {
+// LogHandler is a slog.Handler whose level, format and output can be replaced while loggers
+// derived from it are in use, so logging settings don't require a restart
+type LogHandler struct {
+	root  *logRoot
+	ops   []func(slog.Handler) slog.Handler // WithAttrs/WithGroup calls, replayed on the current handler
+	cache atomic.Pointer[derivedHandler]
+}
+
+type logRoot struct {
+	state atomic.Pointer[logState]
+}
+
+// logState is one configuration of the handler and the file it writes to, if any
+type logState struct {
+	config  LoggingConfig
+	handler slog.Handler
+	file    *os.File
+
+	// Records being written hold mu for reading, so a swapped out file is only closed once the
+	// writes that loaded this state before the swap are done
+	mu     sync.RWMutex
+	closed bool
+}
+
+// derivedHandler is the current handler with a LogHandler's ops applied, rebuilt after a swap
+type derivedHandler struct {
+	state   *logState
+	handler slog.Handler
+}
+
+// NewLogHandler returns a handler for the logging configuration
+func NewLogHandler(cfg LoggingConfig) (*LogHandler, error) {
+	state, err := newLogState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := &LogHandler{root: &logRoot{}}
+	h.root.state.Store(state)
+	return h, nil
+}
+
+// Reconfigure switches every logger derived from h to the new logging configuration.
+// On error the current configuration stays in place.
+func (h *LogHandler) Reconfigure(cfg LoggingConfig) error {
+	state, err := newLogState(cfg)
+	if err != nil {
+		return err
+	}
+	if old := h.root.state.Swap(state); old.file != nil {
+		old.mu.Lock()
+		old.closed = true
+		old.file.Close()
+		old.mu.Unlock()
+	}
+	return nil
+}
+
+// Config returns the logging configuration in effect
+func (h *LogHandler) Config() LoggingConfig {
+	return h.root.state.Load().config
+}
+
+func (h *LogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	_, handler := h.current()
+	return handler.Enabled(ctx, level)
+}
+
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	for {
+		state, handler := h.current()
+		if state.file == nil {
+			return handler.Handle(ctx, r)
+		}
+		state.mu.RLock()
+		if !state.closed {
+			err := handler.Handle(ctx, r)
+			state.mu.RUnlock()
+			return err
+		}
+		// Swapped out after it was loaded, the record goes to the new configuration
+		state.mu.RUnlock()
+	}
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *LogHandler) with(op func(slog.Handler) slog.Handler) *LogHandler {
+	return &LogHandler{root: h.root, ops: append(h.ops[:len(h.ops):len(h.ops)], op)}
+}
+
+// current returns the configuration in effect and its handler with h's attributes and groups applied
+func (h *LogHandler) current() (*logState, slog.Handler) {
+	state := h.root.state.Load()
+	if len(h.ops) == 0 {
+		return state, state.handler
+	}
+	if d := h.cache.Load(); d != nil && d.state == state {
+		return state, d.handler
+	}
+	handler := state.handler
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+	h.cache.Store(&derivedHandler{state: state, handler: handler})
+	return state, handler
+}
+
+func newLogState(cfg LoggingConfig) (*logState, error) {
 	var level slog.Level
 	switch strings.ToLower(cfg.Level) {
 	case "debug":
@@ -22,15 +146,29 @@ func InitLogger(cfg LoggingConfig) *slog.Logger {
 	}
 
 	opts := &slog.HandlerOptions{
-		Level: level,
+		Level:       level,
+		ReplaceAttr: RedactKeyMaterial,
+	}
+
+	state := &logState{config: cfg}
+	var out io.Writer
+	switch cfg.Output {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("logging output: %w", err)
+		}
+		out, state.file = f, f
 	}
 
-	var handler slog.Handler
 	if strings.ToLower(cfg.Format) == "json" {
-		handler = slog.NewJSONHandler(os.Stdout, opts)
+		state.handler = slog.NewJSONHandler(out, opts)
 	} else {
-		handler = slog.NewTextHandler(os.Stdout, opts)
+		state.handler = slog.NewTextHandler(out, opts)
 	}
-
-	return slog.New(handler)
+	return state, nil
 }
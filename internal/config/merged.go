@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Deduplication modes of the merged key set
+const (
+	DedupNone       = "none"       // every IDP's keys are served as published (default)
+	DedupSameKid    = "same_kid"   // keys with the same thumbprint and kid are served once
+	DedupThumbprint = "thumbprint" // keys with the same thumbprint are served once, whatever their kid
+)
+
+// Policies deciding the max-age of merged responses from the IDPs' cache durations
+const (
+	CachePolicyMin      = "min"      // the shortest cache duration, expiring with the earliest IDP (default)
+	CachePolicyMax      = "max"      // the longest cache duration
+	CachePolicyWeighted = "weighted" // the cache durations averaged, weighted by each IDP's key count
+	CachePolicyFixed    = "fixed"    // max_age, whatever the IDPs use
+)
+
+// MergedConfig shapes the key sets combining several IDPs: /.well-known/jwks.json, shared serve_path and /export
+type MergedConfig struct {
+	Dedup     string `yaml:"dedup"`      // none (default), same_kid or thumbprint
+	DedupKeep string `yaml:"dedup_keep"` // first (default) or last occurrence in IDP name order
+
+	CacheControl MergedCacheControl `yaml:"cache_control"` // max-age of merged responses
+}
+
+// MergedCacheControl decides the max-age of merged responses
+type MergedCacheControl struct {
+	Policy string `yaml:"policy"`  // min (default), max, weighted or fixed
+	MaxAge int    `yaml:"max_age"` // seconds: the max-age with fixed, otherwise when no IDP has keys (default: 900)
+
+	// Groups overrides the policy by path: /.well-known/jwks.json, /jwks, /audiences/{aud}/jwks.json
+	// or a serve_path shared by several IDPs
+	Groups map[string]MergedCacheControl `yaml:"groups"`
+}
+
+// GetPolicy returns the policy with min as default
+func (c *MergedCacheControl) GetPolicy() string {
+	if c.Policy == "" {
+		return CachePolicyMin
+	}
+	return c.Policy
+}
+
+// GetMaxAge returns the fixed or fallback max-age with a default of 900 seconds
+func (c *MergedCacheControl) GetMaxAge() int {
+	if c.MaxAge <= 0 {
+		return 900
+	}
+	return c.MaxAge
+}
+
+// For returns the policy of the merged response served on path
+func (c *MergedCacheControl) For(path string) MergedCacheControl {
+	if group, ok := c.Groups[path]; ok {
+		return group
+	}
+	return *c
+}
+
+// GetDedup returns the deduplication mode with none as default
+func (c *MergedConfig) GetDedup() string {
+	if c.Dedup == "" {
+		return DedupNone
+	}
+	return c.Dedup
+}
+
+// GetDedupKeep returns which duplicate is kept with first as default
+func (c *MergedConfig) GetDedupKeep() string {
+	if c.DedupKeep == "" {
+		return "first"
+	}
+	return c.DedupKeep
+}
+
+// validate checks the configuration; mergedPaths are the paths cache_control groups may name
+func (c *MergedConfig) validate(mergedPaths []string) error {
+	switch c.GetDedup() {
+	case DedupNone, DedupSameKid, DedupThumbprint:
+	default:
+		return fmt.Errorf("dedup must be %s, %s or %s", DedupNone, DedupSameKid, DedupThumbprint)
+	}
+	if keep := c.GetDedupKeep(); keep != "first" && keep != "last" {
+		return fmt.Errorf("dedup_keep must be first or last")
+	}
+	if err := c.CacheControl.validate(); err != nil {
+		return fmt.Errorf("cache_control: %w", err)
+	}
+
+	paths := make([]string, 0, len(c.CacheControl.Groups))
+	for path := range c.CacheControl.Groups {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if !slices.Contains(mergedPaths, path) {
+			return fmt.Errorf("cache_control: group %q must be /.well-known/jwks.json, /jwks, an audience path or a serve_path shared by several IDPs", path)
+		}
+		group := c.CacheControl.Groups[path]
+		if len(group.Groups) > 0 {
+			return fmt.Errorf("cache_control: group %q can't have groups", path)
+		}
+		if err := group.validate(); err != nil {
+			return fmt.Errorf("cache_control: group %q: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (c *MergedCacheControl) validate() error {
+	switch c.GetPolicy() {
+	case CachePolicyMin, CachePolicyMax, CachePolicyWeighted, CachePolicyFixed:
+	default:
+		return fmt.Errorf("policy must be %s, %s, %s or %s", CachePolicyMin, CachePolicyMax, CachePolicyWeighted, CachePolicyFixed)
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max_age must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// OutboundConfig limits the fetches of all IDPs together, so very short refresh intervals
+// across many IDPs can't saturate the egress NAT, and sets the lowest refresh_interval allowed
+type OutboundConfig struct {
+	MinRefreshInterval int `yaml:"min_refresh_interval"` // lowest refresh_interval without allow_fast_refresh, in seconds (default: 30)
+
+	MaxConcurrentFetches int     `yaml:"max_concurrent_fetches"` // fetches running at once (default: 0, unlimited)
+	MaxFetchesPerSecond  float64 `yaml:"max_fetches_per_second"` // fetches started per second (default: 0, unlimited)
+	Burst                int     `yaml:"burst"`                  // fetches started at once on top of the rate (default: max_fetches_per_second rounded up)
+	RetryBudget          int     `yaml:"retry_budget"`           // percent of max_fetches_per_second left to fetches following a failure (default: 20)
+}
+
+// Enabled reports whether any outbound limit is set
+func (c *OutboundConfig) Enabled() bool {
+	return c.MaxConcurrentFetches > 0 || c.MaxFetchesPerSecond > 0
+}
+
+// GetMinRefreshInterval returns the refresh_interval floor with a default of 30 seconds
+func (c *OutboundConfig) GetMinRefreshInterval() time.Duration {
+	if c.MinRefreshInterval <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.MinRefreshInterval) * time.Second
+}
+
+// GetBurst returns the bucket size with a default of max_fetches_per_second rounded up
+func (c *OutboundConfig) GetBurst() int {
+	if c.Burst <= 0 {
+		return max(int(math.Ceil(c.MaxFetchesPerSecond)), 1)
+	}
+	return c.Burst
+}
+
+// GetRetryBudget returns the percent of the rate retries may use with a default of 20
+func (c *OutboundConfig) GetRetryBudget() int {
+	if c.RetryBudget <= 0 {
+		return 20
+	}
+	return c.RetryBudget
+}
+
+func (c *OutboundConfig) validate() error {
+	if c.MinRefreshInterval < 0 {
+		return fmt.Errorf("min_refresh_interval must not be negative")
+	}
+	if c.MaxConcurrentFetches < 0 {
+		return fmt.Errorf("max_concurrent_fetches must not be negative")
+	}
+	if c.MaxFetchesPerSecond < 0 {
+		return fmt.Errorf("max_fetches_per_second must not be negative")
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	if c.RetryBudget < 0 || c.RetryBudget > 100 {
+		return fmt.Errorf("retry_budget must be a percentage between 0 and 100")
+	}
+	if c.MaxFetchesPerSecond == 0 && (c.Burst > 0 || c.RetryBudget > 0) {
+		return fmt.Errorf("burst and retry_budget require max_fetches_per_second")
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// IDP presets that expand into concrete entries at load time
+const (
+	TypeAzureAD  = "azure-ad"
+	TypeAuth0    = "auth0"
+	TypeOkta     = "okta"
+	TypeKeycloak = "keycloak"
+)
+
+// presetRefreshInterval is used by presets when neither refresh_interval nor schedules are set
+const presetRefreshInterval = 3600
+
+// azureHosts maps Azure clouds to their login hosts
+var azureHosts = map[string]string{
+	"public": "login.microsoftonline.com",
+	"usgov":  "login.microsoftonline.us",
+	"china":  "login.chinacloudapi.cn",
+}
+
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// expandPresets replaces preset entries (type: ...) with the entries they stand for
+func (c *Config) expandPresets() error {
+	idps := make([]IDPConfig, 0, len(c.IDPs))
+	for _, idp := range c.IDPs {
+		switch idp.Type {
+		case "":
+			idps = append(idps, idp)
+		case TypeAzureAD:
+			expanded, err := expandAzureAD(idp)
+			if err != nil {
+				return fmt.Errorf("idp %q: %w", idp.Name, err)
+			}
+			idps = append(idps, expanded...)
+		case TypeAuth0, TypeOkta, TypeKeycloak:
+			expanded, err := expandProvider(idp)
+			if err != nil {
+				return fmt.Errorf("idp %q: %w", idp.Name, err)
+			}
+			idps = append(idps, expanded)
+		default:
+			return fmt.Errorf("idp %q: unknown type %q", idp.Name, idp.Type)
+		}
+	}
+	c.IDPs = idps
+	return nil
+}
+
+// expandAzureAD creates one entry per tenant, named <name>-<tenant>
+func expandAzureAD(idp IDPConfig) ([]IDPConfig, error) {
+	if len(idp.Tenants) == 0 {
+		return nil, fmt.Errorf("tenants is required for type %q", TypeAzureAD)
+	}
+	if idp.URL != "" {
+		return nil, fmt.Errorf("url is generated for type %q and must not be set", TypeAzureAD)
+	}
+
+	cloud := idp.Cloud
+	if cloud == "" {
+		cloud = "public"
+	}
+	host, ok := azureHosts[cloud]
+	if !ok {
+		return nil, fmt.Errorf("unknown cloud %q (expected public, usgov or china)", idp.Cloud)
+	}
+
+	version := idp.TokenVersion
+	if version == 0 {
+		version = 2
+	}
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("token_version must be 1 or 2, got %d", idp.TokenVersion)
+	}
+
+	expanded := make([]IDPConfig, 0, len(idp.Tenants))
+	for _, tenant := range idp.Tenants {
+		if tenant == "" {
+			return nil, fmt.Errorf("empty tenant")
+		}
+
+		e := idp
+		e.Name = idp.Name + "-" + tenant
+		e.Tenants = []string{tenant}
+		e.Source = SourceHTTP
+		if version == 2 {
+			e.URL = fmt.Sprintf("https://%s/%s/discovery/v2.0/keys", host, tenant)
+		} else {
+			e.URL = fmt.Sprintf("https://%s/%s/discovery/keys", host, tenant)
+		}
+		if e.Issuer == "" {
+			e.Issuer = azureIssuer(host, tenant, version)
+		}
+		if e.ClientCredentials != nil {
+			// Copy so tenants don't share (and overwrite) one token_url
+			cc := *e.ClientCredentials
+			if cc.TokenURL == "" {
+				cc.TokenURL = fmt.Sprintf("https://%s/%s/oauth2/v2.0/token", host, tenant)
+			}
+			e.ClientCredentials = &cc
+		}
+		expanded = append(expanded, e)
+	}
+	return expanded, nil
+}
+
+// azureIssuer returns the iss of tokens issued for a tenant.
+// Tokens always carry the tenant GUID, so for aliases (common, organizations, consumers)
+// and domain names the issuer is left as Azure's {tenantid} template.
+func azureIssuer(host, tenant string, version int) string {
+	tid := tenant
+	if !guidPattern.MatchString(tenant) {
+		tid = "{tenantid}"
+	}
+	if version == 1 {
+		// v1 tokens are issued by sts.windows.net in the public cloud
+		if host == azureHosts["public"] {
+			return fmt.Sprintf("https://sts.windows.net/%s/", tid)
+		}
+		return fmt.Sprintf("https://%s/%s/", host, tid)
+	}
+	return fmt.Sprintf("https://%s/%s/v2.0", host, tid)
+}
+
+// expandProvider derives the JWKS URL, discovery URL and issuer of an auth0, okta or keycloak entry
+func expandProvider(idp IDPConfig) (IDPConfig, error) {
+	if idp.URL != "" {
+		return idp, fmt.Errorf("url is generated for type %q and must not be set", idp.Type)
+	}
+	if idp.Domain == "" {
+		return idp, fmt.Errorf("domain is required for type %q", idp.Type)
+	}
+
+	// Accept "tenant.auth0.com", "https://tenant.auth0.com/" and, for old Keycloak, "sso.example.com/auth"
+	domain := strings.TrimSuffix(idp.Domain, "/")
+	domain = strings.TrimPrefix(strings.TrimPrefix(domain, "https://"), "http://")
+	base := "https://" + domain
+
+	var issuer, jwksURL, tokenURL string
+	switch idp.Type {
+	case TypeAuth0:
+		// Auth0 issuers end with a slash
+		issuer = base + "/"
+		jwksURL = base + "/.well-known/jwks.json"
+		tokenURL = base + "/oauth/token"
+	case TypeOkta:
+		as := idp.AuthorizationServer
+		if as == "" {
+			as = "default"
+		}
+		issuer = base + "/oauth2/" + as
+		jwksURL = issuer + "/v1/keys"
+		tokenURL = issuer + "/v1/token"
+	case TypeKeycloak:
+		if idp.Realm == "" {
+			return idp, fmt.Errorf("realm is required for type %q", TypeKeycloak)
+		}
+		issuer = base + "/realms/" + idp.Realm
+		jwksURL = issuer + "/protocol/openid-connect/certs"
+		tokenURL = issuer + "/protocol/openid-connect/token"
+	}
+
+	idp.Source = SourceHTTP
+	idp.URL = jwksURL
+	if idp.Issuer == "" {
+		idp.Issuer = issuer
+	}
+	if idp.DiscoveryURL == "" {
+		idp.DiscoveryURL = strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	}
+	if idp.RefreshInterval <= 0 && len(idp.Schedules) == 0 {
+		idp.RefreshInterval = presetRefreshInterval
+	}
+	if idp.ClientCredentials != nil && idp.ClientCredentials.TokenURL == "" {
+		cc := *idp.ClientCredentials
+		cc.TokenURL = tokenURL
+		idp.ClientCredentials = &cc
+	}
+	return idp, nil
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Configuration profiles selectable with profile:
+const (
+	ProfileDev    = "dev"
+	ProfileProd   = "prod"
+	ProfileStrict = "strict"
+)
+
+// FeaturesConfig overrides single behaviors of the selected profile
+type FeaturesConfig struct {
+	StrictTLS      *bool `yaml:"strict_tls"`      // IDP, discovery and token URLs must use https
+	DebugEndpoints *bool `yaml:"debug_endpoints"` // serve /debug/vars, /debug/manager and /dashboard, allow faults
+	VerboseHeaders *bool `yaml:"verbose_headers"` // X-Key-Count, X-Max-Keys, X-Last-Updated and X-IDP-Count on JWKS responses
+	FailClosed     *bool `yaml:"fail_closed"`     // /ready fails while any IDP has no keys
+}
+
+// Features are the resolved behaviors of a profile and its overrides
+type Features struct {
+	StrictTLS      bool
+	DebugEndpoints bool
+	VerboseHeaders bool
+	FailClosed     bool
+}
+
+// profiles maps each profile to its behaviors; no profile behaves like dev
+var profiles = map[string]Features{
+	ProfileDev:    {DebugEndpoints: true, VerboseHeaders: true},
+	ProfileProd:   {StrictTLS: true},
+	ProfileStrict: {StrictTLS: true, FailClosed: true},
+}
+
+// GetFeatures returns the behaviors of the configured profile with the features overrides applied
+func (c *Config) GetFeatures() Features {
+	f, ok := profiles[c.Profile]
+	if !ok {
+		f = profiles[ProfileDev]
+	}
+	override := func(dst *bool, src *bool) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	override(&f.StrictTLS, c.Features.StrictTLS)
+	override(&f.DebugEndpoints, c.Features.DebugEndpoints)
+	override(&f.VerboseHeaders, c.Features.VerboseHeaders)
+	override(&f.FailClosed, c.Features.FailClosed)
+	return f
+}
+
+// validateProfile checks the profile name and the settings the selected features forbid
+func (c *Config) validateProfile() error {
+	if _, ok := profiles[c.Profile]; c.Profile != "" && !ok {
+		return fmt.Errorf("unknown profile %q (use %s, %s or %s)", c.Profile, ProfileDev, ProfileProd, ProfileStrict)
+	}
+
+	f := c.GetFeatures()
+	if c.Faults != nil && !f.DebugEndpoints {
+		return fmt.Errorf("faults require debug_endpoints, which profile %q disables", c.Profile)
+	}
+	if c.Recording != nil && c.Recording.Mode == RecordingReplay && !f.DebugEndpoints {
+		return fmt.Errorf("recording replay requires debug_endpoints, which profile %q disables", c.Profile)
+	}
+	if f.StrictTLS {
+		for _, idp := range c.IDPs {
+			urls := []string{idp.DiscoveryURL}
+			if idp.GetSource() == SourceHTTP {
+				urls = append(urls, idp.URL)
+			}
+			if idp.ClientCredentials != nil {
+				urls = append(urls, idp.ClientCredentials.TokenURL)
+			}
+			for _, raw := range urls {
+				if u, err := url.Parse(raw); raw != "" && (err != nil || u.Scheme != "https") {
+					return fmt.Errorf("idp %q: strict_tls requires https, got %q", idp.Name, raw)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import "fmt"
+
+// PublishConfig writes the key sets to files on every change, for consumers that sync files
+type PublishConfig struct {
+	Directory string            `yaml:"directory"` // local directory, written atomically
+	S3        *S3PublishConfig  `yaml:"s3"`
+	Git       *GitPublishConfig `yaml:"git"`
+}
+
+// S3PublishConfig uploads the files to an S3 bucket
+type S3PublishConfig struct {
+	Bucket   string `yaml:"bucket"`
+	Prefix   string `yaml:"prefix"`   // key prefix, e.g. "jwks/"
+	Region   string `yaml:"region"`   // default: AWS_REGION
+	Endpoint string `yaml:"endpoint"` // S3-compatible endpoint (default: https://<bucket>.s3.<region>.amazonaws.com)
+}
+
+// GitPublishConfig commits the files to a local clone of a git repository
+type GitPublishConfig struct {
+	Repository string `yaml:"repository"` // path of the clone
+	Path       string `yaml:"path"`       // directory inside the repository (default: root)
+	Push       bool   `yaml:"push"`       // push after committing
+	Remote     string `yaml:"remote"`     // default: origin
+	Branch     string `yaml:"branch"`     // branch to push (default: current branch)
+}
+
+// GetRemote returns the remote to push to with a default of "origin"
+func (c *GitPublishConfig) GetRemote() string {
+	if c.Remote == "" {
+		return "origin"
+	}
+	return c.Remote
+}
+
+func (c *PublishConfig) validate() error {
+	if c.Directory == "" && c.S3 == nil && c.Git == nil {
+		return fmt.Errorf("at least one of directory, s3 or git is required")
+	}
+	if c.S3 != nil && c.S3.Bucket == "" {
+		return fmt.Errorf("s3.bucket is required")
+	}
+	if c.Git != nil && c.Git.Repository == "" {
+		return fmt.Errorf("git.repository is required")
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import "fmt"
+
+// Recording modes
+const (
+	RecordingRecord = "record" // fetch from upstream and save every response
+	RecordingReplay = "replay" // serve fetches from saved responses, never calling upstream
+)
+
+// RecordingConfig saves upstream responses, or serves fetches from them, to reproduce what an IDP
+// returned: deterministic local development, or a parsing bug replayed from a postmortem recording.
+type RecordingConfig struct {
+	Mode      string `yaml:"mode"`      // record or replay
+	Directory string `yaml:"directory"` // recordings, one subdirectory per IDP (default: recordings)
+}
+
+// GetDirectory returns the recordings directory with a default of "recordings"
+func (c *RecordingConfig) GetDirectory() string {
+	if c.Directory == "" {
+		return "recordings"
+	}
+	return c.Directory
+}
+
+func (c *RecordingConfig) validate() error {
+	if c.Mode != RecordingRecord && c.Mode != RecordingReplay {
+		return fmt.Errorf("mode must be %s or %s", RecordingRecord, RecordingReplay)
+	}
+	return nil
+}
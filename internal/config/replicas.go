@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ReplicasConfig compares the merged key set served by this replica with the other replicas
+type ReplicasConfig struct {
+	Peers    []string `yaml:"peers"`    // base URLs of the other replicas, e.g. http://10.0.1.12:8080
+	Service  string   `yaml:"service"`  // base URL whose host resolves to every replica, e.g. a headless Kubernetes service
+	Interval int      `yaml:"interval"` // seconds between checks (default: 60)
+	Grace    int      `yaml:"grace"`    // seconds a replica may serve other keys before it counts as divergent (default: 300)
+}
+
+// GetInterval returns the check interval with a default of 60 seconds if not set
+func (c *ReplicasConfig) GetInterval() time.Duration {
+	if c.Interval <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+// GetGrace returns how long a difference is tolerated with a default of 5 minutes if not set
+func (c *ReplicasConfig) GetGrace() time.Duration {
+	if c.Grace <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(c.Grace) * time.Second
+}
+
+func (c *ReplicasConfig) validate() error {
+	if len(c.Peers) == 0 && c.Service == "" {
+		return fmt.Errorf("peers or service is required")
+	}
+	if c.Interval < 0 || c.Grace < 0 {
+		return fmt.Errorf("interval and grace must not be negative")
+	}
+	for _, peer := range append(c.Peers[:len(c.Peers):len(c.Peers)], c.Service) {
+		if peer == "" {
+			continue
+		}
+		u, err := url.Parse(peer)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q must be an http or https base URL", peer)
+		}
+	}
+	return nil
+}
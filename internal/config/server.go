@@ -0,0 +1,377 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route groups that can be exposed per listener
+const (
+	RoutesJWKS     = "jwks"     // /.well-known/jwks.json, /jwks, /jwks/{idp}, /.well-known/webfinger, /export, serve_path aliases
+	RoutesStatus   = "status"   // /status, /status/{idp}, /slo, /slo/{idp}, /graphql, /dashboard, /replicas
+	RoutesHealth   = "health"   // /health, /ready, /version
+	RoutesToken    = "token"    // POST /token/{idp}
+	RoutesAdmin    = "admin"    // POST /sign, GET /debug/manager, GET /admin/config, PUT /admin/state, GET /audit/admin
+	RoutesValidate = "validate" // POST /validate, POST /validate/batch
+	RoutesMetrics  = "metrics"  // GET /debug/vars
+)
+
+// AllRoutes lists every route group
+var AllRoutes = []string{RoutesJWKS, RoutesStatus, RoutesHealth, RoutesToken, RoutesAdmin, RoutesValidate, RoutesMetrics}
+
+// DefaultRoutes are served when a listener doesn't restrict its routes. POST /token/{idp} hands
+// out tokens minted with our client secrets, so a listener has to list token explicitly.
+var DefaultRoutes = []string{RoutesJWKS, RoutesStatus, RoutesHealth, RoutesAdmin, RoutesValidate, RoutesMetrics}
+
+type ServerConfig struct {
+	Port       int      `yaml:"port"`
+	Host       string   `yaml:"host"`
+	Listen     string   `yaml:"listen"`      // "tcp://addr", "unix:/path" or "systemd"; default host:port
+	SocketMode string   `yaml:"socket_mode"` // octal permissions for unix sockets, e.g. "0660"
+	Protocols  []string `yaml:"protocols"`   // "http1" and/or "h2c" (default: http1)
+	Routes     []string `yaml:"routes"`      // route groups of the single listener (default: all but token)
+
+	Socket SocketOptions `yaml:"socket"` // dual stack, SO_REUSEPORT and keep-alive of the TCP socket
+
+	// Listeners replaces host/port/listen with several addresses, each with its own routes and TLS
+	Listeners []ListenerConfig `yaml:"listeners"`
+
+	ShutdownDelay   int `yaml:"shutdown_delay"`   // seconds to keep serving with failing readiness before shutdown
+	ShutdownTimeout int `yaml:"shutdown_timeout"` // seconds to wait for in-flight requests (default: 10)
+
+	StaleIfError  int `yaml:"stale_if_error"`  // seconds caches may serve expired JWKS responses on errors (default: 86400, -1 disables)
+	ColdStartWait int `yaml:"cold_start_wait"` // milliseconds /jwks/{idp} waits for an IDP's first fetch instead of a 404, 0 disables
+
+	// TrustedProxies are the IPs and CIDRs whose Forwarded, X-Forwarded-For and X-Real-IP headers are believed
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	Requests RequestLimits `yaml:"requests"` // bounds on what clients can send, applied before any handler
+
+	Memcached *MemcachedConfig `yaml:"memcached"` // read-only memcached text protocol listener for legacy clients
+
+	ErrorPages *ErrorPagesConfig `yaml:"error_pages"` // templates for the error responses this service generates
+
+	Consumers *ConsumersConfig `yaml:"consumers"` // who calls the key endpoints, on /status/consumers
+}
+
+// MemcachedConfig serves the merged and per-IDP key sets to clients that can only issue memcached gets
+type MemcachedConfig struct {
+	Listen         string `yaml:"listen"`          // same forms as server.listen, e.g. "127.0.0.1:11211"
+	SocketMode     string `yaml:"socket_mode"`     // octal permissions for a unix socket
+	MaxConnections int    `yaml:"max_connections"` // concurrent connections (default: 256)
+	IdleTimeout    int    `yaml:"idle_timeout"`    // seconds a connection may wait between commands (default: 300)
+}
+
+// GetMaxConnections returns the connection limit with a default of 256 if not set
+func (c *MemcachedConfig) GetMaxConnections() int {
+	if c.MaxConnections <= 0 {
+		return 256
+	}
+	return c.MaxConnections
+}
+
+// GetIdleTimeout returns how long an idle connection is kept with a default of 300 seconds if not set
+func (c *MemcachedConfig) GetIdleTimeout() time.Duration {
+	if c.IdleTimeout <= 0 {
+		return 300 * time.Second
+	}
+	return time.Duration(c.IdleTimeout) * time.Second
+}
+
+// GetSocketMode returns the unix socket permissions, 0 to keep the default
+func (c *MemcachedConfig) GetSocketMode() os.FileMode {
+	mode, _ := strconv.ParseUint(c.SocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+// RequestLimits protects every listener against oversized and slow requests
+type RequestLimits struct {
+	MaxBodyBytes      int64 `yaml:"max_body_bytes"`      // request body size (default: 1 MiB)
+	MaxHeaderBytes    int   `yaml:"max_header_bytes"`    // request line and headers (default: 32 KiB)
+	MaxHeaderCount    int   `yaml:"max_header_count"`    // header fields (default: 100)
+	ReadHeaderTimeout int   `yaml:"read_header_timeout"` // seconds to receive the headers (default: 5)
+}
+
+// ListenerConfig describes one address the server listens on
+type ListenerConfig struct {
+	Name       string     `yaml:"name"`        // used in logs (default: the address)
+	Listen     string     `yaml:"listen"`      // "host:port", "tcp://host:port", "unix:/path", "systemd" or "systemd:N"
+	SocketMode string     `yaml:"socket_mode"` // octal permissions for unix sockets
+	Protocols  []string   `yaml:"protocols"`   // "http1", "h2c", "http2" (TLS only)
+	Routes     []string   `yaml:"routes"`      // route groups served (default: all)
+	TLS        *TLSConfig `yaml:"tls"`
+
+	Socket SocketOptions `yaml:"socket"` // dual stack, SO_REUSEPORT and keep-alive of the TCP socket
+}
+
+// TLSConfig enables HTTPS on a listener
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"` // require client certificates signed by this CA
+	MinVersion   string `yaml:"min_version"`    // "1.2" (default) or "1.3"
+}
+
+// GetShutdownDelay returns the pre-shutdown drain delay, 0 if not set
+func (c *ServerConfig) GetShutdownDelay() time.Duration {
+	if c.ShutdownDelay <= 0 {
+		return 0
+	}
+	return time.Duration(c.ShutdownDelay) * time.Second
+}
+
+// GetStaleIfError returns the stale-if-error window in seconds with a default of 86400, 0 when disabled
+func (c *ServerConfig) GetStaleIfError() int {
+	if c.StaleIfError < 0 {
+		return 0
+	}
+	if c.StaleIfError == 0 {
+		return 86400
+	}
+	return c.StaleIfError
+}
+
+// GetColdStartWait returns how long /jwks/{idp} waits for an IDP's first fetch, 0 if disabled
+func (c *ServerConfig) GetColdStartWait() time.Duration {
+	if c.ColdStartWait <= 0 {
+		return 0
+	}
+	return time.Duration(c.ColdStartWait) * time.Millisecond
+}
+
+// GetShutdownTimeout returns the shutdown timeout with a default of 10 seconds if not set
+func (c *ServerConfig) GetShutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.ShutdownTimeout) * time.Second
+}
+
+// GetMaxBodyBytes returns the request body limit with a default of 1 MiB
+func (l *RequestLimits) GetMaxBodyBytes() int64 {
+	if l.MaxBodyBytes <= 0 {
+		return 1 << 20
+	}
+	return l.MaxBodyBytes
+}
+
+// GetMaxHeaderBytes returns the request header size limit with a default of 32 KiB
+func (l *RequestLimits) GetMaxHeaderBytes() int {
+	if l.MaxHeaderBytes <= 0 {
+		return 32 << 10
+	}
+	return l.MaxHeaderBytes
+}
+
+// GetMaxHeaderCount returns the header field limit with a default of 100
+func (l *RequestLimits) GetMaxHeaderCount() int {
+	if l.MaxHeaderCount <= 0 {
+		return 100
+	}
+	return l.MaxHeaderCount
+}
+
+// GetReadHeaderTimeout returns how long a client may take to send its headers, 5 seconds by default
+func (l *RequestLimits) GetReadHeaderTimeout() time.Duration {
+	if l.ReadHeaderTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(l.ReadHeaderTimeout) * time.Second
+}
+
+// GetListeners returns the configured listeners, or a single listener built from
+// host/port/listen and routes when none are configured
+func (c *ServerConfig) GetListeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+
+	listen := c.Listen
+	if listen == "" {
+		listen = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	}
+
+	return []ListenerConfig{{
+		Name:       "default",
+		Listen:     listen,
+		SocketMode: c.SocketMode,
+		Protocols:  c.Protocols,
+		Routes:     c.Routes,
+		Socket:     c.Socket,
+	}}
+}
+
+// GetName returns the listener name with the address as default
+func (l *ListenerConfig) GetName() string {
+	if l.Name == "" {
+		return l.Listen
+	}
+	return l.Name
+}
+
+// GetProtocols returns the enabled HTTP protocols, HTTP/1.1 (plus HTTP/2 with TLS) by default
+func (l *ListenerConfig) GetProtocols() []string {
+	if len(l.Protocols) > 0 {
+		return l.Protocols
+	}
+	if l.TLS != nil {
+		return []string{"http1", "http2"}
+	}
+	return []string{"http1"}
+}
+
+// GetRoutes returns the route groups served by the listener, DefaultRoutes by default
+func (l *ListenerConfig) GetRoutes() []string {
+	if len(l.Routes) == 0 {
+		return DefaultRoutes
+	}
+	return l.Routes
+}
+
+// GetSocketMode returns the unix socket permissions, 0 leaves the umask default
+func (l *ListenerConfig) GetSocketMode() os.FileMode {
+	mode, err := strconv.ParseUint(l.SocketMode, 8, 32)
+	if err != nil {
+		return 0
+	}
+	return os.FileMode(mode)
+}
+
+// GetTrustedProxies returns the trusted proxy networks, a single IP as a /32 or /128
+func (c *ServerConfig) GetTrustedProxies() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, entry := range c.TrustedProxies {
+		if prefix, err := parsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// parsePrefix parses a CIDR or a single IP
+func parsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+func (c *ServerConfig) validate() error {
+	if r := c.Requests; r.MaxBodyBytes < 0 || r.MaxHeaderBytes < 0 || r.MaxHeaderCount < 0 || r.ReadHeaderTimeout < 0 {
+		return fmt.Errorf("requests limits must not be negative")
+	}
+
+	for _, entry := range c.TrustedProxies {
+		if _, err := parsePrefix(entry); err != nil {
+			return fmt.Errorf("invalid trusted_proxies entry %q, want an IP or CIDR", entry)
+		}
+	}
+
+	if len(c.Listeners) > 0 && (c.Listen != "" || c.SocketMode != "" || len(c.Protocols) > 0 || len(c.Routes) > 0 || !c.Socket.IsZero()) {
+		return fmt.Errorf("listen, socket_mode, protocols, routes and socket must be set per listener when listeners are configured")
+	}
+
+	seen := make(map[string]bool)
+	for _, l := range c.GetListeners() {
+		if l.Listen == "" {
+			return fmt.Errorf("listener %q: listen is required", l.GetName())
+		}
+		if seen[l.Listen] {
+			return fmt.Errorf("listener %q: address used twice", l.GetName())
+		}
+		seen[l.Listen] = true
+
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("listener %q: %w", l.GetName(), err)
+		}
+	}
+
+	if m := c.Memcached; m != nil {
+		switch {
+		case m.Listen == "":
+			return fmt.Errorf("memcached: listen is required")
+		case seen[m.Listen]:
+			return fmt.Errorf("memcached: address %q is used by an HTTP listener", m.Listen)
+		case m.MaxConnections < 0 || m.IdleTimeout < 0:
+			return fmt.Errorf("memcached: max_connections and idle_timeout must not be negative")
+		}
+		if m.SocketMode != "" {
+			if _, err := strconv.ParseUint(m.SocketMode, 8, 32); err != nil {
+				return fmt.Errorf("memcached: invalid socket_mode %q", m.SocketMode)
+			}
+		}
+	}
+
+	if c.ErrorPages != nil {
+		if err := c.ErrorPages.validate(); err != nil {
+			return fmt.Errorf("error_pages: %w", err)
+		}
+	}
+	if c.Consumers != nil {
+		if err := c.Consumers.validate(); err != nil {
+			return fmt.Errorf("consumers: %w", err)
+		}
+	}
+	return nil
+}
+
+func (l *ListenerConfig) validate() error {
+	if l.SocketMode != "" {
+		if _, err := strconv.ParseUint(l.SocketMode, 8, 32); err != nil {
+			return fmt.Errorf("invalid socket_mode %q", l.SocketMode)
+		}
+	}
+
+	if strings.HasPrefix(l.Listen, "systemd:") {
+		if n, err := strconv.Atoi(strings.TrimPrefix(l.Listen, "systemd:")); err != nil || n < 0 {
+			return fmt.Errorf("invalid systemd socket index in %q", l.Listen)
+		}
+	}
+
+	if err := l.Socket.validate(l.Listen); err != nil {
+		return fmt.Errorf("socket: %w", err)
+	}
+
+	for _, p := range l.Protocols {
+		switch p {
+		case "http1", "h2c":
+		case "http2":
+			if l.TLS == nil {
+				return fmt.Errorf("protocol http2 requires tls, use h2c for cleartext")
+			}
+		default:
+			return fmt.Errorf("unsupported protocol %q (use http1, h2c or http2)", p)
+		}
+	}
+
+	for _, r := range l.Routes {
+		known := false
+		for _, g := range AllRoutes {
+			known = known || r == g
+		}
+		if !known {
+			return fmt.Errorf("unknown route group %q (use %s)", r, strings.Join(AllRoutes, ", "))
+		}
+	}
+
+	if l.TLS != nil {
+		if l.TLS.CertFile == "" || l.TLS.KeyFile == "" {
+			return fmt.Errorf("tls requires cert_file and key_file")
+		}
+		if v := l.TLS.MinVersion; v != "" && v != "1.2" && v != "1.3" {
+			return fmt.Errorf("unsupported tls min_version %q (use 1.2 or 1.3)", v)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SigningConfig enables minting JWTs with local or KMS keys through POST /sign
+type SigningConfig struct {
+	Name     string             `yaml:"name"`     // entry under which the public keys are published (default: signer)
+	Issuer   string             `yaml:"issuer"`   // iss of minted tokens
+	Audience string             `yaml:"audience"` // default aud when the request doesn't set one
+	TTL      int                `yaml:"ttl"`      // default token lifetime in seconds (default: 300)
+	MaxTTL   int                `yaml:"max_ttl"`  // longest lifetime a request may ask for in seconds (default: 3600)
+	Claims   map[string]any     `yaml:"claims"`   // claims added to every token, ${name} expands request values
+	Keys     []SigningKeyConfig `yaml:"keys"`     // the first key signs, all keys are published
+}
+
+// SigningKeyConfig is one signing key, either a PEM file or an AWS KMS key
+type SigningKeyConfig struct {
+	File   string `yaml:"file"`    // PEM private key (PKCS#8, PKCS#1 or SEC 1)
+	KMSKey string `yaml:"kms_key"` // AWS KMS key id, ARN or alias
+	Region string `yaml:"region"`  // AWS region for kms_key (default: AWS_REGION)
+}
+
+// GetName returns the published entry name with a default of "signer"
+func (c *SigningConfig) GetName() string {
+	if c.Name == "" {
+		return "signer"
+	}
+	return c.Name
+}
+
+// GetTTL returns the default token lifetime with a default of 5 minutes if not set
+func (c *SigningConfig) GetTTL() time.Duration {
+	if c.TTL <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(c.TTL) * time.Second
+}
+
+// GetMaxTTL returns the maximum token lifetime with a default of 1 hour if not set
+func (c *SigningConfig) GetMaxTTL() time.Duration {
+	if c.MaxTTL <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.MaxTTL) * time.Second
+}
+
+func (c *SigningConfig) validate(admin AdminConfig, idps []IDPConfig) error {
+	if err := validateName(c.GetName()); err != nil {
+		return err
+	}
+	for _, idp := range idps {
+		if idp.Name == c.GetName() {
+			return fmt.Errorf("name %q is already used by an IDP", c.GetName())
+		}
+	}
+	if c.Issuer == "" {
+		return fmt.Errorf("issuer is required")
+	}
+	if len(c.Keys) == 0 {
+		return fmt.Errorf("at least one key is required")
+	}
+	for i, k := range c.Keys {
+		if (k.File == "") == (k.KMSKey == "") {
+			return fmt.Errorf("key %d: exactly one of file or kms_key is required", i)
+		}
+	}
+	if c.GetTTL() > c.GetMaxTTL() {
+		return fmt.Errorf("ttl must not exceed max_ttl")
+	}
+	if !admin.Enabled() {
+		return fmt.Errorf("admin.token_file or admin.jwt is required to protect POST /sign")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SLOConfig sets the availability objective fetches of every IDP are reported against on /slo
+type SLOConfig struct {
+	Objective float64 `yaml:"objective"` // successful fetch ratio in percent (default: 99.9)
+	Windows   []int   `yaml:"windows"`   // rolling windows in seconds (default: 1h, 1d, 7d, 30d)
+}
+
+// GetObjective returns the availability objective with a default of 99.9 if not set
+func (c *SLOConfig) GetObjective() float64 {
+	if c.Objective <= 0 {
+		return 99.9
+	}
+	return c.Objective
+}
+
+// GetWindows returns the reporting windows with defaults of 1 hour, 1 day, 7 days and 30 days
+func (c *SLOConfig) GetWindows() []time.Duration {
+	if len(c.Windows) == 0 {
+		return []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+	}
+	windows := make([]time.Duration, len(c.Windows))
+	for i, w := range c.Windows {
+		windows[i] = time.Duration(w) * time.Second
+	}
+	return windows
+}
+
+func (c *SLOConfig) validate() error {
+	if c.Objective < 0 || c.Objective > 100 {
+		return fmt.Errorf("objective must be between 0 and 100")
+	}
+	for _, w := range c.Windows {
+		if w < 60 || w > 30*24*3600 {
+			return fmt.Errorf("windows must be between 60 seconds and 30 days")
+		}
+	}
+	return nil
+}
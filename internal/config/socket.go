@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SocketOptions tunes the TCP socket of a listener
+type SocketOptions struct {
+	DualStack         *bool `yaml:"dual_stack"`          // accept IPv4 on IPv6 wildcard addresses (default: true)
+	ReusePort         bool  `yaml:"reuse_port"`          // SO_REUSEPORT, lets the next binary bind before this one exits
+	KeepAliveIdle     int   `yaml:"keep_alive_idle"`     // seconds idle before keep-alive probes (default: 15, -1 disables keep-alive)
+	KeepAliveInterval int   `yaml:"keep_alive_interval"` // seconds between probes (default: 15)
+	KeepAliveCount    int   `yaml:"keep_alive_count"`    // unanswered probes before the connection is dropped (default: 9)
+}
+
+// IsZero reports whether no option is set
+func (o SocketOptions) IsZero() bool {
+	return o == SocketOptions{}
+}
+
+// GetDualStack reports whether IPv6 sockets also accept IPv4 connections
+func (o SocketOptions) GetDualStack() bool {
+	return o.DualStack == nil || *o.DualStack
+}
+
+// GetKeepAlive returns the TCP keep-alive settings, zero values keep the system defaults
+func (o SocketOptions) GetKeepAlive() (enabled bool, idle, interval time.Duration, count int) {
+	if o.KeepAliveIdle < 0 {
+		return false, 0, 0, 0
+	}
+	return true, time.Duration(o.KeepAliveIdle) * time.Second, time.Duration(o.KeepAliveInterval) * time.Second, o.KeepAliveCount
+}
+
+func (o SocketOptions) validate(listen string) error {
+	if o.IsZero() {
+		return nil
+	}
+	if strings.HasPrefix(listen, "unix:") || strings.HasPrefix(listen, "systemd") {
+		return fmt.Errorf("socket options only apply to TCP listeners")
+	}
+	if o.KeepAliveInterval < 0 || o.KeepAliveCount < 0 {
+		return fmt.Errorf("keep_alive_interval and keep_alive_count must not be negative")
+	}
+	return nil
+}
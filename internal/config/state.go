@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kiquetal/go-idp-caller/internal/secrets"
+)
+
+// DeclareIDPs parses a declared IDP list, {"idps": [...]} in JSON or YAML, and returns it the way
+// Load would: presets expanded, validated together with the rest of c and client defaults applied
+func (c *Config) DeclareIDPs(data []byte) ([]IDPConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := secrets.Decrypt(&doc); err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+
+	var declared struct {
+		IDPs *[]IDPConfig `yaml:"idps"`
+	}
+	if doc.Kind != 0 {
+		if err := doc.Decode(&declared); err != nil {
+			return nil, err
+		}
+	}
+	// An empty list removes every IDP, a missing one is more likely a mistake
+	if declared.IDPs == nil {
+		return nil, fmt.Errorf("idps is required")
+	}
+
+	next := *c
+	next.IDPs = *declared.IDPs
+	if err := next.expandPresets(); err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	if err := c.checkDeclared(next.IDPs); err != nil {
+		return nil, err
+	}
+	next.applyClientDefaults()
+	return next.IDPs, nil
+}
+
+// checkDeclared rejects declared IDPs changing what is only applied at startup: serve_path
+// routes are registered when the listeners start. It also keeps the admin API from running
+// commands or reading files on the host, and from sending its cloud or cluster credentials
+// elsewhere: exec and file sources, client_secret_file, the KMS endpoint and region and the
+// Kubernetes namespace and kind are only accepted as the configuration file has them.
+func (c *Config) checkDeclared(idps []IDPConfig) error {
+	for i, idp := range idps {
+		var onDisk IDPConfig
+		for _, configured := range c.IDPs {
+			if configured.Name == idp.Name {
+				onDisk = configured
+			}
+		}
+		if idp.ServePath != onDisk.ServePath {
+			return fmt.Errorf("idps[%d] (%s): serve_path can't be changed without a restart", i, idp.Name)
+		}
+
+		source := idp.GetSource()
+		if source == SourceExec || source == SourceFile {
+			if onDisk.GetSource() != source || idp.Path != onDisk.Path || !slices.Equal(idp.Command, onDisk.Command) {
+				return fmt.Errorf("idps[%d] (%s): %s sources can only be declared as the configuration file has them", i, idp.Name, source)
+			}
+		}
+		if source == SourceAWSKMS || source == SourceGCPKMS {
+			if onDisk.GetSource() != source || kmsTarget(idp) != kmsTarget(onDisk) {
+				return fmt.Errorf("idps[%d] (%s): kms.endpoint and kms.region can only be declared as the configuration file has them", i, idp.Name)
+			}
+		}
+		if source == SourceKubernetes {
+			if onDisk.GetSource() != source || kubernetesTarget(idp) != kubernetesTarget(onDisk) {
+				return fmt.Errorf("idps[%d] (%s): kubernetes.namespace and kubernetes.kind can only be declared as the configuration file has them", i, idp.Name)
+			}
+		}
+		if file := secretFile(idp); file != "" && file != secretFile(onDisk) {
+			return fmt.Errorf("idps[%d] (%s): client_credentials.client_secret_file can only be declared as the configuration file has it", i, idp.Name)
+		}
+	}
+	return nil
+}
+
+// kmsTarget returns where a KMS source sends its signed requests: the endpoint, or the region the
+// default endpoint is built from
+func kmsTarget(idp IDPConfig) [2]string {
+	if idp.KMS == nil {
+		return [2]string{}
+	}
+	return [2]string{idp.KMS.Endpoint, idp.KMS.Region}
+}
+
+// kubernetesTarget returns the namespace and kind a Kubernetes source reads with the pod's token
+func kubernetesTarget(idp IDPConfig) [2]string {
+	if idp.Kubernetes == nil {
+		return [2]string{}
+	}
+	return [2]string{idp.Kubernetes.Namespace, idp.Kubernetes.GetKind()}
+}
+
+// secretFile returns the client_secret_file of an IDP, empty when it has none
+func secretFile(idp IDPConfig) string {
+	if idp.ClientCredentials == nil {
+		return ""
+	}
+	return idp.ClientCredentials.ClientSecretFile
+}
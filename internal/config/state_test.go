@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// onDiskConfig loads a configuration file with IDPs of every source the declared state restricts
+func onDiskConfig(t *testing.T) *Config {
+	t.Helper()
+	t.Setenv("AWS_REGION", "")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `
+idps:
+  - name: web
+    url: https://idp.example.com/jwks
+    refresh_interval: 3600
+  - name: aws
+    source: aws-kms
+    kms: {region: eu-west-1, alias_prefix: alias/jwks-}
+    refresh_interval: 3600
+  - name: gcp
+    source: gcp-kms
+    kms: {key_ring: projects/p/locations/l/keyRings/r}
+    refresh_interval: 3600
+  - name: kube
+    source: kubernetes
+    kubernetes: {namespace: auth, label_selector: app=signer}
+    refresh_interval: 3600
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestDeclareIDPsPinsCredentialTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		idp  string
+		want string // empty when accepted
+	}{
+		{name: "kms unchanged", idp: `{name: aws, source: aws-kms, kms: {region: eu-west-1, alias_prefix: alias/other-}, refresh_interval: 3600}`},
+		{name: "aws kms endpoint", idp: `{name: aws, source: aws-kms, kms: {region: eu-west-1, endpoint: "https://attacker.example/", alias_prefix: alias/jwks-}, refresh_interval: 3600}`,
+			want: "kms.endpoint and kms.region can only be declared as the configuration file has them"},
+		{name: "aws kms region", idp: `{name: aws, source: aws-kms, kms: {region: "attacker.example/#", alias_prefix: alias/jwks-}, refresh_interval: 3600}`,
+			want: "kms.endpoint and kms.region"},
+		{name: "gcp kms endpoint", idp: `{name: gcp, source: gcp-kms, kms: {key_ring: projects/p/locations/l/keyRings/r, endpoint: "https://attacker.example/"}, refresh_interval: 3600}`,
+			want: "kms.endpoint and kms.region"},
+		{name: "new kms IDP", idp: `{name: new, source: gcp-kms, kms: {key_ring: projects/p/locations/l/keyRings/r}, refresh_interval: 3600}`,
+			want: "kms.endpoint and kms.region"},
+		{name: "http IDP switched to kms", idp: `{name: web, source: aws-kms, kms: {region: eu-west-1, alias_prefix: alias/jwks-}, refresh_interval: 3600}`,
+			want: "kms.endpoint and kms.region"},
+		{name: "kubernetes unchanged", idp: `{name: kube, source: kubernetes, kubernetes: {namespace: auth, label_selector: app=other}, refresh_interval: 3600}`},
+		{name: "kubernetes namespace", idp: `{name: kube, source: kubernetes, kubernetes: {namespace: kube-system, label_selector: app=signer}, refresh_interval: 3600}`,
+			want: "kubernetes.namespace and kubernetes.kind can only be declared as the configuration file has them"},
+		{name: "kubernetes kind", idp: `{name: kube, source: kubernetes, kubernetes: {namespace: auth, kind: configmap, label_selector: app=signer}, refresh_interval: 3600}`,
+			want: "kubernetes.namespace and kubernetes.kind"},
+		{name: "exec source", idp: `{name: web, source: exec, command: [/bin/sh, -c, id], refresh_interval: 3600}`,
+			want: "exec sources can only be declared as the configuration file has them"},
+		{name: "http URL", idp: `{name: web, url: https://other.example.com/jwks, refresh_interval: 3600}`},
+	}
+	cfg := onDiskConfig(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cfg.DeclareIDPs([]byte("idps: [" + tt.idp + "]"))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("refused: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Fatalf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
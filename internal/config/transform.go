@@ -0,0 +1,27 @@
+package config
+
+import "fmt"
+
+// TransformConfig rewrites incomplete or oversized upstream key metadata before keys are stored
+type TransformConfig struct {
+	KidPrefix  string `yaml:"kid_prefix"`  // prepended to every kid
+	KidSuffix  string `yaml:"kid_suffix"`  // appended to every kid
+	DefaultAlg string `yaml:"default_alg"` // alg for keys published without one, e.g. RS256
+	DefaultUse string `yaml:"default_use"` // use for keys published without one: sig or enc
+	DropX5c    bool   `yaml:"drop_x5c"`    // remove x5c certificate chains to shrink responses
+}
+
+// Kid returns the published kid for an upstream kid; keys without a kid keep none
+func (c *TransformConfig) Kid(kid string) string {
+	if kid == "" {
+		return ""
+	}
+	return c.KidPrefix + kid + c.KidSuffix
+}
+
+func (c *TransformConfig) validate() error {
+	if c.DefaultUse != "" && c.DefaultUse != "sig" && c.DefaultUse != "enc" {
+		return fmt.Errorf("default_use must be sig or enc")
+	}
+	return nil
+}
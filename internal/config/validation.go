@@ -0,0 +1,57 @@
+package config
+
+import "time"
+
+// ValidationConfig is the default token validation policy; IDPs override it field by field
+type ValidationConfig struct {
+	ClockSkew      int      `yaml:"clock_skew"`      // tolerance for exp, nbf and iat in seconds (default: 60)
+	RequiredClaims []string `yaml:"required_claims"` // claims every token must carry, e.g. sub
+	MaxTokenAge    int      `yaml:"max_token_age"`   // maximum seconds since iat, 0 disables
+	Audiences      []string `yaml:"audiences"`       // accepted aud values for IDPs without their own
+	MaxBatchSize   int      `yaml:"max_batch_size"`  // tokens per POST /validate/batch request (default: 1000)
+	CacheSize      int      `yaml:"cache_size"`      // cached valid results (default: 10000, -1 disables)
+	CacheTTL       int      `yaml:"cache_ttl"`       // longest a result is cached in seconds (default: 60)
+}
+
+// GetCacheSize returns the result cache size with a default of 10000, 0 when disabled
+func (c *ValidationConfig) GetCacheSize() int {
+	if c.CacheSize < 0 {
+		return 0
+	}
+	if c.CacheSize == 0 {
+		return 10000
+	}
+	return c.CacheSize
+}
+
+// GetCacheTTL returns the result cache lifetime with a default of 60 seconds if not set
+func (c *ValidationConfig) GetCacheTTL() time.Duration {
+	if c.CacheTTL <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.CacheTTL) * time.Second
+}
+
+// GetMaxBatchSize returns the batch limit with a default of 1000 if not set
+func (c *ValidationConfig) GetMaxBatchSize() int {
+	if c.MaxBatchSize <= 0 {
+		return 1000
+	}
+	return c.MaxBatchSize
+}
+
+// GetClockSkew returns the clock skew with a default of 60 seconds if not set
+func (c *ValidationConfig) GetClockSkew() time.Duration {
+	if c.ClockSkew <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.ClockSkew) * time.Second
+}
+
+// GetMaxTokenAge returns the maximum token age, 0 if the check is disabled
+func (c *ValidationConfig) GetMaxTokenAge() time.Duration {
+	if c.MaxTokenAge <= 0 {
+		return 0
+	}
+	return time.Duration(c.MaxTokenAge) * time.Second
+}
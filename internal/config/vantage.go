@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// VantageConfig is an extra egress route an IDP's keys are fetched through after every successful fetch,
+// to catch CDN edges or regions serving a different key set than the one we got
+type VantageConfig struct {
+	Name  string     `yaml:"name"`
+	Proxy string     `yaml:"proxy"` // http, https or socks5 proxy URL, empty connects directly
+	DNS   *DNSConfig `yaml:"dns"`   // resolution for this route, e.g. another region's resolver (default: the IDP's dns)
+}
+
+// validateVantages checks the vantages of an http IDP
+func (c *IDPConfig) validateVantages() error {
+	if len(c.Vantages) == 0 {
+		return nil
+	}
+	if c.GetSource() != SourceHTTP {
+		return fmt.Errorf("vantages only apply to source %q", SourceHTTP)
+	}
+
+	seen := make(map[string]bool, len(c.Vantages))
+	for i, v := range c.Vantages {
+		if err := validateName(v.Name); err != nil {
+			return fmt.Errorf("vantage %d: %w", i, err)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("vantage %q: duplicate name", v.Name)
+		}
+		seen[v.Name] = true
+
+		if v.Proxy != "" {
+			u, err := url.Parse(v.Proxy)
+			if err != nil || u.Host == "" {
+				return fmt.Errorf("vantage %q: invalid proxy %q", v.Name, v.Proxy)
+			}
+			switch u.Scheme {
+			case "http", "https", "socks5":
+			default:
+				return fmt.Errorf("vantage %q: unsupported proxy scheme %q (use http, https or socks5)", v.Name, u.Scheme)
+			}
+		}
+		if v.DNS != nil {
+			if err := v.DNS.validate(); err != nil {
+				return fmt.Errorf("vantage %q: dns: %w", v.Name, err)
+			}
+		}
+	}
+	return nil
+}
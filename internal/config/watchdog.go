@@ -0,0 +1,27 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// WatchdogConfig samples goroutines and heap periodically to catch leaks in soak tests
+type WatchdogConfig struct {
+	Interval       int `yaml:"interval"`        // seconds between samples (default: 60)
+	GoroutineLimit int `yaml:"goroutine_limit"` // log an error above this many goroutines, 0 disables
+}
+
+// GetInterval returns the sampling interval with a default of 60 seconds if not set
+func (c *WatchdogConfig) GetInterval() time.Duration {
+	if c.Interval <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+func (c *WatchdogConfig) validate() error {
+	if c.Interval < 0 || c.GoroutineLimit < 0 {
+		return fmt.Errorf("interval and goroutine_limit must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// queueSize bounds events waiting to be sent; further events are dropped with a warning
+const queueSize = 256
+
+// sendTimeout bounds delivery of one event to one sink
+const sendTimeout = 10 * time.Second
+
+// Sink delivers serialized events, keyed by IDP name
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, key string, payload []byte) error
+}
+
+// target is a sink with the format its events are encoded in
+type target struct {
+	sink   Sink
+	format string
+}
+
+// Bus sends events to every sink whenever an IDP's keys change, or it fails or recovers
+type Bus struct {
+	targets []target
+	queue   chan Event
+	logger  *slog.Logger
+}
+
+// New creates a bus for the configured sinks and subscribes it to manager changes.
+// Must be called before the updaters start.
+func New(cfg config.EventsConfig, manager *jwks.Manager, logger *slog.Logger) *Bus {
+	b := &Bus{
+		queue:  make(chan Event, queueSize),
+		logger: logger,
+	}
+	if cfg.NATS != nil {
+		b.targets = append(b.targets, target{&natsSink{config: *cfg.NATS}, cfg.NATS.GetFormat()})
+	}
+	if cfg.Kafka != nil {
+		b.targets = append(b.targets, target{newKafkaSink(*cfg.Kafka), cfg.Kafka.GetFormat()})
+	}
+
+	manager.OnChange(func(c jwks.Change) {
+		for _, e := range FromChange(c) {
+			select {
+			case b.queue <- e:
+			default:
+				logger.Warn("Event queue full, dropping event", "idp", e.Subject, "type", e.Type)
+			}
+		}
+	})
+	return b
+}
+
+// Run sends queued events until ctx is done
+func (b *Bus) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-b.queue:
+			b.send(ctx, e)
+		}
+	}
+}
+
+// send delivers one event to every sink in its format, failures are logged and not retried
+func (b *Bus) send(ctx context.Context, e Event) {
+	payloads := make(map[string][]byte, 2)
+	for _, t := range b.targets {
+		sink := t.sink
+		payload, ok := payloads[t.format]
+		if !ok {
+			var err error
+			if payload, err = e.encode(t.format); err != nil {
+				b.logger.Error("Failed to encode event", "sink", sink.Name(), "idp", e.Subject, "type", e.Type, "error", err)
+				continue
+			}
+			payloads[t.format] = payload
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := sink.Send(sendCtx, e.Subject, payload)
+		cancel()
+		if err != nil {
+			b.logger.Error("Failed to publish event", "sink", sink.Name(), "idp", e.Subject, "type", e.Type, "error", err)
+			continue
+		}
+		b.logger.Debug("Published event", "sink", sink.Name(), "idp", e.Subject, "type", e.Type, "id", e.ID)
+	}
+}
+
+// secret returns the file content when file is set, the inline value otherwise
+func secret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// errUnexpectedReply is returned when a broker answers outside its protocol
+var errUnexpectedReply = errors.New("unexpected reply")
@@ -0,0 +1,135 @@
+// Package events publishes key-change events to message buses so downstream services
+// can invalidate their caches. Events are CloudEvents 1.0 envelopes unless a sink asks for plain JSON.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// Event types, the CloudEvents type is the name prefixed with TypePrefix
+const (
+	TypeKeyAdded     = "key.added"     // a key was published for the first time
+	TypeKeyRemoved   = "key.removed"   // a key is no longer published
+	TypeIDPFailed    = "idp.failed"    // the IDP started failing, previous keys are kept
+	TypeIDPRecovered = "idp.recovered" // the IDP succeeded again after failing
+)
+
+// TypePrefix namespaces event types in the CloudEvents type attribute
+const TypePrefix = "com.github.kiquetal.idp-caller."
+
+// Event is a CloudEvents 1.0 event in structured JSON mode
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`  // /jwks/<idp>
+	Type            string    `json:"type"`    // TypePrefix + one of the event types
+	Subject         string    `json:"subject"` // IDP name
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            EventData `json:"data"`
+}
+
+// EventData is the payload of every event type
+type EventData struct {
+	IDP      string `json:"idp"`
+	Kid      string `json:"kid,omitempty"` // key.added and key.removed
+	Kty      string `json:"kty,omitempty"`
+	Alg      string `json:"alg,omitempty"`
+	KeyCount int    `json:"key_count"`       // keys published after the change
+	Error    string `json:"error,omitempty"` // idp.failed
+}
+
+// plainEvent is an event in the json format: its data with the type, id and time, no envelope
+type plainEvent struct {
+	Type string    `json:"type"` // one of the event types, without TypePrefix
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	EventData
+}
+
+// encode serializes the event in format, config.EventFormatCloudEvents or config.EventFormatJSON
+func (e Event) encode(format string) ([]byte, error) {
+	if format == config.EventFormatJSON {
+		return json.Marshal(plainEvent{
+			Type:      strings.TrimPrefix(e.Type, TypePrefix),
+			ID:        e.ID,
+			Time:      e.Time,
+			EventData: e.Data,
+		})
+	}
+	return json.Marshal(e)
+}
+
+// newEvent creates an event of the given type for an IDP
+func newEvent(eventType string, c jwks.Change, data EventData) Event {
+	data.IDP = c.IDP
+	if c.JWKS != nil {
+		data.KeyCount = len(c.JWKS.Keys)
+	}
+	return Event{
+		SpecVersion:     "1.0",
+		ID:              newID(),
+		Source:          "/jwks/" + c.IDP,
+		Type:            TypePrefix + eventType,
+		Subject:         c.IDP,
+		Time:            c.Time.UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// FromChange converts a manager change into events: failure or recovery first, then one per added or removed key
+func FromChange(c jwks.Change) []Event {
+	var events []Event
+	switch {
+	case c.Error != "" && c.PreviousError == "":
+		events = append(events, newEvent(TypeIDPFailed, c, EventData{Error: c.Error}))
+	case c.Error == "" && c.PreviousError != "":
+		events = append(events, newEvent(TypeIDPRecovered, c, EventData{}))
+	}
+
+	if !c.KeysChanged() {
+		return events
+	}
+	before, after := keysByKid(c.Previous), keysByKid(c.JWKS)
+	for _, k := range keysOf(c.JWKS) {
+		if _, ok := before[k.Kid]; !ok {
+			events = append(events, newEvent(TypeKeyAdded, c, EventData{Kid: k.Kid, Kty: k.Kty, Alg: k.Alg}))
+		}
+	}
+	for _, k := range keysOf(c.Previous) {
+		if _, ok := after[k.Kid]; !ok {
+			events = append(events, newEvent(TypeKeyRemoved, c, EventData{Kid: k.Kid, Kty: k.Kty, Alg: k.Alg}))
+		}
+	}
+	return events
+}
+
+func keysOf(keySet *jwks.JWKS) []jwks.JWK {
+	if keySet == nil {
+		return nil
+	}
+	return keySet.Keys
+}
+
+func keysByKid(keySet *jwks.JWKS) map[string]jwks.JWK {
+	keys := make(map[string]jwks.JWK)
+	for _, k := range keysOf(keySet) {
+		keys[k.Kid] = k
+	}
+	return keys
+}
+
+// newID returns a random event id
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
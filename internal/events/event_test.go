@@ -0,0 +1,141 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+var changeTime = time.Date(2026, 1, 5, 10, 30, 0, 0, time.FixedZone("CET", 3600))
+
+func keySet(kids ...string) *jwks.JWKS {
+	keys := make([]jwks.JWK, len(kids))
+	for i, kid := range kids {
+		keys[i] = jwks.JWK{Kid: kid, Kty: "RSA", Alg: "RS256"}
+	}
+	return &jwks.JWKS{Keys: keys}
+}
+
+func TestFromChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		change jwks.Change
+		want   []string // type and kid of each event
+	}{
+		{"first fetch", jwks.Change{JWKS: keySet("a", "b")}, []string{"key.added a", "key.added b"}},
+		{"rotation", jwks.Change{Previous: keySet("a", "b"), JWKS: keySet("b", "c")}, []string{"key.added c", "key.removed a"}},
+		{"unchanged", jwks.Change{Previous: keySet("a"), JWKS: keySet("a")}, nil},
+		{"failure", jwks.Change{Previous: keySet("a"), JWKS: keySet("a"), Error: "timeout"}, []string{"idp.failed "}},
+		{"still failing", jwks.Change{Previous: keySet("a"), JWKS: keySet("a"), Error: "timeout", PreviousError: "timeout"}, nil},
+		{"recovery with new key", jwks.Change{Previous: keySet("a"), JWKS: keySet("a", "b"), PreviousError: "timeout"},
+			[]string{"idp.recovered ", "key.added b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change.IDP, tt.change.Time = "auth0", changeTime
+			var got []string
+			for _, e := range FromChange(tt.change) {
+				got = append(got, e.Type[len(TypePrefix):]+" "+e.Data.Kid)
+				if e.Subject != "auth0" || e.Data.IDP != "auth0" || e.Source != "/jwks/auth0" {
+					t.Errorf("event %s for subject %q, idp %q, source %q", e.Type, e.Subject, e.Data.IDP, e.Source)
+				}
+				if e.Data.KeyCount != len(tt.change.JWKS.Keys) {
+					t.Errorf("event %s key_count %d, want %d", e.Type, e.Data.KeyCount, len(tt.change.JWKS.Keys))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FromChange() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	events := FromChange(jwks.Change{IDP: "auth0", Time: changeTime, Previous: keySet("a"), JWKS: keySet("a", "key-2026")})
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+	e := events[0]
+
+	tests := []struct {
+		format string
+		want   map[string]any
+	}{
+		{config.EventFormatCloudEvents, map[string]any{
+			"specversion":     "1.0",
+			"id":              e.ID,
+			"source":          "/jwks/auth0",
+			"subject":         "auth0",
+			"type":            "com.github.kiquetal.idp-caller.key.added",
+			"time":            "2026-01-05T09:30:00Z",
+			"datacontenttype": "application/json",
+			"data":            map[string]any{"idp": "auth0", "kid": "key-2026", "kty": "RSA", "alg": "RS256", "key_count": 2.0},
+		}},
+		{config.EventFormatJSON, map[string]any{
+			"type":      "key.added",
+			"id":        e.ID,
+			"time":      "2026-01-05T09:30:00Z",
+			"idp":       "auth0",
+			"kid":       "key-2026",
+			"kty":       "RSA",
+			"alg":       "RS256",
+			"key_count": 2.0,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			payload, err := e.encode(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(payload, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("encode(%s) = %s", tt.format, payload)
+			}
+		})
+	}
+}
+
+// recordingSink keeps the payloads it is sent
+type recordingSink struct {
+	name     string
+	keys     []string
+	payloads []string
+}
+
+func (s *recordingSink) Name() string { return s.name }
+
+func (s *recordingSink) Send(_ context.Context, key string, payload []byte) error {
+	s.keys = append(s.keys, key)
+	s.payloads = append(s.payloads, string(payload))
+	return nil
+}
+
+func TestBusSendsEachSinkItsFormat(t *testing.T) {
+	cloud, plain := &recordingSink{name: "cloud"}, &recordingSink{name: "plain"}
+	b := &Bus{
+		targets: []target{{cloud, config.EventFormatCloudEvents}, {plain, config.EventFormatJSON}},
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	e := FromChange(jwks.Change{IDP: "auth0", Time: changeTime, JWKS: keySet("a")})[0]
+	b.send(context.Background(), e)
+
+	wantCloud, _ := e.encode(config.EventFormatCloudEvents)
+	wantPlain, _ := e.encode(config.EventFormatJSON)
+	if !reflect.DeepEqual(cloud.payloads, []string{string(wantCloud)}) || !reflect.DeepEqual(cloud.keys, []string{"auth0"}) {
+		t.Errorf("cloudevents sink got %q keyed %q", cloud.payloads, cloud.keys)
+	}
+	if !reflect.DeepEqual(plain.payloads, []string{string(wantPlain)}) || !reflect.DeepEqual(plain.keys, []string{"auth0"}) {
+		t.Errorf("json sink got %q keyed %q", plain.payloads, plain.keys)
+	}
+}
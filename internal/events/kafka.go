@@ -0,0 +1,86 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// kafkaSink produces to a Kafka topic through a Kafka REST Proxy (v2 API).
+// Records are keyed by IDP name, so events of one IDP stay ordered within a partition.
+type kafkaSink struct {
+	config config.KafkaConfig
+	client *http.Client
+}
+
+func newKafkaSink(cfg config.KafkaConfig) *kafkaSink {
+	return &kafkaSink{
+		config: cfg,
+		client: &http.Client{Timeout: sendTimeout},
+	}
+}
+
+func (s *kafkaSink) Name() string {
+	return "kafka"
+}
+
+func (s *kafkaSink) Send(ctx context.Context, key string, payload []byte) error {
+	body, err := json.Marshal(map[string]any{
+		"records": []map[string]any{{
+			"key":   key,
+			"value": json.RawMessage(payload),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(s.config.RestProxy, "/") + "/topics/" + url.PathEscape(s.config.GetTopic())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if s.config.Username != "" {
+		password, err := secret(s.config.Password, s.config.PasswordFile)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(s.config.Username, password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	// The proxy answers 200 even when individual records fail
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(msg, &result); err == nil {
+		for _, o := range result.Offsets {
+			if o.ErrorCode != nil {
+				return fmt.Errorf("record rejected: %s", o.Error)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+func TestKafkaSink(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		reply   string
+		wantErr string
+	}{
+		{"accepted", http.StatusOK, `{"offsets":[{"partition":0,"offset":42}]}`, ""},
+		{"record rejected", http.StatusOK, `{"offsets":[{"error_code":40403,"error":"Schema not found"}]}`, "record rejected: Schema not found"},
+		{"proxy error", http.StatusNotFound, `{"error_code":40401,"message":"Topic not found"}`, "unexpected status code 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct {
+				path, contentType, accept, user, password string
+				body                                      []byte
+			}
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got.path = r.URL.EscapedPath()
+				got.contentType = r.Header.Get("Content-Type")
+				got.accept = r.Header.Get("Accept")
+				got.user, got.password, _ = r.BasicAuth()
+				got.body, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/vnd.kafka.v2+json")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.reply)
+			}))
+			defer proxy.Close()
+
+			sink := newKafkaSink(config.KafkaConfig{
+				RestProxy:    proxy.URL + "/",
+				Topic:        "idp/keys",
+				Username:     "idp-caller",
+				PasswordFile: passwordFile,
+			})
+			err := sink.Send(context.Background(), "auth0", []byte(`{"type":"key.added"}`))
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Send() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if got.path != "/topics/idp%2Fkeys" {
+				t.Errorf("path %q", got.path)
+			}
+			if got.contentType != "application/vnd.kafka.json.v2+json" || got.accept != "application/vnd.kafka.v2+json" {
+				t.Errorf("Content-Type %q, Accept %q", got.contentType, got.accept)
+			}
+			if got.user != "idp-caller" || got.password != "s3cret" {
+				t.Errorf("basic auth %q:%q", got.user, got.password)
+			}
+			var body struct {
+				Records []struct {
+					Key   string          `json:"key"`
+					Value json.RawMessage `json:"value"`
+				} `json:"records"`
+			}
+			if err := json.Unmarshal(got.body, &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Records) != 1 || body.Records[0].Key != "auth0" || string(body.Records[0].Value) != `{"type":"key.added"}` {
+				t.Errorf("records %s", got.body)
+			}
+		})
+	}
+}
+
+func TestKafkaSinkWithoutAuth(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Error("basic auth sent without a username")
+		}
+		io.WriteString(w, `{"offsets":[{"partition":0,"offset":1}]}`)
+	}))
+	defer proxy.Close()
+
+	sink := newKafkaSink(config.KafkaConfig{RestProxy: proxy.URL})
+	if err := sink.Send(context.Background(), "auth0", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+}
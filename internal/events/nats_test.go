@@ -0,0 +1,111 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// natsPublish is what the fake server received
+type natsPublish struct {
+	connect map[string]any
+	subject string
+	payload string
+}
+
+// fakeNATS accepts one connection, reads CONNECT, PUB and PING and answers with reply.
+// It returns the server address and a channel receiving what was published.
+func fakeNATS(t *testing.T, reply string) (string, <-chan natsPublish) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	published := make(chan natsPublish, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+
+		var p natsPublish
+		defer func() { published <- p }()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			verb, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+			switch verb {
+			case "CONNECT":
+				json.Unmarshal([]byte(args), &p.connect)
+			case "PUB":
+				subject, size, _ := strings.Cut(args, " ")
+				n, _ := strconv.Atoi(size)
+				payload := make([]byte, n+2) // and CRLF
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				p.subject, p.payload = subject, string(payload[:n])
+			case "PING":
+				io.WriteString(conn, reply)
+				return
+			}
+		}
+	}()
+	return ln.Addr().String(), published
+}
+
+func TestNATSSink(t *testing.T) {
+	addr, published := fakeNATS(t, "PONG\r\n")
+	sink := &natsSink{config: config.NATSConfig{URL: "nats://" + addr, Subject: "keys.changed", Token: "s3cret"}}
+	if err := sink.Send(context.Background(), "auth0", []byte(`{"type":"key.added"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	p := <-published
+	if p.subject != "keys.changed" || p.payload != `{"type":"key.added"}` {
+		t.Errorf("published %q to %q", p.payload, p.subject)
+	}
+	if p.connect["auth_token"] != "s3cret" || p.connect["name"] != "idp-caller" || p.connect["verbose"] != false {
+		t.Errorf("CONNECT %v", p.connect)
+	}
+}
+
+func TestNATSSinkURLCredentials(t *testing.T) {
+	addr, published := fakeNATS(t, "PONG\r\n")
+	sink := &natsSink{config: config.NATSConfig{URL: "nats://idp:pa55@" + addr}}
+	if err := sink.Send(context.Background(), "auth0", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	p := <-published
+	if p.subject != "idp.keys" {
+		t.Errorf("published to %q, want the default subject", p.subject)
+	}
+	if p.connect["user"] != "idp" || p.connect["pass"] != "pa55" {
+		t.Errorf("CONNECT %v", p.connect)
+	}
+}
+
+func TestNATSSinkServerError(t *testing.T) {
+	addr, _ := fakeNATS(t, "-ERR 'Authorization Violation'\r\n")
+	sink := &natsSink{config: config.NATSConfig{URL: "nats://" + addr}}
+	err := sink.Send(context.Background(), "auth0", []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Fatalf("Send() error = %v, want the server error", err)
+	}
+}
@@ -0,0 +1,718 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Request is a GraphQL request as posted in JSON
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is the result of an operation. Data is absent when the request failed validation.
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// null is the data of an execution that failed as a whole, which unlike a failed validation has data
+var null = json.RawMessage("null")
+
+// Error is a request or field error
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Path      []any      `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Location is a position in the query document
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%d:%d", l.Line, l.Column)
+}
+
+// Operation is a validated operation of a request, with its variables coerced
+type Operation struct {
+	schema    *Schema
+	doc       *document
+	op        *operation
+	variables map[string]any
+}
+
+// Prepare parses and validates a request, returning an *Error when it can't be executed
+func (s *Schema) Prepare(req Request) (*Operation, error) {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return nil, &Error{Message: err.Error()}
+	}
+
+	var op *operation
+	for _, candidate := range doc.operations {
+		if req.OperationName == "" && len(doc.operations) > 1 {
+			return nil, &Error{Message: "operationName is required when the document has several operations"}
+		}
+		if req.OperationName == "" || candidate.name == req.OperationName {
+			op = candidate
+			break
+		}
+	}
+	if op == nil {
+		return nil, &Error{Message: fmt.Sprintf("unknown operation %q", req.OperationName)}
+	}
+
+	root := s.query
+	switch op.kind {
+	case "mutation":
+		return nil, &Error{Message: "mutations are not supported"}
+	case "subscription":
+		if s.subscription == nil {
+			return nil, &Error{Message: "subscriptions are not supported"}
+		}
+		root = s.subscription
+	}
+
+	o := &Operation{schema: s, doc: doc, op: op, variables: make(map[string]any)}
+	for _, v := range op.variables {
+		if _, ok := s.named(v.typ); !ok || !s.leaf(v.typ) || s.enums[baseType(v.typ)] != nil {
+			return nil, &Error{Message: fmt.Sprintf("variable $%s: %s is not an input type", v.name, v.typ)}
+		}
+		value, given := req.Variables[v.name]
+		if !given && v.hasDefault {
+			value, given = v.def, true
+		}
+		if !given {
+			if strings.HasSuffix(v.typ, "!") {
+				return nil, &Error{Message: fmt.Sprintf("variable $%s of type %s is required", v.name, v.typ)}
+			}
+			continue
+		}
+		coerced, err := coerce(value, v.typ)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("variable $%s: %v", v.name, err)}
+		}
+		o.variables[v.name] = coerced
+	}
+
+	walked := 0
+	if err := o.validate(root, op.selections, 0, &walked); err != nil {
+		return nil, err
+	}
+	if op.kind == "subscription" {
+		fields, _ := o.collect(root, op.selections, nil)
+		if len(fields) != 1 {
+			return nil, &Error{Message: "a subscription must select exactly one field"}
+		}
+	}
+	return o, nil
+}
+
+// Subscription reports whether the operation is a subscription
+func (o *Operation) Subscription() bool {
+	return o.op.kind == "subscription"
+}
+
+// validate checks the selections against the type recursively, depth bounding fragment expansion.
+// walked counts the selections visited across the whole operation, which fragments spread at several
+// levels would otherwise multiply exponentially.
+func (o *Operation) validate(obj *Object, sels []*selection, depth int, walked *int) error {
+	if depth > maxDepth {
+		return &Error{Message: fmt.Sprintf("selections are nested deeper than %d levels", maxDepth)}
+	}
+	fields, err := o.collect(obj, sels, walked)
+	if err != nil {
+		return err
+	}
+	for _, cf := range fields {
+		first := cf.sels[0]
+		f := cf.field
+		if f == nil {
+			continue // __typename
+		}
+		for _, sel := range cf.sels {
+			if sel.name != first.name {
+				return errorAt(sel, fmt.Sprintf("fields %q and %q both answer %q", first.name, sel.name, cf.key))
+			}
+			if _, err := o.arguments(f, sel); err != nil {
+				return errorAt(sel, err.Error())
+			}
+		}
+		var sub []*selection
+		for _, sel := range cf.sels {
+			sub = append(sub, sel.selections...)
+		}
+		if o.schema.leaf(f.Type) {
+			if len(sub) > 0 {
+				return errorAt(first, fmt.Sprintf("field %q of type %s can't have a selection set", first.name, f.Type))
+			}
+			continue
+		}
+		if len(sub) == 0 {
+			return errorAt(first, fmt.Sprintf("field %q of type %s needs a selection set", first.name, f.Type))
+		}
+		if err := o.validate(o.schema.objects[baseType(f.Type)], sub, depth+1, walked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectedField is the selections of an object sharing a response key, merged
+type collectedField struct {
+	key   string
+	field *Field // nil for __typename
+	sels  []*selection
+}
+
+// maxSelections bounds the selections collected for an object, fragments expanded
+const maxSelections = 1000
+
+// maxOperationSelections bounds the selections validated in a whole operation, fragments expanded
+const maxOperationSelections = 10000
+
+// maxResultValues bounds the fields and list items completed in an execution
+const maxResultValues = 1000000
+
+// meta-fields available on every type, or on Query only
+var (
+	schemaField = &Field{Name: "__schema", Type: "__Schema!", Resolve: func(p Params) (any, error) { return p.Source, nil }}
+	typeField   = &Field{Name: "__type", Type: "__Type", Args: []Arg{{Name: "name", Type: "String!"}},
+		Resolve: func(p Params) (any, error) {
+			s := p.Source.(*Schema)
+			name := p.Args["name"].(string)
+			if _, ok := s.named(name); !ok || name != baseType(name) {
+				return nil, nil
+			}
+			return introType{s, name}, nil
+		}}
+)
+
+// collect applies directives and expands fragments, grouping the fields of an object by response key.
+// walked, when not nil, counts the selections visited against maxOperationSelections.
+func (o *Operation) collect(obj *Object, sels []*selection, walked *int) ([]*collectedField, error) {
+	var fields []*collectedField
+	byKey := make(map[string]*collectedField)
+	visited := 0
+	var walk func(sels []*selection, visiting []string) error
+	walk = func(sels []*selection, visiting []string) error {
+		for _, sel := range sels {
+			// Fragments spread several times multiply the selections
+			if visited++; visited > maxSelections {
+				return errorAt(sel, fmt.Sprintf("the query selects more than %d fields of one object", maxSelections))
+			}
+			if walked != nil {
+				if *walked++; *walked > maxOperationSelections {
+					return errorAt(sel, fmt.Sprintf("the query selects more than %d fields in all, fragments expanded", maxOperationSelections))
+				}
+			}
+			include, err := o.included(sel)
+			if err != nil {
+				return errorAt(sel, err.Error())
+			}
+			if !include {
+				continue
+			}
+
+			if sel.spread != "" {
+				frag := o.doc.fragments[sel.spread]
+				if frag == nil {
+					return errorAt(sel, fmt.Sprintf("unknown fragment %q", sel.spread))
+				}
+				for _, name := range visiting {
+					if name == frag.name {
+						return errorAt(sel, fmt.Sprintf("fragment %q spreads itself", frag.name))
+					}
+				}
+				if err := o.condition(obj, frag.on, sel); err != nil {
+					return err
+				}
+				if err := walk(frag.selections, append(visiting, frag.name)); err != nil {
+					return err
+				}
+				continue
+			}
+			if sel.inline {
+				if sel.on != "" {
+					if err := o.condition(obj, sel.on, sel); err != nil {
+						return err
+					}
+				}
+				if err := walk(sel.selections, visiting); err != nil {
+					return err
+				}
+				continue
+			}
+
+			var f *Field
+			switch {
+			case sel.name == "__typename":
+			case sel.name == "__schema" && obj == o.schema.query:
+				f = schemaField
+			case sel.name == "__type" && obj == o.schema.query:
+				f = typeField
+			default:
+				if f = obj.field(sel.name); f == nil {
+					return errorAt(sel, fmt.Sprintf("type %s has no field %q", obj.Name, sel.name))
+				}
+			}
+			key := sel.responseKey()
+			if cf := byKey[key]; cf != nil {
+				cf.sels = append(cf.sels, sel)
+				continue
+			}
+			cf := &collectedField{key: key, field: f, sels: []*selection{sel}}
+			byKey[key] = cf
+			fields = append(fields, cf)
+		}
+		return nil
+	}
+	return fields, walk(sels, nil)
+}
+
+// condition checks a fragment's type condition; every type is an object, so only its own name applies
+func (o *Operation) condition(obj *Object, on string, sel *selection) error {
+	if on == obj.Name {
+		return nil
+	}
+	if _, ok := o.schema.named(on); !ok {
+		return errorAt(sel, fmt.Sprintf("unknown type %q", on))
+	}
+	return errorAt(sel, fmt.Sprintf("a fragment on %s can't apply to %s", on, obj.Name))
+}
+
+// included evaluates @skip and @include
+func (o *Operation) included(sel *selection) (bool, error) {
+	for _, d := range sel.directives {
+		if d.name != "skip" && d.name != "include" {
+			return false, fmt.Errorf("unknown directive @%s", d.name)
+		}
+		raw, ok := d.args["if"]
+		if !ok || len(d.args) != 1 {
+			return false, fmt.Errorf("@%s takes a single argument if", d.name)
+		}
+		v, err := coerce(o.substitute(raw), "Boolean!")
+		if err != nil {
+			return false, fmt.Errorf("@%s(if:): %v", d.name, err)
+		}
+		if v.(bool) == (d.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// arguments coerces the arguments of a selection, applying defaults
+func (o *Operation) arguments(f *Field, sel *selection) (map[string]any, error) {
+	for name := range sel.args {
+		known := false
+		for _, a := range f.Args {
+			known = known || a.Name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("field %q has no argument %q", f.Name, name)
+		}
+	}
+	args := make(map[string]any, len(f.Args))
+	for _, a := range f.Args {
+		raw, given := sel.args[a.Name]
+		if v, ok := raw.(variable); ok {
+			raw, given = o.variables[string(v)]
+			if _, defined := o.definedVariable(string(v)); !defined {
+				return nil, fmt.Errorf("variable $%s is not defined", v)
+			}
+		}
+		if !given {
+			if a.Default != nil {
+				args[a.Name] = a.Default
+			} else if strings.HasSuffix(a.Type, "!") {
+				return nil, fmt.Errorf("argument %q of type %s is required", a.Name, a.Type)
+			}
+			continue
+		}
+		v, err := coerce(o.substitute(raw), a.Type)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %v", a.Name, err)
+		}
+		args[a.Name] = v
+	}
+	return args, nil
+}
+
+func (o *Operation) definedVariable(name string) (variableDef, bool) {
+	for _, v := range o.op.variables {
+		if v.name == name {
+			return v, true
+		}
+	}
+	return variableDef{}, false
+}
+
+// substitute replaces the variables in a literal with their values
+func (o *Operation) substitute(v any) any {
+	switch v := v.(type) {
+	case variable:
+		return o.variables[string(v)]
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = o.substitute(item)
+		}
+		return out
+	}
+	return v
+}
+
+// coerce converts an input value, from a literal or decoded JSON, to the Go value of a scalar type:
+// string, int, float64 or bool, or a []any of them for lists
+func coerce(v any, typ string) (any, error) {
+	base, nonNull := strings.CutSuffix(typ, "!")
+	if v == nil {
+		if nonNull {
+			return nil, fmt.Errorf("expected %s, got null", typ)
+		}
+		return nil, nil
+	}
+	if inner, ok := strings.CutPrefix(base, "["); ok {
+		inner = strings.TrimSuffix(inner, "]")
+		items, ok := v.([]any)
+		if !ok {
+			items = []any{v} // a single value is a list of one
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			c, err := coerce(item, inner)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	}
+
+	switch base {
+	case "String":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case int:
+			return strconv.Itoa(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatFloat(v, 'f', -1, 64), nil
+			}
+		}
+	case "Int":
+		switch v := v.(type) {
+		case int:
+			return v, nil
+		case float64: // from JSON
+			if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+	case "Float":
+		switch v := v.(type) {
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, got %s", typ, describe(v))
+}
+
+func describe(v any) string {
+	switch v := v.(type) {
+	case enumValue:
+		return string(v)
+	case string:
+		return strconv.Quote(v)
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprint(v)
+}
+
+func errorAt(sel *selection, message string) *Error {
+	return &Error{Message: message, Locations: []Location{sel.loc}}
+}
+
+// Execute runs a query operation against the root value, which resolvers of Query fields get as Source
+func (o *Operation) Execute(ctx context.Context, root any) *Response {
+	e := &executor{op: o, ctx: ctx}
+	data, ok := e.object(o.schema.query, root, o.op.selections, nil)
+	if !ok || e.values > maxResultValues {
+		data = null
+	}
+	return &Response{Data: data, Errors: e.errors}
+}
+
+// Subscribe starts a subscription operation, returning a response per event of the source stream.
+// The channel is closed when the stream ends or ctx is done.
+func (o *Operation) Subscribe(ctx context.Context, root any) (<-chan *Response, error) {
+	fields, err := o.collect(o.schema.subscription, o.op.selections, nil)
+	if err != nil {
+		return nil, err
+	}
+	cf := fields[0]
+	args, err := o.arguments(cf.field, cf.sels[0])
+	if err != nil {
+		return nil, errorAt(cf.sels[0], err.Error())
+	}
+	source, err := cf.field.Subscribe(Params{Context: ctx, Source: root, Args: args})
+	if err != nil {
+		return nil, errorAt(cf.sels[0], err.Error())
+	}
+
+	var sub []*selection
+	for _, sel := range cf.sels {
+		sub = append(sub, sel.selections...)
+	}
+	responses := make(chan *Response)
+	go func() {
+		defer close(responses)
+		for {
+			var event any
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-source:
+				if !ok {
+					return
+				}
+				event = v
+			}
+
+			e := &executor{op: o, ctx: ctx}
+			value, ok := e.complete(cf.field.Type, event, sub, []any{cf.key}, cf.sels[0])
+			var data any = null
+			if ok && e.values <= maxResultValues {
+				data = &orderedMap{keys: []string{cf.key}, values: []any{value}}
+			}
+			select {
+			case responses <- &Response{Data: data, Errors: e.errors}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return responses, nil
+}
+
+// executor runs one execution, collecting field errors
+type executor struct {
+	op     *Operation
+	ctx    context.Context
+	errors []*Error
+	values int // completed so far, against maxResultValues
+}
+
+func (e *executor) fail(sel *selection, path []any, message string) {
+	e.errors = append(e.errors, &Error{Message: message, Locations: []Location{sel.loc}, Path: append([]any{}, path...)})
+}
+
+// object resolves the selected fields of an object. ok is false when a non-null field is null because
+// of an error, which makes the object null in turn.
+func (e *executor) object(obj *Object, source any, sels []*selection, path []any) (any, bool) {
+	fields, err := e.op.collect(obj, sels, nil)
+	if err != nil {
+		// Prepare validated the selections
+		panic(err)
+	}
+	out := &orderedMap{}
+	for _, cf := range fields {
+		sel := cf.sels[0]
+		fieldPath := append(path[:len(path):len(path)], cf.key)
+		if cf.field == nil {
+			out.add(cf.key, obj.Name)
+			continue
+		}
+
+		args, _ := e.op.arguments(cf.field, sel)
+		src := source
+		if cf.field == schemaField || cf.field == typeField {
+			src = e.op.schema
+		}
+		value, err := cf.field.Resolve(Params{Context: e.ctx, Source: src, Args: args})
+		if err != nil {
+			e.fail(sel, fieldPath, err.Error())
+			if strings.HasSuffix(cf.field.Type, "!") {
+				return nil, false
+			}
+			out.add(cf.key, nil)
+			continue
+		}
+
+		var sub []*selection
+		for _, s := range cf.sels {
+			sub = append(sub, s.selections...)
+		}
+		completed, ok := e.complete(cf.field.Type, value, sub, fieldPath, sel)
+		if !ok {
+			return nil, false
+		}
+		out.add(cf.key, completed)
+	}
+	return out, true
+}
+
+// complete converts a resolved value to its result for the type. ok is false when the value is null
+// because of an error already reported, for the nearest nullable parent to become null.
+func (e *executor) complete(typ string, value any, sels []*selection, path []any, sel *selection) (any, bool) {
+	if base, nonNull := strings.CutSuffix(typ, "!"); nonNull {
+		result, ok := e.completeValue(base, value, sels, path, sel)
+		if ok && result == nil {
+			e.fail(sel, path, fmt.Sprintf("non-null field %q resolved to null", sel.name))
+			return nil, false
+		}
+		return result, ok
+	}
+
+	result, ok := e.completeValue(typ, value, sels, path, sel)
+	if !ok {
+		return nil, true
+	}
+	return result, true
+}
+
+func (e *executor) completeValue(typ string, value any, sels []*selection, path []any, sel *selection) (any, bool) {
+	// Past the budget the data is dropped as a whole, reporting it once
+	if e.values++; e.values > maxResultValues {
+		if e.values == maxResultValues+1 {
+			e.fail(sel, path, fmt.Sprintf("the response has more than %d values", maxResultValues))
+		}
+		return nil, false
+	}
+	if isNull(value) {
+		return nil, true
+	}
+
+	if inner, ok := strings.CutPrefix(typ, "["); ok {
+		inner = strings.TrimSuffix(inner, "]")
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.fail(sel, path, fmt.Sprintf("expected a list for %s", typ))
+			return nil, false
+		}
+		items := make([]any, rv.Len())
+		for i := range items {
+			item, ok := e.complete(inner, rv.Index(i).Interface(), sels, append(path[:len(path):len(path)], i), sel)
+			if !ok {
+				return nil, false
+			}
+			items[i] = item
+		}
+		return items, true
+	}
+
+	if obj := e.op.schema.objects[typ]; obj != nil {
+		return e.object(obj, value, sels, path)
+	}
+
+	result, err := serialize(typ, value)
+	if err != nil {
+		e.fail(sel, path, err.Error())
+		return nil, false
+	}
+	return result, true
+}
+
+// isNull reports whether a resolved value is nil, including nil pointers and maps; nil slices are empty lists
+func isNull(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// serialize converts a leaf value to its JSON result
+func serialize(typ string, v any) (any, error) {
+	rv := reflect.ValueOf(v)
+	switch typ {
+	case "Int":
+		switch {
+		case rv.CanInt():
+			return rv.Int(), nil
+		case rv.CanUint():
+			return rv.Uint(), nil
+		}
+	case "Float":
+		switch {
+		case rv.CanFloat():
+			return rv.Float(), nil
+		case rv.CanInt():
+			return float64(rv.Int()), nil
+		}
+	case "Boolean":
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	default: // String, ID and enums
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+		if s, ok := v.(fmt.Stringer); ok {
+			return s.String(), nil
+		}
+	}
+	return nil, fmt.Errorf("can't serialize %T as %s", v, typ)
+}
+
+// orderedMap is a result object, encoded with its fields in selection order
+type orderedMap struct {
+	keys   []string
+	values []any
+}
+
+func (m *orderedMap) add(key string, value any) {
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,362 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testIDP struct {
+	name string
+	kind string
+	keys []testKey
+}
+
+type testKey struct {
+	kid  string
+	size int
+}
+
+var testIDPs = []*testIDP{
+	{name: "corp", kind: "OIDC", keys: []testKey{{"a", 2048}, {"b", 256}}},
+	{name: "partner", kind: "SAML"},
+	{name: "legacy", kind: "OIDC", keys: []testKey{{"c", 1024}, {"broken", 0}}},
+}
+
+// testSchema serves testIDPs; Subscription.events streams the IDPs sent on events
+func testSchema(t *testing.T, events <-chan any) *Schema {
+	t.Helper()
+	query := &Object{Name: "Query", Fields: []*Field{
+		{Name: "idps", Type: "[IDP!]!", Args: []Arg{{Name: "limit", Type: "Int", Default: 2}},
+			Resolve: func(p Params) (any, error) {
+				return testIDPs[:min(p.Args["limit"].(int), len(testIDPs))], nil
+			}},
+		{Name: "idp", Type: "IDP", Args: []Arg{{Name: "name", Type: "String!"}},
+			Resolve: func(p Params) (any, error) {
+				for _, idp := range testIDPs {
+					if idp.name == p.Args["name"] {
+						return idp, nil
+					}
+				}
+				return (*testIDP)(nil), nil
+			}},
+		{Name: "fail", Type: "String", Resolve: func(Params) (any, error) { return nil, errors.New("upstream unavailable") }},
+		{Name: "failRequired", Type: "String!", Resolve: func(Params) (any, error) { return nil, errors.New("upstream unavailable") }},
+	}}
+	subscription := &Object{Name: "Subscription", Fields: []*Field{
+		{Name: "events", Type: "IDP!", Args: []Arg{{Name: "idp", Type: "String"}},
+			Subscribe: func(Params) (<-chan any, error) { return events, nil }},
+	}}
+	idp := &Object{Name: "IDP", Fields: []*Field{
+		{Name: "name", Type: "String!", Resolve: func(p Params) (any, error) { return p.Source.(*testIDP).name, nil }},
+		{Name: "kind", Type: "Kind", Resolve: func(p Params) (any, error) { return p.Source.(*testIDP).kind, nil }},
+		{Name: "keys", Type: "[Key!]", Resolve: func(p Params) (any, error) { return p.Source.(*testIDP).keys, nil }},
+		{Name: "self", Type: "IDP", Resolve: func(p Params) (any, error) { return p.Source, nil }},
+	}}
+	key := &Object{Name: "Key", Fields: []*Field{
+		{Name: "kid", Type: "ID!", Resolve: func(p Params) (any, error) { return p.Source.(testKey).kid, nil }},
+		{Name: "size", Type: "Int!", Resolve: func(p Params) (any, error) {
+			if k := p.Source.(testKey); k.kid != "broken" {
+				return k.size, nil
+			}
+			return nil, errors.New("unsupported key")
+		}},
+	}}
+	kind := &Enum{Name: "Kind", Values: []string{"OIDC", "SAML"}}
+
+	s, err := NewSchema(query, subscription, []*Object{idp, key}, []*Enum{kind})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// run prepares and executes a query, returning its data in JSON and its errors as message@path
+func run(t *testing.T, s *Schema, req Request) (string, []string) {
+	t.Helper()
+	op, err := s.Prepare(req)
+	if err != nil {
+		t.Fatalf("%s: %v", req.Query, err)
+	}
+	resp := op.Execute(context.Background(), nil)
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []string
+	for _, e := range resp.Errors {
+		errs = append(errs, fmt.Sprintf("%s@%v", e.Message, e.Path))
+	}
+	return string(data), errs
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{``, "the document contains no operation"},
+		{`# only a comment`, "the document contains no operation"},
+		{`{`, "unexpected end of document"},
+		{`{ idps { name }`, "unexpected end of document"},
+		{`{ }`, "empty selection set at 1:3"},
+		{`{ 1 }`, `unexpected "1" at 1:3`},
+		{`{ idps { name ^ } }`, `unexpected character '^' at 1:15`},
+		{`{ idp(name: "corp) { name } }`, "unterminated string at 1:13"},
+		{`{ idp(name: "\q") { name } }`, "invalid escape at 1:13"},
+		{`{ idp(name: """corp) { name } }`, "unterminated block string at 1:13"},
+		{`{ idps(limit: 9999999999) { name } }`, "integer 9999999999 at 1:15 is out of range"},
+		{`{ idps(limit: 1.) { name } }`, "invalid number at 1:15"},
+		{`{ idp(name: "a", name: "b") { name } }`, `argument "name" is given twice`},
+		{`query($n: = "a") { idps { name } }`, `unexpected "=" at 1:11`},
+		{`query($n: String = $m) { idps { name } }`, `unexpected "$"`},
+		{`fragment f on IDP { name } fragment f on IDP { kind } { idps { ...f } }`, `fragment "f" is defined twice`},
+		{`fragment on on IDP { name }`, "a fragment can't be named on"},
+		{`type Query { name: String }`, `unexpected "type" at 1:1`},
+	}
+	s := testSchema(t, nil)
+	for _, tt := range tests {
+		_, err := s.Prepare(Request{Query: tt.query})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected %q, got %v", tt.query, tt.want, err)
+		}
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	tests := []struct {
+		query     string
+		variables map[string]any
+		operation string
+		want      string
+	}{
+		{query: `{ nope }`, want: `type Query has no field "nope"`},
+		{query: `{ idps { name { first } } }`, want: `field "name" of type String! can't have a selection set`},
+		{query: `{ idps }`, want: `field "idps" of type [IDP!]! needs a selection set`},
+		{query: `{ idp { name } }`, want: `argument "name" of type String! is required`},
+		{query: `{ idp(name: "corp", kind: OIDC) { name } }`, want: `field "idp" has no argument "kind"`},
+		{query: `{ idp(name: 1) { name } }`, want: `argument "name": expected String!, got 1`},
+		{query: `{ idps(limit: "2") { name } }`, want: `argument "limit": expected Int, got "2"`},
+		{query: `{ idp(name: $name) { name } }`, want: "variable $name is not defined"},
+		{query: `query($name: String!) { idp(name: $name) { name } }`, want: "variable $name of type String! is required"},
+		{query: `query($name: String!) { idp(name: $name) { name } }`, variables: map[string]any{"name": 5.0}, want: "variable $name: expected String!, got 5"},
+		{query: `query($idp: IDP) { idps { name } }`, want: "variable $idp: IDP is not an input type"},
+		{query: `{ idps { x: name x: kind } }`, want: `fields "name" and "kind" both answer "x"`},
+		{query: `{ idps { ...missing } }`, want: `unknown fragment "missing"`},
+		{query: `fragment a on IDP { ...b } fragment b on IDP { ...a } { idps { ...a } }`, want: `fragment "a" spreads itself`},
+		{query: `fragment k on Key { kid } { idps { ...k } }`, want: "a fragment on Key can't apply to IDP"},
+		{query: `{ idps { ... on Nope { name } } }`, want: `unknown type "Nope"`},
+		{query: `{ idps { name @defer } }`, want: "unknown directive @defer"},
+		{query: `{ idps { name @skip } }`, want: "@skip takes a single argument if"},
+		{query: `mutation { idps { name } }`, want: "mutations are not supported"},
+		{query: `query A { idps { name } } query B { fail }`, want: "operationName is required"},
+		{query: `query A { idps { name } }`, operation: "B", want: `unknown operation "B"`},
+		{query: `subscription { events { name } again: events { name } }`, want: "a subscription must select exactly one field"},
+	}
+	s := testSchema(t, nil)
+	for _, tt := range tests {
+		_, err := s.Prepare(Request{Query: tt.query, Variables: tt.variables, OperationName: tt.operation})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected %q, got %v", tt.query, tt.want, err)
+		}
+	}
+
+	_, err := s.Prepare(Request{Query: "{\n  idps {\n    nope\n  }\n}"})
+	var gqlErr *Error
+	if !errors.As(err, &gqlErr) || !slices.Equal(gqlErr.Locations, []Location{{Line: 3, Column: 5}}) {
+		t.Fatalf("expected an error located at 3:5, got %#v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	nested := func(levels int) string {
+		sel := "name"
+		for range levels {
+			sel = "self { " + sel + " }"
+		}
+		return `{ idp(name: "corp") { ` + sel + ` } }`
+	}
+	var chain strings.Builder
+	for i := range 40 {
+		fmt.Fprintf(&chain, "fragment f%d on IDP { self { ...f%d } }\n", i, i+1)
+	}
+	chain.WriteString("fragment f40 on IDP { name }\n{ idp(name: \"corp\") { ...f0 } }")
+	// Each level spreads the one below ten times, multiplying the selections
+	fanOut := "fragment a on IDP { " + strings.Repeat("name ", 10) + "}\n" +
+		"fragment b on IDP { " + strings.Repeat("...a ", 10) + "}\n" +
+		"fragment c on IDP { " + strings.Repeat("...b ", 10) + "}\n" +
+		"{ idps { ...c } }"
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "nested selections", query: nested(40), want: "selections are nested deeper than 32 levels"},
+		{name: "nested through fragments", query: chain.String(), want: "selections are nested deeper than 32 levels"},
+		{name: "nested values", query: `{ idps(limit: ` + strings.Repeat("[", 40) + "1" + strings.Repeat("]", 40) + `) { name } }`, want: "values are nested deeper than 32 levels"},
+		{name: "fragment fan-out", query: fanOut, want: "the query selects more than 1000 fields of one object"},
+	}
+	s := testSchema(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Prepare(Request{Query: tt.query})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// Just under the limits runs
+	if data, errs := run(t, s, Request{Query: nested(30)}); errs != nil || !strings.Contains(data, `{"name":"corp"}`) {
+		t.Fatalf("30 levels: %s %v", data, errs)
+	}
+}
+
+func TestFragmentBomb(t *testing.T) {
+	// Each level selects the next ten times under distinct aliases, so no object exceeds maxSelections
+	// while the expanded tree has 10^7 fields
+	var bomb strings.Builder
+	bomb.WriteString("{ __schema { types { ...F0 } } }\n")
+	for i := range 7 {
+		fmt.Fprintf(&bomb, "fragment F%d on __Type {", i)
+		for j := range 10 {
+			if i < 6 {
+				fmt.Fprintf(&bomb, " a%d: ofType { ...F%d }", j, i+1)
+			} else {
+				fmt.Fprintf(&bomb, " a%d: name", j)
+			}
+		}
+		bomb.WriteString(" }\n")
+	}
+
+	s := testSchema(t, nil)
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Prepare(Request{Query: bomb.String()})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if want := "the query selects more than 10000 fields in all"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("validation of the fragment bomb did not end")
+	}
+}
+
+func TestExecute(t *testing.T) {
+	s := testSchema(t, nil)
+	query := `query Q($withKeys: Boolean!, $name: String!) {
+		first: idp(name: $name) { ...idpFields keys @include(if: $withKeys) { kid size } }
+		idps { name ... on IDP { kind } }
+		missing: idp(name: "nope") { name }
+		__typename
+	}
+	fragment idpFields on IDP { name kind }`
+
+	data, errs := run(t, s, Request{Query: query, Variables: map[string]any{"withKeys": true, "name": "corp"}})
+	want := `{"first":{"name":"corp","kind":"OIDC","keys":[{"kid":"a","size":2048},{"kid":"b","size":256}]},` +
+		`"idps":[{"name":"corp","kind":"OIDC"},{"name":"partner","kind":"SAML"}],"missing":null,"__typename":"Query"}`
+	if data != want || errs != nil {
+		t.Fatalf("got %s %v\nwant %s", data, errs, want)
+	}
+
+	data, _ = run(t, s, Request{Query: query, OperationName: "Q", Variables: map[string]any{"withKeys": false, "name": "partner"}})
+	if !strings.HasPrefix(data, `{"first":{"name":"partner","kind":"SAML"},`) {
+		t.Fatalf("@include(if: false) kept the keys: %s", data)
+	}
+
+	data, _ = run(t, s, Request{Query: `{ idps(limit: 5) { name } }`})
+	if data != `{"idps":[{"name":"corp"},{"name":"partner"},{"name":"legacy"}]}` {
+		t.Fatalf("limit argument: %s", data)
+	}
+
+	data, _ = run(t, s, Request{Query: `{ idp: __type(name: "IDP") { kind name } list: __type(name: "[IDP]") { name } }`})
+	if data != `{"idp":{"kind":"OBJECT","name":"IDP"},"list":null}` {
+		t.Fatalf("introspection: %s", data)
+	}
+}
+
+func TestExecuteFieldErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		data   string
+		errors []string
+	}{
+		{
+			name:   "nullable field",
+			query:  `{ fail idp(name: "corp") { name } }`,
+			data:   `{"fail":null,"idp":{"name":"corp"}}`,
+			errors: []string{"upstream unavailable@[fail]"},
+		},
+		{
+			// The null of a non-null field goes up to the nearest nullable parent, the key list
+			name:   "non-null field",
+			query:  `{ idp(name: "legacy") { name keys { kid size } } }`,
+			data:   `{"idp":{"name":"legacy","keys":null}}`,
+			errors: []string{"unsupported key@[idp keys 1 size]"},
+		},
+		{
+			name:   "non-null root field",
+			query:  `{ idps { name } failRequired }`,
+			data:   `null`,
+			errors: []string{"upstream unavailable@[failRequired]"},
+		},
+	}
+	s := testSchema(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, errs := run(t, s, Request{Query: tt.query})
+			if data != tt.data || !slices.Equal(errs, tt.errors) {
+				t.Fatalf("got %s %v, want %s %v", data, errs, tt.data, tt.errors)
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	events := make(chan any)
+	s := testSchema(t, events)
+	op, err := s.Prepare(Request{Query: `subscription { changed: events(idp: "corp") { name kind } }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !op.Subscription() {
+		t.Fatal("not a subscription")
+	}
+	responses, err := op.Subscribe(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, idp := range testIDPs[:2] {
+		events <- idp
+		select {
+		case resp := <-responses:
+			data, _ := json.Marshal(resp.Data)
+			if want := fmt.Sprintf(`{"changed":{"name":%q,"kind":%q}}`, idp.name, idp.kind); string(data) != want {
+				t.Fatalf("got %s, want %s", data, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no response for the event")
+		}
+	}
+
+	close(events)
+	select {
+	case _, open := <-responses:
+		if open {
+			t.Fatal("response after the stream ended")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("responses not closed when the stream ended")
+	}
+}
@@ -0,0 +1,232 @@
+package graphql
+
+import (
+	"strings"
+)
+
+// Introspection is served by ordinary object types over these sources
+
+type introType struct {
+	schema *Schema
+	ref    string // type reference, e.g. [Key!]!
+}
+
+type introField struct {
+	schema *Schema
+	field  *Field
+}
+
+type introArg struct {
+	schema *Schema
+	arg    Arg
+}
+
+type introDirective struct {
+	schema      *Schema
+	name        string
+	description string
+	locations   []string
+	args        []Arg
+}
+
+// directives are the ones executed, @skip and @include
+var directives = []introDirective{
+	{nil, "skip", "Skips the selection when the argument is true.", []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		[]Arg{{Name: "if", Type: "Boolean!"}}},
+	{nil, "include", "Includes the selection only when the argument is true.", []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		[]Arg{{Name: "if", Type: "Boolean!"}}},
+}
+
+// kind returns the __TypeKind of the outermost wrapper of the reference
+func (t introType) kind() string {
+	switch {
+	case strings.HasSuffix(t.ref, "!"):
+		return "NON_NULL"
+	case strings.HasPrefix(t.ref, "["):
+		return "LIST"
+	}
+	kind, _ := t.schema.named(t.ref)
+	return kind
+}
+
+func (t introType) ofType() any {
+	switch t.kind() {
+	case "NON_NULL":
+		return introType{t.schema, strings.TrimSuffix(t.ref, "!")}
+	case "LIST":
+		return introType{t.schema, t.ref[1 : len(t.ref)-1]}
+	}
+	return nil
+}
+
+// named returns a field of the named type only, nil for wrappers
+func (t introType) named(fn func() any) any {
+	if k := t.kind(); k == "NON_NULL" || k == "LIST" {
+		return nil
+	}
+	return fn()
+}
+
+func (t introType) description() any {
+	return t.named(func() any {
+		if obj := t.schema.objects[t.ref]; obj != nil {
+			return nullable(obj.Description)
+		}
+		if enum := t.schema.enums[t.ref]; enum != nil {
+			return nullable(enum.Description)
+		}
+		return nil
+	})
+}
+
+func (t introType) fields() any {
+	obj := t.schema.objects[t.ref]
+	if obj == nil || t.kind() != "OBJECT" {
+		return nil
+	}
+	fields := make([]introField, len(obj.Fields))
+	for i, f := range obj.Fields {
+		fields[i] = introField{t.schema, f}
+	}
+	return fields
+}
+
+func (t introType) enumValues() any {
+	enum := t.schema.enums[t.ref]
+	if enum == nil || t.kind() != "ENUM" {
+		return nil
+	}
+	return enum.Values
+}
+
+func introArgs(s *Schema, args []Arg) []introArg {
+	out := make([]introArg, len(args))
+	for i, a := range args {
+		out[i] = introArg{s, a}
+	}
+	return out
+}
+
+// nullable turns an empty string into null
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// resolver adapts a function of the source to a field resolver
+func resolver[T any](fn func(T) any) func(Params) (any, error) {
+	return func(p Params) (any, error) {
+		return fn(p.Source.(T)), nil
+	}
+}
+
+func constant(v any) func(Params) (any, error) {
+	return func(Params) (any, error) { return v, nil }
+}
+
+var includeDeprecated = []Arg{{Name: "includeDeprecated", Type: "Boolean", Default: false}}
+
+func introspectionTypes() []*Object {
+	return []*Object{
+		{Name: "__Schema", Fields: []*Field{
+			{Name: "description", Type: "String", Resolve: constant(nil)},
+			{Name: "types", Type: "[__Type!]!", Resolve: resolver(func(s *Schema) any {
+				names := append(append([]string{}, scalars...), s.order...)
+				types := make([]introType, len(names))
+				for i, name := range names {
+					types[i] = introType{s, name}
+				}
+				return types
+			})},
+			{Name: "queryType", Type: "__Type!", Resolve: resolver(func(s *Schema) any { return introType{s, s.query.Name} })},
+			{Name: "mutationType", Type: "__Type", Resolve: constant(nil)},
+			{Name: "subscriptionType", Type: "__Type", Resolve: resolver(func(s *Schema) any {
+				if s.subscription == nil {
+					return nil
+				}
+				return introType{s, s.subscription.Name}
+			})},
+			{Name: "directives", Type: "[__Directive!]!", Resolve: resolver(func(s *Schema) any {
+				out := make([]introDirective, len(directives))
+				for i, d := range directives {
+					d.schema = s
+					out[i] = d
+				}
+				return out
+			})},
+		}},
+		{Name: "__Type", Fields: []*Field{
+			{Name: "kind", Type: "__TypeKind!", Resolve: resolver(func(t introType) any { return t.kind() })},
+			{Name: "name", Type: "String", Resolve: resolver(func(t introType) any {
+				return t.named(func() any { return t.ref })
+			})},
+			{Name: "description", Type: "String", Resolve: resolver(introType.description)},
+			{Name: "specifiedByURL", Type: "String", Resolve: constant(nil)},
+			{Name: "fields", Type: "[__Field!]", Args: includeDeprecated, Resolve: resolver(introType.fields)},
+			{Name: "interfaces", Type: "[__Type!]", Resolve: resolver(func(t introType) any {
+				if t.kind() != "OBJECT" {
+					return nil
+				}
+				return []introType{}
+			})},
+			{Name: "possibleTypes", Type: "[__Type!]", Resolve: constant(nil)},
+			{Name: "enumValues", Type: "[__EnumValue!]", Args: includeDeprecated, Resolve: resolver(introType.enumValues)},
+			{Name: "inputFields", Type: "[__InputValue!]", Args: includeDeprecated, Resolve: constant(nil)},
+			{Name: "ofType", Type: "__Type", Resolve: resolver(introType.ofType)},
+			{Name: "isOneOf", Type: "Boolean", Resolve: resolver(func(t introType) any {
+				return t.named(func() any { return nil })
+			})},
+		}},
+		{Name: "__Field", Fields: []*Field{
+			{Name: "name", Type: "String!", Resolve: resolver(func(f introField) any { return f.field.Name })},
+			{Name: "description", Type: "String", Resolve: resolver(func(f introField) any { return nullable(f.field.Description) })},
+			{Name: "args", Type: "[__InputValue!]!", Args: includeDeprecated, Resolve: resolver(func(f introField) any {
+				return introArgs(f.schema, f.field.Args)
+			})},
+			{Name: "type", Type: "__Type!", Resolve: resolver(func(f introField) any { return introType{f.schema, f.field.Type} })},
+			{Name: "isDeprecated", Type: "Boolean!", Resolve: constant(false)},
+			{Name: "deprecationReason", Type: "String", Resolve: constant(nil)},
+		}},
+		{Name: "__InputValue", Fields: []*Field{
+			{Name: "name", Type: "String!", Resolve: resolver(func(a introArg) any { return a.arg.Name })},
+			{Name: "description", Type: "String", Resolve: resolver(func(a introArg) any { return nullable(a.arg.Description) })},
+			{Name: "type", Type: "__Type!", Resolve: resolver(func(a introArg) any { return introType{a.schema, a.arg.Type} })},
+			{Name: "defaultValue", Type: "String", Resolve: resolver(func(a introArg) any {
+				if a.arg.Default == nil {
+					return nil
+				}
+				return literal(a.arg.Default)
+			})},
+			{Name: "isDeprecated", Type: "Boolean!", Resolve: constant(false)},
+			{Name: "deprecationReason", Type: "String", Resolve: constant(nil)},
+		}},
+		{Name: "__EnumValue", Fields: []*Field{
+			{Name: "name", Type: "String!", Resolve: resolver(func(v string) any { return v })},
+			{Name: "description", Type: "String", Resolve: constant(nil)},
+			{Name: "isDeprecated", Type: "Boolean!", Resolve: constant(false)},
+			{Name: "deprecationReason", Type: "String", Resolve: constant(nil)},
+		}},
+		{Name: "__Directive", Fields: []*Field{
+			{Name: "name", Type: "String!", Resolve: resolver(func(d introDirective) any { return d.name })},
+			{Name: "description", Type: "String", Resolve: resolver(func(d introDirective) any { return d.description })},
+			{Name: "locations", Type: "[__DirectiveLocation!]!", Resolve: resolver(func(d introDirective) any { return d.locations })},
+			{Name: "args", Type: "[__InputValue!]!", Args: includeDeprecated, Resolve: resolver(func(d introDirective) any {
+				return introArgs(d.schema, d.args)
+			})},
+			{Name: "isRepeatable", Type: "Boolean!", Resolve: constant(false)},
+		}},
+	}
+}
+
+func introspectionEnums() []*Enum {
+	return []*Enum{
+		{Name: "__TypeKind", Values: []string{"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL"}},
+		{Name: "__DirectiveLocation", Values: []string{
+			"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT",
+			"VARIABLE_DEFINITION", "SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE",
+			"UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT", "INPUT_FIELD_DEFINITION",
+		}},
+	}
+}
@@ -0,0 +1,673 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parsing of executable documents: operations, fragments, variables, aliases, arguments and the
+// @skip/@include directives. Type system definitions aren't accepted.
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query or subscription
+	name       string
+	variables  []variableDef
+	selections []*selection
+}
+
+type variableDef struct {
+	name       string
+	typ        string
+	def        any
+	hasDefault bool
+}
+
+type fragment struct {
+	name       string
+	on         string
+	selections []*selection
+}
+
+// selection is a field, a fragment spread (spread set) or an inline fragment (inline true)
+type selection struct {
+	alias      string
+	name       string
+	args       map[string]any
+	selections []*selection
+	directives []directive
+
+	spread string
+	inline bool
+	on     string // type condition of an inline fragment, empty for none
+
+	loc Location
+}
+
+type directive struct {
+	name string
+	args map[string]any
+}
+
+// variable is a $name reference in a value
+type variable string
+
+// enumValue is an unquoted name in a value
+type enumValue string
+
+// responseKey is the alias of a field, or its name
+func (s *selection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	loc   Location
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+	col  int
+	tok  token
+}
+
+// maxDepth bounds the nesting of selections and values
+const maxDepth = 32
+
+func parse(src string) (*document, error) {
+	p := &parser{src: src, line: 1, col: 1}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.is(tokPunct, "{"):
+			sels, err := p.selectionSet(0)
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: sels})
+		case p.is(tokName, "query"), p.is(tokName, "subscription"), p.is(tokName, "mutation"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.is(tokName, "fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.fragments[f.name] != nil {
+				return nil, fmt.Errorf("fragment %q is defined twice", f.name)
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document contains no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.value}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.name = p.tok.value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is(tokPunct, "(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.is(tokPunct, ")") {
+			v, err := p.variableDef()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, v)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet(0)
+	if err != nil {
+		return nil, err
+	}
+	op.selections = sels
+	return op, nil
+}
+
+func (p *parser) variableDef() (variableDef, error) {
+	var v variableDef
+	if err := p.expect(tokPunct, "$"); err != nil {
+		return v, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return v, err
+	}
+	v.name = name
+	if err := p.expect(tokPunct, ":"); err != nil {
+		return v, err
+	}
+	if v.typ, err = p.typeRef(); err != nil {
+		return v, err
+	}
+	if p.is(tokPunct, "=") {
+		if err := p.next(); err != nil {
+			return v, err
+		}
+		if v.def, err = p.value(true, 0); err != nil {
+			return v, err
+		}
+		v.hasDefault = true
+	}
+	return v, nil
+}
+
+// typeRef reads a type such as String!, [Key!] or [[Int]]! as written
+func (p *parser) typeRef() (string, error) {
+	var typ string
+	if p.is(tokPunct, "[") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect(tokPunct, "]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.is(tokPunct, "!") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, fmt.Errorf("a fragment can't be named on")
+	}
+	if !p.is(tokName, "on") {
+		return nil, p.unexpected()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	on, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet(0)
+	if err != nil {
+		return nil, err
+	}
+	return &fragment{name: name, on: on, selections: sels}, nil
+}
+
+func (p *parser) selectionSet(depth int) ([]*selection, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("selections are nested deeper than %d levels", maxDepth)
+	}
+	if err := p.expect(tokPunct, "{"); err != nil {
+		return nil, err
+	}
+	var sels []*selection
+	for !p.is(tokPunct, "}") {
+		sel, err := p.selection(depth)
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, fmt.Errorf("empty selection set at %s", p.tok.loc)
+	}
+	return sels, p.next()
+}
+
+func (p *parser) selection(depth int) (*selection, error) {
+	sel := &selection{loc: p.tok.loc}
+	var err error
+
+	if p.is(tokPunct, "...") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName && p.tok.value != "on" {
+			sel.spread = p.tok.value
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			sel.directives, err = p.directives()
+			return sel, err
+		}
+		sel.inline = true
+		if p.is(tokName, "on") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if sel.on, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		sel.selections, err = p.selectionSet(depth + 1)
+		return sel, err
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.is(tokPunct, ":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is(tokPunct, "(") {
+		if sel.args, err = p.arguments(); err != nil {
+			return nil, err
+		}
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.is(tokPunct, "{") {
+		if sel.selections, err = p.selectionSet(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+func (p *parser) arguments() (map[string]any, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	args := make(map[string]any)
+	for !p.is(tokPunct, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := args[name]; dup {
+			return nil, fmt.Errorf("argument %q is given twice", name)
+		}
+		if err := p.expect(tokPunct, ":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false, 0); err != nil {
+			return nil, err
+		}
+	}
+	return args, p.next()
+}
+
+func (p *parser) directives() ([]directive, error) {
+	var dirs []directive
+	for p.is(tokPunct, "@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		d := directive{name: name}
+		if p.is(tokPunct, "(") {
+			if d.args, err = p.arguments(); err != nil {
+				return nil, err
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// value reads a literal; variables aren't allowed in constant contexts such as defaults
+func (p *parser) value(constant bool, depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("values are nested deeper than %d levels", maxDepth)
+	}
+	tok := p.tok
+	switch {
+	case p.is(tokPunct, "$") && !constant:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return variable(name), err
+	case p.is(tokPunct, "["):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		list := []any{}
+		for !p.is(tokPunct, "]") {
+			v, err := p.value(constant, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, p.next()
+	case p.is(tokPunct, "{"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		obj := make(map[string]any)
+		for !p.is(tokPunct, "}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokPunct, ":"); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(constant, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.next()
+	case tok.kind == tokInt:
+		n, err := strconv.ParseInt(tok.value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("integer %s at %s is out of range", tok.value, tok.loc)
+		}
+		return int(n), p.next()
+	case tok.kind == tokFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %s at %s", tok.value, tok.loc)
+		}
+		return f, p.next()
+	case tok.kind == tokString:
+		return tok.value, p.next()
+	case tok.kind == tokName:
+		var v any
+		switch tok.value {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = enumValue(tok.value)
+		}
+		return v, p.next()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.next()
+}
+
+func (p *parser) is(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *parser) expect(kind tokenKind, value string) error {
+	if !p.is(kind, value) {
+		return p.unexpected()
+	}
+	return p.next()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("syntax error: unexpected end of document")
+	}
+	return fmt.Errorf("syntax error: unexpected %q at %s", p.tok.value, p.tok.loc)
+}
+
+// next reads the following token, skipping whitespace, commas and comments
+func (p *parser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.advance(1)
+			p.line, p.col = p.line+1, 1
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			p.advance(1)
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.advance(1)
+			}
+		default:
+			return p.lex()
+		}
+	}
+	p.tok = token{kind: tokEOF, loc: Location{Line: p.line, Column: p.col}}
+	return nil
+}
+
+func (p *parser) advance(n int) {
+	p.pos += n
+	p.col += n
+}
+
+func (p *parser) lex() error {
+	loc := Location{Line: p.line, Column: p.col}
+	rest := p.src[p.pos:]
+	c := rest[0]
+	switch {
+	case strings.HasPrefix(rest, "..."):
+		p.tok = token{kind: tokPunct, value: "...", loc: loc}
+		p.advance(3)
+	case strings.ContainsRune("!$&()/:=@[]{}|", rune(c)):
+		p.tok = token{kind: tokPunct, value: string(c), loc: loc}
+		p.advance(1)
+	case c == '_' || isLetter(c):
+		n := 1
+		for n < len(rest) && (rest[n] == '_' || isLetter(rest[n]) || isDigit(rest[n])) {
+			n++
+		}
+		p.tok = token{kind: tokName, value: rest[:n], loc: loc}
+		p.advance(n)
+	case c == '-' || isDigit(c):
+		return p.lexNumber(loc)
+	case strings.HasPrefix(rest, `"""`):
+		return p.lexBlockString(loc)
+	case c == '"':
+		return p.lexString(loc)
+	default:
+		r, _ := utf8.DecodeRuneInString(rest)
+		return fmt.Errorf("syntax error: unexpected character %q at %s", r, loc)
+	}
+	return nil
+}
+
+func (p *parser) lexNumber(loc Location) error {
+	rest := p.src[p.pos:]
+	n, float := 0, false
+	if rest[n] == '-' {
+		n++
+	}
+	digits := func() int {
+		start := n
+		for n < len(rest) && isDigit(rest[n]) {
+			n++
+		}
+		return n - start
+	}
+	if digits() == 0 {
+		return fmt.Errorf("syntax error: invalid number at %s", loc)
+	}
+	if n < len(rest) && rest[n] == '.' {
+		n++
+		float = true
+		if digits() == 0 {
+			return fmt.Errorf("syntax error: invalid number at %s", loc)
+		}
+	}
+	if n < len(rest) && (rest[n] == 'e' || rest[n] == 'E') {
+		n++
+		float = true
+		if n < len(rest) && (rest[n] == '+' || rest[n] == '-') {
+			n++
+		}
+		if digits() == 0 {
+			return fmt.Errorf("syntax error: invalid number at %s", loc)
+		}
+	}
+	kind := tokInt
+	if float {
+		kind = tokFloat
+	}
+	p.tok = token{kind: kind, value: rest[:n], loc: loc}
+	p.advance(n)
+	return nil
+}
+
+func (p *parser) lexString(loc Location) error {
+	var b strings.Builder
+	p.advance(1)
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.advance(1)
+			p.tok = token{kind: tokString, value: b.String(), loc: loc}
+			return nil
+		case c == '\n' || c == '\r':
+			return fmt.Errorf("syntax error: unterminated string at %s", loc)
+		case c == '\\' && p.pos+1 < len(p.src):
+			esc := p.src[p.pos+1]
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.pos+6 > len(p.src) {
+					return fmt.Errorf("syntax error: invalid escape at %s", loc)
+				}
+				r, err := strconv.ParseUint(p.src[p.pos+2:p.pos+6], 16, 32)
+				if err != nil {
+					return fmt.Errorf("syntax error: invalid escape at %s", loc)
+				}
+				b.WriteRune(rune(r))
+				p.advance(4)
+			default:
+				return fmt.Errorf("syntax error: invalid escape at %s", loc)
+			}
+			p.advance(2)
+		default:
+			b.WriteByte(c)
+			p.advance(1)
+		}
+	}
+	return fmt.Errorf("syntax error: unterminated string at %s", loc)
+}
+
+// lexBlockString reads a """ string; the common indentation isn't removed
+func (p *parser) lexBlockString(loc Location) error {
+	body := p.src[p.pos+3:]
+	end := strings.Index(body, `"""`)
+	for end > 0 && body[end-1] == '\\' {
+		next := strings.Index(body[end+3:], `"""`)
+		if next < 0 {
+			end = -1
+			break
+		}
+		end += 3 + next
+	}
+	if end < 0 {
+		return fmt.Errorf("syntax error: unterminated block string at %s", loc)
+	}
+	value := body[:end]
+	for _, c := range value {
+		if c == '\n' {
+			p.line++
+		}
+	}
+	p.pos += 3 + end + 3
+	p.tok = token{kind: tokString, value: strings.TrimSpace(strings.ReplaceAll(value, `\"""`, `"""`)), loc: loc}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,228 @@
+// Package graphql executes GraphQL queries and subscriptions against a schema of object types whose fields
+// are resolved by Go functions. It covers what read-only APIs need: operations with variables, aliases,
+// fragments, @skip/@include, introspection and the SDL of the schema. Mutations, interfaces, unions and
+// input objects aren't supported.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Built-in scalar types
+var scalars = []string{"Boolean", "Float", "ID", "Int", "String"}
+
+// Object is an object type
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+// Field is a field of an object type. Type is written as in SDL, e.g. String, Int! or [Key!]!.
+type Field struct {
+	Name        string
+	Description string
+	Type        string
+	Args        []Arg
+	Resolve     func(p Params) (any, error)
+
+	// Subscribe starts the event stream of a Subscription field, each value is then resolved against Type.
+	// The channel is closed by the producer, or abandoned once the context is done.
+	Subscribe func(p Params) (<-chan any, error)
+}
+
+// Arg is an argument of a field, of a scalar type or a list of them
+type Arg struct {
+	Name        string
+	Description string
+	Type        string
+	Default     any // nil for none
+}
+
+// Enum is an enum type, only used in results
+type Enum struct {
+	Name        string
+	Description string
+	Values      []string
+}
+
+// Params are passed to resolvers: Source is the object the field belongs to, or the root value for
+// top-level fields, and Args holds the arguments given or defaulted
+type Params struct {
+	Context context.Context
+	Source  any
+	Args    map[string]any
+}
+
+// Schema is a validated set of types, with Query and optionally Subscription as entry points
+type Schema struct {
+	query        *Object
+	subscription *Object
+	objects      map[string]*Object
+	enums        map[string]*Enum
+	order        []string // type names in declaration order, for the SDL
+}
+
+// NewSchema checks that every type referenced by a field or argument is declared in types, enums or the
+// built-in scalars
+func NewSchema(query, subscription *Object, types []*Object, enums []*Enum) (*Schema, error) {
+	s := &Schema{
+		query:        query,
+		subscription: subscription,
+		objects:      make(map[string]*Object),
+		enums:        make(map[string]*Enum),
+	}
+	declare := func(name string) error {
+		if slices.Contains(s.order, name) || slices.Contains(scalars, name) {
+			return fmt.Errorf("type %s is declared twice", name)
+		}
+		s.order = append(s.order, name)
+		return nil
+	}
+
+	all := []*Object{query}
+	if subscription != nil {
+		all = append(all, subscription)
+	}
+	all = append(all, types...)
+	all = append(all, introspectionTypes()...)
+	for _, obj := range all {
+		if err := declare(obj.Name); err != nil {
+			return nil, err
+		}
+		s.objects[obj.Name] = obj
+	}
+	for _, enum := range append(enums, introspectionEnums()...) {
+		if err := declare(enum.Name); err != nil {
+			return nil, err
+		}
+		s.enums[enum.Name] = enum
+	}
+
+	for _, obj := range all {
+		for _, f := range obj.Fields {
+			if _, ok := s.named(f.Type); !ok {
+				return nil, fmt.Errorf("%s.%s: unknown type %s", obj.Name, f.Name, f.Type)
+			}
+			if obj == subscription && f.Subscribe == nil {
+				return nil, fmt.Errorf("%s.%s: subscription field without Subscribe", obj.Name, f.Name)
+			}
+			if obj != subscription && f.Resolve == nil {
+				return nil, fmt.Errorf("%s.%s: field without Resolve", obj.Name, f.Name)
+			}
+			for _, a := range f.Args {
+				if !slices.Contains(scalars, baseType(a.Type)) {
+					return nil, fmt.Errorf("%s.%s(%s): %s is not a scalar type", obj.Name, f.Name, a.Name, a.Type)
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// named returns the kind of the named type at the core of a type reference
+func (s *Schema) named(typ string) (string, bool) {
+	name := baseType(typ)
+	switch {
+	case slices.Contains(scalars, name):
+		return "SCALAR", true
+	case s.objects[name] != nil:
+		return "OBJECT", true
+	case s.enums[name] != nil:
+		return "ENUM", true
+	}
+	return "", false
+}
+
+// leaf reports whether a type reference is a scalar or enum, selected without a selection set
+func (s *Schema) leaf(typ string) bool {
+	kind, _ := s.named(typ)
+	return kind != "OBJECT"
+}
+
+// baseType strips the list and non-null wrappers off a type reference
+func baseType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+func (o *Object) field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// SDL returns the schema in the schema definition language, without the introspection types
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	if s.subscription != nil {
+		fmt.Fprintf(&b, "schema {\n  query: %s\n  subscription: %s\n}\n", s.query.Name, s.subscription.Name)
+	}
+	for _, name := range s.order {
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		b.WriteString("\n")
+		if obj := s.objects[name]; obj != nil {
+			writeDescription(&b, "", obj.Description)
+			fmt.Fprintf(&b, "type %s {\n", name)
+			for _, f := range obj.Fields {
+				writeDescription(&b, "  ", f.Description)
+				fmt.Fprintf(&b, "  %s%s: %s\n", f.Name, sdlArgs(f.Args), f.Type)
+			}
+			b.WriteString("}\n")
+			continue
+		}
+		enum := s.enums[name]
+		writeDescription(&b, "", enum.Description)
+		fmt.Fprintf(&b, "enum %s {\n", name)
+		for _, v := range enum.Values {
+			fmt.Fprintf(&b, "  %s\n", v)
+		}
+		b.WriteString("}\n")
+	}
+	return strings.TrimPrefix(b.String(), "\n")
+}
+
+func sdlArgs(args []Arg) string {
+	if len(args) == 0 {
+		return ""
+	}
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = a.Name + ": " + a.Type
+		if a.Default != nil {
+			parts[i] += " = " + literal(a.Default)
+		}
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		fmt.Fprintf(b, "%s%s\n", indent, strconv.Quote(description))
+	}
+}
+
+// literal formats a default value as a GraphQL literal
+func literal(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = literal(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
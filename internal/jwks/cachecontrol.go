@@ -0,0 +1,107 @@
+package jwks
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxDeltaSeconds caps delta-seconds values, as RFC 9111 section 1.2.2 recommends
+const maxDeltaSeconds = 1<<31 - 1
+
+// cacheControl holds the Cache-Control directives of an upstream response that affect
+// how long keys are cached. Ages are -1 when the directive is absent or invalid.
+type cacheControl struct {
+	maxAge  int
+	sMaxAge int
+	noStore bool
+	noCache bool // bare no-cache; no-cache="field" only restricts the named fields
+}
+
+// parseCacheControl parses a Cache-Control header per RFC 9111: directive names are
+// case-insensitive, values may be quoted (with commas inside quotes), and the first
+// occurrence of a repeated directive wins
+func parseCacheControl(header string) cacheControl {
+	cc := cacheControl{maxAge: -1, sMaxAge: -1}
+	seen := make(map[string]bool)
+	for _, directive := range splitDirectives(header) {
+		name, value, hasValue := strings.Cut(directive, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		value = unquote(strings.TrimSpace(value))
+
+		switch name {
+		case "max-age":
+			cc.maxAge = deltaSeconds(value)
+		case "s-maxage":
+			cc.sMaxAge = deltaSeconds(value)
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			cc.noCache = !hasValue
+		}
+	}
+	return cc
+}
+
+// lifetime returns how long the upstream allows the response to be cached in seconds,
+// 0 when it gave no usable lifetime or forbids caching, so the configured duration applies.
+// s-maxage is meant for shared caches like this service and takes precedence over max-age.
+func (c cacheControl) lifetime() int {
+	if c.noStore || c.noCache {
+		return 0
+	}
+	if c.sMaxAge >= 0 {
+		return c.sMaxAge
+	}
+	return max(c.maxAge, 0)
+}
+
+// splitDirectives splits a header value on commas outside quoted strings
+func splitDirectives(header string) []string {
+	var directives []string
+	start, quoted, escaped := 0, false, false
+	for i := 0; i < len(header); i++ {
+		switch c := header[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			directives = append(directives, header[start:i])
+			start = i + 1
+		}
+	}
+	return append(directives, header[start:])
+}
+
+// unquote removes the quotes and escapes of a quoted-string, other values are returned as is
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	var b strings.Builder
+	for i := 1; i < len(value)-1; i++ {
+		if value[i] == '\\' && i+1 < len(value)-1 {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// deltaSeconds parses a non-negative number of seconds, -1 when invalid
+func deltaSeconds(value string) int {
+	if value == "" || strings.TrimLeft(value, "0123456789") != "" {
+		return -1
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return maxDeltaSeconds // all digits, so it overflowed
+	}
+	return min(n, maxDeltaSeconds)
+}
@@ -0,0 +1,106 @@
+package jwks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		want     cacheControl
+		lifetime int
+	}{
+		{"empty", "", cacheControl{maxAge: -1, sMaxAge: -1}, 0},
+		{"max-age", "public, max-age=3600", cacheControl{maxAge: 3600, sMaxAge: -1}, 3600},
+		{"quoted max-age", `max-age="600"`, cacheControl{maxAge: 600, sMaxAge: -1}, 600},
+		{"quoted value with commas", `private="a, max-age=5, b", max-age=3600`, cacheControl{maxAge: 3600, sMaxAge: -1}, 3600},
+		{"escaped quote in value", `ext="a\", max-age=5", max-age=60`, cacheControl{maxAge: 60, sMaxAge: -1}, 60},
+		{"mixed case", "Public, MAX-AGE=120, S-MaxAge=300", cacheControl{maxAge: 120, sMaxAge: 300}, 300},
+		{"s-maxage over max-age", "max-age=60, s-maxage=3600", cacheControl{maxAge: 60, sMaxAge: 3600}, 3600},
+		{"s-maxage zero over max-age", "max-age=60, s-maxage=0", cacheControl{maxAge: 60, sMaxAge: 0}, 0},
+		{"no-store", "no-store, max-age=3600", cacheControl{maxAge: 3600, sMaxAge: -1, noStore: true}, 0},
+		{"no-cache", "max-age=3600, No-Cache", cacheControl{maxAge: 3600, sMaxAge: -1, noCache: true}, 0},
+		{"no-cache with field", `no-cache="set-cookie, x-trace", max-age=3600`, cacheControl{maxAge: 3600, sMaxAge: -1}, 3600},
+		{"duplicate keeps first", "max-age=60, max-age=3600", cacheControl{maxAge: 60, sMaxAge: -1}, 60},
+		{"invalid first duplicate", "max-age=abc, max-age=3600", cacheControl{maxAge: -1, sMaxAge: -1}, 0},
+		{"negative", "max-age=-5", cacheControl{maxAge: -1, sMaxAge: -1}, 0},
+		{"negative s-maxage", "s-maxage=-5, max-age=60", cacheControl{maxAge: 60, sMaxAge: -1}, 60},
+		{"overflow", "max-age=99999999999999999999", cacheControl{maxAge: maxDeltaSeconds, sMaxAge: -1}, maxDeltaSeconds},
+		{"above cap", "max-age=4294967296", cacheControl{maxAge: maxDeltaSeconds, sMaxAge: -1}, maxDeltaSeconds},
+		{"missing value", "max-age=, max-age", cacheControl{maxAge: -1, sMaxAge: -1}, 0},
+		{"empty directives", " , ,max-age=10,", cacheControl{maxAge: 10, sMaxAge: -1}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseCacheControl(tt.header)
+			if got != tt.want {
+				t.Fatalf("parseCacheControl(%q) = %+v, want %+v", tt.header, got, tt.want)
+			}
+			if n := got.lifetime(); n != tt.lifetime {
+				t.Fatalf("lifetime of %q = %d, want %d", tt.header, n, tt.lifetime)
+			}
+		})
+	}
+}
+
+func TestUpdaterCacheControl(t *testing.T) {
+	const configured = 900
+	tests := []struct {
+		header    string
+		suggested int
+		want      int
+	}{
+		{"", 0, configured},
+		{"max-age=7200", 7200, 7200},
+		{"max-age=60", 60, configured},
+		{"MAX-AGE=7200", 7200, 7200},
+		{"max-age=60, s-maxage=7200", 7200, 7200},
+		{"s-maxage=60, max-age=7200", 60, configured},
+		{"no-store, max-age=7200", 0, configured},
+		{"max-age=7200, no-cache", 0, configured},
+		{`no-cache="set-cookie", max-age=7200`, 7200, 7200},
+		{`private="a, max-age=5", max-age=7200`, 7200, 7200},
+		{"max-age=7200, max-age=60", 7200, 7200},
+		{"max-age=-7200", 0, configured},
+		{"max-age=99999999999999999999", maxDeltaSeconds, maxDeltaSeconds},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if tt.header != "" {
+					w.Header().Set("Cache-Control", tt.header)
+				}
+				io.WriteString(w, `{"keys":[{"kty":"RSA","kid":"k1","use":"sig","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4","e":"AQAB"}]}`)
+			}))
+			defer upstream.Close()
+
+			m := NewManager(discardLogger())
+			idp := config.IDPConfig{Name: "cc", URL: upstream.URL, RefreshInterval: 3600, CacheDuration: configured}
+			u := NewUpdater(idp, m, discardLogger(), WithHTTPClient(upstream.Client()))
+			FetchAll(context.Background(), []*Updater{u}, 1, 5*time.Second, discardLogger())
+
+			data, ok := m.Get("cc")
+			if !ok {
+				t.Fatal("no data after fetch")
+			}
+			if data.LastError != "" {
+				t.Fatalf("fetch failed: %s", data.LastError)
+			}
+			if data.IDPSuggestedCache != tt.suggested {
+				t.Errorf("IDP suggested cache = %d, want %d", data.IDPSuggestedCache, tt.suggested)
+			}
+			if data.CacheDuration != tt.want {
+				t.Errorf("cache duration = %d, want %d", data.CacheDuration, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package jwks
+
+import (
+	"net/http"
+	"time"
+)
+
+// HTTPDoer is the subset of *http.Client used by the Updater.
+// Embedders can wrap it for instrumentation or replace it in tests.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Clock abstracts time so scheduling can be tested deterministically
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the real wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,22 @@
+package jwks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newRequestID returns a random 128-bit correlation id, hex encoded so it doubles as a W3C trace-id
+func newRequestID() string {
+	return randomHex(16)
+}
+
+// traceparent returns a W3C traceparent header value for a sampled request within the trace id
+func traceparent(traceID string) string {
+	return "00-" + traceID + "-" + randomHex(8) + "-01"
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
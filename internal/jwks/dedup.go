@@ -0,0 +1,61 @@
+package jwks
+
+import (
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// Merge combines the keys of the named IDPs in order, leaving out IDPs without keys or drained at
+// now, and deduplicates them as cfg says. It returns the merged key set and how many duplicates were
+// removed. Every merged key set, served or published, is built here.
+func Merge(all map[string]*IDPData, names []string, cfg config.MergedConfig, now time.Time) (*JWKS, int) {
+	// Merge into a new array, the IDPs' key sets are shared and never appended to
+	merged := &JWKS{Keys: make([]JWK, 0)}
+	for _, name := range names {
+		data := all[name]
+		if data != nil && data.JWKS != nil && len(data.JWKS.Keys) > 0 && !data.Drained(now) {
+			merged.Keys = append(merged.Keys, data.JWKS.Keys...)
+		}
+	}
+
+	mode := cfg.GetDedup()
+	if mode == config.DedupNone {
+		return merged, 0
+	}
+	keys, removed := Dedup(merged.Keys, mode == config.DedupSameKid, cfg.GetDedupKeep() == "last")
+	merged.Keys = keys
+	return merged, removed
+}
+
+// Dedup returns keys with duplicates of the same public key removed, keeping the first
+// or, with keepLast, the last occurrence in place. Keys are duplicates when their RFC 7638
+// thumbprints match and, with sameKid, their kids match too. Keys without a thumbprint
+// (symmetric or unknown types) are always kept. The input slice is not modified.
+func Dedup(keys []JWK, sameKid, keepLast bool) ([]JWK, int) {
+	type identity struct{ thumbprint, kid string }
+	identities := make([]identity, len(keys))
+	keep := make(map[identity]int, len(keys)) // index of the occurrence kept
+	for i, k := range keys {
+		tp, err := thumbprint(k)
+		if err != nil {
+			continue
+		}
+		id := identity{thumbprint: tp}
+		if sameKid {
+			id.kid = k.Kid
+		}
+		identities[i] = id
+		if _, seen := keep[id]; !seen || keepLast {
+			keep[id] = i
+		}
+	}
+
+	out := make([]JWK, 0, len(keep))
+	for i, k := range keys {
+		if id := identities[i]; id.thumbprint == "" || keep[id] == i {
+			out = append(out, k)
+		}
+	}
+	return out, len(keys) - len(out)
+}
@@ -0,0 +1,69 @@
+package jwks
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	n := strings.Repeat("A", 342)
+	first := JWK{Kty: "RSA", Kid: "a", Alg: "RS256", Use: "sig", N: n, E: "AQAB"}
+	other := JWK{Kty: "RSA", Kid: "x", Alg: "RS256", Use: "sig", N: strings.Repeat("B", 342), E: "AQAB"}
+	renamed := JWK{Kty: "RSA", Kid: "b", Alg: "RS256", Use: "sig", N: n, E: "AQAB"}  // same key, other kid
+	reissued := JWK{Kty: "RSA", Kid: "a", Alg: "PS256", Use: "sig", N: n, E: "AQAB"} // same key and kid, other alg
+	secret := JWK{Kty: "oct", Kid: "s", K: "c2VjcmV0"}                               // no thumbprint for oct
+	unknown := JWK{Kty: "XYZ", Kid: "u", X: "AAAA"}                                  // nor for unknown types
+	keys := []JWK{first, other, renamed, reissued, secret, secret, unknown, unknown}
+	input := slices.Clone(keys)
+
+	tests := []struct {
+		name              string
+		sameKid, keepLast bool
+		want              []JWK
+	}{
+		{"thumbprint keep first", false, false, []JWK{first, other, secret, secret, unknown, unknown}},
+		{"thumbprint keep last", false, true, []JWK{other, reissued, secret, secret, unknown, unknown}},
+		{"same kid keep first", true, false, []JWK{first, other, renamed, secret, secret, unknown, unknown}},
+		{"same kid keep last", true, true, []JWK{other, renamed, reissued, secret, secret, unknown, unknown}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := Dedup(keys, tt.sameKid, tt.keepLast)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Dedup() kept %v, want %v", kidsAndAlgs(got), kidsAndAlgs(tt.want))
+			}
+			if want := len(keys) - len(tt.want); dropped != want {
+				t.Fatalf("Dedup() dropped %d, want %d", dropped, want)
+			}
+			if !reflect.DeepEqual(keys, input) {
+				t.Fatal("Dedup() modified its input")
+			}
+		})
+	}
+}
+
+func TestDedupWithoutDuplicates(t *testing.T) {
+	keys := testKeySet("corp", 3).Keys
+	for i := range keys {
+		keys[i].N = strings.Repeat(string(rune('A'+i)), 342)
+	}
+	for _, keepLast := range []bool{false, true} {
+		got, dropped := Dedup(keys, false, keepLast)
+		if dropped != 0 || !reflect.DeepEqual(got, keys) {
+			t.Fatalf("keepLast=%v: dropped %d, kept %v", keepLast, dropped, kidsAndAlgs(got))
+		}
+	}
+	if got, dropped := Dedup(nil, false, false); dropped != 0 || len(got) != 0 {
+		t.Fatalf("Dedup(nil) = %v, %d", got, dropped)
+	}
+}
+
+func kidsAndAlgs(keys []JWK) []string {
+	var ids []string
+	for _, k := range keys {
+		ids = append(ids, k.Kid+"/"+k.Alg)
+	}
+	return ids
+}
@@ -0,0 +1,204 @@
+package jwks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// dnsDialer resolves an IDP's host with its static hosts, resolver and cache settings, then dials
+// the addresses of the preferred family first, racing the other family after the fallback delay
+type dnsDialer struct {
+	config   config.DNSConfig
+	resolver *net.Resolver
+	dialer   net.Dialer
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	cache map[string]resolved
+}
+
+// resolved holds the cached addresses of a host
+type resolved struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+func newDNSDialer(cfg config.DNSConfig, logger *slog.Logger) *dnsDialer {
+	d := &dnsDialer{
+		config:   cfg,
+		resolver: net.DefaultResolver,
+		dialer:   net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second},
+		logger:   logger,
+		cache:    make(map[string]resolved),
+	}
+	if cfg.Resolver != "" {
+		d.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, cfg.Resolver)
+			},
+		}
+	}
+	return d
+}
+
+// lookup returns the addresses of host: literal IPs as is, then static hosts, the cache and the resolver
+func (d *dnsDialer) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if addrs, ok := d.config.Hosts[host]; ok {
+		ips := make([]net.IP, len(addrs))
+		for i, addr := range addrs {
+			ips[i] = net.ParseIP(addr)
+		}
+		return ips, nil
+	}
+
+	ttl := d.config.GetCacheTTL()
+	if ttl > 0 {
+		d.mu.Lock()
+		entry, ok := d.cache[host]
+		d.mu.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			return entry.ips, nil
+		}
+	}
+
+	ips, err := d.resolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	d.logger.Debug("Resolved IDP host", "host", host, "addresses", ips)
+
+	if ttl > 0 {
+		d.mu.Lock()
+		d.cache[host] = resolved{ips: ips, expires: time.Now().Add(ttl)}
+		d.mu.Unlock()
+	}
+	return ips, nil
+}
+
+// partition splits addresses into the preferred family and the rest.
+// Without a preference the family of the first address is preferred, like net.Dialer does.
+func (d *dnsDialer) partition(ips []net.IP) (primaries, fallbacks []net.IP) {
+	wantIPv4 := ips[0].To4() != nil
+	switch d.config.Prefer {
+	case config.PreferIPv4:
+		wantIPv4 = true
+	case config.PreferIPv6:
+		wantIPv4 = false
+	}
+	for _, ip := range ips {
+		if (ip.To4() != nil) == wantIPv4 {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(primaries) == 0 {
+		return fallbacks, nil
+	}
+	return primaries, fallbacks
+}
+
+// DialContext is used as the IDP transport's dial function
+func (d *dnsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+
+	primaries, fallbacks := d.partition(ips)
+	delay := d.config.GetFallbackDelay()
+	if len(fallbacks) == 0 || delay == 0 {
+		return d.dialSerial(ctx, network, append(primaries, fallbacks...), port)
+	}
+	return d.dialParallel(ctx, network, primaries, fallbacks, port, delay)
+}
+
+// dialSerial tries the addresses in order, returning the first connection or the last error
+func (d *dnsDialer) dialSerial(ctx context.Context, network string, ips []net.IP, port string) (net.Conn, error) {
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// dialParallel dials the primaries and starts the fallbacks after delay or once the primaries fail (RFC 8305)
+func (d *dnsDialer) dialParallel(ctx context.Context, network string, primaries, fallbacks []net.IP, port string, delay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	dial := func(ips []net.IP) {
+		go func() {
+			conn, err := d.dialSerial(ctx, network, ips, port)
+			results <- result{conn, err}
+		}()
+	}
+
+	dial(primaries)
+	pending, fallbackStarted := 1, false
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				dial(fallbacks)
+				pending, fallbackStarted = pending+1, true
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if pending > 0 {
+					// The other family may still connect before the cancel reaches it
+					go func() {
+						if other := <-results; other.conn != nil {
+							other.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if !fallbackStarted {
+				dial(fallbacks)
+				pending, fallbackStarted = pending+1, true
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package jwks
+
+import "time"
+
+// Drain puts an IDP into drain mode: it stays in status and keeps being fetched, but its keys stop
+// being served once grace has passed. It returns when that happens.
+// Listeners are notified when the keys stop being served, right away or at the end of grace.
+func (m *Manager) Drain(name string, grace time.Duration) (time.Time, error) {
+	m.lock()
+	if _, exists := m.state.Load().idps[name]; !exists {
+		m.mu.Unlock()
+		return time.Time{}, ErrUnknownIDP
+	}
+	data := m.modify(name)
+	now := time.Now()
+	wasDrained := data.Drained(now)
+	data.DrainingSince = now
+	data.DrainAt = now.Add(grace)
+	m.publish(data)
+	m.stopDrainTimer(name)
+	if grace > 0 {
+		drainAt := data.DrainAt
+		m.drainTimers[name] = time.AfterFunc(grace, func() { m.drainDue(name, drainAt) })
+	}
+	m.mu.Unlock()
+
+	m.logger.Info("IDP draining", "idp", name, "grace", grace.String(), "drain_at", data.DrainAt.Format(time.RFC3339))
+	if wasDrained != data.Drained(now) {
+		m.notify(drainChange(data, now))
+	}
+	return data.DrainAt, nil
+}
+
+// ServedJWKS returns the keys of an IDP like GetJWKS, except for a drained IDP which has none.
+// Tokens are validated against these, so decommissioning an IDP also stops trusting its tokens.
+func (m *Manager) ServedJWKS(name string, now time.Time) (*JWKS, bool) {
+	data, exists := m.state.Load().idps[name]
+	if !exists || data.JWKS == nil || data.Drained(now) {
+		return nil, false
+	}
+	return data.JWKS, true
+}
+
+// Undrain serves an IDP's keys again, reporting whether it was draining
+func (m *Manager) Undrain(name string) bool {
+	m.lock()
+	current, exists := m.state.Load().idps[name]
+	if !exists || current.DrainingSince.IsZero() {
+		m.mu.Unlock()
+		return false
+	}
+	now := time.Now()
+	wasDrained := current.Drained(now)
+	data := m.modify(name)
+	data.DrainingSince, data.DrainAt = time.Time{}, time.Time{}
+	m.publish(data)
+	m.stopDrainTimer(name)
+	m.mu.Unlock()
+
+	m.logger.Info("IDP no longer draining", "idp", name)
+	if wasDrained {
+		m.notify(drainChange(data, now))
+	}
+	return true
+}
+
+// drainDue notifies the listeners that an IDP's grace period ended, unless it was undrained
+// or drained again since
+func (m *Manager) drainDue(name string, drainAt time.Time) {
+	m.lock()
+	data, exists := m.state.Load().idps[name]
+	due := exists && data.DrainAt.Equal(drainAt)
+	if due {
+		delete(m.drainTimers, name)
+	}
+	m.mu.Unlock()
+
+	if due {
+		m.logger.Info("IDP drained, its keys are no longer served", "idp", name)
+		m.notify(drainChange(data, time.Now()))
+	}
+}
+
+// stopDrainTimer cancels the pending end of an IDP's grace period, must hold m.mu
+func (m *Manager) stopDrainTimer(name string) {
+	if t, ok := m.drainTimers[name]; ok {
+		t.Stop()
+		delete(m.drainTimers, name)
+	}
+}
+
+// drainChange describes an IDP whose keys stopped or started being served through drain mode
+func drainChange(data *IDPData, now time.Time) Change {
+	return Change{
+		IDP:           data.Name,
+		Previous:      data.JWKS,
+		JWKS:          data.JWKS,
+		Error:         data.LastError,
+		PreviousError: data.LastError,
+		Time:          now,
+		DrainChanged:  true,
+	}
+}
@@ -0,0 +1,107 @@
+package jwks
+
+import (
+	"testing"
+	"time"
+)
+
+// drainChanges returns a manager holding corp's keys and a channel receiving its drain changes
+func drainChanges(t *testing.T) (*Manager, <-chan Change) {
+	t.Helper()
+	m := NewManager(discardLogger())
+	m.Update("corp", testKeySet("corp", 2), 10, 900, nil)
+	changes := make(chan Change, 10)
+	m.OnChange(func(c Change) {
+		if c.DrainChanged {
+			changes <- c
+		}
+	})
+	return m, changes
+}
+
+func expectDrainChange(t *testing.T, changes <-chan Change, within time.Duration) Change {
+	t.Helper()
+	select {
+	case c := <-changes:
+		return c
+	case <-time.After(within):
+		t.Fatal("no drain change")
+		return Change{}
+	}
+}
+
+func expectNoDrainChange(t *testing.T, changes <-chan Change, within time.Duration) {
+	t.Helper()
+	select {
+	case c := <-changes:
+		t.Fatalf("unexpected drain change at %s", c.Time)
+	case <-time.After(within):
+	}
+}
+
+func TestDrainNotifiesAtDrainAt(t *testing.T) {
+	m, changes := drainChanges(t)
+	drainAt, err := m.Drain("corp", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNoDrainChange(t, changes, 20*time.Millisecond)
+
+	c := expectDrainChange(t, changes, time.Second)
+	if c.IDP != "corp" || c.Time.Before(drainAt) || !c.ServedChanged() || c.KeysChanged() {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	data, _ := m.Get("corp")
+	if !data.Drained(c.Time) {
+		t.Fatal("IDP not drained when the listeners were notified")
+	}
+
+	if !m.Undrain("corp") {
+		t.Fatal("IDP wasn't draining")
+	}
+	expectDrainChange(t, changes, time.Second)
+}
+
+func TestDrainWithoutGraceNotifiesRightAway(t *testing.T) {
+	m, changes := drainChanges(t)
+	if _, err := m.Drain("corp", 0); err != nil {
+		t.Fatal(err)
+	}
+	expectDrainChange(t, changes, time.Second)
+}
+
+func TestUndrainCancelsPendingDrain(t *testing.T) {
+	m, changes := drainChanges(t)
+	if _, err := m.Drain("corp", 30*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	m.Undrain("corp")
+	expectNoDrainChange(t, changes, 100*time.Millisecond)
+
+	// Draining again replaces the previous grace period
+	if _, err := m.Drain("corp", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Drain("corp", 30*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	expectDrainChange(t, changes, time.Second)
+	expectNoDrainChange(t, changes, 50*time.Millisecond)
+}
+
+func TestServedJWKSEndsAtDrainAt(t *testing.T) {
+	m, _ := drainChanges(t)
+	drainAt, err := m.Drain("corp", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keySet, ok := m.ServedJWKS("corp", drainAt.Add(-time.Second)); !ok || len(keySet.Keys) != 2 {
+		t.Fatal("keys not served during the grace period")
+	}
+	if _, ok := m.ServedJWKS("corp", drainAt); ok {
+		t.Fatal("keys served once drained")
+	}
+	if _, ok := m.GetJWKS("corp"); !ok {
+		t.Fatal("drained keys no longer held")
+	}
+}
@@ -0,0 +1,123 @@
+package jwks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// FetchResult is a key set retrieved from a source
+type FetchResult struct {
+	JWKS   *JWKS
+	MaxAge int // cache lifetime suggested by the source in seconds, 0 if none
+}
+
+// Fetcher retrieves the key set of an IDP from its source (HTTPS endpoint, file, command, ...).
+// Results are validated by the Updater, fetchers only need to return what the source holds.
+type Fetcher interface {
+	Fetch(ctx context.Context) (*FetchResult, error)
+}
+
+// FetcherFunc adapts a function to the Fetcher interface
+type FetcherFunc func(ctx context.Context) (*FetchResult, error)
+
+func (f FetcherFunc) Fetch(ctx context.Context) (*FetchResult, error) {
+	return f(ctx)
+}
+
+// newFetcher builds the fetcher for the IDP's configured source
+func (u *Updater) newFetcher() Fetcher {
+	switch u.config.GetSource() {
+	case config.SourceFile:
+		return &fileFetcher{path: u.config.Path, maxBytes: u.config.GetMaxResponseBytes()}
+	case config.SourceExec:
+		return &execFetcher{command: u.config.Command, maxBytes: u.config.GetMaxResponseBytes()}
+	case config.SourceAWSKMS:
+		kms := u.config.KMS
+		return newAWSKMSFetcher(u.client, kms.Region, kms.Endpoint, kms.AliasPrefix, kms.KeyIDs, u.config.GetMaxResponseBytes())
+	case config.SourceGCPKMS:
+		kms := u.config.KMS
+		return newGCPKMSFetcher(u.client, kms.Endpoint, kms.KeyRing, kms.Filter, u.config.GetMaxResponseBytes())
+	case config.SourceKubernetes:
+		kube := u.config.Kubernetes
+		f, err := newKubeFetcher(kube.GetKind(), kube.Namespace, kube.LabelSelector, u.config.GetMaxResponseBytes(), u.logger)
+		if err != nil {
+			return errorFetcher{err: err}
+		}
+		return f
+	default:
+		return &httpFetcher{
+			config: u.config,
+			client: u.client,
+			policy: u.policy,
+			logger: u.logger,
+		}
+	}
+}
+
+// errorFetcher always fails, used when a source can't be set up
+type errorFetcher struct {
+	err error
+}
+
+func (f errorFetcher) Fetch(context.Context) (*FetchResult, error) {
+	return nil, f.err
+}
+
+// decodeJWKS decodes a key set while streaming, rejecting input larger than maxBytes
+func decodeJWKS(r io.Reader, maxBytes int64) (*JWKS, error) {
+	var jwks JWKS
+	if err := decodeLimited(r, maxBytes, &jwks, "JWKS"); err != nil {
+		return nil, err
+	}
+	return &jwks, nil
+}
+
+// doJSON sends req and decodes a 200 JSON response into out, rejecting bodies larger than maxBytes
+// like key sets
+func doJSON(client HTTPDoer, req *http.Request, out any, maxBytes int64) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(msg))
+	}
+	if resp.ContentLength > maxBytes {
+		return fmt.Errorf("response too large: content length %d exceeds limit of %d bytes", resp.ContentLength, maxBytes)
+	}
+	return decodeLimited(resp.Body, maxBytes, out, "response")
+}
+
+// decodeLimited decodes JSON into out while streaming, rejecting input larger than maxBytes.
+// what names the document in parse errors.
+func decodeLimited(r io.Reader, maxBytes int64, out any, what string) error {
+	// Read at most one byte past the limit to detect oversized input
+	body := &countingReader{r: io.LimitReader(r, maxBytes+1)}
+	if err := json.NewDecoder(body).Decode(out); err != nil {
+		if body.n > maxBytes {
+			return fmt.Errorf("response too large: exceeds limit of %d bytes", maxBytes)
+		}
+		return fmt.Errorf("failed to parse %s: %w", what, err)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
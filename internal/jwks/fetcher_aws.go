@@ -0,0 +1,181 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/awsauth"
+)
+
+// awsSigningAlgorithms maps KMS signing algorithms to JWA names, in order of preference
+var awsSigningAlgorithms = []struct{ kms, jwa string }{
+	{"RSASSA_PKCS1_V1_5_SHA_256", "RS256"},
+	{"RSASSA_PKCS1_V1_5_SHA_384", "RS384"},
+	{"RSASSA_PKCS1_V1_5_SHA_512", "RS512"},
+	{"RSASSA_PSS_SHA_256", "PS256"},
+	{"RSASSA_PSS_SHA_384", "PS384"},
+	{"RSASSA_PSS_SHA_512", "PS512"},
+	{"ECDSA_SHA_256", "ES256"},
+	{"ECDSA_SHA_384", "ES384"},
+	{"ECDSA_SHA_512", "ES512"},
+}
+
+// awsKMSFetcher publishes the public keys of AWS KMS signing keys.
+// Keys are selected by alias prefix and/or explicit key ids. Credentials come from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type awsKMSFetcher struct {
+	client      HTTPDoer
+	region      string
+	endpoint    string
+	aliasPrefix string
+	keyIDs      []string
+	maxBytes    int64
+}
+
+func newAWSKMSFetcher(client HTTPDoer, region, endpoint, aliasPrefix string, keyIDs []string, maxBytes int64) *awsKMSFetcher {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com/", region)
+	}
+	return &awsKMSFetcher{
+		client:      client,
+		region:      region,
+		endpoint:    endpoint,
+		aliasPrefix: aliasPrefix,
+		keyIDs:      keyIDs,
+		maxBytes:    maxBytes,
+	}
+}
+
+func (f *awsKMSFetcher) Fetch(ctx context.Context) (*FetchResult, error) {
+	keyIDs := append([]string(nil), f.keyIDs...)
+	if f.aliasPrefix != "" {
+		aliased, err := f.listAliasedKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keyIDs = append(keyIDs, aliased...)
+	}
+
+	jwks := &JWKS{Keys: make([]JWK, 0, len(keyIDs))}
+	seen := make(map[string]bool)
+	for _, id := range keyIDs {
+		jwk, err := f.publicKey(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if jwk == nil || seen[jwk.Kid] {
+			continue
+		}
+		seen[jwk.Kid] = true
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+	return &FetchResult{JWKS: jwks}, nil
+}
+
+// listAliasedKeys returns the target key ids of all aliases starting with the prefix
+func (f *awsKMSFetcher) listAliasedKeys(ctx context.Context) ([]string, error) {
+	var ids []string
+	marker := ""
+	for {
+		req := map[string]any{"Limit": 100}
+		if marker != "" {
+			req["Marker"] = marker
+		}
+		var resp struct {
+			Aliases []struct {
+				AliasName   string
+				TargetKeyId string
+			}
+			NextMarker string
+			Truncated  bool
+		}
+		if err := f.call(ctx, "ListAliases", req, &resp); err != nil {
+			return nil, err
+		}
+		for _, a := range resp.Aliases {
+			if a.TargetKeyId != "" && strings.HasPrefix(a.AliasName, f.aliasPrefix) {
+				ids = append(ids, a.TargetKeyId)
+			}
+		}
+		if !resp.Truncated || resp.NextMarker == "" {
+			return ids, nil
+		}
+		marker = resp.NextMarker
+	}
+}
+
+// publicKey fetches one key's public part, returning nil for keys that can't sign
+func (f *awsKMSFetcher) publicKey(ctx context.Context, keyID string) (*JWK, error) {
+	var resp struct {
+		PublicKey         string
+		KeyUsage          string
+		SigningAlgorithms []string
+	}
+	if err := f.call(ctx, "GetPublicKey", map[string]string{"KeyId": keyID}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.KeyUsage != "SIGN_VERIFY" {
+		return nil, nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	pub, err := parsePublicKeyDER(der)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk, err := PublicKeyJWK(pub, awsAlgorithm(resp.SigningAlgorithms))
+	if err != nil {
+		return nil, err
+	}
+	return &jwk, nil
+}
+
+// awsAlgorithm picks the preferred JWA algorithm supported by a key
+func awsAlgorithm(supported []string) string {
+	for _, a := range awsSigningAlgorithms {
+		for _, s := range supported {
+			if s == a.kms {
+				return a.jwa
+			}
+		}
+	}
+	return ""
+}
+
+// call performs a signed KMS JSON API request
+func (f *awsKMSFetcher) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	if err := awsauth.Sign(req, body, f.region, "kms", time.Now()); err != nil {
+		return err
+	}
+
+	if err := doJSON(f.client, req, out, f.maxBytes); err != nil {
+		return fmt.Errorf("kms %s: %w", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package jwks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcpKMSEndpoint   = "https://cloudkms.googleapis.com/v1/"
+	gcpMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// gcpKMSFetcher publishes the public keys of enabled signing key versions in a GCP KMS key ring.
+// The access token is read from GOOGLE_OAUTH_ACCESS_TOKEN or the GCE/GKE metadata server.
+type gcpKMSFetcher struct {
+	client   HTTPDoer
+	endpoint string
+	keyRing  string // projects/{project}/locations/{location}/keyRings/{ring}
+	filter   string // cryptoKeys list filter, e.g. labels.jwks=true
+	maxBytes int64
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newGCPKMSFetcher(client HTTPDoer, endpoint, keyRing, filter string, maxBytes int64) *gcpKMSFetcher {
+	if endpoint == "" {
+		endpoint = gcpKMSEndpoint
+	}
+	return &gcpKMSFetcher{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/",
+		keyRing:  keyRing,
+		filter:   filter,
+		maxBytes: maxBytes,
+	}
+}
+
+func (f *gcpKMSFetcher) Fetch(ctx context.Context) (*FetchResult, error) {
+	keys, err := f.listSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		versions, err := f.listEnabledVersions(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			jwk, err := f.publicKey(ctx, version)
+			if err != nil {
+				return nil, fmt.Errorf("key version %s: %w", version, err)
+			}
+			if jwk != nil {
+				jwks.Keys = append(jwks.Keys, *jwk)
+			}
+		}
+	}
+	return &FetchResult{JWKS: jwks}, nil
+}
+
+// listSigningKeys returns the names of asymmetric signing keys in the key ring
+func (f *gcpKMSFetcher) listSigningKeys(ctx context.Context) ([]string, error) {
+	var names []string
+	pageToken := ""
+	for {
+		q := url.Values{}
+		if f.filter != "" {
+			q.Set("filter", f.filter)
+		}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		var resp struct {
+			CryptoKeys []struct {
+				Name    string `json:"name"`
+				Purpose string `json:"purpose"`
+			} `json:"cryptoKeys"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := f.get(ctx, f.keyRing+"/cryptoKeys", q, &resp); err != nil {
+			return nil, err
+		}
+		for _, k := range resp.CryptoKeys {
+			if k.Purpose == "ASYMMETRIC_SIGN" {
+				names = append(names, k.Name)
+			}
+		}
+		if resp.NextPageToken == "" {
+			return names, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// listEnabledVersions returns the names of enabled versions of a key
+func (f *gcpKMSFetcher) listEnabledVersions(ctx context.Context, key string) ([]string, error) {
+	var names []string
+	pageToken := ""
+	for {
+		q := url.Values{"filter": {"state=ENABLED"}}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		var resp struct {
+			CryptoKeyVersions []struct {
+				Name string `json:"name"`
+			} `json:"cryptoKeyVersions"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := f.get(ctx, key+"/cryptoKeyVersions", q, &resp); err != nil {
+			return nil, err
+		}
+		for _, v := range resp.CryptoKeyVersions {
+			names = append(names, v.Name)
+		}
+		if resp.NextPageToken == "" {
+			return names, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// publicKey fetches one key version's public part, returning nil for algorithms JWS can't use
+func (f *gcpKMSFetcher) publicKey(ctx context.Context, version string) (*JWK, error) {
+	var resp struct {
+		Pem       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := f.get(ctx, version+"/publicKey", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	alg := gcpAlgorithm(resp.Algorithm)
+	if alg == "" {
+		return nil, nil
+	}
+
+	pub, err := parsePublicKeyPEM([]byte(resp.Pem))
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := PublicKeyJWK(pub, alg)
+	if err != nil {
+		return nil, err
+	}
+	return &jwk, nil
+}
+
+// gcpAlgorithm maps a KMS algorithm (e.g. RSA_SIGN_PKCS1_2048_SHA256) to its JWA name
+func gcpAlgorithm(algorithm string) string {
+	if algorithm == "EC_SIGN_ED25519" {
+		return "EdDSA"
+	}
+
+	var family string
+	switch {
+	case strings.HasPrefix(algorithm, "RSA_SIGN_PKCS1_"):
+		family = "RS"
+	case strings.HasPrefix(algorithm, "RSA_SIGN_PSS_"):
+		family = "PS"
+	case strings.HasPrefix(algorithm, "EC_SIGN_P"):
+		family = "ES"
+	default:
+		return ""
+	}
+
+	for _, bits := range []string{"256", "384", "512"} {
+		if strings.HasSuffix(algorithm, "_SHA"+bits) {
+			return family + bits
+		}
+	}
+	return ""
+}
+
+// get performs an authenticated GET against the KMS REST API
+func (f *gcpKMSFetcher) get(ctx context.Context, path string, query url.Values, out any) error {
+	token, err := f.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	u := f.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return doJSON(f.client, req, out, f.maxBytes)
+}
+
+// accessToken returns a cached OAuth token, refreshing it from the metadata server when needed
+func (f *gcpKMSFetcher) accessToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.token != "" && time.Now().Before(f.tokenExpiry) {
+		return f.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataToken, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doJSON(f.client, req, &resp, f.maxBytes); err != nil {
+		return "", fmt.Errorf("failed to get access token from metadata server: %w", err)
+	}
+
+	f.token = resp.AccessToken
+	// Refresh a minute early so a token never expires mid-fetch
+	f.tokenExpiry = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return f.token, nil
+}
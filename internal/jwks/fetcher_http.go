@@ -0,0 +1,169 @@
+package jwks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/version"
+)
+
+// errorBodyLimit is how much of a non-200 response body is kept for the error message
+const errorBodyLimit = 1024
+
+// httpFetcher retrieves a JWKS from an HTTP(S) endpoint
+type httpFetcher struct {
+	config config.IDPConfig
+	client HTTPDoer
+	policy *redirectPolicy
+	logger *slog.Logger
+}
+
+// Fetch retrieves JWKS from the IDP endpoint and returns the data plus cache duration from headers.
+// Requests carry a correlation id that failures report, so the IDP vendor can find them in their logs.
+func (f *httpFetcher) Fetch(ctx context.Context) (*FetchResult, error) {
+	if f.config.GetRequestIDHeader() == "" {
+		return f.fetch(ctx, "")
+	}
+
+	requestID := newRequestID()
+	f.logger.Debug("Sending JWKS request", "idp", f.config.Name, "url", f.config.URL, "request_id", requestID)
+
+	result, err := f.fetch(ctx, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("%w (request_id %s)", err, requestID)
+	}
+	return result, nil
+}
+
+// fetch makes the discovery check and JWKS request, tagged with requestID unless it is empty
+func (f *httpFetcher) fetch(ctx context.Context, requestID string) (*FetchResult, error) {
+	if f.config.DiscoveryURL != "" {
+		if err := f.checkDiscovery(ctx, requestID); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", f.config.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	f.setHeaders(req, requestID)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Request != nil {
+		if err := f.policy.checkFinal(resp.Request.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Only keep the start of error bodies, they can be arbitrarily large HTML pages
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+		if t := throttled(resp, string(body), time.Now()); t != nil {
+			throttledResponses.Add(f.config.Name, 1)
+			return nil, t
+		}
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	if err := checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		return nil, err
+	}
+
+	maxBytes := f.config.GetMaxResponseBytes()
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("response too large: content length %d exceeds limit of %d bytes", resp.ContentLength, maxBytes)
+	}
+
+	// Parse Cache-Control header from IDP response
+	cacheControl := resp.Header.Get("Cache-Control")
+	directives := parseCacheControl(cacheControl)
+	idpMaxAge := directives.lifetime()
+
+	if idpMaxAge > 0 {
+		f.logger.Debug("IDP provided cache control",
+			"idp", f.config.Name,
+			"cache_control", cacheControl,
+			"max_age", idpMaxAge,
+		)
+	} else if directives.noStore || directives.noCache {
+		f.logger.Debug("IDP forbids caching, using config cache duration",
+			"idp", f.config.Name,
+			"cache_control", cacheControl,
+		)
+	}
+
+	jwks, err := decodeJWKS(resp.Body, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FetchResult{JWKS: jwks, MaxAge: idpMaxAge}, nil
+}
+
+// setHeaders applies the configured headers, Host override, User-Agent and correlation headers
+func (f *httpFetcher) setHeaders(req *http.Request, requestID string) {
+	if f.config.HostHeader != "" {
+		// Kept on relative redirects only, so it never reaches another host
+		req.Host = f.config.HostHeader
+	}
+	for k, v := range f.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if requestID != "" {
+		req.Header.Set(f.config.GetRequestIDHeader(), requestID)
+		if f.config.Traceparent {
+			req.Header.Set("Traceparent", traceparent(requestID))
+		}
+	}
+
+	userAgent := f.config.UserAgent
+	if userAgent == "" {
+		userAgent = version.UserAgent()
+	}
+	req.Header.Set("User-Agent", userAgent)
+}
+
+// checkDiscovery fetches the OpenID configuration and verifies it advertises the expected issuer
+// and JWKS URL, catching a domain or realm that points at the wrong tenant
+func (f *httpFetcher) checkDiscovery(ctx context.Context, requestID string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", f.config.DiscoveryURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create discovery request: %w", err)
+	}
+	f.setHeaders(req, requestID)
+	req.Header.Set("Accept", "application/json")
+
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := doJSON(f.client, req, &doc, f.config.GetMaxResponseBytes()); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+
+	if f.config.Issuer != "" && doc.Issuer != f.config.Issuer {
+		return validationErrorf("discovery issuer %q does not match expected issuer %q", doc.Issuer, f.config.Issuer)
+	}
+	if doc.JWKSURI != "" && doc.JWKSURI != f.config.URL {
+		f.logger.Warn("Discovery advertises a different JWKS URL",
+			"idp", f.config.Name,
+			"jwks_uri", doc.JWKSURI,
+			"url", f.config.URL,
+		)
+	}
+	return nil
+}
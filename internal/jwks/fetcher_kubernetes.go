@@ -0,0 +1,338 @@
+package jwks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// kubeWatchTimeout makes the API server end watches periodically so they are re-established
+	kubeWatchTimeout = 5 * time.Minute
+	// kubeWatchBackoff is the pause before re-watching after an error
+	kubeWatchBackoff = 5 * time.Second
+	// kubeTokenTTL is how long the service account token is used before it is read again.
+	// Projected tokens are rotated by the kubelet well before they expire.
+	kubeTokenTTL = time.Minute
+)
+
+// Watcher is implemented by fetchers whose source can push changes.
+// The Updater refetches as soon as the returned channel fires, in addition to its schedule.
+type Watcher interface {
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// kubeObject is the part of a Secret or ConfigMap the fetcher reads
+type kubeObject struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// kubeFetcher publishes keys stored in labeled Secrets or ConfigMaps.
+// It lists the objects on Fetch and watches them so changes are picked up immediately.
+type kubeFetcher struct {
+	client    *http.Client
+	apiURL    string // https://host:port
+	tokenFile string // service account token, read again every kubeTokenTTL
+	resource  string // secrets or configmaps
+	ns        string
+	selector  string
+	maxBytes  int64
+	logger    *slog.Logger
+
+	mu              sync.Mutex
+	resourceVersion string // of the last list or watch event, where the next watch starts
+	token           string
+	tokenRead       time.Time
+}
+
+func newKubeFetcher(kind, namespace, selector string, maxBytes int64, logger *slog.Logger) (*kubeFetcher, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster (KUBERNETES_SERVICE_HOST not set)")
+	}
+
+	tokenFile := serviceAccountDir + "/token"
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in cluster CA")
+	}
+
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	resource := "secrets"
+	if kind == "configmap" {
+		resource = "configmaps"
+	}
+
+	return &kubeFetcher{
+		// No client timeout, watches are long-lived and bounded by their context and timeoutSeconds
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		},
+		apiURL:    "https://" + net.JoinHostPort(host, port),
+		tokenFile: tokenFile,
+		resource:  resource,
+		ns:        namespace,
+		selector:  selector,
+		maxBytes:  maxBytes,
+		logger:    logger,
+		token:     strings.TrimSpace(string(token)),
+		tokenRead: time.Now(),
+	}, nil
+}
+
+func (f *kubeFetcher) Fetch(ctx context.Context) (*FetchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := f.request(ctx, url.Values{"labelSelector": {f.selector}})
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []kubeObject `json:"items"`
+	}
+	if err := doJSON(f.client, req, &list, f.maxBytes); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", f.resource, err)
+	}
+
+	f.mu.Lock()
+	f.resourceVersion = list.Metadata.ResourceVersion
+	f.mu.Unlock()
+
+	jwks := &JWKS{Keys: make([]JWK, 0)}
+	for _, obj := range list.Items {
+		jwks.Keys = append(jwks.Keys, f.objectKeys(obj)...)
+	}
+	return &FetchResult{JWKS: jwks}, nil
+}
+
+// objectKeys extracts the keys of every data entry, skipping entries that aren't keys
+func (f *kubeFetcher) objectKeys(obj kubeObject) []JWK {
+	names := make([]string, 0, len(obj.Data))
+	for name := range obj.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var keys []JWK
+	for _, name := range names {
+		value := []byte(obj.Data[name])
+		if f.resource == "secrets" {
+			decoded, err := base64.StdEncoding.DecodeString(obj.Data[name])
+			if err != nil {
+				f.logger.Warn("Skipping undecodable secret entry", "object", obj.Metadata.Name, "entry", name, "error", err)
+				continue
+			}
+			value = decoded
+		}
+
+		entryKeys, err := parseKeyEntry(value)
+		if err != nil {
+			f.logger.Warn("Skipping entry without a usable key", "object", obj.Metadata.Name, "entry", name, "error", err)
+			continue
+		}
+		keys = append(keys, entryKeys...)
+	}
+	return keys
+}
+
+// parseKeyEntry parses a JWKS, a single JWK or a PEM key/certificate.
+// Private key material is never returned.
+func parseKeyEntry(value []byte) ([]JWK, error) {
+	value = bytes.TrimSpace(value)
+
+	if bytes.HasPrefix(value, []byte("{")) {
+		var doc struct {
+			JWK
+			Keys []JWK `json:"keys"`
+		}
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		keys := doc.Keys
+		if keys == nil {
+			keys = []JWK{doc.JWK}
+		}
+		for i := range keys {
+			if keys[i].Kty == "" {
+				return nil, fmt.Errorf("JSON entry is not a JWK or JWKS")
+			}
+			if keys[i].Kty == "oct" {
+				return nil, fmt.Errorf("symmetric keys are never published")
+			}
+			keys[i] = publicPart(keys[i])
+		}
+		return keys, nil
+	}
+
+	pub, err := parsePublicKeyPEM(value)
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := PublicKeyJWK(pub, "")
+	if err != nil {
+		return nil, err
+	}
+	return []JWK{jwk}, nil
+}
+
+// Watch streams changes to the selected objects until ctx is done
+func (f *kubeFetcher) Watch(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		for ctx.Err() == nil {
+			if err := f.watch(ctx, changes); err != nil && ctx.Err() == nil {
+				f.logger.Warn("Kubernetes watch failed, retrying", "resource", f.resource, "error", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(kubeWatchBackoff):
+				}
+			}
+		}
+	}()
+
+	return changes
+}
+
+// watch runs one watch request, signalling changes until the server ends it
+func (f *kubeFetcher) watch(ctx context.Context, changes chan<- struct{}) error {
+	f.mu.Lock()
+	rv := f.resourceVersion
+	f.mu.Unlock()
+
+	q := url.Values{
+		"labelSelector":       {f.selector},
+		"watch":               {"true"},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {fmt.Sprint(int(kubeWatchTimeout.Seconds()))},
+	}
+	if rv != "" {
+		q.Set("resourceVersion", rv)
+	}
+	req, err := f.request(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	for scanner.Scan() {
+		var event struct {
+			Type   string     `json:"type"`
+			Object kubeObject `json:"object"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("invalid watch event: %w", err)
+		}
+
+		switch event.Type {
+		case "ERROR":
+			// Usually 410 Gone: our resource version is too old. Relist via a refetch.
+			f.setResourceVersion("")
+			notify(changes)
+			return fmt.Errorf("watch expired")
+		case "BOOKMARK":
+			f.setResourceVersion(event.Object.Metadata.ResourceVersion)
+		default:
+			f.setResourceVersion(event.Object.Metadata.ResourceVersion)
+			f.logger.Info("Key source changed", "resource", f.resource, "object", event.Object.Metadata.Name, "event", event.Type)
+			notify(changes)
+		}
+	}
+	return scanner.Err()
+}
+
+func (f *kubeFetcher) setResourceVersion(rv string) {
+	f.mu.Lock()
+	f.resourceVersion = rv
+	f.mu.Unlock()
+}
+
+// request builds an authenticated GET for the selected resource
+func (f *kubeFetcher) request(ctx context.Context, q url.Values) (*http.Request, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/%s?%s", f.apiURL, url.PathEscape(f.ns), f.resource, q.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+f.bearerToken())
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// bearerToken returns the service account token, read again once kubeTokenTTL has passed so a
+// rotated token is used before the previous one expires. A failed read keeps the previous token.
+func (f *kubeFetcher) bearerToken() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.tokenRead) < kubeTokenTTL {
+		return f.token
+	}
+	token, err := os.ReadFile(f.tokenFile)
+	if err != nil {
+		f.logger.Warn("Failed to read service account token, using the previous one", "error", err)
+		return f.token
+	}
+	f.token, f.tokenRead = strings.TrimSpace(string(token)), time.Now()
+	return f.token
+}
+
+// notify signals a change without blocking; pending signals are coalesced
+func notify(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
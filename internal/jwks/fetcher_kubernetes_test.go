@@ -0,0 +1,35 @@
+package jwks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKubeFetcherRereadsRotatedToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f := &kubeFetcher{tokenFile: tokenFile, logger: discardLogger(), token: "first", tokenRead: time.Now()}
+
+	// The kubelet rotates the projected token
+	if err := os.WriteFile(tokenFile, []byte("second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if token := f.bearerToken(); token != "first" {
+		t.Fatalf("token %q read again within kubeTokenTTL", token)
+	}
+	f.tokenRead = time.Now().Add(-kubeTokenTTL)
+	if token := f.bearerToken(); token != "second" {
+		t.Fatalf("token %q, want the rotated one", token)
+	}
+
+	// A failed read keeps the token last read
+	os.Remove(tokenFile)
+	f.tokenRead = time.Now().Add(-kubeTokenTTL)
+	if token := f.bearerToken(); token != "second" {
+		t.Fatalf("token %q after a failed read", token)
+	}
+}
@@ -0,0 +1,81 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execTimeout bounds commands that don't get a deadline from the caller
+const execTimeout = 30 * time.Second
+
+// fileFetcher reads a JWKS document from a local file
+type fileFetcher struct {
+	path     string
+	maxBytes int64
+}
+
+func (f *fileFetcher) Fetch(ctx context.Context) (*FetchResult, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JWKS file: %w", err)
+	}
+	defer file.Close()
+
+	jwks, err := decodeJWKS(file, f.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &FetchResult{JWKS: jwks}, nil
+}
+
+// execFetcher runs a command that prints a JWKS document on stdout.
+// This covers sources without a built-in fetcher, e.g. a psql query or ldapsearch.
+type execFetcher struct {
+	command  []string
+	maxBytes int64
+}
+
+func (f *execFetcher) Fetch(ctx context.Context) (*FetchResult, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, execTimeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, f.command[0], f.command[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedBuffer{buf: &stdout, limit: f.maxBytes + 1}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, limit: errorBodyLimit}
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("command %q failed: %w: %s", f.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	jwks, err := decodeJWKS(&stdout, f.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &FetchResult{JWKS: jwks}, nil
+}
+
+// limitedBuffer keeps the first limit bytes written and discards the rest
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int64
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	if room := l.limit - int64(l.buf.Len()); room > 0 {
+		if int64(len(p)) > room {
+			l.buf.Write(p[:room])
+		} else {
+			l.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
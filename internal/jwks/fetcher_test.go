@@ -0,0 +1,49 @@
+package jwks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+func TestDiscoveryResponseLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunked bool // no Content-Length, only the streaming limit applies
+	}{
+		{name: "content length"},
+		{name: "chunked", chunked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := `{"issuer":"https://idp.example.com","jwks_uri":"https://idp.example.com/jwks","padding":"` + strings.Repeat("x", 4096) + `"}`
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if tt.chunked {
+					w.(http.Flusher).Flush()
+				}
+				w.Write([]byte(doc))
+			}))
+			defer srv.Close()
+
+			f := &httpFetcher{
+				config: config.IDPConfig{Name: "corp", DiscoveryURL: srv.URL, MaxResponseBytes: 1024},
+				client: srv.Client(),
+				logger: discardLogger(),
+			}
+			err := f.checkDiscovery(context.Background(), "req")
+			if err == nil || !strings.Contains(err.Error(), "response too large") {
+				t.Fatalf("expected the oversized discovery document to be rejected, got %v", err)
+			}
+
+			f.config.MaxResponseBytes = int64(len(doc))
+			if err := f.checkDiscovery(context.Background(), "req"); err != nil {
+				t.Fatalf("discovery document within the limit rejected: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package jwks
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ManagerStats is a snapshot of the manager's internals for diagnosing memory growth
+type ManagerStats struct {
+	IDPs        int                 `json:"idps"`
+	Subscribers int                 `json:"subscribers"` // OnChange listeners
+	Snapshots   int64               `json:"snapshots"`   // snapshots published since start
+	Contended   int64               `json:"contended"`   // updates that had to wait for another update
+	WaitMs      float64             `json:"wait_ms"`     // total time updates spent waiting
+	PerIDP      map[string]IDPStats `json:"per_idp"`
+}
+
+// IDPStats describes the memory held for one IDP
+type IDPStats struct {
+	Keys            int `json:"keys"`
+	KeyMaterial     int `json:"key_material_bytes"` // bytes of key fields (n, e, x5c, ...)
+	SerializedBytes int `json:"serialized_bytes"`   // size of the JWKS as served
+	KeyHistory      int `json:"key_history"`
+	RecentErrors    int `json:"recent_errors"`
+	FetchSamples    int `json:"fetch_samples"` // entries kept for SLO reporting
+}
+
+// Inspect returns the current snapshot, subscriber and per-IDP statistics
+func (m *Manager) Inspect() ManagerStats {
+	idps := m.state.Load().idps
+	stats := ManagerStats{
+		IDPs:        len(idps),
+		Subscribers: len(m.listeners),
+		Snapshots:   m.swaps.Load(),
+		Contended:   m.contended.Load(),
+		WaitMs:      float64(m.waitNanos.Load()) / float64(time.Millisecond),
+		PerIDP:      make(map[string]IDPStats, len(idps)),
+	}
+	for name, data := range idps {
+		stats.PerIDP[name] = idpStats(data)
+	}
+
+	m.historyMu.Lock()
+	for name, h := range m.history {
+		h.mu.Lock()
+		s := stats.PerIDP[name]
+		s.FetchSamples = len(h.samples)
+		stats.PerIDP[name] = s
+		h.mu.Unlock()
+	}
+	m.historyMu.Unlock()
+
+	return stats
+}
+
+func idpStats(data *IDPData) IDPStats {
+	s := IDPStats{
+		KeyHistory:   len(data.KeyHistory),
+		RecentErrors: len(data.RecentErrors),
+	}
+	if data.JWKS == nil {
+		return s
+	}
+
+	s.Keys = len(data.JWKS.Keys)
+	for _, k := range data.JWKS.Keys {
+		s.KeyMaterial += keyMaterialSize(k)
+	}
+	if encoded, err := json.Marshal(data.JWKS); err == nil {
+		s.SerializedBytes = len(encoded)
+	}
+	return s
+}
+
+// keyMaterialSize returns the bytes held by a key's fields
+func keyMaterialSize(k JWK) int {
+	n := len(k.Kid) + len(k.Kty) + len(k.Alg) + len(k.Use) + len(k.N) + len(k.E) +
+		len(k.X5t) + len(k.X5tS256) + len(k.Crv) + len(k.X) + len(k.Y) +
+		len(k.D) + len(k.P) + len(k.Q) + len(k.Dp) + len(k.Dq) + len(k.Qi) + len(k.K)
+	for _, c := range k.X5c {
+		n += len(c)
+	}
+	return n
+}
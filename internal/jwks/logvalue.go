@@ -0,0 +1,26 @@
+package jwks
+
+import "log/slog"
+
+// LogValue logs a key by its identifying members only, never its key material
+func (k JWK) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("kid", k.Kid), slog.String("kty", k.Kty)}
+	for _, m := range []struct{ name, value string }{{"alg", k.Alg}, {"use", k.Use}, {"crv", k.Crv}} {
+		if m.value != "" {
+			attrs = append(attrs, slog.String(m.name, m.value))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// LogValue logs a key set as its size and kids
+func (j *JWKS) LogValue() slog.Value {
+	if j == nil {
+		return slog.GroupValue()
+	}
+	kids := make([]string, len(j.Keys))
+	for i, k := range j.Keys {
+		kids[i] = k.Kid
+	}
+	return slog.GroupValue(slog.Int("key_count", len(j.Keys)), slog.Any("kids", kids))
+}
@@ -1,46 +1,159 @@
 package jwks
 
 import (
+	"context"
 	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// historySize is how many key changes and errors are kept per IDP
+const historySize = 20
+
+// state is an immutable snapshot of all IDPs. Updates copy it and swap the pointer,
+// so readers never lock and always see a consistent view across IDPs.
+type state struct {
+	idps map[string]*IDPData
+}
+
 // Manager manages JWKS data for multiple IDPs
 type Manager struct {
-	mu     sync.RWMutex
-	data   map[string]*IDPData
-	logger *slog.Logger
+	state     atomic.Pointer[state]
+	mu        sync.Mutex // serializes updates
+	logger    *slog.Logger
+	listeners []func(Change)
+	limits    Limits
+	pending   map[string]chan struct{} // closed on an expected IDP's first update, guarded by mu
+
+	drainTimers map[string]*time.Timer // end of each draining IDP's grace period, guarded by mu
+
+	contended atomic.Int64 // updates that had to wait for another update
+	waitNanos atomic.Int64 // total time spent waiting
+	swaps     atomic.Int64 // snapshots published
+
+	historyMu sync.Mutex
+	history   map[string]*fetchHistory // fetch outcomes for SLO reporting
+	fetched   []func(Fetch)
 }
 
 // NewManager creates a new JWKS manager
 func NewManager(logger *slog.Logger) *Manager {
-	return &Manager{
-		data:   make(map[string]*IDPData),
-		logger: logger,
+	m := &Manager{
+		logger:  logger,
+		history: make(map[string]*fetchHistory),
+		pending: make(map[string]chan struct{}),
+
+		drainTimers: make(map[string]*time.Timer),
 	}
+	m.state.Store(&state{idps: make(map[string]*IDPData)})
+	return m
 }
 
-// Update stores or updates JWKS data for an IDP
-func (m *Manager) Update(name string, jwks *JWKS, maxKeys int, cacheDuration int, err error) {
+// lock takes the update lock, counting contention
+func (m *Manager) lock() {
+	if m.mu.TryLock() {
+		return
+	}
+	start := time.Now()
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.contended.Add(1)
+	m.waitNanos.Add(int64(time.Since(start)))
+}
 
-	data, exists := m.data[name]
+// modify returns a private copy of the IDP's data for an update, must hold m.mu
+func (m *Manager) modify(name string) *IDPData {
+	current, exists := m.state.Load().idps[name]
 	if !exists {
-		data = &IDPData{
+		return &IDPData{
 			Name: name,
 		}
-		m.data[name] = data
+	}
+	data := *current
+	return &data
+}
+
+// publish swaps in a snapshot with the IDP's data replaced, must hold m.mu
+func (m *Manager) publish(data *IDPData) {
+	current := m.state.Load().idps
+	idps := make(map[string]*IDPData, len(current)+1)
+	for name, d := range current {
+		idps[name] = d
+	}
+	idps[data.Name] = data
+	m.state.Store(&state{idps: idps})
+	m.swaps.Add(1)
+	updateTotals(idps)
+
+	if first, ok := m.pending[data.Name]; ok {
+		close(first)
+		delete(m.pending, data.Name)
+	}
+}
+
+// expect registers an IDP whose first update Wait can block on
+func (m *Manager) expect(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.state.Load().idps[name]; !exists && m.pending[name] == nil {
+		m.pending[name] = make(chan struct{})
+	}
+}
+
+// Pending returns the IDPs with an updater that haven't completed a fetch yet, sorted by name
+func (m *Manager) Pending() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.pending))
+	for name := range m.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Wait is Get that blocks until the first update of an IDP whose updater hasn't completed
+// a fetch yet, or until ctx is done. Unknown IDPs return right away.
+func (m *Manager) Wait(ctx context.Context, name string) (*IDPData, bool) {
+	m.mu.Lock()
+	first := m.pending[name]
+	m.mu.Unlock()
+
+	if first != nil {
+		select {
+		case <-first:
+		case <-ctx.Done():
+		}
+	}
+	return m.Get(name)
+}
+
+// Update stores a copy of jwks for an IDP, or records err
+func (m *Manager) Update(name string, jwks *JWKS, maxKeys int, cacheDuration int, err error) {
+	m.lock()
+
+	data := m.modify(name)
+	previous, previousError := data.JWKS, data.LastError
+
+	// The manager keeps its own copy, the caller may reuse or modify jwks after Update returns.
+	// Over quota updates fail and keep the previous keys.
+	keyBytes := 0
+	if err == nil {
+		jwks = m.holdPromoted(data, jwks).Clone()
+		keyBytes, err = m.applyQuota(name, jwks, maxKeys)
 	}
 
 	data.LastUpdated = time.Now()
 	data.UpdateCount++
 	data.MaxKeys = maxKeys
 	data.CacheDuration = cacheDuration
+	data.ThrottledUntil = throttledUntil(err, data.LastUpdated)
 
 	if err != nil {
 		data.LastError = err.Error()
+		data.Failures++
+		data.RecentErrors = appendBounded(data.RecentErrors, FetchError{Time: data.LastUpdated, Error: data.LastError})
 		m.logger.Error("Failed to update JWKS",
 			"idp", name,
 			"error", err,
@@ -48,21 +161,16 @@ func (m *Manager) Update(name string, jwks *JWKS, maxKeys int, cacheDuration int
 			"update_count", data.UpdateCount,
 		)
 	} else {
-		// Apply key limiting
-		originalCount := len(jwks.Keys)
-		if originalCount > maxKeys {
-			m.logger.Warn("Truncating keys to max limit",
-				"idp", name,
-				"original_count", originalCount,
-				"max_keys", maxKeys,
-			)
-			jwks.Keys = jwks.Keys[:maxKeys]
+		if added, removed := DiffKids(previous, jwks); len(added) > 0 || len(removed) > 0 {
+			data.KeyHistory = appendBounded(data.KeyHistory, KeyChange{Time: data.LastUpdated, Added: added, Removed: removed})
 		}
-
 		data.JWKS = jwks
 		data.KeyCount = len(jwks.Keys)
+		data.KeyBytes = keyBytes
 		data.CacheUntil = time.Now().Add(time.Duration(cacheDuration) * time.Second)
 		data.LastError = ""
+		data.LastSuccess = data.LastUpdated
+		data.Failures = 0
 
 		m.logger.Info("Successfully updated JWKS",
 			"idp", name,
@@ -74,30 +182,47 @@ func (m *Manager) Update(name string, jwks *JWKS, maxKeys int, cacheDuration int
 			"update_count", data.UpdateCount,
 		)
 	}
+
+	change := Change{
+		IDP:           name,
+		Previous:      previous,
+		JWKS:          data.JWKS,
+		Error:         data.LastError,
+		Time:          data.LastUpdated,
+		PreviousError: previousError,
+	}
+	m.publish(data)
+	m.mu.Unlock()
+	m.notify(change)
 }
 
 // UpdateWithIDPCache stores or updates JWKS data with IDP's suggested cache duration
 func (m *Manager) UpdateWithIDPCache(name string, jwks *JWKS, maxKeys int, cacheDuration int, idpSuggestedCache int, refreshInterval int, err error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.lock()
 
-	data, exists := m.data[name]
-	if !exists {
-		data = &IDPData{
-			Name: name,
-		}
-		m.data[name] = data
+	data := m.modify(name)
+	previous, previousError := data.JWKS, data.LastError
+
+	// The manager keeps its own copy, the caller may reuse or modify jwks after Update returns.
+	// Over quota updates fail and keep the previous keys.
+	keyBytes := 0
+	if err == nil {
+		jwks = m.holdPromoted(data, jwks).Clone()
+		keyBytes, err = m.applyQuota(name, jwks, maxKeys)
 	}
 
 	data.LastUpdated = time.Now()
 	data.UpdateCount++
 	data.MaxKeys = maxKeys
 	data.CacheDuration = cacheDuration
+	data.ThrottledUntil = throttledUntil(err, data.LastUpdated)
 	data.IDPSuggestedCache = idpSuggestedCache
 	data.RefreshInterval = refreshInterval
 
 	if err != nil {
 		data.LastError = err.Error()
+		data.Failures++
+		data.RecentErrors = appendBounded(data.RecentErrors, FetchError{Time: data.LastUpdated, Error: data.LastError})
 		m.logger.Error("Failed to update JWKS",
 			"idp", name,
 			"error", err,
@@ -105,21 +230,16 @@ func (m *Manager) UpdateWithIDPCache(name string, jwks *JWKS, maxKeys int, cache
 			"update_count", data.UpdateCount,
 		)
 	} else {
-		// Apply key limiting
-		originalCount := len(jwks.Keys)
-		if originalCount > maxKeys {
-			m.logger.Warn("Truncating keys to max limit",
-				"idp", name,
-				"original_count", originalCount,
-				"max_keys", maxKeys,
-			)
-			jwks.Keys = jwks.Keys[:maxKeys]
+		if added, removed := DiffKids(previous, jwks); len(added) > 0 || len(removed) > 0 {
+			data.KeyHistory = appendBounded(data.KeyHistory, KeyChange{Time: data.LastUpdated, Added: added, Removed: removed})
 		}
-
 		data.JWKS = jwks
 		data.KeyCount = len(jwks.Keys)
+		data.KeyBytes = keyBytes
 		data.CacheUntil = time.Now().Add(time.Duration(cacheDuration) * time.Second)
 		data.LastError = ""
+		data.LastSuccess = data.LastUpdated
+		data.Failures = 0
 
 		logFields := []interface{}{
 			"idp", name,
@@ -138,54 +258,113 @@ func (m *Manager) UpdateWithIDPCache(name string, jwks *JWKS, maxKeys int, cache
 
 		m.logger.Info("Successfully updated JWKS", logFields...)
 	}
+
+	change := Change{
+		IDP:           name,
+		Previous:      previous,
+		JWKS:          data.JWKS,
+		Error:         data.LastError,
+		Time:          data.LastUpdated,
+		PreviousError: previousError,
+	}
+	m.publish(data)
+	m.mu.Unlock()
+	m.notify(change)
+}
+
+// Remove drops an IDP whose updater was stopped, with its keys and fetch history, and returns its
+// last data, nil if it had none. Listeners see its keys go away.
+func (m *Manager) Remove(name string) *IDPData {
+	m.lock()
+	current := m.state.Load().idps
+	data, exists := current[name]
+	if first := m.pending[name]; first != nil {
+		close(first)
+		delete(m.pending, name)
+	}
+	if !exists {
+		m.mu.Unlock()
+		return nil
+	}
+	idps := make(map[string]*IDPData, len(current))
+	for n, d := range current {
+		if n != name {
+			idps[n] = d
+		}
+	}
+	m.state.Store(&state{idps: idps})
+	m.swaps.Add(1)
+	updateTotals(idps)
+	m.mu.Unlock()
+
+	m.historyMu.Lock()
+	delete(m.history, name)
+	m.historyMu.Unlock()
+
+	m.notify(Change{IDP: name, Previous: data.JWKS, Time: time.Now()})
+	return data
+}
+
+// Restore serves the data Remove returned again, keys included, until the IDP's next update.
+// Listeners see its keys come back.
+func (m *Manager) Restore(data *IDPData) {
+	m.lock()
+	m.publish(data)
+	m.mu.Unlock()
+	m.notify(Change{IDP: data.Name, JWKS: data.JWKS, Error: data.LastError, Time: time.Now()})
+}
+
+// appendBounded returns a new slice with v appended, keeping the last historySize entries.
+// Copies of IDPData share the old slice, so it is never modified in place.
+func appendBounded[T any](s []T, v T) []T {
+	start := max(0, len(s)+1-historySize)
+	out := make([]T, 0, len(s)+1-start)
+	out = append(out, s[start:]...)
+	return append(out, v)
+}
+
+// OnChange registers fn to be called after updates that change an IDP's keys or error state.
+// Must be called before the updaters start; fn runs on the updating goroutine and must not block.
+func (m *Manager) OnChange(fn func(Change)) {
+	m.listeners = append(m.listeners, fn)
+}
+
+// notify calls the listeners when the served keys changed or the IDP failed or recovered
+func (m *Manager) notify(change Change) {
+	failedOrRecovered := (change.Error == "") != (change.PreviousError == "")
+	if !change.ServedChanged() && !failedOrRecovered {
+		return
+	}
+	for _, fn := range m.listeners {
+		fn(change)
+	}
 }
 
 // Get retrieves JWKS data for a specific IDP
 func (m *Manager) Get(name string) (*IDPData, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	data, exists := m.data[name]
+	data, exists := m.state.Load().idps[name]
 	if !exists {
 		return nil, false
 	}
 
-	// Return a copy to avoid race conditions
-	dataCopy := &IDPData{
-		Name:        data.Name,
-		JWKS:        data.JWKS,
-		LastUpdated: data.LastUpdated,
-		LastError:   data.LastError,
-		UpdateCount: data.UpdateCount,
-	}
-
-	return dataCopy, true
+	// Return a copy so callers can't modify the snapshot
+	dataCopy := *data
+	return &dataCopy, true
 }
 
-// GetAll retrieves all IDP data
+// GetAll retrieves all IDP data from a single consistent snapshot
 func (m *Manager) GetAll() map[string]*IDPData {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	result := make(map[string]*IDPData, len(m.data))
-	for name, data := range m.data {
-		result[name] = &IDPData{
-			Name:          data.Name,
-			JWKS:          data.JWKS,
-			LastUpdated:   data.LastUpdated,
-			LastError:     data.LastError,
-			UpdateCount:   data.UpdateCount,
-			KeyCount:      data.KeyCount,
-			MaxKeys:       data.MaxKeys,
-			CacheDuration: data.CacheDuration,
-			CacheUntil:    data.CacheUntil,
-		}
+	idps := m.state.Load().idps
+	result := make(map[string]*IDPData, len(idps))
+	for name, data := range idps {
+		dataCopy := *data
+		result[name] = &dataCopy
 	}
 
 	return result
 }
 
-// GetJWKS retrieves only the JWKS for a specific IDP
+// GetJWKS retrieves only the JWKS for a specific IDP, which must not be modified
 func (m *Manager) GetJWKS(name string) (*JWKS, bool) {
 	data, exists := m.Get(name)
 	if !exists || data.JWKS == nil {
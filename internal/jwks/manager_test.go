@@ -0,0 +1,188 @@
+package jwks
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	benchIDPs       = 500
+	benchKeysPerIDP = 10 // 5k keys in total
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// testKeySet returns n RSA keys whose kids start with prefix
+func testKeySet(prefix string, n int) *JWKS {
+	keys := make([]JWK, n)
+	for i := range keys {
+		keys[i] = JWK{
+			Kid: fmt.Sprintf("%s-%d", prefix, i),
+			Kty: "RSA",
+			Alg: "RS256",
+			Use: "sig",
+			N:   strings.Repeat("A", 342), // the size of a 2048 bit modulus
+			E:   "AQAB",
+		}
+	}
+	return &JWKS{Keys: keys}
+}
+
+func TestUpdateKeepsOwnCopy(t *testing.T) {
+	updates := map[string]func(m *Manager, keySet *JWKS, maxKeys int){
+		"Update": func(m *Manager, keySet *JWKS, maxKeys int) {
+			m.Update("corp", keySet, maxKeys, 900, nil)
+		},
+		"UpdateWithIDPCache": func(m *Manager, keySet *JWKS, maxKeys int) {
+			m.UpdateWithIDPCache("corp", keySet, maxKeys, 900, 0, 3600, nil)
+		},
+	}
+	for name, update := range updates {
+		t.Run(name, func(t *testing.T) {
+			m := NewManager(discardLogger())
+			keySet := testKeySet("corp", 3)
+			keySet.Keys[0].X5c = []string{"cert"}
+			update(m, keySet, 2)
+
+			// Over max_keys truncates the manager's copy, not the caller's key set
+			if len(keySet.Keys) != 3 {
+				t.Fatalf("caller's key set truncated to %d keys", len(keySet.Keys))
+			}
+
+			// The caller reuses its key set for the next fetch
+			keySet.Keys[0].Kid = "changed"
+			keySet.Keys[0].X5c[0] = "changed"
+			keySet.Keys[1] = JWK{Kid: "replaced", Kty: "oct"}
+			keySet.Keys = append(keySet.Keys[:0], JWK{Kid: "appended"})
+
+			served, ok := m.GetJWKS("corp")
+			if !ok {
+				t.Fatal("no key set served")
+			}
+			if len(served.Keys) != 2 || served.Keys[0].Kid != "corp-0" || served.Keys[1].Kid != "corp-1" {
+				t.Fatalf("served key set changed with the caller's: %+v", served.Keys)
+			}
+			if served.Keys[0].X5c[0] != "cert" {
+				t.Fatalf("served x5c changed with the caller's: %v", served.Keys[0].X5c)
+			}
+		})
+	}
+}
+
+// benchManager returns a manager holding benchIDPs IDPs of benchKeysPerIDP keys, and their names
+func benchManager(b *testing.B) (*Manager, []string) {
+	b.Helper()
+	m := NewManager(discardLogger())
+	names := make([]string, benchIDPs)
+	for i := range names {
+		names[i] = fmt.Sprintf("idp-%03d", i)
+		m.Update(names[i], testKeySet(names[i], benchKeysPerIDP), benchKeysPerIDP, 900, nil)
+	}
+	return m, names
+}
+
+func BenchmarkManagerUpdate(b *testing.B) {
+	m, names := benchManager(b)
+	sets := make([]*JWKS, len(names))
+	for i, name := range names {
+		sets[i] = testKeySet(name+"-rotated", benchKeysPerIDP)
+	}
+
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		n := i % len(names)
+		m.Update(names[n], sets[n], benchKeysPerIDP, 900, nil)
+	}
+}
+
+func BenchmarkManagerGet(b *testing.B) {
+	m, names := benchManager(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, ok := m.Get(names[i%len(names)]); !ok {
+				b.Fatal("missing IDP")
+			}
+		}
+	})
+}
+
+// BenchmarkManagerGetDuringUpdates measures reads while every IDP is refreshed continuously,
+// as when a short refresh interval meets many IDPs
+func BenchmarkManagerGetDuringUpdates(b *testing.B) {
+	m, names := benchManager(b)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				name := names[i%len(names)]
+				m.Update(name, testKeySet(name, benchKeysPerIDP), benchKeysPerIDP, 900, nil)
+			}
+		}()
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			data, ok := m.Get(names[i%len(names)])
+			if !ok || len(data.JWKS.Keys) != benchKeysPerIDP {
+				b.Fatal("inconsistent IDP data")
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+
+	b.ReportMetric(float64(m.contended.Load()), "contended-updates")
+}
+
+func BenchmarkManagerGetAllDuringUpdates(b *testing.B) {
+	m, names := benchManager(b)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			name := names[i%len(names)]
+			m.Update(name, testKeySet(name, benchKeysPerIDP), benchKeysPerIDP, 900, nil)
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if all := m.GetAll(); len(all) != benchIDPs {
+				b.Fatalf("snapshot has %d IDPs", len(all))
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
@@ -0,0 +1,90 @@
+package jwks
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// canonicalKty and canonicalCrv map lowercased names to their registered spelling
+var (
+	canonicalKty = map[string]string{"rsa": "RSA", "ec": "EC", "okp": "OKP", "oct": "oct"}
+	canonicalCrv = map[string]string{
+		"p-256": "P-256", "p-384": "P-384", "p-521": "P-521",
+		"ed25519": "Ed25519", "ed448": "Ed448", "x25519": "X25519", "x448": "X448",
+	}
+)
+
+// algForCurve is the only signature algorithm defined for each curve
+var algForCurve = map[string]string{
+	"P-256": "ES256", "P-384": "ES384", "P-521": "ES512",
+	"Ed25519": "EdDSA", "Ed448": "EdDSA",
+}
+
+// normalize canonicalizes a key set in place: kty and crv get their registered spelling,
+// base64url members lose padding and standard alphabet characters, and a missing alg is
+// inferred where the key type determines it. Keys that can't be normalized are removed
+// and returned as errors.
+func normalize(keySet *JWKS) []error {
+	var dropped []error
+	kept := keySet.Keys[:0]
+	for i, k := range keySet.Keys {
+		if err := normalizeKey(&k); err != nil {
+			dropped = append(dropped, fmt.Errorf("key %d (kid %q): %w", i, k.Kid, err))
+			continue
+		}
+		kept = append(kept, k)
+	}
+	keySet.Keys = kept
+	return dropped
+}
+
+func normalizeKey(k *JWK) error {
+	kty, ok := canonicalKty[strings.ToLower(k.Kty)]
+	if !ok {
+		return fmt.Errorf("unsupported kty %q", k.Kty)
+	}
+	k.Kty = kty
+
+	if k.Crv != "" {
+		crv, ok := canonicalCrv[strings.ToLower(k.Crv)]
+		if !ok {
+			return fmt.Errorf("unsupported crv %q", k.Crv)
+		}
+		k.Crv = crv
+	}
+	if (kty == "EC" || kty == "OKP") && k.Crv == "" {
+		return fmt.Errorf("%s key without crv", kty)
+	}
+
+	for _, field := range []*string{&k.N, &k.E, &k.X, &k.Y, &k.D, &k.P, &k.Q, &k.Dp, &k.Dq, &k.Qi, &k.K, &k.X5t, &k.X5tS256} {
+		v, err := canonicalBase64URL(*field)
+		if err != nil {
+			return err
+		}
+		*field = v
+	}
+
+	if k.Alg == "" && k.Use != "enc" {
+		switch kty {
+		case "EC", "OKP":
+			k.Alg = algForCurve[k.Crv] // X25519 and X448 are key agreement only
+		case "RSA":
+			k.Alg = "RS256" // the algorithm OIDC requires for RSA signing keys
+		}
+	}
+	return nil
+}
+
+// canonicalBase64URL strips padding and maps the standard base64 alphabet to base64url
+func canonicalBase64URL(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	v = strings.TrimRight(v, "=")
+	v = strings.NewReplacer("+", "-", "/", "_").Replace(v)
+	if _, err := base64.RawURLEncoding.DecodeString(v); err != nil {
+		return "", fmt.Errorf("invalid base64url value")
+	}
+	return v, nil
+}
@@ -0,0 +1,158 @@
+package jwks
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// outboundStats counts fetches that waited for or were skipped by the outbound limiter
+var outboundStats = expvar.NewMap("outbound_limiter")
+
+// errRetryBudget is returned for a retry while the retry budget is spent
+var errRetryBudget = errors.New("retry budget exhausted")
+
+// OutboundLimiter bounds the fetches of all updaters together: how many run at once and how
+// many start per second. Fetches following a failure only get a share of the rate, so failing
+// IDPs can't starve the healthy ones.
+type OutboundLimiter struct {
+	slots   chan struct{} // nil when concurrency isn't limited
+	fetches *tokenBucket  // nil when the rate isn't limited
+	retries *tokenBucket  // nil when the rate isn't limited
+}
+
+// NewOutboundLimiter creates the limiter shared by all updaters, nil when no limit is set
+func NewOutboundLimiter(cfg config.OutboundConfig) *OutboundLimiter {
+	if !cfg.Enabled() {
+		return nil
+	}
+	l := &OutboundLimiter{}
+	if cfg.MaxConcurrentFetches > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrentFetches)
+	}
+	if cfg.MaxFetchesPerSecond > 0 {
+		rate := cfg.MaxFetchesPerSecond
+		l.fetches = newTokenBucket(rate, cfg.GetBurst())
+		retryRate := rate * float64(cfg.GetRetryBudget()) / 100
+		l.retries = newTokenBucket(retryRate, max(int(retryRate), 1))
+	}
+	return l
+}
+
+// WithOutboundLimiter makes the updater wait for the shared limiter before every fetch
+func WithOutboundLimiter(l *OutboundLimiter) UpdaterOption {
+	return func(u *Updater) {
+		u.limiter = l
+	}
+}
+
+// acquire waits until a fetch may start, or ctx is done. A retry fails right away with
+// errRetryBudget while the retry budget is spent. The returned release must be called
+// once the fetch is done.
+func (l *OutboundLimiter) acquire(ctx context.Context, retry bool) (func(), error) {
+	if retry && l.retries != nil && !l.retries.take(time.Now()) {
+		outboundStats.Add("skipped_retry_budget", 1)
+		return nil, errRetryBudget
+	}
+	waited := false
+	fail := func(err error) (func(), error) {
+		if retry && l.retries != nil {
+			l.retries.cancel()
+		}
+		outboundStats.Add("skipped_deadline", 1)
+		return nil, err
+	}
+
+	if l.fetches != nil {
+		if wait := l.fetches.reserve(time.Now()); wait > 0 {
+			waited = true
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				l.fetches.cancel()
+				return fail(ctx.Err())
+			}
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			waited = true
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				return fail(ctx.Err())
+			}
+		}
+	}
+
+	if waited {
+		outboundStats.Add("waited", 1)
+	}
+	return func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}, nil
+}
+
+// tokenBucket allows rate events per second on average and burst at once
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// refill adds the tokens accrued since the last call, must hold b.mu
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// take takes a token if one is available
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes a token, ahead of time if none is available, and returns how long to wait for it
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a reserved token that wasn't used
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
@@ -0,0 +1,108 @@
+package jwks
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+)
+
+var (
+	quotaStats      = expvar.NewMap("key_quotas")
+	heldKeys        = new(expvar.Int)
+	heldKeyBytes    = new(expvar.Int)
+	truncatedKeys   = new(expvar.Int)
+	rejectedUpdates = new(expvar.Int)
+)
+
+func init() {
+	quotaStats.Set("total_keys", heldKeys)
+	quotaStats.Set("total_key_bytes", heldKeyBytes)
+	quotaStats.Set("truncated_keys", truncatedKeys)
+	quotaStats.Set("rejected_updates", rejectedUpdates)
+}
+
+// ErrQuotaExceeded is returned for updates that would exceed a global limit
+var ErrQuotaExceeded = errors.New("key quota exceeded")
+
+// Limits bounds the keys the manager holds. Zero values mean no limit.
+type Limits struct {
+	MaxTotalKeys     int            // keys across all IDPs
+	MaxTotalKeyBytes int            // bytes of key material across all IDPs
+	MaxKeyBytes      map[string]int // bytes of key material per IDP
+}
+
+// SetLimits sets the key quotas, must be called before the updaters start
+func (m *Manager) SetLimits(limits Limits) {
+	m.limits = limits
+}
+
+// SetMaxKeyBytes changes the key material quota of one IDP, 0 for no limit
+func (m *Manager) SetMaxKeyBytes(name string, limit int) {
+	m.lock()
+	defer m.mu.Unlock()
+	perIDP := make(map[string]int, len(m.limits.MaxKeyBytes)+1)
+	for idp, n := range m.limits.MaxKeyBytes {
+		perIDP[idp] = n
+	}
+	perIDP[name] = limit
+	m.limits.MaxKeyBytes = perIDP
+}
+
+// applyQuota truncates the manager's copy of the key set to the IDP's quotas and checks the global limits
+// against the other IDPs' current keys. It returns the bytes of key material kept. Must hold m.mu.
+func (m *Manager) applyQuota(name string, keySet *JWKS, maxKeys int) (int, error) {
+	if originalCount := len(keySet.Keys); originalCount > maxKeys {
+		m.logger.Warn("Truncating keys to max limit",
+			"idp", name,
+			"original_count", originalCount,
+			"max_keys", maxKeys,
+		)
+		truncatedKeys.Add(int64(originalCount - maxKeys))
+		keySet.Keys = keySet.Keys[:maxKeys]
+	}
+
+	keyBytes := 0
+	for i, k := range keySet.Keys {
+		size := keyMaterialSize(k)
+		if limit := m.limits.MaxKeyBytes[name]; limit > 0 && keyBytes+size > limit {
+			m.logger.Warn("Truncating keys to max key bytes",
+				"idp", name,
+				"original_count", len(keySet.Keys),
+				"kept", i,
+				"max_key_bytes", limit,
+			)
+			truncatedKeys.Add(int64(len(keySet.Keys) - i))
+			keySet.Keys = keySet.Keys[:i]
+			break
+		}
+		keyBytes += size
+	}
+
+	totalKeys, totalBytes := len(keySet.Keys), keyBytes
+	for other, data := range m.state.Load().idps {
+		if other != name {
+			totalKeys += data.KeyCount
+			totalBytes += data.KeyBytes
+		}
+	}
+	if limit := m.limits.MaxTotalKeys; limit > 0 && totalKeys > limit {
+		rejectedUpdates.Add(1)
+		return 0, fmt.Errorf("%w: %d keys across all IDPs, max_total_keys is %d", ErrQuotaExceeded, totalKeys, limit)
+	}
+	if limit := m.limits.MaxTotalKeyBytes; limit > 0 && totalBytes > limit {
+		rejectedUpdates.Add(1)
+		return 0, fmt.Errorf("%w: %d bytes of keys across all IDPs, max_total_key_bytes is %d", ErrQuotaExceeded, totalBytes, limit)
+	}
+	return keyBytes, nil
+}
+
+// updateTotals refreshes the total gauges from a snapshot
+func updateTotals(idps map[string]*IDPData) {
+	keys, keyBytes := 0, 0
+	for _, data := range idps {
+		keys += data.KeyCount
+		keyBytes += data.KeyBytes
+	}
+	heldKeys.Set(int64(keys))
+	heldKeyBytes.Set(int64(keyBytes))
+}
@@ -0,0 +1,227 @@
+package jwks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// recording is one upstream exchange, saved as a JSON file named after the time it was recorded,
+// so file order is time order. Request headers aren't saved, they may carry credentials.
+type recording struct {
+	RecordedAt  time.Time   `json:"recorded_at"`
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"request_body,omitempty"`
+	FinalURL    string      `json:"final_url,omitempty"` // after redirects
+	Error       string      `json:"error,omitempty"`     // the request failed without a response
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body,omitempty"`
+	BodyBase64  []byte      `json:"body_base64,omitempty"` // instead of body when it isn't valid UTF-8
+}
+
+// WithRecording saves the IDP's upstream responses to, or replays them from, its subdirectory of
+// the recordings directory. It wraps the HTTP client set so far, so it goes after WithHTTPClient.
+// Sources that aren't fetched over HTTP (file, exec, kubernetes) are not affected.
+func WithRecording(cfg config.RecordingConfig) UpdaterOption {
+	return func(u *Updater) {
+		dir := filepath.Join(cfg.GetDirectory(), u.config.Name)
+		switch cfg.Mode {
+		case config.RecordingRecord:
+			u.client = &recorder{
+				next:     u.client,
+				dir:      dir,
+				maxBytes: u.config.GetMaxResponseBytes(),
+				logger:   u.logger,
+			}
+		case config.RecordingReplay:
+			u.client = &replayer{dir: dir, next: make(map[string]int), logger: u.logger}
+		}
+	}
+}
+
+// recorder saves every response of the wrapped client before handing it on
+type recorder struct {
+	next     HTTPDoer
+	dir      string
+	maxBytes int64
+	logger   *slog.Logger
+}
+
+func (r *recorder) Do(req *http.Request) (*http.Response, error) {
+	reqBody, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	rec := recording{RecordedAt: time.Now(), Method: req.Method, URL: req.URL.String(), RequestBody: string(reqBody)}
+
+	resp, err := r.next.Do(req)
+	if err != nil {
+		rec.Error = err.Error()
+		r.save(rec)
+		return nil, err
+	}
+
+	// Keep one byte past the limit, so replays fail on oversized bodies the same way
+	body, err := io.ReadAll(io.LimitReader(resp.Body, r.maxBytes+1))
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec.Status = resp.StatusCode
+	rec.Header = resp.Header
+	if resp.Request != nil && resp.Request.URL.String() != rec.URL {
+		rec.FinalURL = resp.Request.URL.String()
+	}
+	if utf8.Valid(body) {
+		rec.Body = string(body)
+	} else {
+		rec.BodyBase64 = body
+	}
+	r.save(rec)
+	return resp, nil
+}
+
+// save writes a recording. Failing to record doesn't fail the fetch.
+func (r *recorder) save(rec recording) {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(r.dir, 0o755)
+	}
+	path := filepath.Join(r.dir, rec.RecordedAt.UTC().Format("20060102T150405.000000000Z")+".json")
+	if err == nil {
+		err = os.WriteFile(path, data, 0o644)
+	}
+	if err != nil {
+		r.logger.Warn("Failed to record upstream response", "url", rec.URL, "error", err)
+		return
+	}
+	r.logger.Debug("Recorded upstream response", "url", rec.URL, "status", rec.Status, "path", path)
+}
+
+// replayer answers requests from recordings instead of the network. Each request gets the next
+// recording of the same method, URL and body; the last one is repeated once all were replayed.
+type replayer struct {
+	dir    string
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	next map[string]int // by request, index of the recording replayed next
+}
+
+func (r *replayer) Do(req *http.Request) (*http.Response, error) {
+	reqBody, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := loadRecordings(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	recs = slices.DeleteFunc(recs, func(rec recording) bool {
+		return rec.Method != req.Method || rec.URL != req.URL.String() || rec.RequestBody != string(reqBody)
+	})
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("replay: no recording of %s %s in %s", req.Method, req.URL, r.dir)
+	}
+
+	key := req.Method + " " + req.URL.String() + "\n" + string(reqBody)
+	r.mu.Lock()
+	i := min(r.next[key], len(recs)-1)
+	r.next[key] = i + 1
+	r.mu.Unlock()
+	rec := recs[i]
+
+	r.logger.Debug("Replaying recorded response", "url", rec.URL, "recorded_at", rec.RecordedAt, "status", rec.Status)
+	if rec.Error != "" {
+		return nil, errors.New(rec.Error)
+	}
+
+	final := req
+	if rec.FinalURL != "" {
+		u, err := url.Parse(rec.FinalURL)
+		if err != nil {
+			return nil, fmt.Errorf("replay: invalid final_url: %w", err)
+		}
+		final = req.Clone(req.Context())
+		final.URL = u
+	}
+	body := rec.BodyBase64
+	if body == nil {
+		body = []byte(rec.Body)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		StatusCode:    rec.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       final,
+	}, nil
+}
+
+// loadRecordings reads the recordings in dir, oldest first
+func loadRecordings(dir string) ([]recording, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var recs []recording
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var rec recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// requestBody returns the body of req, leaving it readable for the request itself
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
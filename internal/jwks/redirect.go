@@ -0,0 +1,56 @@
+package jwks
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// redirectPolicy enforces the per-IDP redirect settings on the HTTP client
+type redirectPolicy struct {
+	maxRedirects    int
+	allowCrossHost  bool
+	requireSameHost bool
+	origin          *url.URL
+}
+
+func newRedirectPolicy(rawURL string, maxRedirects int, allowCrossHost, requireSameHost bool) *redirectPolicy {
+	origin, _ := url.Parse(rawURL)
+	return &redirectPolicy{
+		maxRedirects:    maxRedirects,
+		allowCrossHost:  allowCrossHost,
+		requireSameHost: requireSameHost,
+		origin:          origin,
+	}
+}
+
+// checkRedirect is used as http.Client.CheckRedirect
+func (p *redirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > p.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", p.maxRedirects)
+	}
+
+	prev := via[len(via)-1].URL
+	if prev.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing redirect from https to %s (%s)", req.URL.Scheme, req.URL.Redacted())
+	}
+
+	if !p.allowCrossHost && !p.sameHost(req.URL) {
+		return fmt.Errorf("refusing cross-host redirect to %s", req.URL.Redacted())
+	}
+
+	return nil
+}
+
+// checkFinal verifies where the request chain ended up
+func (p *redirectPolicy) checkFinal(final *url.URL) error {
+	if p.requireSameHost && !p.sameHost(final) {
+		return fmt.Errorf("final URL %s is not on configured host %s", final.Redacted(), p.origin.Host)
+	}
+	return nil
+}
+
+func (p *redirectPolicy) sameHost(u *url.URL) bool {
+	return p.origin != nil && strings.EqualFold(u.Host, p.origin.Host)
+}
@@ -0,0 +1,177 @@
+package jwks
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fetch samples are kept for sloRetention, at most maxSamples per IDP
+const (
+	sloRetention = 30 * 24 * time.Hour
+	maxSamples   = 50000
+)
+
+// fetchSample is the outcome of one fetch
+type fetchSample struct {
+	at      time.Time
+	ok      bool
+	latency time.Duration
+}
+
+// fetchHistory holds the recent fetches of one IDP, oldest first
+type fetchHistory struct {
+	mu      sync.Mutex
+	samples []fetchSample
+}
+
+// SLOWindow summarizes the fetches of one IDP over a rolling window
+type SLOWindow struct {
+	Window               string  `json:"window"`
+	Fetches              int     `json:"fetches"`
+	Successes            int     `json:"successes"`
+	Availability         float64 `json:"availability"`           // percent of successful fetches, 100 without fetches
+	P95LatencyMs         int64   `json:"p95_latency_ms"`         // over all fetches, failed ones included
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"` // percent of allowed failures left, negative when exceeded
+}
+
+// Fetch is the outcome of one fetch as recorded for SLO reporting
+type Fetch struct {
+	IDP     string
+	OK      bool
+	Latency time.Duration
+	Time    time.Time
+}
+
+// OnFetch registers fn to be called after every recorded fetch.
+// Must be called before the updaters start; fn runs on the updating goroutine and must not block.
+func (m *Manager) OnFetch(fn func(Fetch)) {
+	m.fetched = append(m.fetched, fn)
+}
+
+// RecordFetch records the outcome and latency of a fetch for SLO reporting
+func (m *Manager) RecordFetch(name string, ok bool, latency time.Duration, at time.Time) {
+	m.recordSample(name, fetchSample{at: at, ok: ok, latency: latency})
+	for _, fn := range m.fetched {
+		fn(Fetch{IDP: name, OK: ok, Latency: latency, Time: at})
+	}
+}
+
+// recordSample adds a sample to the IDP's history, dropping the ones past retention
+func (m *Manager) recordSample(name string, sample fetchSample) {
+	m.historyMu.Lock()
+	h, exists := m.history[name]
+	if !exists {
+		h = &fetchHistory{}
+		m.history[name] = h
+	}
+	m.historyMu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples = append(h.samples, sample)
+
+	// Drop samples past retention or over the cap
+	cutoff := sample.at.Add(-sloRetention)
+	drop := sort.Search(len(h.samples), func(i int) bool { return h.samples[i].at.After(cutoff) })
+	drop = max(drop, len(h.samples)-maxSamples)
+	if drop > 0 {
+		h.samples = append(h.samples[:0], h.samples[drop:]...)
+	}
+}
+
+// SLOReport summarizes an IDP's fetches over each window against an availability objective in percent
+func (m *Manager) SLOReport(name string, objective float64, windows []time.Duration, now time.Time) ([]SLOWindow, bool) {
+	m.historyMu.Lock()
+	h, exists := m.history[name]
+	m.historyMu.Unlock()
+	if !exists {
+		return nil, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	report := make([]SLOWindow, 0, len(windows))
+	for _, window := range windows {
+		cutoff := now.Add(-window)
+		start := sort.Search(len(h.samples), func(i int) bool { return h.samples[i].at.After(cutoff) })
+		report = append(report, summarize(h.samples[start:], window, objective))
+	}
+	return report, true
+}
+
+// Fetches returns up to limit of the IDP's most recent fetches, newest first
+func (m *Manager) Fetches(name string, limit int) []Fetch {
+	m.historyMu.Lock()
+	h, exists := m.history[name]
+	m.historyMu.Unlock()
+	if !exists {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fetches := make([]Fetch, 0, min(limit, len(h.samples)))
+	for i := len(h.samples) - 1; i >= 0 && len(fetches) < limit; i-- {
+		s := h.samples[i]
+		fetches = append(fetches, Fetch{IDP: name, OK: s.ok, Latency: s.latency, Time: s.at})
+	}
+	return fetches
+}
+
+// summarize computes availability, p95 latency and remaining error budget of samples
+func summarize(samples []fetchSample, window time.Duration, objective float64) SLOWindow {
+	w := SLOWindow{
+		Window:               windowName(window),
+		Fetches:              len(samples),
+		Availability:         100,
+		ErrorBudgetRemaining: 100,
+	}
+	if len(samples) == 0 {
+		return w
+	}
+
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		if s.ok {
+			w.Successes++
+		}
+		latencies = append(latencies, s.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	w.P95LatencyMs = latencies[int(math.Ceil(0.95*float64(len(latencies))))-1].Milliseconds()
+
+	failures := float64(w.Fetches - w.Successes)
+	w.Availability = round2(100 * float64(w.Successes) / float64(w.Fetches))
+
+	// Budget is the number of failures the objective allows over the window's fetches
+	allowed := (100 - objective) / 100 * float64(w.Fetches)
+	switch {
+	case allowed > 0:
+		w.ErrorBudgetRemaining = round2(100 * (allowed - failures) / allowed)
+	case failures > 0:
+		w.ErrorBudgetRemaining = -100
+	}
+	return w
+}
+
+// windowName renders a window as 30m, 1h or 7d
+func windowName(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,121 @@
+package jwks
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrUnknownIDP is returned for an IDP the manager has no data for
+	ErrUnknownIDP = errors.New("unknown IDP")
+	// ErrNothingStaged is returned by Promote when the IDP has no staged key set
+	ErrNothingStaged = errors.New("no staged key set")
+)
+
+// Stage stores a candidate key set for an IDP, replacing any staged before. It is not served as
+// the IDP's keys until Promote. The staged keys are public, so symmetric keys and private key
+// members are refused.
+func (m *Manager) Stage(name string, keys *JWKS) error {
+	if err := validateJWKS(keys, false, false); err != nil {
+		return err
+	}
+	for i, k := range keys.Keys {
+		if k.Kty == "oct" || k.K != "" {
+			return fmt.Errorf("key %d (%s): symmetric keys are never published", i, k.Kid)
+		}
+		if k.D != "" || k.P != "" || k.Q != "" || k.Dp != "" || k.Dq != "" || k.Qi != "" {
+			return fmt.Errorf("key %d (%s): private key members are never published", i, k.Kid)
+		}
+	}
+	m.lock()
+	defer m.mu.Unlock()
+	if _, exists := m.state.Load().idps[name]; !exists {
+		return ErrUnknownIDP
+	}
+	data := m.modify(name)
+	data.Staged = keys.Clone()
+	data.StagedAt = time.Now()
+	m.publish(data)
+	m.logger.Info("Key set staged", "idp", name, "key_count", len(keys.Keys))
+	return nil
+}
+
+// Unstage discards the staged key set of an IDP, reporting whether there was one
+func (m *Manager) Unstage(name string) bool {
+	m.lock()
+	defer m.mu.Unlock()
+	current, exists := m.state.Load().idps[name]
+	if !exists || current.Staged == nil {
+		return false
+	}
+	data := m.modify(name)
+	data.Staged, data.StagedAt = nil, time.Time{}
+	m.publish(data)
+	m.logger.Info("Staged key set discarded", "idp", name)
+	return true
+}
+
+// Promote makes the staged key set of an IDP its live keys in one swap. Fetched keys don't replace
+// it until the IDP publishes every promoted kid, so the trust change happens when the operator
+// decides rather than at the next refresh.
+func (m *Manager) Promote(name string) (*JWKS, error) {
+	m.lock()
+
+	current, exists := m.state.Load().idps[name]
+	if !exists {
+		m.mu.Unlock()
+		return nil, ErrUnknownIDP
+	}
+	if current.Staged == nil {
+		m.mu.Unlock()
+		return nil, ErrNothingStaged
+	}
+
+	data := m.modify(name)
+	previous, previousError := data.JWKS, data.LastError
+	keys := data.Staged.Clone()
+	keyBytes, err := m.applyQuota(name, keys, data.MaxKeys)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	now := time.Now()
+	if added, removed := DiffKids(previous, keys); len(added) > 0 || len(removed) > 0 {
+		data.KeyHistory = appendBounded(data.KeyHistory, KeyChange{Time: now, Added: added, Removed: removed})
+	}
+	data.JWKS = keys
+	data.KeyCount = len(keys.Keys)
+	data.KeyBytes = keyBytes
+	data.Promoted, data.PromotedAt = keys, now
+	data.Staged, data.StagedAt = nil, time.Time{}
+	m.publish(data)
+	m.mu.Unlock()
+
+	m.logger.Info("Staged key set promoted", "idp", name, "key_count", len(keys.Keys))
+	m.notify(Change{
+		IDP:           name,
+		Previous:      previous,
+		JWKS:          keys,
+		Error:         previousError,
+		Time:          now,
+		PreviousError: previousError,
+	})
+	return keys, nil
+}
+
+// holdPromoted returns the key set to store for a successful fetch: the promoted keys while the
+// IDP doesn't publish all of them yet, the fetched keys otherwise. Must hold m.mu.
+func (m *Manager) holdPromoted(data *IDPData, fetched *JWKS) *JWKS {
+	if data.Promoted == nil {
+		return fetched
+	}
+	if _, missing := DiffKids(data.Promoted, fetched); len(missing) > 0 {
+		m.logger.Debug("Keeping promoted key set, IDP doesn't publish it yet", "idp", data.Name, "missing", missing)
+		return data.Promoted
+	}
+	m.logger.Info("IDP publishes the promoted key set, serving fetched keys again", "idp", data.Name)
+	data.Promoted, data.PromotedAt = nil, time.Time{}
+	return fetched
+}
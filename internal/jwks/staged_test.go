@@ -0,0 +1,36 @@
+package jwks
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStageRefusesSecretKeyMaterial(t *testing.T) {
+	tests := []struct {
+		name string
+		key  JWK
+		want string
+	}{
+		{name: "RSA private exponent", key: JWK{Kid: "k", Kty: "RSA", N: "AQAB", E: "AQAB", D: "AQAB"}, want: "private key members"},
+		{name: "RSA CRT parameters", key: JWK{Kid: "k", Kty: "RSA", N: "AQAB", E: "AQAB", P: "AQ", Q: "AQ", Dp: "AQ", Dq: "AQ", Qi: "AQ"}, want: "private key members"},
+		{name: "EC private key", key: JWK{Kid: "k", Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ", D: "AQ"}, want: "private key members"},
+		{name: "symmetric key", key: JWK{Kid: "k", Kty: "oct", K: "c2VjcmV0"}, want: "symmetric keys"},
+	}
+	m := NewManager(discardLogger())
+	m.Update("corp", testKeySet("corp", 1), 10, 900, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Stage("corp", &JWKS{Keys: []JWK{testKeySet("next", 1).Keys[0], tt.key}})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected %q, got %v", tt.want, err)
+			}
+			if data, _ := m.Get("corp"); data.Staged != nil {
+				t.Fatal("refused key set was staged")
+			}
+		})
+	}
+
+	if err := m.Stage("corp", testKeySet("next", 2)); err != nil {
+		t.Fatalf("public key set refused: %v", err)
+	}
+}
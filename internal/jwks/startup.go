@@ -0,0 +1,70 @@
+package jwks
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// FetchAll performs the initial fetch for all updaters through a bounded worker pool.
+// It returns once every IDP has been fetched or the timeout expires; IDPs not fetched in
+// time are picked up by their updater as soon as it starts.
+func FetchAll(ctx context.Context, updaters []*Updater, concurrency int, timeout time.Duration, logger *slog.Logger) {
+	if len(updaters) == 0 {
+		return
+	}
+	if concurrency <= 0 || concurrency > len(updaters) {
+		concurrency = len(updaters)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	logger.Info("Fetching initial JWKS",
+		"idp_count", len(updaters),
+		"concurrency", concurrency,
+		"timeout", timeout.String(),
+	)
+
+	jobs := make(chan *Updater)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				u.fetchAndUpdate(ctx, time.Time{})
+			}
+		}()
+	}
+
+dispatch:
+	for _, u := range updaters {
+		select {
+		case jobs <- u:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	pending := make([]string, 0)
+	for _, u := range updaters {
+		if !u.initialized.Load() {
+			pending = append(pending, u.Name())
+		}
+	}
+
+	if len(pending) > 0 {
+		logger.Warn("Initial JWKS fetch deadline exceeded",
+			"duration_ms", time.Since(start).Milliseconds(),
+			"pending", pending,
+		)
+		return
+	}
+
+	logger.Info("Initial JWKS fetch completed", "duration_ms", time.Since(start).Milliseconds())
+}
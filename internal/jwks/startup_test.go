@@ -0,0 +1,35 @@
+package jwks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+func TestFetchAllDeadlineLeavesManagerAlone(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	m := NewManager(discardLogger())
+	idp := config.IDPConfig{Name: "slow", URL: upstream.URL, RefreshInterval: 3600}
+	u := NewUpdater(idp, m, discardLogger(), WithHTTPClient(upstream.Client()))
+	FetchAll(context.Background(), []*Updater{u}, 1, 50*time.Millisecond, discardLogger())
+
+	if data, ok := m.Get("slow"); ok {
+		t.Fatalf("fetch cut off by the startup deadline was recorded: last error %q", data.LastError)
+	}
+	if u.initialized.Load() {
+		t.Fatal("fetch cut off by the startup deadline counts as the initial fetch")
+	}
+}
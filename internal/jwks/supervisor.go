@@ -0,0 +1,294 @@
+package jwks
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+var (
+	// ErrNotStarted is returned by Reconcile and Restore before the updaters started
+	ErrNotStarted = errors.New("updaters have not started yet")
+	// ErrNoTombstone is returned by Restore for an IDP that wasn't removed within the retention
+	ErrNoTombstone = errors.New("no removed IDP to restore")
+	// ErrThrottled is returned by Refresh while the IDP's Retry-After hasn't passed
+	ErrThrottled = errors.New("IDP is throttling fetches")
+)
+
+// Supervisor runs the updaters and changes which IDPs are fetched at runtime
+type Supervisor struct {
+	manager *Manager
+	logger  *slog.Logger
+	opts    []UpdaterOption
+
+	mu         sync.Mutex // serializes Start, Reconcile and Restore
+	ctx        context.Context
+	running    map[string]*supervised // by IDP name
+	declared   []config.IDPConfig     // configurations of the running IDPs, in declaration order
+	onChange   func([]config.IDPConfig)
+	tombstones map[string]*Tombstone // removed IDPs that can be restored, by name
+	retention  time.Duration         // how long tombstones are kept, 0 drops removed IDPs right away
+}
+
+// Tombstone is an IDP removed by Reconcile, kept to be restored with its configuration and keys
+type Tombstone struct {
+	Name      string           `json:"name"`
+	RemovedAt time.Time        `json:"removed_at"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	KeyCount  int              `json:"key_count"`
+	config    config.IDPConfig // restarted on Restore
+	data      *IDPData         // served again on Restore, nil when the IDP had no data
+}
+
+// Config returns the configuration the IDP had when it was removed
+func (t Tombstone) Config() config.IDPConfig {
+	return t.config
+}
+
+// supervised is a running updater
+type supervised struct {
+	updater *Updater
+	config  config.IDPConfig
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// ReconcileResult lists the IDPs a reconciliation changed, each sorted by name
+type ReconcileResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"` // restarted with the new configuration, keys kept until the next fetch
+	Deleted   []string `json:"deleted"` // stopped, keys no longer served
+	Unchanged []string `json:"unchanged"`
+
+	Previous map[string]config.IDPConfig `json:"-"` // configurations of the updated and deleted IDPs before
+}
+
+// NewSupervisor creates a supervisor; opts are applied to the updaters Reconcile creates
+func NewSupervisor(manager *Manager, logger *slog.Logger, opts ...UpdaterOption) *Supervisor {
+	return &Supervisor{
+		manager:    manager,
+		logger:     logger,
+		opts:       opts,
+		running:    make(map[string]*supervised),
+		tombstones: make(map[string]*Tombstone),
+	}
+}
+
+// SetTombstoneRetention keeps IDPs removed by Reconcile restorable for retention, 0 disables.
+// Must be called before Start.
+func (s *Supervisor) SetTombstoneRetention(retention time.Duration) {
+	s.retention = retention
+}
+
+// OnChange calls fn with the configurations of the running IDPs, in declaration order, whenever
+// Reconcile or Restore changes them, so what is built from the IDP list follows. fn is called with
+// the supervisor locked and must not call it back. Must be called before Start.
+func (s *Supervisor) OnChange(fn func(idps []config.IDPConfig)) {
+	s.onChange = fn
+}
+
+// Start runs the updaters until ctx is done or Reconcile removes their IDP
+func (s *Supervisor) Start(ctx context.Context, updaters []*Updater) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+	for _, u := range updaters {
+		s.start(u)
+		s.declared = append(s.declared, u.config)
+	}
+}
+
+// changed reports the running IDPs to the OnChange function, must hold s.mu
+func (s *Supervisor) changed() {
+	if s.onChange != nil {
+		s.onChange(slices.Clone(s.declared))
+	}
+}
+
+// start runs u, must hold s.mu
+func (s *Supervisor) start(u *Updater) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	r := &supervised{updater: u, config: u.config, cancel: cancel, done: make(chan struct{})}
+	s.running[u.config.Name] = r
+
+	s.logger.Info("Starting updater for IDP", "name", u.config.Name, "url", u.config.URL, "interval", u.config.RefreshInterval)
+	go func() {
+		defer close(r.done)
+		u.Start(ctx)
+	}()
+}
+
+// stop ends an updater and waits for it to return, returning its configuration. Must hold s.mu.
+func (s *Supervisor) stop(name string) config.IDPConfig {
+	r := s.running[name]
+	r.cancel()
+	<-r.done
+	delete(s.running, name)
+	return r.config
+}
+
+// Plan returns what Reconcile would change for idps without changing anything
+func (s *Supervisor) Plan(idps []config.IDPConfig) ReconcileResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.plan(idps)
+}
+
+// plan compares idps to the running updaters, must hold s.mu
+func (s *Supervisor) plan(idps []config.IDPConfig) ReconcileResult {
+	result := ReconcileResult{Created: []string{}, Updated: []string{}, Deleted: []string{}, Unchanged: []string{},
+		Previous: make(map[string]config.IDPConfig)}
+	declared := make(map[string]bool, len(idps))
+	for _, idp := range idps {
+		declared[idp.Name] = true
+		r, ok := s.running[idp.Name]
+		switch {
+		case !ok:
+			result.Created = append(result.Created, idp.Name)
+		case !reflect.DeepEqual(r.config, idp):
+			result.Updated = append(result.Updated, idp.Name)
+			result.Previous[idp.Name] = r.config
+		default:
+			result.Unchanged = append(result.Unchanged, idp.Name)
+		}
+	}
+	for name, r := range s.running {
+		if !declared[name] {
+			result.Deleted = append(result.Deleted, name)
+			result.Previous[name] = r.config
+		}
+	}
+	for _, names := range [][]string{result.Created, result.Updated, result.Deleted, result.Unchanged} {
+		slices.Sort(names)
+	}
+	return result
+}
+
+// Reconcile makes the running updaters match idps, which must be validated: new IDPs are started,
+// changed ones restarted with their new configuration and missing ones stopped and removed from
+// the manager. Declaring the same IDPs again changes nothing.
+func (s *Supervisor) Reconcile(idps []config.IDPConfig) (ReconcileResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return ReconcileResult{}, ErrNotStarted
+	}
+
+	result := s.plan(idps)
+	now := time.Now()
+	s.expire(now)
+	for _, name := range result.Deleted {
+		cfg := s.stop(name)
+		data := s.manager.Remove(name)
+		if s.retention <= 0 {
+			s.logger.Info("IDP removed", "name", name)
+			continue
+		}
+		t := &Tombstone{Name: name, RemovedAt: now, ExpiresAt: now.Add(s.retention), config: cfg, data: data}
+		if data != nil {
+			t.KeyCount = data.KeyCount
+		}
+		s.tombstones[name] = t
+		s.logger.Info("IDP removed, restorable until it expires", "name", name, "expires_at", t.ExpiresAt.Format(time.RFC3339))
+	}
+	for _, idp := range idps {
+		if slices.Contains(result.Unchanged, idp.Name) {
+			continue
+		}
+		// A declared IDP replaces a removed one of the same name
+		delete(s.tombstones, idp.Name)
+		if _, ok := s.running[idp.Name]; ok {
+			s.stop(idp.Name)
+		}
+		s.manager.SetMaxKeyBytes(idp.Name, idp.GetMaxKeyBytes())
+		s.start(NewUpdater(idp, s.manager, s.logger, s.opts...))
+	}
+	s.declared = slices.Clone(idps)
+	if len(result.Created)+len(result.Updated)+len(result.Deleted) > 0 {
+		s.changed()
+	}
+	return result, nil
+}
+
+// Restore restarts an IDP removed by Reconcile within the retention, with its configuration at
+// removal. Its last keys are served again right away, until its first fetch. Returns the tombstone
+// the IDP was restored from.
+func (s *Supervisor) Restore(name string) (Tombstone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return Tombstone{}, ErrNotStarted
+	}
+	s.expire(time.Now())
+	t, ok := s.tombstones[name]
+	if !ok {
+		return Tombstone{}, ErrNoTombstone
+	}
+
+	delete(s.tombstones, name)
+	if t.data != nil {
+		s.manager.Restore(t.data)
+	}
+	s.manager.SetMaxKeyBytes(name, t.config.GetMaxKeyBytes())
+	s.start(NewUpdater(t.config, s.manager, s.logger, s.opts...))
+	s.declared = append(s.declared, t.config)
+	s.changed()
+	s.logger.Info("IDP restored", "name", name, "key_count", t.KeyCount)
+	return *t, nil
+}
+
+// Running returns how many updaters the supervisor runs, one per IDP currently fetched
+func (s *Supervisor) Running() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.running)
+}
+
+// Refresh makes the updater of a running IDP fetch its keys now instead of at its next refresh.
+// An IDP throttling us is left alone until its Retry-After has passed.
+func (s *Supervisor) Refresh(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return ErrNotStarted
+	}
+	r, ok := s.running[name]
+	if !ok {
+		return ErrUnknownIDP
+	}
+	if data, ok := s.manager.Get(name); ok && time.Now().Before(data.ThrottledUntil) {
+		return ErrThrottled
+	}
+	r.updater.Refresh()
+	return nil
+}
+
+// Tombstones returns the removed IDPs that can still be restored, sorted by name
+func (s *Supervisor) Tombstones() []Tombstone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	tombstones := make([]Tombstone, 0, len(s.tombstones))
+	for _, t := range s.tombstones {
+		tombstones = append(tombstones, *t)
+	}
+	slices.SortFunc(tombstones, func(a, b Tombstone) int { return strings.Compare(a.Name, b.Name) })
+	return tombstones
+}
+
+// expire drops the tombstones past their retention, must hold s.mu
+func (s *Supervisor) expire(now time.Time) {
+	for name, t := range s.tombstones {
+		if !now.Before(t.ExpiresAt) {
+			delete(s.tombstones, name)
+			s.logger.Info("Removed IDP expired, it can no longer be restored", "name", name)
+		}
+	}
+}
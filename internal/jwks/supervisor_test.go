@@ -0,0 +1,60 @@
+package jwks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+func TestSupervisorRunningFollowsReconcile(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"keys":[{"kty":"RSA","kid":"k1","use":"sig","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4","e":"AQAB"}]}`)
+	}))
+	defer upstream.Close()
+	idp := func(name string) config.IDPConfig {
+		return config.IDPConfig{Name: name, URL: upstream.URL, RefreshInterval: 3600}
+	}
+
+	m := NewManager(discardLogger())
+	s := NewSupervisor(m, discardLogger(), WithHTTPClient(upstream.Client()))
+	var changed []string
+	s.OnChange(func(idps []config.IDPConfig) {
+		changed = []string{}
+		for _, idp := range idps {
+			changed = append(changed, idp.Name)
+		}
+	})
+	if n := s.Running(); n != 0 {
+		t.Fatalf("%d updaters before Start", n)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx, []*Updater{NewUpdater(idp("a"), m, discardLogger(), WithHTTPClient(upstream.Client()))})
+
+	steps := [][]string{{"a", "b", "c"}, {"c"}, {}}
+	for _, names := range steps {
+		var idps []config.IDPConfig
+		for _, name := range names {
+			idps = append(idps, idp(name))
+		}
+		if _, err := s.Reconcile(idps); err != nil {
+			t.Fatal(err)
+		}
+		if n := s.Running(); n != len(names) {
+			t.Fatalf("Running() = %d after declaring %v", n, names)
+		}
+		if !slices.Equal(changed, names) {
+			t.Fatalf("OnChange got %v after declaring %v", changed, names)
+		}
+		// Updaters of removed IDPs have returned once Reconcile does
+		if n := RunningUpdaters(); n > len(names) {
+			t.Fatalf("%d updater goroutines for %d IDPs", n, len(names))
+		}
+	}
+}
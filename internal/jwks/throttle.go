@@ -0,0 +1,76 @@
+package jwks
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttledResponses counts 429 and 503 responses with Retry-After per IDP
+var throttledResponses = expvar.NewMap("upstream_throttled")
+
+// ThrottledError is returned when an IDP answers 429 or 503 with a Retry-After header
+type ThrottledError struct {
+	StatusCode int
+	RetryAfter time.Duration // how long the IDP asked us to wait, bounded by the updater
+	Body       string
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("throttled by IDP with status code %d, retry after %s: %s", e.StatusCode, e.RetryAfter, e.Body)
+}
+
+// throttled returns the ThrottledError for a 429 or 503 response carrying a valid Retry-After, nil otherwise
+func throttled(resp *http.Response, body string, now time.Time) *ThrottledError {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok {
+		return nil
+	}
+	return &ThrottledError{StatusCode: resp.StatusCode, RetryAfter: retryAfter, Body: body}
+}
+
+// parseRetryAfter parses delay-seconds or an HTTP-date, dates in the past give 0
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(min(seconds, maxDeltaSeconds)) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// retryAfter returns the bounded delay before the next fetch when err is a throttled response, 0 otherwise.
+// The delay is at least a second and at most limit; err is updated so status reports the delay applied.
+func retryAfter(err error, limit time.Duration) time.Duration {
+	var t *ThrottledError
+	if !errors.As(err, &t) {
+		return 0
+	}
+	t.RetryAfter = min(max(t.RetryAfter, time.Second), limit)
+	return t.RetryAfter
+}
+
+// throttledUntil returns until when an update's IDP is throttling us, zero when err isn't a throttled response
+func throttledUntil(err error, at time.Time) time.Time {
+	var t *ThrottledError
+	if !errors.As(err, &t) {
+		return time.Time{}
+	}
+	return at.Add(t.RetryAfter)
+}
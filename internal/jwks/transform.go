@@ -0,0 +1,20 @@
+package jwks
+
+import "github.com/kiquetal/go-idp-caller/internal/config"
+
+// transform applies an IDP's declarative transforms to a freshly fetched key set in place
+func transform(c *config.TransformConfig, keySet *JWKS) {
+	for i := range keySet.Keys {
+		k := &keySet.Keys[i]
+		k.Kid = c.Kid(k.Kid)
+		if k.Alg == "" {
+			k.Alg = c.DefaultAlg
+		}
+		if k.Use == "" {
+			k.Use = c.DefaultUse
+		}
+		if c.DropX5c {
+			k.X5c = nil
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package jwks
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// newTransport returns the transport for an IDP's HTTP client, nil for the default transport
+func newTransport(cfg config.IDPConfig, logger *slog.Logger) http.RoundTripper {
+	serverName := cfg.GetTLSServerName()
+	if cfg.DNS == nil && serverName == "" {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.DNS != nil {
+		transport.DialContext = newDNSDialer(*cfg.DNS, logger).DialContext
+	}
+	if serverName != "" {
+		// The certificate is still verified, against the public name instead of the VIP in url
+		transport.TLSClientConfig = &tls.Config{ServerName: serverName}
+	}
+	return transport
+}
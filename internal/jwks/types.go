@@ -1,12 +1,30 @@
 package jwks
 
-import "time"
+import (
+	"reflect"
+	"time"
+)
 
 // JWKS represents a JSON Web Key Set
 type JWKS struct {
 	Keys []JWK `json:"keys"`
 }
 
+// Clone returns a deep copy that shares no slices with the original
+func (j *JWKS) Clone() *JWKS {
+	if j == nil {
+		return nil
+	}
+	keys := make([]JWK, len(j.Keys))
+	for i, k := range j.Keys {
+		if k.X5c != nil {
+			k.X5c = append([]string(nil), k.X5c...)
+		}
+		keys[i] = k
+	}
+	return &JWKS{Keys: keys}
+}
+
 // JWK represents a JSON Web Key
 type JWK struct {
 	Kid     string   `json:"kid,omitempty"`
@@ -30,17 +48,111 @@ type JWK struct {
 	K       string   `json:"k,omitempty"`
 }
 
-// IDPData holds the JWKS data and metadata for an IDP
+// Change describes an update that altered an IDP's keys, or made it fail or recover.
+// Its key sets are owned by the manager and must not be modified.
+type Change struct {
+	IDP      string
+	Previous *JWKS  // keys before the update, nil before the first successful fetch
+	JWKS     *JWKS  // keys after the update, unchanged when it failed
+	Error    string // set when the update failed
+	Time     time.Time
+
+	PreviousError string // error of the update before, empty when it succeeded
+
+	DrainChanged bool // the keys stopped or started being served through drain mode, see IDPData.Drained
+}
+
+// KeysChanged reports whether the update replaced the key set with different keys
+func (c Change) KeysChanged() bool {
+	if c.Previous == nil || c.JWKS == nil {
+		return c.Previous != c.JWKS
+	}
+	return !reflect.DeepEqual(c.Previous.Keys, c.JWKS.Keys)
+}
+
+// ServedChanged reports whether the keys served for the IDP changed: its key set, or whether it is drained
+func (c Change) ServedChanged() bool {
+	return c.DrainChanged || c.KeysChanged()
+}
+
+// DiffKids returns the kids only in current and only in previous, in key set order
+func DiffKids(previous, current *JWKS) (added, removed []string) {
+	before, after := kidSet(previous), kidSet(current)
+	if current != nil {
+		for _, k := range current.Keys {
+			if !before[k.Kid] {
+				added = append(added, k.Kid)
+			}
+		}
+	}
+	if previous != nil {
+		for _, k := range previous.Keys {
+			if !after[k.Kid] {
+				removed = append(removed, k.Kid)
+			}
+		}
+	}
+	return added, removed
+}
+
+func kidSet(keySet *JWKS) map[string]bool {
+	kids := make(map[string]bool)
+	if keySet != nil {
+		for _, k := range keySet.Keys {
+			kids[k.Kid] = true
+		}
+	}
+	return kids
+}
+
+// IDPData holds the JWKS data and metadata for an IDP.
+// JWKS is owned by the manager and shared between readers, it must not be modified.
 type IDPData struct {
 	Name              string    `json:"name"`
-	JWKS              *JWKS     `json:"jwks"`
+	JWKS              *JWKS     `json:"jwks,omitempty"` // left out of /status unless asked for
 	LastUpdated       time.Time `json:"last_updated"`
 	LastError         string    `json:"last_error,omitempty"`
+	LastSuccess       time.Time `json:"last_success,omitzero"` // last successful fetch
+	Failures          int       `json:"consecutive_failures"`  // failed fetches since the last success
 	UpdateCount       int       `json:"update_count"`
 	KeyCount          int       `json:"key_count"`           // current number of keys
 	MaxKeys           int       `json:"max_keys"`            // maximum allowed keys
+	KeyBytes          int       `json:"key_bytes"`           // bytes of key material held
 	CacheDuration     int       `json:"cache_duration"`      // cache duration in seconds (what we use)
 	IDPSuggestedCache int       `json:"idp_suggested_cache"` // what IDP recommended via Cache-Control
 	CacheUntil        time.Time `json:"cache_until"`         // cache valid until
 	RefreshInterval   int       `json:"refresh_interval"`    // how often we fetch from IDP
+
+	ThrottledUntil time.Time `json:"throttled_until,omitzero"` // next fetch delayed by the IDP's Retry-After
+
+	KeyHistory   []KeyChange  `json:"key_history,omitempty"`   // most recent last, at most historySize
+	RecentErrors []FetchError `json:"recent_errors,omitempty"` // most recent last, at most historySize
+
+	Vantages []VantageResult `json:"vantages,omitempty"` // last comparison with the fetches through each vantage
+
+	Staged     *JWKS     `json:"-"`                    // candidate key set waiting for promotion
+	StagedAt   time.Time `json:"staged_at,omitzero"`   // when Staged was uploaded
+	Promoted   *JWKS     `json:"-"`                    // promoted key set served instead of fetched keys
+	PromotedAt time.Time `json:"promoted_at,omitzero"` // until the IDP publishes every promoted kid
+
+	DrainingSince time.Time `json:"draining_since,omitzero"` // when the IDP was put into drain mode
+	DrainAt       time.Time `json:"drain_at,omitzero"`       // when its keys stop being served
+}
+
+// Drained reports whether the IDP's keys are no longer served at now
+func (d *IDPData) Drained(now time.Time) bool {
+	return !d.DrainingSince.IsZero() && !now.Before(d.DrainAt)
+}
+
+// KeyChange records a change of an IDP's key set
+type KeyChange struct {
+	Time    time.Time `json:"time"`
+	Added   []string  `json:"added,omitempty"`   // kids of new keys
+	Removed []string  `json:"removed,omitempty"` // kids of keys no longer published
+}
+
+// FetchError records a failed update
+type FetchError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
 }
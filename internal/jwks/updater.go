@@ -2,14 +2,16 @@ package jwks
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
+	"expvar"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/schedule"
 )
 
 // Updater handles periodic updates of JWKS from an IDP
@@ -17,48 +19,254 @@ type Updater struct {
 	config  config.IDPConfig
 	manager *Manager
 	logger  *slog.Logger
-	client  *http.Client
+	client  HTTPDoer
+	clock   Clock
+	policy  *redirectPolicy
+	fetcher Fetcher
+	limiter *OutboundLimiter // shared by all updaters, nil when outbound fetches aren't limited
+
+	initialized atomic.Bool   // set once a fetch has completed (successfully or not)
+	fetching    atomic.Bool   // a fetch is running, others are skipped meanwhile
+	failing     atomic.Bool   // the last fetch failed, so the next one is a retry
+	refresh     chan struct{} // manual refresh requested, see Refresh
+
+	vantages     []vantage
+	fetched      map[string]string // fingerprints of the last fetched keys before transforms, for vantages
+	vantageCheck atomic.Bool       // a vantage check is running
+}
+
+// UpdaterOption customizes an Updater
+type UpdaterOption func(*Updater)
+
+// WithHTTPClient replaces the default HTTP client, e.g. to point the updater at a test server.
+// The redirect policy is applied unless the client sets its own CheckRedirect.
+func WithHTTPClient(client *http.Client) UpdaterOption {
+	return func(u *Updater) {
+		c := *client
+		if c.CheckRedirect == nil {
+			c.CheckRedirect = u.policy.checkRedirect
+		}
+		u.client = &c
+	}
+}
+
+// WithHTTPDoer replaces the HTTP client with any HTTPDoer.
+// Redirects are handled by the doer; the final URL check still applies.
+func WithHTTPDoer(doer HTTPDoer) UpdaterOption {
+	return func(u *Updater) {
+		u.client = doer
+	}
+}
+
+// WithFetcher replaces the key source configured for the IDP
+func WithFetcher(fetcher Fetcher) UpdaterOption {
+	return func(u *Updater) {
+		u.fetcher = fetcher
+	}
+}
+
+// WithClock replaces the wall clock used for scheduling
+func WithClock(clock Clock) UpdaterOption {
+	return func(u *Updater) {
+		u.clock = clock
+	}
 }
 
 // NewUpdater creates a new JWKS updater
-func NewUpdater(cfg config.IDPConfig, manager *Manager, logger *slog.Logger) *Updater {
-	return &Updater{
+func NewUpdater(cfg config.IDPConfig, manager *Manager, logger *slog.Logger, opts ...UpdaterOption) *Updater {
+	policy := newRedirectPolicy(cfg.URL, cfg.GetMaxRedirects(), cfg.AllowCrossHostRedirects, cfg.RequireSameHost)
+
+	u := &Updater{
 		config:  cfg,
 		manager: manager,
 		logger:  logger,
+		// No client timeout: each fetch gets a deadline of fetch_timeout, bounded by the next refresh
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			CheckRedirect: policy.checkRedirect,
+			Transport:     newTransport(cfg, logger),
 		},
+		clock:   systemClock{},
+		policy:  policy,
+		refresh: make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	if u.fetcher == nil {
+		u.fetcher = u.newFetcher()
+	}
+	// Vantages compare live egress routes, which a replay never contacts
+	if _, replay := u.client.(*replayer); !replay && cfg.GetSource() == config.SourceHTTP {
+		u.vantages = u.newVantages()
 	}
+	if manager != nil {
+		manager.expect(cfg.Name)
+	}
+	return u
+}
+
+// minFetchDeadline is the least time a scheduled fetch gets, even when the next refresh is sooner
+const minFetchDeadline = time.Second
+
+// fetchOverlaps counts per IDP the fetches skipped because the previous one was still running
+var fetchOverlaps = expvar.NewMap("fetch_overlaps")
+
+// running counts the updaters between Start and its return, for leak detection
+var running atomic.Int64
+
+// RunningUpdaters returns how many updaters are currently running
+func RunningUpdaters() int {
+	return int(running.Load())
 }
 
 // Start begins the periodic update process
 func (u *Updater) Start(ctx context.Context) {
+	running.Add(1)
+	defer running.Add(-1)
 	u.logger.Info("Starting JWKS updater", "idp", u.config.Name)
 
-	// Perform initial fetch immediately
-	u.fetchAndUpdate()
+	plan, err := u.config.Plan()
+	if err != nil {
+		// Config is validated at load time, so this only happens for hand-built configs
+		u.logger.Error("Invalid schedule, falling back to refresh interval", "idp", u.config.Name, "error", err)
+		plan = schedule.NewPlan(time.Duration(u.config.RefreshInterval)*time.Second, nil, nil, nil)
+	}
+
+	// Perform initial fetch immediately, even inside a blackout window, so we have keys to serve.
+	// Skipped when the startup pool already fetched this IDP.
+	var backoff time.Duration
+	if !u.initialized.Load() {
+		backoff = u.fetchAndUpdate(ctx, time.Time{})
+	} else if data, ok := u.manager.Get(u.config.Name); ok && !data.ThrottledUntil.IsZero() {
+		backoff = max(data.ThrottledUntil.Sub(u.clock.Now()), time.Second)
+	}
 
-	// Setup ticker for periodic updates
-	ticker := time.NewTicker(time.Duration(u.config.RefreshInterval) * time.Second)
-	defer ticker.Stop()
+	// Sources that push changes trigger a fetch right away, on top of the schedule
+	var changes <-chan struct{}
+	if w, ok := u.fetcher.(Watcher); ok {
+		changes = w.Watch(ctx)
+	}
 
+	// The first refresh is offset by start_jitter so IDPs sharing an interval don't fire together forever
+	offset := jitter(u.config.StartJitter)
 	for {
+		now := u.clock.Now()
+		next := plan.Next(now).Add(offset)
+		offset = jitter(u.config.RefreshJitter)
+		if backoff > 0 {
+			// The IDP told us when to come back, the regular schedule would hit it again too early or too late
+			next = now.Add(backoff)
+			u.logger.Warn("IDP is throttling, delaying next fetch",
+				"idp", u.config.Name,
+				"retry_after", backoff.String(),
+				"at", next.Format(time.RFC3339),
+			)
+		}
+		u.logger.Debug("Next JWKS fetch scheduled", "idp", u.config.Name, "at", next.Format(time.RFC3339))
+
 		select {
 		case <-ctx.Done():
 			u.logger.Info("Stopping JWKS updater", "idp", u.config.Name)
 			return
-		case <-ticker.C:
-			u.fetchAndUpdate()
+		case <-u.clock.After(next.Sub(now)):
+			following := plan.Next(next)
+			backoff = u.fetchAndUpdate(ctx, following)
+			if late := u.clock.Now().Sub(following); late > 0 {
+				// Only possible when the next refresh is closer than minFetchDeadline
+				fetchOverlaps.Add(u.config.Name, 1)
+				u.logger.Warn("Fetch ran past the next refresh, skipping it", "idp", u.config.Name, "late", late.String())
+			}
+		case <-changes:
+			backoff = u.fetchAndUpdate(ctx, next)
+		case <-u.refresh:
+			backoff = u.fetchAndUpdate(ctx, next)
 		}
 	}
 }
 
-// fetchAndUpdate fetches JWKS from the IDP and updates the manager
-func (u *Updater) fetchAndUpdate() {
+// Refresh makes the running updater fetch right away, on top of its schedule.
+// Requests made while one is pending are coalesced.
+func (u *Updater) Refresh() {
+	select {
+	case u.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// jitter returns a random duration in [0, maxSeconds)
+func jitter(maxSeconds int) time.Duration {
+	if maxSeconds <= 0 {
+		return 0
+	}
+	return rand.N(time.Duration(maxSeconds) * time.Second)
+}
+
+// Fetch retrieves and validates the JWKS once without updating the manager
+func (u *Updater) Fetch(ctx context.Context) (*JWKS, error) {
+	jwks, _, err := u.fetch(ctx)
+	return jwks, err
+}
+
+// Name returns the IDP name this updater is responsible for
+func (u *Updater) Name() string {
+	return u.config.Name
+}
+
+// fetchAndUpdate fetches JWKS from the IDP and updates the manager. The fetch gets fetch_timeout and,
+// unless nextRefresh is zero, never runs past the next refresh. A fetch requested while another one
+// is running, or held back by the outbound limiter past its deadline, is skipped.
+// It returns how long to wait before the next fetch when the IDP is throttling us, 0 otherwise.
+func (u *Updater) fetchAndUpdate(ctx context.Context, nextRefresh time.Time) time.Duration {
+	if !u.fetching.CompareAndSwap(false, true) {
+		fetchOverlaps.Add(u.config.Name, 1)
+		u.logger.Warn("Previous fetch still running, skipping this one", "idp", u.config.Name)
+		return 0
+	}
+	defer u.fetching.Store(false)
+
 	u.logger.Debug("Fetching JWKS", "idp", u.config.Name, "url", u.config.URL)
 
-	jwks, idpCacheDuration, err := u.fetch()
+	start := u.clock.Now()
+	timeout := u.config.GetFetchTimeout()
+	if !nextRefresh.IsZero() {
+		timeout = min(timeout, max(nextRefresh.Sub(start), minFetchDeadline))
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Waiting for the outbound limiter uses up the fetch deadline, a fetch that can't start in time is skipped
+	if u.limiter != nil {
+		release, err := u.limiter.acquire(fetchCtx, u.failing.Load())
+		if err != nil {
+			u.logger.Warn("Outbound fetch limit reached, skipping this fetch", "idp", u.config.Name, "error", err)
+			return 0
+		}
+		defer release()
+		start = u.clock.Now()
+	}
+
+	jwks, idpCacheDuration, err := u.fetch(fetchCtx)
+	// A fetch aborted by shutdown or the startup deadline doesn't count, Start will retry it right
+	// away. Recording it would report the deadline as the IDP's failure, to cold-start waiters too.
+	if ctx.Err() != nil {
+		u.logger.Debug("Fetch aborted", "idp", u.config.Name, "error", err)
+		return 0
+	}
+	u.failing.Store(err != nil)
+	u.manager.RecordFetch(u.config.Name, err == nil, u.clock.Now().Sub(start), start)
+	if errors.Is(err, ErrEmptyKeySet) {
+		previous := 0
+		if current, ok := u.manager.GetJWKS(u.config.Name); ok {
+			previous = len(current.Keys)
+		}
+		u.logger.Error("IDP returned an empty key set, keeping previous keys",
+			"idp", u.config.Name,
+			"url", u.config.URL,
+			"previous_key_count", previous,
+			"hint", "set allow_empty_jwks: true if this IDP may legitimately publish no keys",
+		)
+	}
+	backoff := retryAfter(err, u.config.GetMaxRetryAfter())
 	maxKeys := u.config.GetMaxKeys()
 
 	// Use IDP's suggested cache duration if available and reasonable
@@ -66,6 +274,12 @@ func (u *Updater) fetchAndUpdate() {
 	refreshInterval := u.config.RefreshInterval
 
 	u.manager.UpdateWithIDPCache(u.config.Name, jwks, maxKeys, cacheDuration, idpCacheDuration, refreshInterval, err)
+	if err == nil && len(u.vantages) > 0 {
+		u.checkVantages(ctx, u.fetched)
+	}
+
+	u.initialized.Store(true)
+	return backoff
 }
 
 // determineCacheDuration determines the best cache duration based on IDP response and config
@@ -108,114 +322,40 @@ func (u *Updater) determineCacheDuration(idpMaxAge int) int {
 	return idpMaxAge
 }
 
-// fetch retrieves JWKS from the IDP endpoint and returns the data plus cache duration from headers
-func (u *Updater) fetch() (*JWKS, int, error) {
-	req, err := http.NewRequest("GET", u.config.URL, nil)
+// fetch retrieves the key set from the IDP's source and validates it.
+// Returns the key set plus the cache duration suggested by the source.
+func (u *Updater) fetch(ctx context.Context) (*JWKS, int, error) {
+	result, err := u.fetcher.Fetch(ctx)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, 0, err
 	}
-
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := u.client.Do(req)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to fetch JWKS: %w", err)
+	if result == nil || result.JWKS == nil {
+		return nil, 0, validationErrorf("source returned no key set")
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, 0, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	if len(u.vantages) > 0 {
+		// Vantages fetch the raw upstream keys, so compare before transforms change them
+		u.fetched = keyFingerprints(result.JWKS)
 	}
 
-	// Parse Cache-Control header from IDP response
-	cacheControl := resp.Header.Get("Cache-Control")
-	idpMaxAge := parseCacheControl(cacheControl)
-
-	if idpMaxAge > 0 {
-		u.logger.Debug("IDP provided cache control",
-			"idp", u.config.Name,
-			"cache_control", cacheControl,
-			"max_age", idpMaxAge,
-		)
+	// Transforms run first so an injected use: sig counts for require_signing_key
+	if u.config.Transform != nil {
+		transform(u.config.Transform, result.JWKS)
 	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	var jwks JWKS
-	if err := json.Unmarshal(body, &jwks); err != nil {
-		return nil, 0, fmt.Errorf("failed to parse JWKS: %w", err)
-	}
-
-	return &jwks, idpMaxAge, nil
-}
-
-// parseCacheControl extracts max-age value from Cache-Control header
-// Returns 0 if not found or invalid
-func parseCacheControl(cacheControl string) int {
-	if cacheControl == "" {
-		return 0
-	}
-
-	// Parse directives (e.g., "public, max-age=86400, must-revalidate")
-	directives := splitCacheControl(cacheControl)
-
-	for _, directive := range directives {
-		// Look for max-age=value
-		if len(directive) > 8 && directive[:8] == "max-age=" {
-			var maxAge int
-			if _, err := fmt.Sscanf(directive[8:], "%d", &maxAge); err == nil {
-				return maxAge
-			}
+	if u.config.Normalize {
+		for _, err := range normalize(result.JWKS) {
+			u.logger.Warn("Dropping key that can't be normalized", "idp", u.config.Name, "error", err)
 		}
 	}
-
-	return 0
-}
-
-// splitCacheControl splits Cache-Control header by commas and trims spaces
-func splitCacheControl(s string) []string {
-	var result []string
-	current := ""
-
-	for _, char := range s {
-		if char == ',' {
-			trimmed := trimSpace(current)
-			if trimmed != "" {
-				result = append(result, trimmed)
-			}
-			current = ""
-		} else {
-			current += string(char)
+	// Refused keys are never served; a key set left empty fails validation below
+	if FIPS() {
+		for _, err := range filterFIPS(result.JWKS) {
+			fipsRejected.Add(u.config.Name, 1)
+			u.logger.Warn("Dropping key that isn't FIPS compliant", "idp", u.config.Name, "error", err)
 		}
 	}
-
-	// Add last directive
-	trimmed := trimSpace(current)
-	if trimmed != "" {
-		result = append(result, trimmed)
-	}
-
-	return result
-}
-
-// trimSpace removes leading and trailing spaces
-func trimSpace(s string) string {
-	start := 0
-	end := len(s)
-
-	// Trim leading spaces
-	for start < end && (s[start] == ' ' || s[start] == '\t') {
-		start++
-	}
-
-	// Trim trailing spaces
-	for end > start && (s[end-1] == ' ' || s[end-1] == '\t') {
-		end--
+	if err := validateJWKS(result.JWKS, u.config.RequireSigningKey, u.config.AllowEmptyJWKS); err != nil {
+		return nil, 0, err
 	}
 
-	return s[start:end]
+	return result.JWKS, result.MaxAge, nil
 }
@@ -0,0 +1,69 @@
+package jwks
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// ValidationError reports an upstream response that was fetched but rejected
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return "validation failed: " + e.Reason
+}
+
+// ErrEmptyKeySet is returned when an upstream serves a key set with no keys
+var ErrEmptyKeySet error = &ValidationError{Reason: "response contains no keys"}
+
+func validationErrorf(format string, args ...any) error {
+	return &ValidationError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// checkContentType accepts JSON media types (application/json, application/jwk-set+json, ...).
+// A missing Content-Type is tolerated since some IDPs omit it.
+func checkContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return validationErrorf("invalid content type %q", contentType)
+	}
+
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+
+	return validationErrorf("unexpected content type %q", mediaType)
+}
+
+// validateJWKS checks that a decoded key set is usable before it replaces the current one.
+// An empty key set is only accepted when allowEmpty is set.
+func validateJWKS(jwks *JWKS, requireSigningKey, allowEmpty bool) error {
+	if jwks.Keys == nil {
+		return validationErrorf("response has no \"keys\" array")
+	}
+	if len(jwks.Keys) == 0 && !allowEmpty {
+		return ErrEmptyKeySet
+	}
+
+	hasSigningKey := false
+	for i, key := range jwks.Keys {
+		if key.Kty == "" {
+			return validationErrorf("key %d (kid %q) has no kty", i, key.Kid)
+		}
+		if key.Use == "sig" {
+			hasSigningKey = true
+		}
+	}
+
+	if requireSigningKey && !hasSigningKey {
+		return validationErrorf("no key with use=sig")
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package jwks
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// vantageTimeout bounds a check through all vantages
+const vantageTimeout = 30 * time.Second
+
+// vantageMismatches counts vantage fetches that got a different key set, per IDP
+var vantageMismatches = expvar.NewMap("vantage_mismatches")
+
+// VantageResult is the outcome of the last fetch through one vantage
+type VantageResult struct {
+	Name     string    `json:"name"`
+	Time     time.Time `json:"time"`
+	Mismatch bool      `json:"mismatch"`          // the key set differs from the primary fetch
+	Missing  []string  `json:"missing,omitempty"` // keys of the primary fetch this vantage didn't get, by kid
+	Extra    []string  `json:"extra,omitempty"`   // keys only this vantage got, by kid
+	Error    string    `json:"error,omitempty"`   // the fetch failed, nothing was compared
+}
+
+// DisagreeingVantages returns the vantages whose last fetch got a different key set than the primary fetch
+func (d *IDPData) DisagreeingVantages() []string {
+	var names []string
+	for _, v := range d.Vantages {
+		if v.Mismatch {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
+// vantage fetches an IDP's keys through one extra egress route
+type vantage struct {
+	name    string
+	fetcher Fetcher
+}
+
+// newVantages builds a fetcher per configured vantage, sharing the IDP's headers, redirect policy and limits
+func (u *Updater) newVantages() []vantage {
+	vantages := make([]vantage, 0, len(u.config.Vantages))
+	for _, v := range u.config.Vantages {
+		client := &http.Client{
+			Timeout:       10 * time.Second,
+			CheckRedirect: u.policy.checkRedirect,
+			Transport:     newVantageTransport(u.config, v, u.logger),
+		}
+		vantages = append(vantages, vantage{
+			name:    v.Name,
+			fetcher: &httpFetcher{config: u.config, client: client, policy: u.policy, logger: u.logger},
+		})
+	}
+	return vantages
+}
+
+// newVantageTransport returns a transport going through the vantage's proxy, or directly without one.
+// Proxy environment variables are ignored so a direct vantage is really direct.
+func newVantageTransport(cfg config.IDPConfig, v config.VantageConfig, logger *slog.Logger) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if v.Proxy != "" {
+		if proxy, err := url.Parse(v.Proxy); err == nil {
+			transport.Proxy = http.ProxyURL(proxy)
+		}
+	}
+	if dns := cmp.Or(v.DNS, cfg.DNS); dns != nil {
+		transport.DialContext = newDNSDialer(*dns, logger).DialContext
+	}
+	if serverName := cfg.GetTLSServerName(); serverName != "" {
+		transport.TLSClientConfig = &tls.Config{ServerName: serverName}
+	}
+	return transport
+}
+
+// checkVantages fetches the keys through every vantage in the background and records how they compare
+// with primary, the fingerprints of the last fetch. A check still running when the next fetch completes
+// is not started again.
+func (u *Updater) checkVantages(ctx context.Context, primary map[string]string) {
+	if !u.vantageCheck.CompareAndSwap(false, true) {
+		return
+	}
+	// The startup pool cancels its context once the initial fetch is done, the check outlives it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), vantageTimeout)
+	go func() {
+		defer cancel()
+		defer u.vantageCheck.Store(false)
+
+		results := make([]VantageResult, len(u.vantages))
+		var wg sync.WaitGroup
+		for i, v := range u.vantages {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = u.compareVantage(ctx, v, primary)
+			}()
+		}
+		wg.Wait()
+		if ctx.Err() == nil {
+			u.manager.RecordVantages(u.config.Name, results)
+		}
+	}()
+}
+
+// compareVantage fetches through one vantage and diffs its key set against primary
+func (u *Updater) compareVantage(ctx context.Context, v vantage, primary map[string]string) VantageResult {
+	result := VantageResult{Name: v.name, Time: u.clock.Now()}
+
+	fetched, err := v.fetcher.Fetch(ctx)
+	if err == nil && (fetched == nil || fetched.JWKS == nil) {
+		err = validationErrorf("source returned no key set")
+	}
+	if err != nil {
+		result.Error = err.Error()
+		u.logger.Warn("Vantage fetch failed", "idp", u.config.Name, "vantage", v.name, "error", err)
+		return result
+	}
+
+	seen := keyFingerprints(fetched.JWKS)
+	for fp, kid := range primary {
+		if _, ok := seen[fp]; !ok {
+			result.Missing = append(result.Missing, kid)
+		}
+	}
+	for fp, kid := range seen {
+		if _, ok := primary[fp]; !ok {
+			result.Extra = append(result.Extra, kid)
+		}
+	}
+	slices.Sort(result.Missing)
+	slices.Sort(result.Extra)
+	result.Mismatch = len(result.Missing) > 0 || len(result.Extra) > 0
+
+	if result.Mismatch {
+		vantageMismatches.Add(u.config.Name, 1)
+		u.logger.Warn("Vantage serves a different key set",
+			"idp", u.config.Name,
+			"vantage", v.name,
+			"missing", result.Missing,
+			"extra", result.Extra,
+		)
+	}
+	return result
+}
+
+// keyFingerprints maps each key of a key set to its kid, keyed by the RFC 7638 thumbprint of
+// kid and key together so a kid reused for other key material counts as a different key
+func keyFingerprints(keySet *JWKS) map[string]string {
+	fps := make(map[string]string, len(keySet.Keys))
+	for _, k := range keySet.Keys {
+		id, err := thumbprint(k)
+		if err != nil {
+			// Symmetric and unknown key types have no thumbprint here, compare their members instead
+			id = k.Kty + "." + k.K + "." + k.N + "." + k.X
+		}
+		sum := sha256.Sum256([]byte(k.Kid + "." + id))
+		fps[hex.EncodeToString(sum[:])] = k.Kid
+	}
+	return fps
+}
+
+// RecordVantages stores the outcome of the last vantage check of an IDP that has been fetched
+func (m *Manager) RecordVantages(name string, results []VantageResult) {
+	m.lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.state.Load().idps[name]; !exists {
+		return
+	}
+	data := m.modify(name)
+	data.Vantages = results
+	m.publish(data)
+}
@@ -0,0 +1,357 @@
+// Package kv is an embedded key-value store for single-node deployments: an append-only log in a
+// directory, replayed into memory on open and rewritten with only the live entries once it's mostly garbage
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	logName    = "data.log"
+	lockName   = "LOCK"
+	headerSize = 12 // CRC-32C of the rest, key length, value length
+	tombstone  = math.MaxUint32
+	maxEntry   = 64 << 20
+	compactMin = 1 << 20 // logs below this size are never compacted
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Stats describes the log
+type Stats struct {
+	Keys        int    `json:"keys"`
+	Size        int64  `json:"size_bytes"` // bytes of the log
+	Live        int64  `json:"live_bytes"` // bytes the current entries take, the rest is garbage
+	Compactions int    `json:"compactions"`
+	Recovered   int64  `json:"recovered_bytes"` // torn or corrupt tail dropped on open
+	CompactErr  string `json:"compact_error,omitempty"`
+}
+
+// DB is an open store. All writes are synced to disk before they return.
+type DB struct {
+	mu    sync.Mutex
+	dir   string
+	file  *os.File
+	lock  *os.File
+	data  map[string][]byte
+	stats Stats
+}
+
+// Batch is a set of puts and deletes written with a single sync
+type Batch struct {
+	keys   []string
+	values [][]byte // nil deletes the key
+}
+
+// Put sets key to value
+func (b *Batch) Put(key string, value []byte) {
+	b.keys = append(b.keys, key)
+	b.values = append(b.values, append([]byte{}, value...))
+}
+
+// Delete removes key
+func (b *Batch) Delete(key string) {
+	b.keys = append(b.keys, key)
+	b.values = append(b.values, nil)
+}
+
+// Len returns the number of operations
+func (b *Batch) Len() int {
+	return len(b.keys)
+}
+
+// Open opens or creates the store in dir, which only one process may have open at a time
+func Open(dir string) (*DB, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("kv: %w", err)
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, lockName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("kv: %w", err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("kv: %s is in use by another process", dir)
+	}
+
+	db := &DB{dir: dir, lock: lock, data: make(map[string][]byte)}
+	if err := db.replay(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if db.file, err = os.OpenFile(db.path(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("kv: %w", err)
+	}
+	db.maybeCompact()
+	return db, nil
+}
+
+// replay loads the log into memory, truncating it after the last intact entry
+func (db *DB) replay() error {
+	log, err := os.ReadFile(db.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("kv: %w", err)
+	}
+
+	offset := 0
+	for offset < len(log) {
+		key, value, n, ok := decode(log[offset:])
+		if !ok {
+			break
+		}
+		db.apply(key, value, int64(n))
+		offset += n
+	}
+	db.stats.Size = int64(offset)
+	if offset < len(log) {
+		db.stats.Recovered = int64(len(log) - offset)
+		if err := os.Truncate(db.path(), int64(offset)); err != nil {
+			return fmt.Errorf("kv: truncate corrupt tail: %w", err)
+		}
+	}
+	return nil
+}
+
+// Get returns the value of key
+func (db *DB) Get(key string) ([]byte, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	value, ok := db.data[key]
+	return value, ok
+}
+
+// Scan calls fn for every key with the prefix, in key order. The store may be written from fn.
+func (db *DB) Scan(prefix string, fn func(key string, value []byte)) {
+	db.mu.Lock()
+	var keys []string
+	for key := range db.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	values := make([][]byte, len(keys))
+	slices.Sort(keys)
+	for i, key := range keys {
+		values[i] = db.data[key]
+	}
+	db.mu.Unlock()
+
+	// Values are never modified in place, so they are safe to hand out
+	for i, key := range keys {
+		fn(key, values[i])
+	}
+}
+
+// Put sets key to value
+func (db *DB) Put(key string, value []byte) error {
+	var b Batch
+	b.Put(key, value)
+	return db.Write(&b)
+}
+
+// Delete removes key
+func (db *DB) Delete(key string) error {
+	var b Batch
+	b.Delete(key)
+	return db.Write(&b)
+}
+
+// Write appends the batch to the log, syncs it and applies it, compacting the log when more than
+// half of it is garbage. A failed compaction keeps the old log and is reported in Stats.
+func (db *DB) Write(b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	var buf []byte
+	for i, key := range b.keys {
+		if len(key)+len(b.values[i]) > maxEntry {
+			return fmt.Errorf("kv: entry %q exceeds %d bytes", key, maxEntry)
+		}
+		buf = encode(buf, key, b.values[i])
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return fmt.Errorf("kv: closed")
+	}
+	_, err := db.file.Write(buf)
+	if err == nil {
+		err = db.file.Sync()
+	}
+	if err != nil {
+		// Drop a partial write, later entries would be lost behind it on the next open
+		db.file.Truncate(db.stats.Size)
+		return fmt.Errorf("kv: %w", err)
+	}
+	db.stats.Size += int64(len(buf))
+	for i, key := range b.keys {
+		db.apply(key, b.values[i], int64(entrySize(key, b.values[i])))
+	}
+	db.maybeCompact()
+	return nil
+}
+
+// apply updates the in-memory data and live size for an entry of n bytes
+func (db *DB) apply(key string, value []byte, n int64) {
+	if old, ok := db.data[key]; ok {
+		db.stats.Live -= int64(entrySize(key, old))
+	}
+	if value == nil {
+		delete(db.data, key)
+		return
+	}
+	db.data[key] = value
+	db.stats.Live += n
+}
+
+// maybeCompact rewrites the log when it's large and mostly garbage, must hold db.mu
+func (db *DB) maybeCompact() {
+	if db.stats.Size < compactMin || db.stats.Size <= 2*db.stats.Live {
+		return
+	}
+	if err := db.compact(); err != nil {
+		db.stats.CompactErr = err.Error()
+		return
+	}
+	db.stats.CompactErr = ""
+	db.stats.Compactions++
+}
+
+// compact writes the live entries to a new log and renames it over the old one, must hold db.mu.
+// The new log is opened for appending from the start, so there's no reopen to fail after the rename.
+func (db *DB) compact() error {
+	tmp := db.path() + ".compact"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(db.data))
+	for key := range db.data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	w := bufio.NewWriter(f)
+	var size int64
+	for _, key := range keys {
+		entry := encode(nil, key, db.data[key])
+		size += int64(len(entry))
+		w.Write(entry)
+	}
+	if err := errors.Join(w.Flush(), f.Sync()); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, db.path()); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(db.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	db.file.Close()
+	db.file = f
+	db.stats.Size, db.stats.Live = size, size
+	return nil
+}
+
+// Compact rewrites the log with only the live entries
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return fmt.Errorf("kv: closed")
+	}
+	if err := db.compact(); err != nil {
+		return fmt.Errorf("kv: compact: %w", err)
+	}
+	db.stats.Compactions++
+	return nil
+}
+
+// Stats returns the current state of the log
+func (db *DB) Stats() Stats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s := db.stats
+	s.Keys = len(db.data)
+	return s
+}
+
+// Close closes the log and releases the directory
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return errors.Join(err, db.lock.Close())
+}
+
+func (db *DB) path() string {
+	return filepath.Join(db.dir, logName)
+}
+
+func entrySize(key string, value []byte) int {
+	return headerSize + len(key) + len(value)
+}
+
+// encode appends an entry, a nil value is a deletion
+func encode(buf []byte, key string, value []byte) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, 4)...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(key)))
+	if value == nil {
+		buf = binary.BigEndian.AppendUint32(buf, tombstone)
+	} else {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+	}
+	buf = append(append(buf, key...), value...)
+	binary.BigEndian.PutUint32(buf[start:], crc32.Checksum(buf[start+4:], castagnoli))
+	return buf
+}
+
+// decode reads the entry at the start of log, ok is false for a torn or corrupt entry
+func decode(log []byte) (key string, value []byte, n int, ok bool) {
+	if len(log) < headerSize {
+		return "", nil, 0, false
+	}
+	keyLen := int(binary.BigEndian.Uint32(log[4:8]))
+	valueLen := binary.BigEndian.Uint32(log[8:12])
+	n = headerSize + keyLen
+	if valueLen != tombstone {
+		n += int(valueLen)
+	}
+	if keyLen > maxEntry || (valueLen != tombstone && valueLen > maxEntry) || n > len(log) {
+		return "", nil, 0, false
+	}
+	if crc32.Checksum(log[4:n], castagnoli) != binary.BigEndian.Uint32(log) {
+		return "", nil, 0, false
+	}
+	key = string(log[headerSize : headerSize+keyLen])
+	if valueLen != tombstone {
+		value = append([]byte{}, log[headerSize+keyLen:n]...)
+	}
+	return key, value, n, true
+}
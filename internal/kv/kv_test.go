@@ -0,0 +1,167 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func open(t *testing.T, dir string) *DB {
+	t.Helper()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func put(t *testing.T, db *DB, key, value string) {
+	t.Helper()
+	if err := db.Put(key, []byte(value)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect checks the store holds exactly want
+func expect(t *testing.T, db *DB, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	db.Scan("", func(key string, value []byte) { got[key] = string(value) })
+	if len(got) != len(want) {
+		t.Fatalf("store holds %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if v, ok := db.Get(key); !ok || string(v) != value {
+			t.Fatalf("%s = %q (found %v), want %q", key, v, ok, value)
+		}
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir)
+	put(t, db, "idp/corp", "v1")
+	put(t, db, "idp/corp", "v2")
+	put(t, db, "idp/empty", "")
+	put(t, db, "idp/gone", "x")
+	var b Batch
+	b.Put("audit/1", []byte("a"))
+	b.Delete("idp/gone")
+	if err := db.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db = open(t, dir)
+	expect(t, db, map[string]string{"idp/corp": "v2", "idp/empty": "", "audit/1": "a"})
+	if s := db.Stats(); s.Recovered != 0 || s.Keys != 3 {
+		t.Fatalf("stats after a clean reopen %+v", s)
+	}
+}
+
+func TestReopenDropsDamagedTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(log []byte, last int) []byte // last is the offset of the final entry
+	}{
+		{name: "torn entry", damage: func(log []byte, last int) []byte { return log[:len(log)-3] }},
+		{name: "torn header", damage: func(log []byte, last int) []byte { return log[:last+headerSize-1] }},
+		{name: "checksum mismatch", damage: func(log []byte, last int) []byte {
+			log[len(log)-1] ^= 0xff
+			return log
+		}},
+		{name: "oversized length", damage: func(log []byte, last int) []byte {
+			copy(log[last+4:], []byte{0xff, 0xff, 0xff, 0xf0})
+			return log
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := open(t, dir)
+			put(t, db, "kept", "v1")
+			put(t, db, "overwritten", "old")
+			last := db.Stats().Size
+			put(t, db, "overwritten", "new, lost")
+			db.Close()
+
+			path := filepath.Join(dir, logName)
+			log, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			damaged := tt.damage(log, int(last))
+			if err := os.WriteFile(path, damaged, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			db = open(t, dir)
+			expect(t, db, map[string]string{"kept": "v1", "overwritten": "old"})
+			if s := db.Stats(); s.Recovered != int64(len(damaged))-last || s.Size != last {
+				t.Fatalf("recovered %d of %d bytes, size %d, want %d and %d", s.Recovered, len(damaged), s.Size, int64(len(damaged))-last, last)
+			}
+
+			// The tail is truncated, so writes after the recovery survive the next open
+			put(t, db, "after", "recovery")
+			db.Close()
+			db = open(t, dir)
+			expect(t, db, map[string]string{"kept": "v1", "overwritten": "old", "after": "recovery"})
+			if s := db.Stats(); s.Recovered != 0 {
+				t.Fatalf("recovered %d bytes on the second open", s.Recovered)
+			}
+		})
+	}
+}
+
+func TestReopenAfterCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir)
+	value := bytes.Repeat([]byte("k"), 64<<10)
+	for i := range 40 {
+		value[0] = byte('a' + i%26)
+		if err := db.Put("idp/corp", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(t, db, "idp/gone", "x")
+	if err := db.Delete("idp/gone"); err != nil {
+		t.Fatal(err)
+	}
+	s := db.Stats()
+	if s.Compactions == 0 || s.Size >= compactMin {
+		t.Fatalf("log not compacted: %+v", s)
+	}
+
+	// Appends after a compaction go to the new log
+	put(t, db, "idp/after", "compaction")
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	put(t, db, "idp/last", "write")
+	db.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, logName+".compact")); !os.IsNotExist(err) {
+		t.Fatalf("compaction left its temporary file: %v", err)
+	}
+	db = open(t, dir)
+	expect(t, db, map[string]string{"idp/corp": string(value), "idp/after": "compaction", "idp/last": "write"})
+	if s := db.Stats(); s.Recovered != 0 || s.Size != s.Live {
+		t.Fatalf("stats after reopening a compacted log %+v", s)
+	}
+}
+
+func TestOpenLocksDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("no directory lock on this platform")
+	}
+	dir := t.TempDir()
+	db := open(t, dir)
+	if _, err := Open(dir); err == nil {
+		t.Fatal("a second open of the directory succeeded")
+	}
+	db.Close()
+	open(t, dir)
+}
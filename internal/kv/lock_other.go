@@ -0,0 +1,10 @@
+//go:build !unix
+
+package kv
+
+import "os"
+
+// lockFile does nothing, a second process on the same directory isn't detected on this platform
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,223 @@
+// Package mockidp implements a fake identity provider that serves a JWKS with
+// configurable key rotation, error injection, latency and Cache-Control headers.
+// cmd/mockidp runs it standalone; the package's integration tests fetch it through the updater
+// and server and verify tokens signed with its keys.
+package mockidp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	mrand "math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// Options configures the mock IDP
+type Options struct {
+	Issuer           string        // issuer advertised in the discovery document
+	Algorithm        string        // RS256 (default) or ES256
+	KeyCount         int           // number of published keys (default: 2)
+	RotationInterval time.Duration // add a new key and drop the oldest every interval, 0 disables
+	Latency          time.Duration // delay before every response
+	ErrorRate        float64       // fraction of JWKS requests answered with ErrorStatus
+	ErrorStatus      int           // status used for injected errors (default: 503)
+	CacheControl     string        // Cache-Control header on JWKS responses, empty omits it
+	ContentType      string        // Content-Type of JWKS responses (default: application/json)
+}
+
+// Key is a published key together with its private part
+type Key struct {
+	JWK     jwks.JWK
+	Private crypto.Signer
+	Created time.Time
+}
+
+// Server is an http.Handler serving:
+//
+//	GET  /.well-known/jwks.json               current key set
+//	GET  /.well-known/openid-configuration    discovery document
+//	POST /rotate                              rotate keys immediately
+type Server struct {
+	opts Options
+
+	mu       sync.RWMutex
+	keys     []Key
+	rotated  time.Time
+	sequence int
+	requests int
+}
+
+// New creates a mock IDP with freshly generated keys
+func New(opts Options) (*Server, error) {
+	if opts.Algorithm == "" {
+		opts.Algorithm = "RS256"
+	}
+	if opts.Algorithm != "RS256" && opts.Algorithm != "ES256" {
+		return nil, fmt.Errorf("unsupported algorithm %q", opts.Algorithm)
+	}
+	if opts.KeyCount <= 0 {
+		opts.KeyCount = 2
+	}
+	if opts.ErrorStatus == 0 {
+		opts.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = "application/json"
+	}
+
+	s := &Server{opts: opts, rotated: time.Now()}
+	for i := 0; i < opts.KeyCount; i++ {
+		if err := s.addKey(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Rotate publishes a new key and drops the oldest one
+func (s *Server) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotateLocked()
+}
+
+// Keys returns the currently published keys, newest last
+func (s *Server) Keys() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Key(nil), s.keys...)
+}
+
+// Requests returns how many JWKS requests were received
+func (s *Server) Requests() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.requests
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Latency > 0 {
+		select {
+		case <-time.After(s.opts.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case r.URL.Path == "/.well-known/jwks.json" && r.Method == http.MethodGet:
+		s.handleJWKS(w)
+	case r.URL.Path == "/.well-known/openid-configuration" && r.Method == http.MethodGet:
+		s.handleDiscovery(w, r)
+	case r.URL.Path == "/rotate" && r.Method == http.MethodPost:
+		if err := s.Rotate(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.requests++
+	if s.opts.RotationInterval > 0 && time.Since(s.rotated) >= s.opts.RotationInterval {
+		if err := s.rotateLocked(); err != nil {
+			s.mu.Unlock()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	set := jwks.JWKS{Keys: make([]jwks.JWK, 0, len(s.keys))}
+	for _, k := range s.keys {
+		set.Keys = append(set.Keys, k.JWK)
+	}
+	s.mu.Unlock()
+
+	if s.opts.ErrorRate > 0 && mrand.Float64() < s.opts.ErrorRate {
+		http.Error(w, "injected error", s.opts.ErrorStatus)
+		return
+	}
+
+	w.Header().Set("Content-Type", s.opts.ContentType)
+	if s.opts.CacheControl != "" {
+		w.Header().Set("Cache-Control", s.opts.CacheControl)
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	issuer := s.opts.Issuer
+	if issuer == "" {
+		issuer = "http://" + r.Host
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                issuer,
+		"jwks_uri":                              strings.TrimSuffix(issuer, "/") + "/.well-known/jwks.json",
+		"id_token_signing_alg_values_supported": []string{s.opts.Algorithm},
+	})
+}
+
+func (s *Server) rotateLocked() error {
+	if err := s.addKey(); err != nil {
+		return err
+	}
+	if len(s.keys) > s.opts.KeyCount {
+		s.keys = s.keys[len(s.keys)-s.opts.KeyCount:]
+	}
+	s.rotated = time.Now()
+	return nil
+}
+
+// addKey generates a key and appends it, callers must hold the lock (or own s)
+func (s *Server) addKey() error {
+	s.sequence++
+	kid := fmt.Sprintf("mock-%d", s.sequence)
+
+	var key Key
+	switch s.opts.Algorithm {
+	case "ES256":
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		key = Key{Private: priv, JWK: jwks.JWK{
+			Kid: kid, Kty: "EC", Alg: "ES256", Use: "sig", Crv: "P-256",
+			X: b64(priv.PublicKey.X.FillBytes(make([]byte, 32))),
+			Y: b64(priv.PublicKey.Y.FillBytes(make([]byte, 32))),
+		}}
+	default:
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		key = Key{Private: priv, JWK: jwks.JWK{
+			Kid: kid, Kty: "RSA", Alg: "RS256", Use: "sig",
+			N: b64(priv.PublicKey.N.Bytes()),
+			E: b64(big.NewInt(int64(priv.PublicKey.E)).Bytes()),
+		}}
+	}
+
+	key.Created = time.Now()
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,216 @@
+package mockidp_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/internal/mockidp"
+	"github.com/kiquetal/go-idp-caller/internal/testutil"
+	"github.com/kiquetal/go-idp-caller/pkg/verify"
+)
+
+// start serves a mock IDP and a harness fetching it as "mock", with the discovery check enabled
+func start(t *testing.T, opts mockidp.Options, configure ...func(*config.IDPConfig)) (*mockidp.Server, *testutil.Harness) {
+	t.Helper()
+	idp, err := mockidp.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(idp)
+	t.Cleanup(upstream.Close)
+
+	cfg := testutil.IDPConfig("mock", upstream.URL+"/.well-known/jwks.json")
+	cfg.Issuer = upstream.URL
+	cfg.DiscoveryURL = upstream.URL + "/.well-known/openid-configuration"
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+	h := testutil.NewHarness([]config.IDPConfig{cfg}, nil)
+	t.Cleanup(h.Close)
+	return idp, h
+}
+
+// served returns the key set idp-caller serves for the mock, nil when it has none
+func served(t *testing.T, h *testutil.Harness) *jwks.JWKS {
+	t.Helper()
+	resp, err := h.Get("/jwks/mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var set jwks.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+	return &set
+}
+
+func status(t *testing.T, h *testutil.Harness) jwks.IDPData {
+	t.Helper()
+	data, ok := h.Manager.Get("mock")
+	if !ok {
+		t.Fatal("mock IDP not in the manager")
+	}
+	return *data
+}
+
+func kids(set *jwks.JWKS) []string {
+	var kids []string
+	if set != nil {
+		for _, k := range set.Keys {
+			kids = append(kids, k.Kid)
+		}
+	}
+	slices.Sort(kids)
+	return kids
+}
+
+// sign issues a token with the mock's key, as the IDP would
+func sign(t *testing.T, key mockidp.Key, issuer string) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": key.JWK.Alg, "kid": key.JWK.Kid, "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{"iss": issuer, "sub": "user", "exp": time.Now().Add(time.Hour).Unix()})
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	var err error
+	if ec, ok := key.Private.(*ecdsa.PrivateKey); ok {
+		// JWS wants r || s rather than the ASN.1 signature of crypto.Signer
+		r, s, signErr := ecdsa.Sign(rand.Reader, ec, digest[:])
+		sig, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), signErr
+	} else {
+		sig, err = key.Private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestRotation(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			idp, h := start(t, mockidp.Options{Algorithm: alg})
+			h.Refresh(context.Background())
+			if got := kids(served(t, h)); !slices.Equal(got, []string{"mock-1", "mock-2"}) {
+				t.Fatalf("served %v, last error %q", got, status(t, h).LastError)
+			}
+			oldToken := sign(t, idp.Keys()[0], "issuer")
+
+			if err := idp.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			h.Refresh(context.Background())
+			set := served(t, h)
+			if got := kids(set); !slices.Equal(got, []string{"mock-2", "mock-3"}) {
+				t.Fatalf("served %v after the rotation", got)
+			}
+			if history := status(t, h).KeyHistory; len(history) == 0 ||
+				!slices.Equal(history[len(history)-1].Added, []string{"mock-3"}) ||
+				!slices.Equal(history[len(history)-1].Removed, []string{"mock-1"}) {
+				t.Fatalf("rotation not recorded: %+v", history)
+			}
+
+			// Tokens of the new key verify against the served set, those of the dropped key no longer do
+			keys := verify.KeysFromJWKS(set)
+			newest := idp.Keys()[1]
+			if _, err := verify.Verify(sign(t, newest, "issuer"), keys, verify.Policy{}); err != nil {
+				t.Fatalf("token of the new key: %v", err)
+			}
+			if _, err := verify.Verify(oldToken, keys, verify.Policy{}); err == nil || !strings.Contains(err.Error(), "no key matches") {
+				t.Fatalf("token of the dropped key: %v", err)
+			}
+		})
+	}
+}
+
+func TestRotationInterval(t *testing.T) {
+	// Every request is past a nanosecond interval, so each fetch sees exactly one rotation
+	idp, h := start(t, mockidp.Options{Algorithm: "ES256", RotationInterval: time.Nanosecond})
+	for i := range 3 {
+		h.Refresh(context.Background())
+		got := kids(served(t, h))
+		want := []string{"mock-" + string(rune('2'+i)), "mock-" + string(rune('3'+i))}
+		if !slices.Equal(got, want) {
+			t.Fatalf("fetch %d: served %v, want %v", i+1, got, want)
+		}
+	}
+	if n := idp.Requests(); n != 3 {
+		t.Fatalf("expected 3 JWKS requests, got %d", n)
+	}
+}
+
+func TestUpstreamBehaviour(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      mockidp.Options
+		configure func(*config.IDPConfig)
+		check     func(t *testing.T, data jwks.IDPData)
+	}{
+		{
+			name: "injected errors",
+			opts: mockidp.Options{Algorithm: "ES256", ErrorRate: 1, ErrorStatus: http.StatusBadGateway},
+			check: func(t *testing.T, data jwks.IDPData) {
+				if !strings.Contains(data.LastError, "502") || data.Failures != 1 {
+					t.Fatalf("last_error %q, consecutive_failures %d", data.LastError, data.Failures)
+				}
+			},
+		},
+		{
+			name: "login page content type",
+			opts: mockidp.Options{Algorithm: "ES256", ContentType: "text/html"},
+			check: func(t *testing.T, data jwks.IDPData) {
+				if data.LastError == "" || data.KeyCount != 0 {
+					t.Fatalf("text/html accepted: last_error %q, %d keys", data.LastError, data.KeyCount)
+				}
+			},
+		},
+		{
+			name:      "slow responses",
+			opts:      mockidp.Options{Algorithm: "ES256", Latency: 3 * time.Second},
+			configure: func(c *config.IDPConfig) { c.FetchTimeout = 1 },
+			check: func(t *testing.T, data jwks.IDPData) {
+				if data.LastError == "" || data.KeyCount != 0 {
+					t.Fatalf("slow fetch not cut off: last_error %q, %d keys", data.LastError, data.KeyCount)
+				}
+			},
+		},
+		{
+			name: "long Cache-Control",
+			opts: mockidp.Options{Algorithm: "ES256", CacheControl: "public, max-age=86400"},
+			check: func(t *testing.T, data jwks.IDPData) {
+				if data.IDPSuggestedCache != 86400 || data.KeyCount != 2 {
+					t.Fatalf("idp_suggested_cache %d, %d keys", data.IDPSuggestedCache, data.KeyCount)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(*config.IDPConfig)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			_, h := start(t, tt.opts, configure...)
+			h.Refresh(context.Background())
+			tt.check(t, status(t, h))
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// directoryTarget writes the files below a local directory.
+// Each file is written to a temporary file and renamed, so readers never see partial content.
+type directoryTarget struct {
+	dir string
+}
+
+func (t *directoryTarget) Name() string {
+	return "directory"
+}
+
+func (t *directoryTarget) Publish(_ context.Context, files map[string][]byte) error {
+	for _, p := range sortedPaths(files) {
+		if err := writeAtomic(filepath.Join(t.dir, filepath.FromSlash(p)), files[p]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAtomic replaces name with data through a temporary file in the same directory
+func writeAtomic(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", name, err)
+	}
+	return nil
+}
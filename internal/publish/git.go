@@ -0,0 +1,71 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// gitTarget writes the files into a local clone and commits them, pushing when configured.
+// The clone must exist and have a commit identity (user.name, user.email) configured.
+type gitTarget struct {
+	config config.GitPublishConfig
+}
+
+func (t *gitTarget) Name() string {
+	return "git"
+}
+
+func (t *gitTarget) Publish(ctx context.Context, files map[string][]byte) error {
+	dir := filepath.Join(t.config.Repository, filepath.FromSlash(t.config.Path))
+	if err := (&directoryTarget{dir: dir}).Publish(ctx, files); err != nil {
+		return err
+	}
+
+	pathspec := t.config.Path
+	if pathspec == "" {
+		pathspec = "."
+	}
+	if err := t.git(ctx, "add", "--", pathspec); err != nil {
+		return err
+	}
+
+	// Nothing staged means the files already match, e.g. after a restart
+	if err := t.git(ctx, "diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	if err := t.git(ctx, "commit", "--quiet", "-m", "Update published JWKS"); err != nil {
+		return err
+	}
+
+	if t.config.Push {
+		ref := "HEAD"
+		if t.config.Branch != "" {
+			ref = "HEAD:" + t.config.Branch
+		}
+		if err := t.git(ctx, "push", "--quiet", t.config.GetRemote(), ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// git runs a git command in the repository, the error includes its stderr
+func (t *gitTarget) git(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", t.config.Repository}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return nil
+}
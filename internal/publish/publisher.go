@@ -0,0 +1,164 @@
+// Package publish writes the key sets to a directory, S3 bucket or git repository on every change,
+// for air-gapped consumers that sync files instead of calling the service
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// retryInterval is how long a failed publish waits before trying again
+const retryInterval = time.Minute
+
+// mergedFile is the published file holding the keys of all IDPs
+const mergedFile = "jwks.json"
+
+// Target stores the published files
+type Target interface {
+	Name() string
+	Publish(ctx context.Context, files map[string][]byte) error
+}
+
+// Publisher writes jwks.json (merged) and <idp>/jwks.json to its targets whenever keys change
+type Publisher struct {
+	manager *jwks.Manager
+	merged  config.MergedConfig
+	targets []Target
+	logger  *slog.Logger
+	changes chan struct{}
+}
+
+// New creates a publisher for the configured targets and subscribes it to key changes.
+// jwks.json is merged like the served merged key set. Must be called before the updaters start.
+func New(cfg config.PublishConfig, merged config.MergedConfig, manager *jwks.Manager, logger *slog.Logger) *Publisher {
+	p := &Publisher{
+		manager: manager,
+		merged:  merged,
+		logger:  logger,
+		changes: make(chan struct{}, 1),
+	}
+	if cfg.Directory != "" {
+		p.targets = append(p.targets, &directoryTarget{dir: cfg.Directory})
+	}
+	if cfg.S3 != nil {
+		p.targets = append(p.targets, newS3Target(*cfg.S3))
+	}
+	if cfg.Git != nil {
+		p.targets = append(p.targets, &gitTarget{config: *cfg.Git})
+	}
+
+	manager.OnChange(func(c jwks.Change) {
+		if !c.ServedChanged() {
+			return
+		}
+		// Coalesce: one pending publish covers any number of changes
+		select {
+		case p.changes <- struct{}{}:
+		default:
+		}
+	})
+	return p
+}
+
+// Run publishes after every key change until ctx is done.
+// Failed publishes are retried, later changes are included in the retry.
+func (p *Publisher) Run(ctx context.Context) {
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.changes:
+		case <-retry:
+		}
+
+		retry = nil
+		if err := p.publish(ctx); err != nil {
+			p.logger.Error("Failed to publish key sets", "error", err, "retry_in", retryInterval.String())
+			retry = time.After(retryInterval)
+		}
+	}
+}
+
+// publish writes the current files to every target
+func (p *Publisher) publish(ctx context.Context) error {
+	files, err := p.files()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, t := range p.targets {
+		if err := t.Publish(ctx, files); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.Name(), err))
+			continue
+		}
+		p.logger.Info("Published key sets", "target", t.Name(), "files", len(files))
+	}
+	return errors.Join(errs...)
+}
+
+// files renders the merged key set and one key set per IDP, in IDP name order.
+// Drained IDPs are left out of the merged key set and their own file is published without keys.
+func (p *Publisher) files() (map[string][]byte, error) {
+	all := p.manager.GetAll()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make(map[string][]byte, len(all)+1)
+	now := time.Now()
+	for _, name := range names {
+		keySet := all[name].JWKS
+		if keySet == nil {
+			continue
+		}
+		if all[name].Drained(now) {
+			keySet = &jwks.JWKS{Keys: make([]jwks.JWK, 0)}
+		}
+
+		data, err := encode(keySet)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Join(name, mergedFile)] = data
+	}
+
+	merged, _ := jwks.Merge(all, names, p.merged, now)
+	data, err := encode(merged)
+	if err != nil {
+		return nil, err
+	}
+	files[mergedFile] = data
+	return files, nil
+}
+
+// encode renders a key set as indented JSON, so published files diff cleanly
+func encode(keySet *jwks.JWKS) ([]byte, error) {
+	data, err := json.MarshalIndent(keySet, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// sortedPaths returns the file paths in a stable order
+func sortedPaths(files map[string][]byte) []string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,121 @@
+package publish
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+func newTestPublisher(t *testing.T) (*Publisher, *jwks.Manager) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := jwks.NewManager(logger)
+	return New(config.PublishConfig{}, config.MergedConfig{}, manager, logger), manager
+}
+
+func keySet(kids ...string) *jwks.JWKS {
+	keys := make([]jwks.JWK, len(kids))
+	for i, kid := range kids {
+		keys[i] = jwks.JWK{Kid: kid, Kty: "RSA", N: "n-" + kid, E: "AQAB"}
+	}
+	return &jwks.JWKS{Keys: keys}
+}
+
+// publishedKids decodes a published file into its kids
+func publishedKids(t *testing.T, files map[string][]byte, name string) []string {
+	t.Helper()
+	var set jwks.JWKS
+	if err := json.Unmarshal(files[name], &set); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	kids := []string{}
+	for _, k := range set.Keys {
+		kids = append(kids, k.Kid)
+	}
+	return kids
+}
+
+func TestFilesLeaveOutDrainedIDPs(t *testing.T) {
+	p, manager := newTestPublisher(t)
+	manager.Update("corp", keySet("a", "b"), 10, 900, nil)
+	manager.Update("legacy", keySet("c"), 10, 900, nil)
+	<-p.changes
+	if _, err := manager.Drain("legacy", 0); err != nil {
+		t.Fatal(err)
+	}
+	// The drain is a change of the served keys
+	select {
+	case <-p.changes:
+	default:
+		t.Fatal("drain didn't trigger a publish")
+	}
+
+	files, err := p.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := publishedKids(t, files, "jwks.json"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("merged file has %v", got)
+	}
+	if got := publishedKids(t, files, "legacy/jwks.json"); len(got) != 0 {
+		t.Fatalf("drained IDP file has %v", got)
+	}
+	if got := publishedKids(t, files, "corp/jwks.json"); len(got) != 2 {
+		t.Fatalf("corp file has %v", got)
+	}
+}
+
+func TestDrainGraceEndTriggersPublish(t *testing.T) {
+	p, manager := newTestPublisher(t)
+	manager.Update("legacy", keySet("c"), 10, 900, nil)
+	<-p.changes
+	if _, err := manager.Drain("legacy", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.changes:
+	case <-time.After(time.Second):
+		t.Fatal("end of the grace period didn't trigger a publish")
+	}
+	files, err := p.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := publishedKids(t, files, "jwks.json"); len(got) != 0 {
+		t.Fatalf("merged file has %v after the grace period", got)
+	}
+}
+
+func TestMergedFileDeduplicatesLikeServer(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := jwks.NewManager(logger)
+	merged := config.MergedConfig{Dedup: config.DedupSameKid}
+	p := New(config.PublishConfig{}, merged, manager, logger)
+
+	// Tenants of one provider publish the same signing keys
+	manager.Update("tenant-a", keySet("shared", "a"), 10, 900, nil)
+	manager.Update("tenant-b", keySet("shared", "b"), 10, 900, nil)
+
+	files, err := p.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := publishedKids(t, files, "jwks.json")
+	if len(got) != 3 || got[0] != "shared" || got[1] != "a" || got[2] != "b" {
+		t.Fatalf("merged file has %v, want [shared a b]", got)
+	}
+	if got := publishedKids(t, files, "tenant-b/jwks.json"); len(got) != 2 {
+		t.Fatalf("per-IDP file deduplicated: %v", got)
+	}
+
+	all := manager.GetAll()
+	served, _ := jwks.Merge(all, []string{"tenant-a", "tenant-b"}, merged, time.Now())
+	if want, _ := encode(served); string(files["jwks.json"]) != string(want) {
+		t.Fatalf("published merged file differs from the served one:\n%s\n%s", files["jwks.json"], want)
+	}
+}
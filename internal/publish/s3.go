@@ -0,0 +1,80 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/awsauth"
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// s3Target uploads the files to an S3 bucket. Each PUT replaces its object atomically.
+// Credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type s3Target struct {
+	client *http.Client
+	region string
+	base   string // URL the object key is appended to
+	prefix string
+}
+
+func newS3Target(cfg config.S3PublishConfig) *s3Target {
+	region := cfg.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	// Virtual-hosted style on AWS, path style on custom endpoints (MinIO, localstack)
+	base := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", cfg.Bucket, region)
+	if cfg.Endpoint != "" {
+		base = strings.TrimSuffix(cfg.Endpoint, "/") + "/" + cfg.Bucket + "/"
+	}
+
+	return &s3Target{
+		client: &http.Client{Timeout: 30 * time.Second},
+		region: region,
+		base:   base,
+		prefix: cfg.Prefix,
+	}
+}
+
+func (t *s3Target) Name() string {
+	return "s3"
+}
+
+func (t *s3Target) Publish(ctx context.Context, files map[string][]byte) error {
+	for _, p := range sortedPaths(files) {
+		if err := t.put(ctx, t.prefix+p, files[p]); err != nil {
+			return fmt.Errorf("%s: %w", t.prefix+p, err)
+		}
+	}
+	return nil
+}
+
+func (t *s3Target) put(ctx context.Context, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, t.base+key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := awsauth.Sign(req, body, t.region, "s3", time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Package replicas compares the merged key set served by this replica with the one served by the others,
+// catching a replica left on stale keys or a split between replicas
+package replicas
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+var (
+	stats       = expvar.NewMap("replicas")
+	checks      = new(expvar.Int)
+	divergent   = new(expvar.Int) // peers serving other keys for longer than grace in the last check
+	unreachable = new(expvar.Int) // peers that couldn't be checked in the last check
+)
+
+func init() {
+	stats.Set("checks", checks)
+	stats.Set("divergent_peers", divergent)
+	stats.Set("unreachable_peers", unreachable)
+}
+
+// Peer is the outcome of the last check of one replica
+type Peer struct {
+	URL           string    `json:"url"`
+	ETag          string    `json:"etag,omitempty"`
+	Matches       bool      `json:"matches"`                 // serves the same merged key set as this replica
+	DivergedSince time.Time `json:"diverged_since,omitzero"` // first check it served other keys, zero while it matches
+	Divergent     bool      `json:"divergent"`               // served other keys for longer than grace
+	Error         string    `json:"error,omitempty"`         // the peer couldn't be checked
+}
+
+// Report is the outcome of the last check of all replicas
+type Report struct {
+	ETag       string    `json:"etag"`                // what this replica serves
+	Consistent bool      `json:"consistent"`          // no peer is divergent
+	CheckedAt  time.Time `json:"checked_at,omitzero"` // zero before the first check
+	Peers      []Peer    `json:"peers"`
+}
+
+// Checker periodically asks every peer for its merged key set with this replica's ETag.
+// A peer answering 304 serves the same keys; any other ETag means it serves different keys.
+type Checker struct {
+	config config.ReplicasConfig
+	local  func() string // ETag of the merged key set served here
+	client *http.Client
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	report Report
+	since  map[string]time.Time // when each peer started serving other keys
+}
+
+// New creates a checker comparing the peers with local, the ETag this replica serves
+func New(cfg config.ReplicasConfig, local func() string, logger *slog.Logger) *Checker {
+	return &Checker{
+		config: cfg,
+		local:  local,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		report: Report{Consistent: true, Peers: []Peer{}},
+		since:  make(map[string]time.Time),
+	}
+}
+
+// Report returns the outcome of the last check
+func (c *Checker) Report() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := c.report
+	report.Peers = slices.Clone(report.Peers)
+	return report
+}
+
+// Run checks the peers every interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.config.GetInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.check(ctx, now)
+		}
+	}
+}
+
+// check compares every peer with the local ETag and publishes the report
+func (c *Checker) check(ctx context.Context, now time.Time) {
+	ours := c.local()
+	urls, err := c.peers(ctx)
+	if err != nil {
+		c.logger.Warn("Failed to resolve replicas", "service", c.config.Service, "error", err)
+	}
+
+	peers := make([]Peer, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tag, err := c.peerETag(ctx, u, ours)
+			peers[i] = Peer{URL: u, ETag: tag}
+			if err != nil {
+				peers[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := Report{ETag: ours, Consistent: true, CheckedAt: now, Peers: peers}
+	var divergentCount, unreachableCount int64
+	seen := make(map[string]bool, len(peers))
+	for i := range peers {
+		p := &peers[i]
+		seen[p.URL] = true
+		if p.Error != "" {
+			// Unreachable peers keep their divergence clock, a restart doesn't make them consistent
+			unreachableCount++
+			p.DivergedSince = c.since[p.URL]
+			continue
+		}
+
+		p.Matches = p.ETag == ours
+		if p.Matches {
+			if !c.since[p.URL].IsZero() {
+				c.logger.Info("Replica serves the same keys again", "peer", p.URL, "etag", ours)
+			}
+			delete(c.since, p.URL)
+			continue
+		}
+		if c.since[p.URL].IsZero() {
+			c.since[p.URL] = now
+		}
+		p.DivergedSince = c.since[p.URL]
+		p.Divergent = now.Sub(p.DivergedSince) >= c.config.GetGrace()
+		if p.Divergent {
+			divergentCount++
+			report.Consistent = false
+			c.logger.Warn("Replica serves a different merged key set",
+				"peer", p.URL,
+				"peer_etag", p.ETag,
+				"etag", ours,
+				"since", p.DivergedSince.Format(time.RFC3339),
+			)
+		}
+	}
+	for u := range c.since {
+		if !seen[u] {
+			delete(c.since, u) // scaled down or rescheduled
+		}
+	}
+
+	c.report = report
+	checks.Add(1)
+	divergent.Set(divergentCount)
+	unreachable.Set(unreachableCount)
+}
+
+// peerETag asks a peer for its merged key set, sending ours so a matching peer answers 304 without a body
+func (c *Checker) peerETag(ctx context.Context, base, ours string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+"/.well-known/jwks.json", nil)
+	if err != nil {
+		return "", err
+	}
+	if ours != "" {
+		req.Header.Set("If-None-Match", ours)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return ours, nil
+	case http.StatusOK:
+		if tag := resp.Header.Get("ETag"); tag != "" {
+			return tag, nil
+		}
+		return "", fmt.Errorf("no ETag served")
+	default:
+		return "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+}
+
+// peers returns the configured peers plus the addresses the service resolves to, without this replica's own
+func (c *Checker) peers(ctx context.Context) ([]string, error) {
+	urls := slices.Clone(c.config.Peers)
+	if c.config.Service == "" {
+		return urls, nil
+	}
+
+	service, err := url.Parse(c.config.Service)
+	if err != nil {
+		return urls, err
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, service.Hostname())
+	if err != nil {
+		return urls, err
+	}
+	own := localAddrs()
+	for _, addr := range addrs {
+		if own[addr] {
+			continue
+		}
+		u := *service
+		u.Host = addr
+		if port := service.Port(); port != "" {
+			u.Host = net.JoinHostPort(addr, port)
+		} else if strings.Contains(addr, ":") {
+			u.Host = "[" + addr + "]"
+		}
+		if peer := u.String(); !slices.Contains(urls, peer) {
+			urls = append(urls, peer)
+		}
+	}
+	return urls, nil
+}
+
+// localAddrs returns the addresses of this host's interfaces
+func localAddrs() map[string]bool {
+	own := make(map[string]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return own
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			own[ipNet.IP.String()] = true
+		}
+	}
+	return own
+}
@@ -0,0 +1,75 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2026, 3, 10, 10, 7, 0, 0, time.UTC) // a Tuesday
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", at(3, 10, 10, 15)},
+		{"0 * * * *", at(3, 10, 11, 0)},
+		{"7 10 * * *", at(3, 11, 10, 7)}, // strictly after from
+		{"5 10 * * *", at(3, 11, 10, 5)},
+		{"30 10 10 3 *", at(3, 10, 10, 30)},
+		{"0,30 12 * * *", at(3, 10, 12, 0)},
+		{"0 9-17/2 * * *", at(3, 10, 11, 0)},
+		{"0 0 1 * *", at(4, 1, 0, 0)},
+		{"0 0 * 4 *", at(4, 1, 0, 0)},
+		{"0 0 13 * *", at(3, 13, 0, 0)},
+		{"0 0 * * 5", at(3, 13, 0, 0)},
+		{"0 0 ? * 1-5", at(3, 11, 0, 0)},
+		// Sunday is 0 or 7
+		{"0 0 * * 0", at(3, 15, 0, 0)},
+		{"0 0 * * 7", at(3, 15, 0, 0)},
+		// With both day fields restricted, either one matching is enough
+		{"0 0 15 * 5", at(3, 13, 0, 0)},
+		{"0 0 13 * 0", at(3, 13, 0, 0)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Next(from); !got.Equal(tt.want) {
+				t.Fatalf("Next = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * *", "must have 5 fields, got 4"},
+		{"* * * * * *", "must have 5 fields, got 6"},
+		{"60 * * * *", `invalid minute value "60" (allowed 0-59)`},
+		{"* 24 * * *", `invalid hour value "24" (allowed 0-23)`},
+		{"* * 0 * *", `invalid day-of-month value "0" (allowed 1-31)`},
+		{"* * * 13 *", `invalid month value "13" (allowed 1-12)`},
+		{"* * * * 8", `invalid day-of-week value "8" (allowed 0-7)`},
+		{"a * * * *", `invalid minute value "a"`},
+		{"1-x * * * *", `invalid minute value "x"`},
+		{"5-1 * * * *", `invalid range in minute field "5-1"`},
+		{"*/0 * * * *", `invalid step in minute field "*/0"`},
+		{"*/x * * * *", `invalid step in minute field "*/x"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected %q, got %v", tt.expr, tt.want, err)
+		}
+	}
+}
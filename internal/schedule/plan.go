@@ -0,0 +1,91 @@
+package schedule
+
+import "time"
+
+// maxBlackoutHops bounds how many consecutive blackout windows Next will skip over
+const maxBlackoutHops = 64
+
+// Plan decides when the next fetch for an IDP should run.
+// Cron expressions take precedence over the fixed interval; blackout windows are skipped.
+type Plan struct {
+	interval  time.Duration
+	crons     []*Cron
+	blackouts []*Window
+	loc       *time.Location
+}
+
+// NewPlan creates a fetch plan. A nil location means local time.
+func NewPlan(interval time.Duration, crons []*Cron, blackouts []*Window, loc *time.Location) *Plan {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &Plan{
+		interval:  interval,
+		crons:     crons,
+		blackouts: blackouts,
+		loc:       loc,
+	}
+}
+
+// Next returns the next fetch time after t
+func (p *Plan) Next(t time.Time) time.Time {
+	next := p.candidate(t)
+
+	for i := 0; i < maxBlackoutHops; i++ {
+		w := p.blackoutAt(next)
+		if w == nil {
+			return next
+		}
+		end := w.EndAfter(next.In(p.loc))
+		if len(p.crons) > 0 {
+			// First cron match at or after the end of the window
+			next = p.candidate(end.Add(-time.Nanosecond))
+		} else {
+			next = end
+		}
+	}
+
+	return next
+}
+
+// InBlackout reports whether t falls inside a blackout window
+func (p *Plan) InBlackout(t time.Time) bool {
+	return p.blackoutAt(t) != nil
+}
+
+func (p *Plan) candidate(t time.Time) time.Time {
+	if len(p.crons) == 0 {
+		return t.Add(p.interval)
+	}
+
+	var next time.Time
+	local := t.In(p.loc)
+	for _, c := range p.crons {
+		n := c.Next(local)
+		if n.IsZero() {
+			continue
+		}
+		if next.IsZero() || n.Before(next) {
+			next = n
+		}
+	}
+
+	if next.IsZero() {
+		// Expressions that never fire (e.g. Feb 31) fall back to the interval
+		if p.interval <= 0 {
+			return t.Add(time.Hour)
+		}
+		return t.Add(p.interval)
+	}
+	return next
+}
+
+func (p *Plan) blackoutAt(t time.Time) *Window {
+	local := t.In(p.loc)
+	for _, w := range p.blackouts {
+		if w.Contains(local) {
+			return w
+		}
+	}
+	return nil
+}
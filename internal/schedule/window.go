@@ -0,0 +1,96 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a recurring daily time range, optionally restricted to certain weekdays.
+// Windows where start is after end wrap around midnight (e.g. 23:00-02:00).
+type Window struct {
+	start int // minutes since midnight
+	end   int // minutes since midnight
+	days  uint8
+}
+
+// ParseWindow parses a window from "HH:MM" start/end values and optional weekday names
+func ParseWindow(start, end string, days []string) (*Window, error) {
+	s, err := parseClock(start)
+	if err != nil {
+		return nil, err
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return nil, err
+	}
+	if s == e {
+		return nil, fmt.Errorf("window start and end must differ (%s)", start)
+	}
+
+	w := &Window{start: s, end: e}
+	if len(days) == 0 {
+		w.days = 0x7f
+	}
+	for _, d := range days {
+		wd, ok := parseWeekday(d)
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", d)
+		}
+		w.days |= 1 << uint(wd)
+	}
+
+	return w, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w *Window) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+
+	if w.start < w.end {
+		return w.dayEnabled(t.Weekday()) && m >= w.start && m < w.end
+	}
+
+	// Wrapping window: the part after midnight belongs to the previous day's window
+	if m >= w.start {
+		return w.dayEnabled(t.Weekday())
+	}
+	if m < w.end {
+		return w.dayEnabled((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+// EndAfter returns when the window containing t closes
+func (w *Window) EndAfter(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	end := midnight.Add(time.Duration(w.end) * time.Minute)
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+func (w *Window) dayEnabled(d time.Weekday) bool {
+	return w.days&(1<<uint(d)) != 0
+}
+
+// parseWeekday accepts a full weekday name or its three-letter abbreviation, in any case
+func parseWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(s)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if name := strings.ToLower(d.String()); s == name || s == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
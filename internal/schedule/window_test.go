@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindowErrors(t *testing.T) {
+	tests := []struct {
+		start, end string
+		days       []string
+		want       string
+	}{
+		{start: "25:00", end: "02:00", want: `invalid time of day "25:00"`},
+		{start: "09:00", end: "noon", want: `invalid time of day "noon"`},
+		{start: "09:00", end: "09:00", want: "window start and end must differ"},
+		{start: "09:00", end: "17:00", days: []string{"monXYZ"}, want: `invalid weekday "monXYZ"`},
+		{start: "09:00", end: "17:00", days: []string{"sundae"}, want: `invalid weekday "sundae"`},
+		{start: "09:00", end: "17:00", days: []string{"mo"}, want: `invalid weekday "mo"`},
+		{start: "09:00", end: "17:00", days: []string{""}, want: `invalid weekday ""`},
+	}
+	for _, tt := range tests {
+		_, err := ParseWindow(tt.start, tt.end, tt.days)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s-%s %v: expected %q, got %v", tt.start, tt.end, tt.days, tt.want, err)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	// 2026-03-13 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name       string
+		start, end string
+		days       []string
+		t          time.Time
+		want       bool
+	}{
+		{name: "inside", start: "09:00", end: "17:00", t: at(13, 12, 0), want: true},
+		{name: "at start", start: "09:00", end: "17:00", t: at(13, 9, 0), want: true},
+		{name: "at end", start: "09:00", end: "17:00", t: at(13, 17, 0), want: false},
+		{name: "full weekday name", start: "09:00", end: "17:00", days: []string{"Friday"}, t: at(13, 12, 0), want: true},
+		{name: "other weekday", start: "09:00", end: "17:00", days: []string{"MON", "tue"}, t: at(13, 12, 0), want: false},
+		{name: "wrapping, before midnight", start: "23:00", end: "02:00", days: []string{"fri"}, t: at(13, 23, 30), want: true},
+		// After midnight the window still belongs to the day it started on
+		{name: "wrapping, after midnight", start: "23:00", end: "02:00", days: []string{"fri"}, t: at(14, 1, 0), want: true},
+		{name: "wrapping, previous day", start: "23:00", end: "02:00", days: []string{"fri"}, t: at(13, 1, 0), want: false},
+		{name: "wrapping, outside", start: "23:00", end: "02:00", t: at(13, 12, 0), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseWindow(tt.start, tt.end, tt.days)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Contains(tt.t); got != tt.want {
+				t.Fatalf("Contains(%s) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanSkipsBlackouts(t *testing.T) {
+	blackout, err := ParseWindow("10:00", "12:00", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hourly, err := ParseCron("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 3, 13, 9, 30, 0, 0, time.UTC)
+	noon := time.Date(2026, 3, 13, 12, 0, 0, 0, time.UTC)
+
+	if next := NewPlan(time.Hour, nil, []*Window{blackout}, time.UTC).Next(from); !next.Equal(noon) {
+		t.Fatalf("interval plan: Next = %s, want the end of the blackout %s", next, noon)
+	}
+	if next := NewPlan(time.Hour, []*Cron{hourly}, []*Window{blackout}, time.UTC).Next(from); !next.Equal(noon) {
+		t.Fatalf("cron plan: Next = %s, want the first match after the blackout %s", next, noon)
+	}
+}
@@ -0,0 +1,269 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// age (https://age-encryption.org/v1) with X25519 recipients, decryption only
+
+const (
+	ageIntro       = "age-encryption.org/v1"
+	ageArmorBegin  = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorEnd    = "-----END AGE ENCRYPTED FILE-----"
+	ageIdentityHRP = "age-secret-key-"
+	ageChunkSize   = 64 << 10
+	ageFileKeySize = 16
+)
+
+var errNoIdentity = errors.New("no age identity matches the recipients of the file")
+
+// Identity is an age X25519 private key
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// ParseIdentities reads AGE-SECRET-KEY-1... lines, ignoring empty lines and # comments as age key files have
+func ParseIdentities(text string) ([]Identity, error) {
+	var ids []Identity
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hrp, data, err := bech32Decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity: %w", err)
+		}
+		if hrp != ageIdentityHRP {
+			return nil, fmt.Errorf("invalid age identity: not an X25519 secret key")
+		}
+		key, err := ecdh.X25519().NewPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity: %w", err)
+		}
+		ids = append(ids, Identity{key: key})
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no age identity found")
+	}
+	return ids, nil
+}
+
+// IsAgeArmored reports whether s is an ASCII armored age file
+func IsAgeArmored(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), ageArmorBegin)
+}
+
+// DecryptAge decrypts an age file, binary or ASCII armored, with the first identity that unwraps its file key
+func DecryptAge(file []byte, ids []Identity) ([]byte, error) {
+	if IsAgeArmored(string(file)) {
+		var err error
+		if file, err = dearmor(string(file)); err != nil {
+			return nil, err
+		}
+	}
+
+	header, payload, err := parseAgeHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := header.unwrap(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	hmacKey, err := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(header.macInput)
+	if !hmac.Equal(mac.Sum(nil), header.mac) {
+		return nil, fmt.Errorf("age: header MAC mismatch")
+	}
+
+	return decryptAgePayload(fileKey, payload)
+}
+
+// dearmor decodes the base64 body between the armor lines
+func dearmor(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	body, ok := strings.CutPrefix(s, ageArmorBegin)
+	if !ok {
+		return nil, fmt.Errorf("age: missing armor header")
+	}
+	body, ok = strings.CutSuffix(body, ageArmorEnd)
+	if !ok {
+		return nil, fmt.Errorf("age: missing armor footer")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return nil, fmt.Errorf("age: invalid armor: %w", err)
+	}
+	return data, nil
+}
+
+// ageStanza is one recipient entry of the header, "-> type args..." and its body
+type ageStanza struct {
+	typ  string
+	args []string
+	body []byte
+}
+
+type ageHeader struct {
+	stanzas  []ageStanza
+	macInput []byte // the header up to and including "---"
+	mac      []byte
+}
+
+func parseAgeHeader(file []byte) (*ageHeader, []byte, error) {
+	r := bufio.NewReader(bytes.NewReader(file))
+	consumed := 0
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("age: truncated header")
+		}
+		consumed += len(line)
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	intro, err := readLine()
+	if err != nil {
+		return nil, nil, err
+	}
+	if intro != ageIntro {
+		return nil, nil, fmt.Errorf("age: unsupported format %q", intro)
+	}
+
+	h := &ageHeader{}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, nil, err
+		}
+		if mac, ok := strings.CutPrefix(line, "--- "); ok {
+			h.macInput = file[:consumed-len(line)-1+len("---")]
+			if h.mac, err = base64.RawStdEncoding.Strict().DecodeString(mac); err != nil {
+				return nil, nil, fmt.Errorf("age: invalid header MAC")
+			}
+			return h, file[consumed:], nil
+		}
+
+		fields, ok := strings.CutPrefix(line, "-> ")
+		if !ok {
+			return nil, nil, fmt.Errorf("age: malformed header line %q", line)
+		}
+		parts := strings.Split(fields, " ")
+		s := ageStanza{typ: parts[0], args: parts[1:]}
+		// The body is wrapped at 64 columns and ends with a shorter, possibly empty, line
+		for {
+			line, err := readLine()
+			if err != nil {
+				return nil, nil, err
+			}
+			chunk, err := base64.RawStdEncoding.Strict().DecodeString(line)
+			if err != nil {
+				return nil, nil, fmt.Errorf("age: invalid stanza body")
+			}
+			s.body = append(s.body, chunk...)
+			if len(line) < 64 {
+				break
+			}
+		}
+		h.stanzas = append(h.stanzas, s)
+	}
+}
+
+// unwrap returns the file key from the first X25519 stanza one of ids can open
+func (h *ageHeader) unwrap(ids []Identity) ([]byte, error) {
+	for _, s := range h.stanzas {
+		if s.typ != "X25519" || len(s.args) != 1 {
+			continue
+		}
+		share, err := base64.RawStdEncoding.Strict().DecodeString(s.args[0])
+		if err != nil {
+			return nil, fmt.Errorf("age: invalid X25519 stanza")
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(share)
+		if err != nil {
+			return nil, fmt.Errorf("age: invalid X25519 stanza")
+		}
+		for _, id := range ids {
+			shared, err := id.key.ECDH(ephemeral)
+			if err != nil {
+				continue
+			}
+			salt := append(append([]byte{}, share...), id.key.PublicKey().Bytes()...)
+			wrapKey, err := hkdf.Key(sha256.New, shared, salt, "age-encryption.org/v1/X25519", chacha20poly1305.KeySize)
+			if err != nil {
+				return nil, err
+			}
+			fileKey, err := openChaCha20Poly1305(wrapKey, make([]byte, chacha20poly1305.NonceSize), s.body)
+			if err == nil && len(fileKey) == ageFileKeySize {
+				return fileKey, nil
+			}
+		}
+	}
+	return nil, errNoIdentity
+}
+
+// decryptAgePayload opens the STREAM of 64 KiB chunks following the 16 byte payload nonce
+func decryptAgePayload(fileKey, payload []byte) ([]byte, error) {
+	if len(payload) < 16 {
+		return nil, fmt.Errorf("age: truncated payload")
+	}
+	key, err := hkdf.Key(sha256.New, fileKey, payload[:16], "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[16:]
+
+	var plaintext []byte
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for counter := uint64(0); ; counter++ {
+		n := min(len(payload), ageChunkSize+aead.Overhead())
+		last := n == len(payload)
+		binary.BigEndian.PutUint64(nonce[3:11], counter)
+		if last {
+			nonce[11] = 1
+		}
+		chunk, err := aead.Open(nil, nonce, payload[:n], nil)
+		if err != nil {
+			return nil, fmt.Errorf("age: payload: %w", err)
+		}
+		if len(chunk) == 0 && counter > 0 {
+			return nil, fmt.Errorf("age: empty final chunk")
+		}
+		plaintext = append(plaintext, chunk...)
+		payload = payload[n:]
+		if last {
+			return plaintext, nil
+		}
+	}
+}
+
+// openChaCha20Poly1305 authenticates and decrypts a ciphertext ending with its 16 byte tag
+func openChaCha20Poly1305(key, nonce, ciphertext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,93 @@
+// Package secrets decrypts values encrypted with age or SOPS in the configuration file
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables holding the age identities, checked in order; the SOPS ones are read as well
+// so a key set up for the sops CLI works unchanged
+var (
+	keyEnv     = []string{"IDP_CALLER_AGE_KEY", "SOPS_AGE_KEY"}
+	keyFileEnv = []string{"IDP_CALLER_AGE_KEY_FILE", "SOPS_AGE_KEY_FILE"}
+)
+
+// Decrypt replaces encrypted values of a parsed YAML document with their plaintext: a whole file
+// encrypted by SOPS with age, and single values that are ASCII armored age files.
+// Identities are only loaded when something is encrypted.
+func Decrypt(doc *yaml.Node) error {
+	ids := sync.OnceValues(loadIdentities)
+
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) == 1 && doc.Content[0].Kind == yaml.MappingNode {
+		root := doc.Content[0]
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value != "sops" {
+				continue
+			}
+			meta := root.Content[i+1]
+			root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+			if err := decryptSOPS(root, meta, ids); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return decryptArmored(doc, nil, ids)
+}
+
+// decryptArmored decrypts every scalar that is an armored age file
+func decryptArmored(n *yaml.Node, path []string, ids func() ([]Identity, error)) error {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := decryptArmored(c, path, ids); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := decryptArmored(n.Content[i+1], append(path, n.Content[i].Value), ids); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !IsAgeArmored(n.Value) {
+			return nil
+		}
+		identities, err := ids()
+		if err != nil {
+			return err
+		}
+		plaintext, err := DecryptAge([]byte(n.Value), identities)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+		}
+		n.Value, n.Tag, n.Style = string(plaintext), "!!str", 0
+	}
+	return nil
+}
+
+// loadIdentities reads the age identities from the first key or key file variable that is set
+func loadIdentities() ([]Identity, error) {
+	for _, name := range keyEnv {
+		if v := os.Getenv(name); v != "" {
+			return ParseIdentities(v)
+		}
+	}
+	for _, name := range keyFileEnv {
+		if path := os.Getenv(name); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("read age key file: %w", err)
+			}
+			return ParseIdentities(string(data))
+		}
+	}
+	return nil, fmt.Errorf("the configuration has encrypted values, set IDP_CALLER_AGE_KEY_FILE or IDP_CALLER_AGE_KEY")
+}
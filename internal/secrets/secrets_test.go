@@ -0,0 +1,375 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"gopkg.in/yaml.v3"
+)
+
+// RFC 8439 section 2.8.2
+func TestChaCha20Poly1305Vector(t *testing.T) {
+	key := unhex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := unhex(t, "070000004041424344454647")
+	aad := unhex(t, "50515253c0c1c2c3c4c5c6c7")
+	plaintext := "Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+	ciphertext := unhex(t, "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4def08e4b7a9de576d26586cec64b6116")
+	tag := unhex(t, "1ae10b594f09e26a7e902ecbd0600691")
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := aead.Open(nil, nonce, append(ciphertext, tag...), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != plaintext {
+		t.Fatalf("unexpected plaintext %q", got)
+	}
+	if _, err := openChaCha20Poly1305(key, nonce, append(ciphertext, tag...)); err == nil {
+		t.Fatal("opened without the additional data")
+	}
+}
+
+func TestDecryptAge(t *testing.T) {
+	id := newIdentity(t)
+	other := newIdentity(t)
+
+	tests := []struct {
+		name      string
+		plaintext []byte
+		armor     bool
+	}{
+		{name: "empty", plaintext: nil},
+		{name: "short", plaintext: []byte("client-secret")},
+		{name: "armored", plaintext: []byte("client-secret"), armor: true},
+		{name: "exact chunk", plaintext: bytes.Repeat([]byte("a"), ageChunkSize)},
+		{name: "several chunks", plaintext: bytes.Repeat([]byte("b"), 2*ageChunkSize+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := encryptAge(t, tt.plaintext, id.key.PublicKey())
+			if tt.armor {
+				file = armor(file)
+			}
+			got, err := DecryptAge(file, []Identity{other, id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.plaintext) {
+				t.Fatalf("plaintext mismatch: got %d bytes, want %d", len(got), len(tt.plaintext))
+			}
+		})
+	}
+}
+
+func TestDecryptAgeRejects(t *testing.T) {
+	id := newIdentity(t)
+	file := encryptAge(t, bytes.Repeat([]byte("c"), ageChunkSize+10), id.key.PublicKey())
+	payloadStart := bytes.Index(file, []byte("\n--- ")) + 1
+	payloadStart += bytes.IndexByte(file[payloadStart:], '\n') + 1
+
+	tests := []struct {
+		name    string
+		file    []byte
+		ids     []Identity
+		wantErr string
+	}{
+		{name: "other identity", file: file, ids: []Identity{newIdentity(t)}, wantErr: "no age identity"},
+		{name: "modified header", file: bytes.Replace(file, []byte("X25519"), []byte("X25518"), 1), ids: []Identity{id}, wantErr: "no age identity"},
+		{name: "modified mac", file: flip(file, payloadStart-3), ids: []Identity{id}, wantErr: "header MAC"},
+		{name: "modified payload", file: flip(file, len(file)-1), ids: []Identity{id}, wantErr: "payload"},
+		{name: "truncated last chunk", file: file[:payloadStart+16+ageChunkSize+chacha20poly1305.Overhead], ids: []Identity{id}, wantErr: "payload"},
+		{name: "truncated header", file: file[:20], ids: []Identity{id}, wantErr: "truncated header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecryptAge(tt.file, tt.ids)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseIdentities(t *testing.T) {
+	id := newIdentity(t)
+	text := "# created: today\n# public key: age1...\n\n" + encodeIdentity(t, id) + "\n"
+	ids, err := ParseIdentities(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || !ids[0].key.Equal(id.key) {
+		t.Fatal("parsed identity differs")
+	}
+
+	if _, err := ParseIdentities("# only comments\n"); err == nil {
+		t.Fatal("expected an error without identities")
+	}
+	if _, err := ParseIdentities(encodeIdentity(t, id)[:40]); err == nil {
+		t.Fatal("expected an error for a truncated identity")
+	}
+}
+
+func TestDecryptSOPS(t *testing.T) {
+	id := newIdentity(t)
+	t.Setenv("IDP_CALLER_AGE_KEY", encodeIdentity(t, id))
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+
+	doc := sopsFile(t, dataKey, id, false)
+	if err := Decrypt(doc); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Server struct {
+			Port int `yaml:"port"`
+		} `yaml:"server"`
+		IDPs []struct {
+			Secret string `yaml:"client_secret"`
+		} `yaml:"idps"`
+		Enabled bool           `yaml:"enabled"`
+		Sops    map[string]any `yaml:"sops"`
+	}
+	if err := doc.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Server.Port != 8080 || len(got.IDPs) != 1 || got.IDPs[0].Secret != "s3cr3t" || !got.Enabled || got.Sops != nil {
+		t.Fatalf("unexpected decrypted document %+v", got)
+	}
+
+	if err := Decrypt(sopsFile(t, dataKey, id, true)); err == nil || !strings.Contains(err.Error(), "MAC mismatch") {
+		t.Fatalf("expected a MAC mismatch, got %v", err)
+	}
+}
+
+func TestDecryptArmoredValue(t *testing.T) {
+	id := newIdentity(t)
+	t.Setenv("IDP_CALLER_AGE_KEY", encodeIdentity(t, id))
+	value := string(armor(encryptAge(t, []byte("s3cr3t"), id.key.PublicKey())))
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(fmt.Appendf(nil, "idps:\n  - client_secret: |\n%s", indent(value, "      ")), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := Decrypt(&doc); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		IDPs []struct {
+			Secret string `yaml:"client_secret"`
+		} `yaml:"idps"`
+	}
+	if err := doc.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.IDPs[0].Secret != "s3cr3t" {
+		t.Fatalf("unexpected secret %q", got.IDPs[0].Secret)
+	}
+}
+
+// sopsFile builds a SOPS file the way the sops CLI writes one with an age recipient; tamper
+// changes a value after the MAC was computed
+func sopsFile(t *testing.T, dataKey []byte, id Identity, tamper bool) *yaml.Node {
+	t.Helper()
+	hash := sha512.New()
+	enc := func(value, typ, path string) string {
+		hash.Write([]byte(value))
+		return sopsEncrypt(t, dataKey, value, typ, path)
+	}
+	port := enc("8080", "int", "server:port:")
+	secret := enc("s3cr3t", "str", "idps:client_secret:")
+	enabled := enc("True", "bool", "enabled:")
+	if tamper {
+		secret = sopsEncrypt(t, dataKey, "other", "str", "idps:client_secret:")
+	}
+	lastModified := "2026-10-16T10:00:00Z"
+	mac := sopsEncrypt(t, dataKey, fmt.Sprintf("%X", hash.Sum(nil)), "str", lastModified)
+	recipient := string(armor(encryptAge(t, dataKey, id.key.PublicKey())))
+
+	text := fmt.Sprintf(`server:
+  port: %s
+idps:
+  - client_secret: %s
+enabled: %s
+sops:
+  age:
+    - recipient: age1test
+      enc: |
+%s
+  lastmodified: "%s"
+  mac: %s
+  version: 3.9.0
+`, port, secret, enabled, indent(recipient, "        "), lastModified, mac)
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return &doc
+}
+
+func sopsEncrypt(t *testing.T, key []byte, value, typ, aad string) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, 32)
+	rand.Read(iv)
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(aad))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), typ)
+}
+
+// encryptAge writes a binary age file for one X25519 recipient, following the age v1 specification
+func encryptAge(t *testing.T, plaintext []byte, recipient *ecdh.PublicKey) []byte {
+	t.Helper()
+	fileKey := make([]byte, ageFileKeySize)
+	rand.Read(fileKey)
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := ephemeral.PublicKey().Bytes()
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapKey, err := hkdf.Key(sha256.New, shared, append(append([]byte{}, share...), recipient.Bytes()...), "age-encryption.org/v1/X25519", chacha20poly1305.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := seal(t, wrapKey, make([]byte, chacha20poly1305.NonceSize), fileKey)
+
+	b64 := base64.RawStdEncoding
+	header := ageIntro + "\n-> X25519 " + b64.EncodeToString(share) + "\n" + b64.EncodeToString(body) + "\n---"
+	hmacKey, err := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write([]byte(header))
+	file := []byte(header + " " + b64.EncodeToString(mac.Sum(nil)) + "\n")
+
+	payloadNonce := make([]byte, 16)
+	rand.Read(payloadNonce)
+	streamKey, err := hkdf.Key(sha256.New, fileKey, payloadNonce, "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file = append(file, payloadNonce...)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for counter := uint64(0); ; counter++ {
+		n := min(len(plaintext), ageChunkSize)
+		last := len(plaintext) == n
+		binary.BigEndian.PutUint64(nonce[3:11], counter)
+		if last {
+			nonce[11] = 1
+		}
+		file = append(file, seal(t, streamKey, nonce, plaintext[:n])...)
+		plaintext = plaintext[n:]
+		if last {
+			return file
+		}
+	}
+}
+
+func seal(t *testing.T, key, nonce, plaintext []byte) []byte {
+	t.Helper()
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead.Seal(nil, nonce, plaintext, nil)
+}
+
+func armor(file []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(file)
+	var b strings.Builder
+	b.WriteString(ageArmorBegin + "\n")
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\n" + ageArmorEnd + "\n")
+	return []byte(b.String())
+}
+
+func newIdentity(t *testing.T) Identity {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Identity{key: key}
+}
+
+// encodeIdentity writes id as an AGE-SECRET-KEY-1... bech32 string
+func encodeIdentity(t *testing.T, id Identity) string {
+	t.Helper()
+	// Regroup into 5 bit values, zero padding the last one
+	var data []byte
+	var acc, bits uint
+	for _, b := range id.key.Bytes() {
+		acc = acc<<8 | uint(b)
+		for bits += 8; bits >= 5; bits -= 5 {
+			data = append(data, byte(acc>>(bits-5)&31))
+		}
+	}
+	if bits > 0 {
+		data = append(data, byte(acc<<(5-bits)&31))
+	}
+	values := append(bech32HRPExpand(ageIdentityHRP), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := range 6 {
+		data = append(data, byte(polymod>>(5*(5-i)))&31)
+	}
+	var b strings.Builder
+	b.WriteString(ageIdentityHRP + "1")
+	for _, v := range data {
+		b.WriteByte(bech32Charset[v])
+	}
+	return strings.ToUpper(b.String())
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
+
+func flip(b []byte, i int) []byte {
+	b = bytes.Clone(b)
+	b[i] ^= 1
+	return b
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,159 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sopsValue matches a value encrypted by SOPS
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// sopsMetadata is the part of the sops: block needed to decrypt a file with age
+type sopsMetadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	LastModified     string `yaml:"lastmodified"`
+	MAC              string `yaml:"mac"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+}
+
+// decryptSOPS decrypts the values of a SOPS file in place and removes its sops: block.
+// The data key must be encrypted to an age recipient; the file's MAC is verified over all values.
+func decryptSOPS(root *yaml.Node, metaNode *yaml.Node, ids func() ([]Identity, error)) error {
+	var meta sopsMetadata
+	if err := metaNode.Decode(&meta); err != nil {
+		return fmt.Errorf("sops: invalid metadata: %w", err)
+	}
+	if len(meta.Age) == 0 {
+		return fmt.Errorf("sops: only age recipients are supported")
+	}
+	identities, err := ids()
+	if err != nil {
+		return err
+	}
+
+	var dataKey []byte
+	for _, r := range meta.Age {
+		if dataKey, err = DecryptAge([]byte(r.Enc), identities); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("sops: data key: %w", err)
+	}
+
+	hash := sha512.New()
+	var walk func(n *yaml.Node, path []string) error
+	walk = func(n *yaml.Node, path []string) error {
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if err := walk(n.Content[i+1], append(path, n.Content[i].Value)); err != nil {
+					return err
+				}
+			}
+		case yaml.SequenceNode:
+			// List items share the path of their list
+			for _, item := range n.Content {
+				if err := walk(item, path); err != nil {
+					return err
+				}
+			}
+		case yaml.ScalarNode:
+			encrypted := sopsValue.MatchString(n.Value)
+			if encrypted {
+				value, typ, err := sopsDecrypt(n.Value, dataKey, strings.Join(path, ":")+":")
+				if err != nil {
+					return fmt.Errorf("sops: %s: %w", strings.Join(path, "."), err)
+				}
+				n.Value, n.Tag, n.Style = value, sopsTags[typ], 0
+			}
+			if encrypted || !meta.MACOnlyEncrypted {
+				hash.Write(sopsMACBytes(n))
+			}
+		}
+		return nil
+	}
+	if err := walk(root, nil); err != nil {
+		return err
+	}
+
+	mac, _, err := sopsDecrypt(meta.MAC, dataKey, meta.LastModified)
+	if err != nil {
+		return fmt.Errorf("sops: mac: %w", err)
+	}
+	if !strings.EqualFold(mac, fmt.Sprintf("%X", hash.Sum(nil))) {
+		return fmt.Errorf("sops: MAC mismatch, the file was modified after encryption")
+	}
+	return nil
+}
+
+// sopsTags are the YAML tags of the decrypted SOPS value types
+var sopsTags = map[string]string{"str": "!!str", "int": "!!int", "float": "!!float", "bool": "!!bool", "bytes": "!!str"}
+
+// sopsDecrypt opens one ENC[AES256_GCM,...] value, authenticated with the path of its key
+func sopsDecrypt(value string, key []byte, aad string) (string, string, error) {
+	m := sopsValue.FindStringSubmatch(value)
+	if m == nil {
+		return "", "", fmt.Errorf("not an encrypted value")
+	}
+	data, err1 := base64.StdEncoding.DecodeString(m[1])
+	iv, err2 := base64.StdEncoding.DecodeString(m[2])
+	tag, err3 := base64.StdEncoding.DecodeString(m[3])
+	if err1 != nil || err2 != nil || err3 != nil || len(iv) == 0 {
+		return "", "", fmt.Errorf("malformed encrypted value")
+	}
+	if _, ok := sopsTags[m[4]]; !ok {
+		return "", "", fmt.Errorf("unsupported value type %q", m[4])
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(aad))
+	if err != nil {
+		return "", "", fmt.Errorf("decryption failed, wrong key or modified value")
+	}
+	return string(plaintext), m[4], nil
+}
+
+// sopsMACBytes is the representation SOPS hashes for a value: strings as is, numbers in their shortest
+// form and booleans as True/False
+func sopsMACBytes(n *yaml.Node) []byte {
+	switch n.ShortTag() {
+	case "!!int":
+		if v, err := strconv.ParseInt(n.Value, 0, 64); err == nil {
+			return []byte(strconv.FormatInt(v, 10))
+		}
+	case "!!float":
+		if v, err := strconv.ParseFloat(n.Value, 64); err == nil {
+			return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	case "!!bool":
+		var v bool
+		if n.Decode(&v) == nil {
+			if v {
+				return []byte("True")
+			}
+			return []byte("False")
+		}
+	case "!!null":
+		return nil
+	}
+	return []byte(n.Value)
+}
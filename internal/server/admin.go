@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// SetAdmin configures authentication of the admin endpoints, must be called before Start
+func (s *Server) SetAdmin(cfg config.AdminConfig) {
+	s.admin = cfg
+}
+
+// requireRole rejects requests without a valid admin bearer token or, with admin.jwt, a valid JWT,
+// and requests whose role doesn't include role.
+// The token file is re-read on every request so tokens can be rotated without a restart.
+func (s *Server) requireRole(role string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || presented == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			actor, granted, err := s.authenticateAdmin(presented)
+			if err == nil && !config.RoleAllows(granted, role) {
+				err = fmt.Errorf("role %s required, token has %s", role, granted)
+			}
+			if err != nil {
+				s.logger.Warn("Rejected admin request", "path", r.URL.Path, "client_ip", clientIP(r), "reason", err.Error())
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, actor)))
+		}
+	}
+}
+
+// authenticateAdmin returns the actor and role of a static token or, with admin.jwt, a JWT
+func (s *Server) authenticateAdmin(presented string) (actor, role string, err error) {
+	if role, ok := s.adminTokenRole(presented); ok {
+		return "token:" + fingerprint(presented), role, nil
+	}
+	if s.admin.JWT != nil && strings.Count(presented, ".") == 2 {
+		return s.validAdminJWT(presented)
+	}
+	return "", "", fmt.Errorf("unknown token")
+}
+
+type actorKey struct{}
+
+// adminActor returns who made an admin request: "token:<fingerprint>" for static tokens, "<idp>:<sub>" for JWTs
+func adminActor(r *http.Request) string {
+	actor, _ := r.Context().Value(actorKey{}).(string)
+	return actor
+}
+
+// fingerprint identifies a static token in audit records without revealing it
+func fingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
+
+// adminTokenRole compares the presented token with every configured token in constant time.
+// Lines are "<token>" or "<token> <role>", tokens without a role are admins.
+func (s *Server) adminTokenRole(presented string) (string, bool) {
+	if s.admin.TokenFile == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(s.admin.TokenFile)
+	if err != nil {
+		s.logger.Error("Failed to read admin token file", "error", err)
+		return "", false
+	}
+
+	role, valid := "", false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		lineRole := config.RoleAdmin
+		if len(fields) > 1 {
+			lineRole = fields[1]
+		}
+		if subtle.ConstantTimeCompare([]byte(fields[0]), []byte(presented)) == 1 {
+			if !config.ValidRole(lineRole) {
+				s.logger.Error("Unknown role in admin token file", "role", lineRole)
+				continue
+			}
+			role, valid = lineRole, true
+		}
+	}
+	return role, valid
+}
+
+// validAdminJWT verifies the token with the cached keys of its IDP and checks the admin.jwt requirements.
+// It returns the token's subject as "<idp>:<sub>" and its role from admin.jwt.role_mapping.
+func (s *Server) validAdminJWT(raw string) (string, string, error) {
+	validator := s.validator.Load()
+	if validator == nil {
+		return "", "", fmt.Errorf("token validation is not configured")
+	}
+	result := validator.Validate(raw)
+	if !result.Valid {
+		return "", "", fmt.Errorf("invalid token: %s", result.Error)
+	}
+
+	cfg := s.admin.JWT
+	if !slices.Contains(cfg.IDPs, result.IDP) {
+		return "", "", fmt.Errorf("tokens of idp %q are not accepted", result.IDP)
+	}
+	if len(cfg.Audiences) > 0 && !containsAny(claimValues(result.Claims, "aud"), cfg.Audiences) {
+		return "", "", fmt.Errorf("token audience not accepted")
+	}
+	if len(cfg.Roles) > 0 && !containsAny(claimValues(result.Claims, cfg.GetRolesClaim()), cfg.Roles) {
+		return "", "", fmt.Errorf("token has none of the required roles")
+	}
+	if len(cfg.Scopes) > 0 {
+		scopes := append(claimValues(result.Claims, "scope"), claimValues(result.Claims, "scp")...)
+		if !containsAny(scopes, cfg.Scopes) {
+			return "", "", fmt.Errorf("token has none of the required scopes")
+		}
+	}
+	role := jwtRole(result.Claims, cfg)
+	if role == "" {
+		return "", "", fmt.Errorf("token maps to no admin role")
+	}
+	sub, _ := result.Claims["sub"].(string)
+	return result.IDP + ":" + sub, role, nil
+}
+
+// jwtRole returns the highest role of role_mapping matched, client only when no other role matches, by the token's roles or scopes, admin without a mapping
+func jwtRole(claims map[string]any, cfg *config.AdminJWTConfig) string {
+	if len(cfg.RoleMapping) == 0 {
+		return config.RoleAdmin
+	}
+	values := claimValues(claims, cfg.GetRolesClaim())
+	values = append(values, claimValues(claims, "scope")...)
+	values = append(values, claimValues(claims, "scp")...)
+	for _, role := range []string{config.RoleAdmin, config.RoleOperator, config.RoleViewer, config.RoleClient} {
+		if containsAny(values, cfg.RoleMapping[role]) {
+			return role
+		}
+	}
+	return ""
+}
+
+// claimValues returns the strings of a claim, following dots into nested objects (realm_access.roles).
+// A string claim is split on spaces, as OAuth scope is.
+func claimValues(claims map[string]any, path string) []string {
+	var value any = claims
+	for name := range strings.SplitSeq(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsAny(have, want []string) bool {
+	return slices.ContainsFunc(have, func(v string) bool { return slices.Contains(want, v) })
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/validate"
+)
+
+// SetAudiences maps audiences to the IDPs whose tokens carry them, served on
+// /audiences/{aud}/jwks.json and checked by /validate?audience=. It can be called again while
+// serving, when PUT /admin/state changes the IDPs.
+func (s *Server) SetAudiences(audiences map[string][]string) {
+	s.audiences.Store(&audiences)
+}
+
+// audienceIDPs returns the IDPs issuing tokens for aud
+func (s *Server) audienceIDPs(aud string) ([]string, bool) {
+	audiences := s.audiences.Load()
+	if audiences == nil {
+		return nil, false
+	}
+	idps, ok := (*audiences)[aud]
+	return idps, ok
+}
+
+// handleAudienceJWKS serves the merged keys of the IDPs issuing tokens for one audience, so a
+// resource server only trusts the keys meant for it. Audiences containing slashes are path escaped.
+func (s *Server) handleAudienceJWKS(w http.ResponseWriter, r *http.Request) {
+	aud := r.PathValue("aud")
+	idps, ok := s.audienceIDPs(aud)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Audience '%s' not found", aud), http.StatusNotFound)
+		return
+	}
+	s.serveGroupJWKS(w, r, config.AudiencePath(aud), idps)
+}
+
+// checkAudience rejects a valid result whose IDP isn't mapped to aud or whose token doesn't carry it
+func (s *Server) checkAudience(result validate.Result, aud string) validate.Result {
+	if !result.Valid {
+		return result
+	}
+	if idps, _ := s.audienceIDPs(aud); !slices.Contains(idps, result.IDP) {
+		return validate.Result{IDP: result.IDP, Error: fmt.Sprintf("IDP %q doesn't issue tokens for audience %q", result.IDP, aud)}
+	}
+	if !slices.Contains(claimValues(result.Claims, "aud"), aud) {
+		return validate.Result{IDP: result.IDP, Error: fmt.Sprintf("token audience doesn't include %q", aud)}
+	}
+	return result
+}
+
+// requestAudience returns the ?audience= of a /validate request, answering 404 when it isn't configured
+func (s *Server) requestAudience(w http.ResponseWriter, r *http.Request) (string, bool) {
+	aud := r.URL.Query().Get("audience")
+	if aud == "" {
+		return "", true
+	}
+	if _, ok := s.audienceIDPs(aud); !ok {
+		http.Error(w, fmt.Sprintf("Audience '%s' not found", aud), http.StatusNotFound)
+		return "", false
+	}
+	return aud, true
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/audit"
+)
+
+// defaultAuditLimit is the number of records GET /audit/admin returns without ?limit=
+const defaultAuditLimit = 100
+
+// SetAudit records admin actions and enables GET /audit/admin, must be called before Start
+func (s *Server) SetAudit(log *audit.Log) {
+	s.audit = log
+}
+
+// recordAudit records an admin action of the request's actor
+func (s *Server) recordAudit(r *http.Request, action, target string, before, after any) {
+	if s.audit == nil {
+		return
+	}
+	s.audit.Record(audit.Record{
+		Actor:    adminActor(r),
+		Action:   action,
+		Target:   target,
+		Before:   before,
+		After:    after,
+		ClientIP: clientIP(r),
+	})
+}
+
+// handleAudit returns admin actions, newest first, filtered by ?actor=, ?action=, ?target=, ?since= and ?limit=
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if s.audit == nil {
+		http.Error(w, "Audit log is not configured", http.StatusNotFound)
+		return
+	}
+
+	q := audit.Query{
+		Actor:  r.URL.Query().Get("actor"),
+		Action: r.URL.Query().Get("action"),
+		Target: r.URL.Query().Get("target"),
+		Limit:  defaultAuditLimit,
+	}
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		q.Since = since
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+
+	records := s.audit.Query(q)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(map[string]any{"records": records, "count": len(records)}); err != nil {
+		s.logger.Error("Failed to encode audit response", "error", err)
+	}
+}
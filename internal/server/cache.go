@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// freshness is how long a JWKS response may be cached, counted from the fetch that produced it
+type freshness struct {
+	maxAge int       // freshness lifetime in seconds
+	until  time.Time // when the response expires, zero before the first successful fetch
+}
+
+// mergedFreshness returns the freshness of the response served on path combining the keys of several
+// IDPs, from the cache durations of the IDPs with served keys as merged.cache_control decides.
+// Without any, or with the fixed policy, it is max_age counted from now.
+func (s *Server) mergedFreshness(path string, all map[string]*jwks.IDPData) freshness {
+	policy := s.merged.CacheControl.For(path)
+	f := freshness{maxAge: policy.GetMaxAge()}
+	if policy.GetPolicy() == config.CachePolicyFixed {
+		return f
+	}
+
+	now := time.Now()
+	var shortest, longest freshness
+	weighted, keys := 0, 0
+	for _, data := range all {
+		if data.JWKS == nil || len(data.JWKS.Keys) == 0 || data.Drained(now) || data.CacheDuration <= 0 {
+			continue
+		}
+		if shortest.maxAge == 0 || data.CacheDuration < shortest.maxAge {
+			shortest.maxAge = data.CacheDuration
+		}
+		if !data.CacheUntil.IsZero() && (shortest.until.IsZero() || data.CacheUntil.Before(shortest.until)) {
+			shortest.until = data.CacheUntil
+		}
+		if data.CacheDuration > longest.maxAge {
+			longest.maxAge = data.CacheDuration
+		}
+		if data.CacheUntil.After(longest.until) {
+			longest.until = data.CacheUntil
+		}
+		weighted += data.CacheDuration * len(data.JWKS.Keys)
+		keys += len(data.JWKS.Keys)
+	}
+	if keys == 0 {
+		return f
+	}
+
+	switch policy.GetPolicy() {
+	case config.CachePolicyMax:
+		return longest
+	case config.CachePolicyWeighted:
+		return freshness{maxAge: weighted / keys}
+	default:
+		return shortest
+	}
+}
+
+// setCacheHeaders sets Cache-Control, Age and Expires so that caches keep the response exactly
+// until it expires. Once it has expired, during an upstream outage, Age exceeds max-age and
+// stale-if-error lets caches keep serving it if they can't reach us.
+func (s *Server) setCacheHeaders(w http.ResponseWriter, f freshness) {
+	now := time.Now()
+	until := f.until
+	if until.IsZero() || until.After(now.Add(time.Duration(f.maxAge)*time.Second)) {
+		until = now.Add(time.Duration(f.maxAge) * time.Second)
+	}
+	// Whole seconds since the fetch: the remaining lifetime rounds up, or a fresh response would be a second old
+	age := max(0, f.maxAge-int(math.Ceil(until.Sub(now).Seconds())))
+
+	cacheControl := fmt.Sprintf("public, max-age=%d", f.maxAge)
+	if staleIfError := s.config.GetStaleIfError(); staleIfError > 0 {
+		cacheControl += fmt.Sprintf(", stale-if-error=%d", staleIfError)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("Age", strconv.Itoa(age))
+	w.Header().Set("Expires", until.UTC().Format(http.TimeFormat))
+}
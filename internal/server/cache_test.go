@@ -0,0 +1,60 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+func TestSetCacheHeadersAge(t *testing.T) {
+	tests := []struct {
+		name  string
+		until time.Duration // from now, zero for none
+		age   string
+	}{
+		{name: "never fetched", age: "0"},
+		{name: "just fetched", until: 300*time.Second - 5*time.Millisecond, age: "0"},
+		{name: "partly elapsed", until: 200*time.Second - 500*time.Millisecond, age: "100"},
+		{name: "expired", until: -10*time.Second - 500*time.Millisecond, age: "310"},
+	}
+	s := &Server{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := freshness{maxAge: 300}
+			if tt.until != 0 {
+				f.until = time.Now().Add(tt.until)
+			}
+			w := httptest.NewRecorder()
+			s.setCacheHeaders(w, f)
+			if age := w.Header().Get("Age"); age != tt.age {
+				t.Fatalf("Age %s, want %s", age, tt.age)
+			}
+		})
+	}
+}
+
+func TestFreshResponseAge(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := jwks.NewManager(logger)
+	keySet := &jwks.JWKS{Keys: []jwks.JWK{{Kid: "k1", Kty: "RSA", N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4", E: "AQAB"}}}
+	manager.Update("corp", keySet, 10, 300, nil)
+	srv := New(config.ServerConfig{}, manager, logger)
+	srv.MarkReady()
+
+	time.Sleep(10 * time.Millisecond)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks/corp", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if age, cc := w.Header().Get("Age"), w.Header().Get("Cache-Control"); age != "0" || !strings.HasPrefix(cc, "public, max-age=300") {
+		t.Fatalf("Age %s, Cache-Control %q for a response fetched just now", age, cc)
+	}
+}
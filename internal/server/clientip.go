@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// withClientIP stores the client address of the request, see resolveClientIP
+func (s *Server) withClientIP(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, s.resolveClientIP(r)))
+}
+
+// clientIP returns the client address resolved by the logging middleware, the peer address without it
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerHost(r.RemoteAddr)
+}
+
+// resolveClientIP returns the address of the client behind our trusted proxies.
+// Forwarding headers are only read when the peer is a trusted proxy, and their hops are walked from
+// the nearest one, so a client can't spoof its address by sending the headers itself: the first
+// untrusted hop is the client. Forwarded wins over X-Forwarded-For, which wins over X-Real-IP.
+func (s *Server) resolveClientIP(r *http.Request) string {
+	peer, err := netip.ParseAddr(peerHost(r.RemoteAddr))
+	if err != nil || !s.trustedProxy(peer) {
+		return peerHost(r.RemoteAddr)
+	}
+
+	hops := forwardedHops(r.Header)
+	if len(hops) == 0 {
+		hops = splitHops(r.Header.Values("X-Forwarded-For"))
+	}
+	if len(hops) == 0 {
+		hops = splitHops(r.Header.Values("X-Real-IP"))
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// "unknown", an obfuscated identifier or garbage: nothing further left can be trusted
+			break
+		}
+		client = addr
+		if !s.trustedProxy(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHops returns the for= values of the RFC 7239 Forwarded headers, client first
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	for _, element := range splitHops(h.Values("Forwarded")) {
+		for pair := range strings.SplitSeq(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hops = append(hops, value)
+			}
+		}
+	}
+	return hops
+}
+
+// splitHops splits comma separated header values, over several header lines, into single hops
+func splitHops(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for hop := range strings.SplitSeq(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses "192.0.2.1", "192.0.2.1:4711", "2001:db8::1" and "[2001:db8::1]:4711", quoted or not
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.Trim(hop, `"`)
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// peerHost strips the port of a RemoteAddr; unix socket peers have no address and are returned as is
+func peerHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
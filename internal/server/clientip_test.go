@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	cfg := config.ServerConfig{TrustedProxies: []string{"10.0.0.0/8", "2001:db8:ffff::1"}}
+	s := &Server{trustedProxies: cfg.GetTrustedProxies()}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string][]string
+		want    string
+	}{
+		{"no headers", "192.0.2.7:4711", nil, "192.0.2.7"},
+		{"unix socket peer", "@", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "@"},
+		{"untrusted peer spoofs X-Forwarded-For", "192.0.2.7:4711",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.7"},
+		{"untrusted peer spoofs Forwarded", "192.0.2.7:4711",
+			map[string][]string{"Forwarded": {"for=198.51.100.1"}}, "192.0.2.7"},
+		{"untrusted peer spoofs X-Real-IP", "192.0.2.7:4711",
+			map[string][]string{"X-Real-IP": {"198.51.100.1"}}, "192.0.2.7"},
+		{"trusted peer without headers", "10.0.0.1:4711", nil, "10.0.0.1"},
+		{"trusted peer X-Forwarded-For", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"trusted hops walked from the right", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.9, 198.51.100.1, 10.1.1.1, 10.2.2.2"}}, "198.51.100.1"},
+		{"hops over several header lines", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.9, 198.51.100.1", "10.2.2.2"}}, "198.51.100.1"},
+		{"all hops trusted", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"10.3.3.3, 10.2.2.2"}}, "10.3.3.3"},
+		{"Forwarded over X-Forwarded-For", "10.0.0.1:4711",
+			map[string][]string{
+				"Forwarded":       {"for=198.51.100.1;proto=https"},
+				"X-Forwarded-For": {"198.51.100.2"},
+				"X-Real-IP":       {"198.51.100.3"},
+			}, "198.51.100.1"},
+		{"X-Forwarded-For over X-Real-IP", "10.0.0.1:4711",
+			map[string][]string{
+				"X-Forwarded-For": {"198.51.100.2"},
+				"X-Real-IP":       {"198.51.100.3"},
+			}, "198.51.100.2"},
+		{"X-Real-IP", "10.0.0.1:4711",
+			map[string][]string{"X-Real-IP": {"198.51.100.3"}}, "198.51.100.3"},
+		{"Forwarded elements and case", "10.0.0.1:4711",
+			map[string][]string{"Forwarded": {"FOR=203.0.113.9, proto=http;For=198.51.100.1", "for=10.2.2.2;by=10.0.0.1"}}, "198.51.100.1"},
+		{"Forwarded without for falls back", "10.0.0.1:4711",
+			map[string][]string{"Forwarded": {"proto=https;host=example.com"}, "X-Forwarded-For": {"198.51.100.2"}}, "198.51.100.2"},
+		{"unknown hop stops the walk", "10.0.0.1:4711",
+			map[string][]string{"Forwarded": {"for=198.51.100.1, for=unknown, for=10.2.2.2"}}, "10.2.2.2"},
+		{"obfuscated hop stops the walk", "10.0.0.1:4711",
+			map[string][]string{"Forwarded": {`for=198.51.100.1, for="_hidden"`}}, "10.0.0.1"},
+		{"quoted IPv4 with port", "10.0.0.1:4711",
+			map[string][]string{"Forwarded": {`for="198.51.100.1:8080"`}}, "198.51.100.1"},
+		{"bracketed IPv6 with port", "10.0.0.1:4711",
+			map[string][]string{"Forwarded": {`for="[2001:db8::17]:4711"`}}, "2001:db8::17"},
+		{"bracketed IPv6 without port", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"[2001:db8::17]"}}, "2001:db8::17"},
+		{"bare IPv6", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"2001:db8::17"}}, "2001:db8::17"},
+		{"trusted IPv6 peer", "[2001:db8:ffff::1]:4711",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"untrusted IPv6 peer", "[2001:db8::2]:4711",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "2001:db8::2"},
+		{"IPv4-mapped IPv6 hop", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, ::ffff:10.2.2.2"}}, "198.51.100.1"},
+		{"malformed hop", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"not-an-ip"}}, "10.0.0.1"},
+		{"malformed hop behind client", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"garbage, 198.51.100.1"}}, "198.51.100.1"},
+		{"malformed hop behind trusted hop", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, 300.1.1.1, 10.2.2.2"}}, "10.2.2.2"},
+		{"empty hops", "10.0.0.1:4711",
+			map[string][]string{"X-Forwarded-For": {" , ,198.51.100.1, "}}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			for name, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+			if got := s.resolveClientIP(r); got != tt.want {
+				t.Fatalf("resolveClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
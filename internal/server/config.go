@@ -0,0 +1,21 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// SetConfig sets the effective configuration served on GET /admin/config, must be called before Start
+func (s *Server) SetConfig(effective map[string]any) {
+	s.effectiveConfig = effective
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.effectiveConfig); err != nil {
+		s.logger.Error("Failed to encode config response", "error", err)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// maxUserAgentLength bounds the User-Agent kept per consumer
+const maxUserAgentLength = 256
+
+// consumerKey identifies a consumer of one endpoint
+type consumerKey struct {
+	path      string
+	clientIP  string
+	userAgent string
+}
+
+type consumerStats struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	requests  int64
+}
+
+// consumerTracker remembers the distinct consumers of each key endpoint for a rolling window
+type consumerTracker struct {
+	window time.Duration
+	max    int
+
+	mu      sync.Mutex
+	seen    map[consumerKey]*consumerStats
+	dropped int64 // consumers evicted to stay under max
+}
+
+func newConsumerTracker(cfg *config.ConsumersConfig) *consumerTracker {
+	if cfg == nil {
+		return nil
+	}
+	return &consumerTracker{
+		window: cfg.GetWindow(),
+		max:    cfg.GetMaxConsumers(),
+		seen:   make(map[consumerKey]*consumerStats),
+	}
+}
+
+// record counts a request of a consumer
+func (t *consumerTracker) record(key consumerKey, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if stats, ok := t.seen[key]; ok {
+		stats.lastSeen = now
+		stats.requests++
+		return
+	}
+	if len(t.seen) >= t.max {
+		t.expire(now)
+	}
+	if len(t.seen) >= t.max {
+		t.evictOldest()
+	}
+	t.seen[key] = &consumerStats{firstSeen: now, lastSeen: now, requests: 1}
+}
+
+// expire forgets consumers not seen within the window, must hold t.mu
+func (t *consumerTracker) expire(now time.Time) {
+	for key, stats := range t.seen {
+		if now.Sub(stats.lastSeen) > t.window {
+			delete(t.seen, key)
+		}
+	}
+}
+
+// evictOldest forgets the least recently seen consumer, must hold t.mu
+func (t *consumerTracker) evictOldest() {
+	var oldest consumerKey
+	var oldestSeen time.Time
+	for key, stats := range t.seen {
+		if oldestSeen.IsZero() || stats.lastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = key, stats.lastSeen
+		}
+	}
+	delete(t.seen, oldest)
+	t.dropped++
+}
+
+// consumer is one entry of /status/consumers
+type consumer struct {
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent"`
+	Requests  int64     `json:"requests"` // since first_seen
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// report returns the consumers seen within the window by path, most requests first; path limits it to one endpoint
+func (t *consumerTracker) report(now time.Time, path string) (map[string][]consumer, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+
+	endpoints := make(map[string][]consumer)
+	for key, stats := range t.seen {
+		if path != "" && key.path != path {
+			continue
+		}
+		endpoints[key.path] = append(endpoints[key.path], consumer{
+			ClientIP:  key.clientIP,
+			UserAgent: key.userAgent,
+			Requests:  stats.requests,
+			FirstSeen: stats.firstSeen,
+			LastSeen:  stats.lastSeen,
+		})
+	}
+	for _, consumers := range endpoints {
+		sort.Slice(consumers, func(i, j int) bool {
+			if consumers[i].Requests != consumers[j].Requests {
+				return consumers[i].Requests > consumers[j].Requests
+			}
+			return consumers[i].LastSeen.After(consumers[j].LastSeen)
+		})
+	}
+	return endpoints, t.dropped
+}
+
+// trackConsumers records who successfully requested a key endpoint, identified by the client
+// address resolved through trusted proxies and the User-Agent
+func (s *Server) trackConsumers(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next(rw, r)
+		if rw.statusCode >= http.StatusBadRequest {
+			return
+		}
+		userAgent := r.UserAgent()
+		if len(userAgent) > maxUserAgentLength {
+			userAgent = userAgent[:maxUserAgentLength]
+		}
+		s.consumers.record(consumerKey{path: r.URL.Path, clientIP: clientIP(r), userAgent: userAgent}, time.Now())
+	}
+}
+
+// handleConsumers lists the consumers of each key endpoint seen within the window, ?path= selects one endpoint
+func (s *Server) handleConsumers(w http.ResponseWriter, r *http.Request) {
+	endpoints, dropped := s.consumers.report(time.Now(), r.URL.Query().Get("path"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	response := struct {
+		Window    int                   `json:"window"` // seconds
+		Dropped   int64                 `json:"dropped"`
+		Endpoints map[string][]consumer `json:"endpoints"`
+	}{int(s.consumers.window.Seconds()), dropped, endpoints}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode consumers response", "error", err)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// debugManagerResponse is the body of GET /debug/manager
+type debugManagerResponse struct {
+	Manager jwks.ManagerStats `json:"manager"`
+	Runtime runtimeStats      `json:"runtime"`
+}
+
+// runtimeStats is the subset of runtime.MemStats useful to spot memory growth
+type runtimeStats struct {
+	Goroutines   int    `json:"goroutines"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalMs uint64 `json:"gc_pause_total_ms"`
+}
+
+// handleDebugManager dumps the manager's internal state and memory statistics
+func (s *Server) handleDebugManager(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	response := debugManagerResponse{
+		Manager: s.manager.Inspect(),
+		Runtime: runtimeStats{
+			Goroutines:   runtime.NumGoroutine(),
+			HeapAlloc:    ms.HeapAlloc,
+			HeapInuse:    ms.HeapInuse,
+			HeapObjects:  ms.HeapObjects,
+			Sys:          ms.Sys,
+			NumGC:        ms.NumGC,
+			PauseTotalMs: ms.PauseTotalNs / 1e6,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode debug response", "error", err)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// drainingRequests counts requests for the key set of draining IDPs, by IDP
+var drainingRequests = expvar.NewMap("draining_requests")
+
+// handleDrain puts an IDP into drain mode: it stays in status, but ?grace=<seconds> (default 0)
+// later its keys leave merged output and its per-IDP endpoint answers 404
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("idp")
+	grace := 0
+	if v := r.URL.Query().Get("grace"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "grace must be a non-negative number of seconds", http.StatusBadRequest)
+			return
+		}
+		grace = n
+	}
+
+	drainAt, err := s.manager.Drain(name, time.Duration(grace)*time.Second)
+	if errors.Is(err, jwks.ErrUnknownIDP) {
+		http.Error(w, fmt.Sprintf("IDP '%s' not found", name), http.StatusNotFound)
+		return
+	}
+	s.recordAudit(r, "idp.drain", name, nil, map[string]any{"grace": grace, "drain_at": drainAt})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	response := struct {
+		IDP     string    `json:"idp"`
+		DrainAt time.Time `json:"drain_at"`
+	}{name, drainAt}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode drain response", "error", err)
+	}
+}
+
+// handleUndrain serves the keys of a draining IDP again
+func (s *Server) handleUndrain(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("idp")
+	if !s.manager.Undrain(name) {
+		http.Error(w, fmt.Sprintf("IDP '%s' is not draining", name), http.StatusNotFound)
+		return
+	}
+	s.recordAudit(r, "idp.undrain", name, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// drainingRequest logs a consumer still requesting the keys of a draining IDP, so it can be
+// found before the IDP is retired, and reports whether the keys are no longer served
+func (s *Server) drainingRequest(r *http.Request, data *jwks.IDPData) bool {
+	drainingRequests.Add(data.Name, 1)
+	drained := data.Drained(time.Now())
+	s.logger.Warn("Key set of draining IDP requested",
+		"idp", data.Name,
+		"path", r.URL.Path,
+		"client_ip", clientIP(r),
+		"user_agent", r.UserAgent(),
+		"drained", drained,
+	)
+	return drained
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+const (
+	maxRequestIDLength = 128  // longer request ids from clients are replaced
+	errorMessageLimit  = 1024 // how much of a handler's error message is passed to templates
+)
+
+// renderErrorPages replaces the plain-text error responses of next (http.Error, the mux's 404 and
+// 405) with the template configured for their status. Responses handlers write themselves, e.g.
+// JSON validation results, are left alone.
+func (s *Server) renderErrorPages(next http.Handler) http.Handler {
+	if s.config.ErrorPages == nil {
+		return next
+	}
+	// Validated at load time
+	templates, _ := s.config.ErrorPages.ParseTemplates()
+	if len(templates) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorPageWriter{ResponseWriter: w, s: s, r: r, templates: templates}
+		next.ServeHTTP(ew, r)
+		ew.finish()
+	})
+}
+
+// errorPageWriter holds back plain-text error responses that have a template, to render them once
+// the handler returns
+type errorPageWriter struct {
+	http.ResponseWriter
+	s         *Server
+	r         *http.Request
+	templates map[int]*template.Template
+
+	wroteHeader bool
+	status      int // status of the held back error, 0 when passing through
+	message     bytes.Buffer
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if _, ok := w.templates[code]; ok && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			w.status = code
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorPageWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status == 0 {
+		return w.ResponseWriter.Write(p)
+	}
+	if room := errorMessageLimit - w.message.Len(); room > 0 {
+		w.message.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// Unwrap lets http.ResponseController reach the connection, to flush streams
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish renders the held back error, if any
+func (w *errorPageWriter) finish() {
+	if w.status == 0 {
+		return
+	}
+	cfg := w.s.config.ErrorPages
+	requestID := w.r.Header.Get(cfg.GetRequestIDHeader())
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		requestID = newRequestID()
+	}
+	page := config.ErrorPage{
+		Status:    w.status,
+		Error:     http.StatusText(w.status),
+		Message:   strings.TrimSpace(w.message.String()),
+		Method:    w.r.Method,
+		Path:      w.r.URL.Path,
+		RequestID: requestID,
+		DocsURL:   cfg.DocsURL,
+	}
+
+	h := w.Header()
+	h.Set(cfg.GetRequestIDHeader(), requestID)
+	var body bytes.Buffer
+	if err := w.templates[w.status].Execute(&body, page); err != nil {
+		// Templates are rendered at load time, so only unusual messages get here; keep the original
+		w.s.logger.Error("Failed to render error page", "status", w.status, "error", err)
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.message.Bytes())
+		return
+	}
+	h.Set("Content-Type", cfg.GetContentType())
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.r.Method != http.MethodHead {
+		w.ResponseWriter.Write(body.Bytes())
+	}
+}
+
+// newRequestID returns a random 128-bit request id, hex encoded
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
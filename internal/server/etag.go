@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// etag returns a strong entity tag derived from a response body, so replicas serving the same keys
+// serve the same tag
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether the request's If-None-Match lists tag
+func notModified(r *http.Request, tag string) bool {
+	for _, header := range r.Header.Values("If-None-Match") {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == tag || candidate == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeTagged writes v as JSON with its ETag, or 304 without a body when the client already has it
+func writeTagged(w http.ResponseWriter, r *http.Request, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	tag := etag(body)
+	w.Header().Set("ETag", tag)
+	if notModified(r, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// MergedETag returns the ETag GET /.well-known/jwks.json currently serves without pagination
+func (s *Server) MergedETag() string {
+	all := s.manager.GetAll()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	merged, _ := s.merge(all, names)
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return ""
+	}
+	return etag(append(body, '\n'))
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// Export formats for GET /export
+const (
+	exportConfigMap = "configmap" // Kubernetes ConfigMap manifest
+	exportKustomize = "kustomize" // kustomization.yaml with a configMapGenerator
+	exportTerraform = "tf"        // tfvars JSON
+)
+
+// exportKey is the file name the merged JWKS is stored under
+const exportKey = "jwks.json"
+
+// handleExport wraps the merged JWKS for deploy-time consumption, e.g.
+// GET /export?format=configmap&name=idp-jwks&namespace=gateway
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		name = "idp-jwks"
+	}
+	namespace := query.Get("namespace")
+
+	merged, _, _ := s.mergedJWKS()
+	keySet, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		s.logger.Error("Failed to encode merged JWKS for export", "error", err)
+		http.Error(w, "Failed to encode JWKS", http.StatusInternalServerError)
+		return
+	}
+
+	var body []byte
+	contentType := "application/yaml"
+	switch format := query.Get("format"); format {
+	case exportConfigMap:
+		body, err = yaml.Marshal(configMapManifest(name, namespace, string(keySet)))
+	case exportKustomize:
+		body, err = yaml.Marshal(kustomization(name, namespace, string(keySet)))
+	case exportTerraform:
+		contentType = "application/json"
+		body, err = json.MarshalIndent(terraformVars(merged.Keys, string(keySet)), "", "  ")
+		body = append(body, '\n')
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q, expected configmap, kustomize or tf", format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to encode export", "error", err)
+		http.Error(w, "Failed to encode export", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(body); err != nil {
+		s.logger.Error("Failed to write export response", "error", err)
+	}
+}
+
+// configMapManifest returns a ConfigMap holding the key set
+func configMapManifest(name, namespace, keySet string) map[string]any {
+	metadata := map[string]any{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   metadata,
+		"data":       map[string]string{exportKey: keySet},
+	}
+}
+
+// kustomization returns a kustomization generating the ConfigMap from a literal.
+// Generated names get a content hash, so workloads roll when keys rotate.
+func kustomization(name, namespace, keySet string) map[string]any {
+	k := map[string]any{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"configMapGenerator": []map[string]any{{
+			"name":     name,
+			"literals": []string{exportKey + "=" + keySet},
+		}},
+	}
+	if namespace != "" {
+		k["namespace"] = namespace
+	}
+	return k
+}
+
+// terraformVars returns a tfvars document: the key set as a string plus key IDs for lookups
+func terraformVars(keys []jwks.JWK, keySet string) map[string]any {
+	kids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		kids = append(kids, key.Kid)
+	}
+	return map[string]any{
+		"jwks_json": keySet,
+		"jwks_kids": kids,
+	}
+}
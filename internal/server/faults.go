@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// SetFaults enables fault injection on the JWKS endpoints, must be called before Start
+func (s *Server) SetFaults(cfg *config.FaultsConfig) {
+	s.faults = cfg
+}
+
+// injectFaults delays, fails or truncates responses as configured.
+// Affected responses carry X-Fault-Injected so they can be told apart from real failures.
+func (s *Server) injectFaults(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		delay := s.faults.GetLatency()
+		if jitter := s.faults.GetLatencyJitter(); jitter > 0 {
+			delay += rand.N(jitter)
+		}
+		if delay > 0 {
+			w.Header().Add("X-Fault-Injected", "latency")
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if rand.Float64() < s.faults.ErrorRate {
+			w.Header().Add("X-Fault-Injected", "error")
+			status := s.faults.GetErrorStatus()
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		if rand.Float64() < s.faults.TruncateRate {
+			w.Header().Add("X-Fault-Injected", "truncate")
+			tw := &truncatingWriter{ResponseWriter: w, status: http.StatusOK}
+			next(tw, r)
+			w.Header().Del("Content-Length")
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes()[:tw.body.Len()/2])
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// truncatingWriter buffers a response so only part of it is sent
+type truncatingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (tw *truncatingWriter) WriteHeader(code int) {
+	tw.status = code
+}
+
+func (tw *truncatingWriter) Write(b []byte) (int, error) {
+	return tw.body.Write(b)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// statusFieldNames are the fields ?fields= can select on /status, as named in the JSON
+var statusFieldNames = func() []string {
+	t := reflect.TypeFor[jwks.IDPData]()
+	names := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}()
+
+// statusIncludes are the parts of the status ?include= adds, left out by default
+var statusIncludes = []string{"jwks"}
+
+// statusSelection is the part of an IDP's status a request asks for
+type statusSelection struct {
+	fields []string // nil for every field
+	jwks   bool     // the key set, which /jwks/{idp} serves, is only included when asked for
+}
+
+// parseStatusSelection reads ?fields=name,last_updated,key_count and ?include=jwks.
+// A key set named in fields is included as well.
+func parseStatusSelection(r *http.Request) (statusSelection, error) {
+	var sel statusSelection
+	query := r.URL.Query()
+
+	for name := range strings.SplitSeq(query.Get("include"), ",") {
+		switch name = strings.TrimSpace(name); {
+		case name == "":
+		case slices.Contains(statusIncludes, name):
+			sel.jwks = true
+		default:
+			return sel, fmt.Errorf("unknown include %q, includes are %s", name, strings.Join(statusIncludes, ", "))
+		}
+	}
+
+	if !query.Has("fields") {
+		return sel, nil
+	}
+	sel.fields = []string{}
+	for name := range strings.SplitSeq(query.Get("fields"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(statusFieldNames, name) {
+			return sel, fmt.Errorf("unknown field %q, fields are %s", name, strings.Join(statusFieldNames, ", "))
+		}
+		sel.fields = append(sel.fields, name)
+		sel.jwks = sel.jwks || name == "jwks"
+	}
+	if len(sel.fields) == 0 {
+		return sel, fmt.Errorf("fields must name at least one field")
+	}
+	if sel.jwks && !slices.Contains(sel.fields, "jwks") {
+		sel.fields = append(sel.fields, "jwks")
+	}
+	return sel, nil
+}
+
+// apply returns the selected status of an IDP. Fields left out of the full status because they're
+// empty are left out here too.
+func (sel statusSelection) apply(data *jwks.IDPData) (any, error) {
+	if !sel.jwks {
+		withoutKeys := *data
+		withoutKeys.JWKS = nil
+		data = &withoutKeys
+	}
+	if sel.fields == nil {
+		return data, nil
+	}
+
+	full, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(full, &all); err != nil {
+		return nil, err
+	}
+	selected := make(map[string]json.RawMessage, len(sel.fields))
+	for _, name := range sel.fields {
+		if value, ok := all[name]; ok {
+			selected[name] = value
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,467 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/events"
+	"github.com/kiquetal/go-idp-caller/internal/graphql"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+const (
+	maxGraphQLRequestBytes  = 64 << 10
+	graphQLFeedSize         = 256 // change events kept for the events query
+	graphQLSubscriberBuffer = 64  // events queued per subscription before it's ended as too slow
+	maxGraphQLSubscriptions = 256
+	graphQLHeartbeat        = 15 * time.Second
+)
+
+var (
+	graphQLStats         = expvar.NewMap("graphql")
+	graphQLSubscriptions = new(expvar.Int) // open right now
+)
+
+func init() {
+	graphQLStats.Set("subscriptions", graphQLSubscriptions)
+}
+
+// eventFeed keeps the recent change events and passes new ones to the open subscriptions
+type eventFeed struct {
+	mu          sync.Mutex
+	recent      []events.Event // oldest first
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	events chan any
+	match  func(events.Event) bool
+}
+
+func newEventFeed(manager *jwks.Manager) *eventFeed {
+	f := &eventFeed{subscribers: make(map[*subscriber]struct{})}
+	manager.OnChange(f.add)
+	return f
+}
+
+// add records the events of a change; a subscription that can't take them is ended, since it would
+// otherwise silently miss events
+func (f *eventFeed) add(c jwks.Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range events.FromChange(c) {
+		f.recent = append(f.recent, e)
+		for sub := range f.subscribers {
+			if !sub.match(e) {
+				continue
+			}
+			select {
+			case sub.events <- e:
+			default:
+				graphQLStats.Add("dropped_subscriptions", 1)
+				delete(f.subscribers, sub)
+				close(sub.events)
+			}
+		}
+	}
+	if over := len(f.recent) - graphQLFeedSize; over > 0 {
+		f.recent = slices.Delete(f.recent, 0, over)
+	}
+}
+
+// subscribe returns the matching events from now on, until ctx is done
+func (f *eventFeed) subscribe(ctx context.Context, match func(events.Event) bool) <-chan any {
+	sub := &subscriber{events: make(chan any, graphQLSubscriberBuffer), match: match}
+	f.mu.Lock()
+	f.subscribers[sub] = struct{}{}
+	f.mu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subscribers[sub]; ok {
+			delete(f.subscribers, sub)
+			close(sub.events)
+		}
+	})
+	return sub.events
+}
+
+// latest returns up to limit matching events, newest first
+func (f *eventFeed) latest(match func(events.Event) bool, limit int) []events.Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []events.Event
+	for i := len(f.recent) - 1; i >= 0 && len(out) < limit; i-- {
+		if match(f.recent[i]) {
+			out = append(out, f.recent[i])
+		}
+	}
+	return out
+}
+
+// eventFilter matches events by IDP and type, either of which may be empty
+func eventFilter(args map[string]any) func(events.Event) bool {
+	idp, _ := args["idp"].(string)
+	eventType, _ := args["type"].(string)
+	return func(e events.Event) bool {
+		return (idp == "" || e.Subject == idp) && (eventType == "" || e.Type == events.TypePrefix+eventType)
+	}
+}
+
+// idpKey is a key with the IDP publishing it
+type idpKey struct {
+	idp string
+	jwk jwks.JWK
+}
+
+// filterKeys returns the keys of an IDP matching the kid, alg, use and kty arguments that are given
+func filterKeys(data *jwks.IDPData, args map[string]any) []idpKey {
+	if data.JWKS == nil {
+		return nil
+	}
+	var keys []idpKey
+	for _, k := range data.JWKS.Keys {
+		if matchArg(args, "kid", k.Kid) && matchArg(args, "alg", k.Alg) &&
+			matchArg(args, "use", k.Use) && matchArg(args, "kty", k.Kty) {
+			keys = append(keys, idpKey{idp: data.Name, jwk: k})
+		}
+	}
+	return keys
+}
+
+func matchArg(args map[string]any, name, value string) bool {
+	want, ok := args[name].(string)
+	return !ok || want == value
+}
+
+// limitArg returns the limit argument, 100 unless given
+func limitArg(args map[string]any) (int, error) {
+	limit, ok := args["limit"].(int)
+	if !ok {
+		return 100, nil
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("limit must not be negative")
+	}
+	return limit, nil
+}
+
+// timeValue renders a time as RFC 3339, the zero time as null
+func timeValue(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// field builds a field resolved from its source alone
+func field[T any](name, typ, description string, fn func(T) any) *graphql.Field {
+	return &graphql.Field{Name: name, Type: typ, Description: description, Resolve: func(p graphql.Params) (any, error) {
+		return fn(p.Source.(T)), nil
+	}}
+}
+
+var keyFilterArgs = []graphql.Arg{
+	{Name: "kid", Type: "String"},
+	{Name: "alg", Type: "String"},
+	{Name: "use", Type: "String"},
+	{Name: "kty", Type: "String"},
+}
+
+var limitArgs = []graphql.Arg{{Name: "limit", Type: "Int", Default: 100}}
+
+// graphQLSchema is the schema of /graphql; resolvers of Query and Subscription get the *Server as source
+var graphQLSchema = func() *graphql.Schema {
+	query := &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{Name: "idps", Type: "[IDP!]!", Description: "IDPs in name order, only the named ones when names is given",
+			Args: []graphql.Arg{{Name: "names", Type: "[String!]"}},
+			Resolve: func(p graphql.Params) (any, error) {
+				names, filtered := p.Args["names"].([]any)
+				all := p.Source.(*Server).manager.GetAll()
+				idps := make([]*jwks.IDPData, 0, len(all))
+				for name, data := range all {
+					if !filtered || slices.Contains(names, any(name)) {
+						idps = append(idps, data)
+					}
+				}
+				slices.SortFunc(idps, func(a, b *jwks.IDPData) int { return strings.Compare(a.Name, b.Name) })
+				return idps, nil
+			}},
+		{Name: "idp", Type: "IDP", Description: "An IDP by name, null when not configured",
+			Args: []graphql.Arg{{Name: "name", Type: "String!"}},
+			Resolve: func(p graphql.Params) (any, error) {
+				data, _ := p.Source.(*Server).manager.Get(p.Args["name"].(string))
+				return data, nil
+			}},
+		{Name: "keys", Type: "[Key!]!", Description: "Keys of every IDP, or of one, filtered by the arguments given",
+			Args: append([]graphql.Arg{{Name: "idp", Type: "String"}}, keyFilterArgs...),
+			Resolve: func(p graphql.Params) (any, error) {
+				all := p.Source.(*Server).manager.GetAll()
+				names := make([]string, 0, len(all))
+				for name := range all {
+					if idp, ok := p.Args["idp"].(string); !ok || idp == name {
+						names = append(names, name)
+					}
+				}
+				slices.Sort(names)
+				var keys []idpKey
+				for _, name := range names {
+					keys = append(keys, filterKeys(all[name], p.Args)...)
+				}
+				return keys, nil
+			}},
+		{Name: "events", Type: "[Event!]!", Description: "Recent change events, newest first",
+			Args: append([]graphql.Arg{{Name: "idp", Type: "String"}, {Name: "type", Type: "String"}}, limitArgs...),
+			Resolve: func(p graphql.Params) (any, error) {
+				limit, err := limitArg(p.Args)
+				if err != nil {
+					return nil, err
+				}
+				return p.Source.(*Server).feed.latest(eventFilter(p.Args), limit), nil
+			}},
+	}}
+
+	subscription := &graphql.Object{Name: "Subscription", Fields: []*graphql.Field{
+		{Name: "events", Type: "Event!", Description: "Change events as they happen",
+			Args: []graphql.Arg{{Name: "idp", Type: "String"}, {Name: "type", Type: "String"}},
+			Subscribe: func(p graphql.Params) (<-chan any, error) {
+				return p.Source.(*Server).feed.subscribe(p.Context, eventFilter(p.Args)), nil
+			}},
+	}}
+
+	idp := &graphql.Object{Name: "IDP", Fields: []*graphql.Field{
+		field("name", "String!", "", func(d *jwks.IDPData) any { return d.Name }),
+		field("lastUpdated", "String", "", func(d *jwks.IDPData) any { return timeValue(d.LastUpdated) }),
+		field("lastSuccess", "String", "", func(d *jwks.IDPData) any { return timeValue(d.LastSuccess) }),
+		field("lastError", "String", "Error of the last fetch, null when it succeeded", func(d *jwks.IDPData) any {
+			return nullString(d.LastError)
+		}),
+		field("consecutiveFailures", "Int!", "", func(d *jwks.IDPData) any { return d.Failures }),
+		field("updateCount", "Int!", "", func(d *jwks.IDPData) any { return d.UpdateCount }),
+		field("keyCount", "Int!", "", func(d *jwks.IDPData) any { return d.KeyCount }),
+		field("maxKeys", "Int!", "", func(d *jwks.IDPData) any { return d.MaxKeys }),
+		field("cacheDuration", "Int!", "Seconds the key set is cached for", func(d *jwks.IDPData) any { return d.CacheDuration }),
+		field("cacheUntil", "String", "", func(d *jwks.IDPData) any { return timeValue(d.CacheUntil) }),
+		field("refreshInterval", "Int!", "Seconds between fetches", func(d *jwks.IDPData) any { return d.RefreshInterval }),
+		{Name: "keys", Type: "[Key!]!", Description: "Published keys, filtered by the arguments given", Args: keyFilterArgs,
+			Resolve: func(p graphql.Params) (any, error) {
+				return filterKeys(p.Source.(*jwks.IDPData), p.Args), nil
+			}},
+		field("keyHistory", "[KeyChange!]!", "Recent key set changes, oldest first", func(d *jwks.IDPData) any { return d.KeyHistory }),
+		field("recentErrors", "[FetchError!]!", "Recent failed fetches, oldest first", func(d *jwks.IDPData) any { return d.RecentErrors }),
+		{Name: "fetches", Type: "[Fetch!]!", Description: "Fetch outcomes behind the SLO report, newest first", Args: limitArgs,
+			Resolve: func(p graphql.Params) (any, error) {
+				limit, err := limitArg(p.Args)
+				if err != nil {
+					return nil, err
+				}
+				srv := p.Context.Value(graphQLServerKey{}).(*Server)
+				return srv.manager.Fetches(p.Source.(*jwks.IDPData).Name, limit), nil
+			}},
+		{Name: "slo", Type: "[SLOWindow!]!", Description: "Availability over the configured SLO windows",
+			Resolve: func(p graphql.Params) (any, error) {
+				srv := p.Context.Value(graphQLServerKey{}).(*Server)
+				windows, _ := srv.manager.SLOReport(p.Source.(*jwks.IDPData).Name, srv.slo.GetObjective(), srv.slo.GetWindows(), time.Now())
+				return windows, nil
+			}},
+	}}
+
+	key := &graphql.Object{Name: "Key", Fields: []*graphql.Field{
+		field("idp", "String!", "", func(k idpKey) any { return k.idp }),
+		field("kid", "String", "", func(k idpKey) any { return nullString(k.jwk.Kid) }),
+		field("kty", "String!", "", func(k idpKey) any { return k.jwk.Kty }),
+		field("alg", "String", "", func(k idpKey) any { return nullString(k.jwk.Alg) }),
+		field("use", "String", "", func(k idpKey) any { return nullString(k.jwk.Use) }),
+		field("crv", "String", "", func(k idpKey) any { return nullString(k.jwk.Crv) }),
+		field("n", "String", "", func(k idpKey) any { return nullString(k.jwk.N) }),
+		field("e", "String", "", func(k idpKey) any { return nullString(k.jwk.E) }),
+		field("x", "String", "", func(k idpKey) any { return nullString(k.jwk.X) }),
+		field("y", "String", "", func(k idpKey) any { return nullString(k.jwk.Y) }),
+		field("x5c", "[String!]", "", func(k idpKey) any {
+			if k.jwk.X5c == nil {
+				return nil
+			}
+			return k.jwk.X5c
+		}),
+		field("x5t", "String", "", func(k idpKey) any { return nullString(k.jwk.X5t) }),
+		field("x5tS256", "String", "", func(k idpKey) any { return nullString(k.jwk.X5tS256) }),
+		field("jwk", "String!", "The key as published, in JSON", func(k idpKey) any {
+			b, _ := json.Marshal(k.jwk)
+			return string(b)
+		}),
+	}}
+
+	keyChange := &graphql.Object{Name: "KeyChange", Fields: []*graphql.Field{
+		field("time", "String!", "", func(c jwks.KeyChange) any { return timeValue(c.Time) }),
+		field("added", "[String!]!", "Kids of new keys", func(c jwks.KeyChange) any { return c.Added }),
+		field("removed", "[String!]!", "Kids of keys no longer published", func(c jwks.KeyChange) any { return c.Removed }),
+	}}
+
+	fetchError := &graphql.Object{Name: "FetchError", Fields: []*graphql.Field{
+		field("time", "String!", "", func(e jwks.FetchError) any { return timeValue(e.Time) }),
+		field("error", "String!", "", func(e jwks.FetchError) any { return e.Error }),
+	}}
+
+	fetch := &graphql.Object{Name: "Fetch", Fields: []*graphql.Field{
+		field("time", "String!", "", func(f jwks.Fetch) any { return timeValue(f.Time) }),
+		field("ok", "Boolean!", "", func(f jwks.Fetch) any { return f.OK }),
+		field("latencyMs", "Int!", "", func(f jwks.Fetch) any { return f.Latency.Milliseconds() }),
+	}}
+
+	sloWindow := &graphql.Object{Name: "SLOWindow", Fields: []*graphql.Field{
+		field("window", "String!", "", func(w jwks.SLOWindow) any { return w.Window }),
+		field("fetches", "Int!", "", func(w jwks.SLOWindow) any { return w.Fetches }),
+		field("successes", "Int!", "", func(w jwks.SLOWindow) any { return w.Successes }),
+		field("availability", "Float!", "Percent of successful fetches", func(w jwks.SLOWindow) any { return w.Availability }),
+		field("p95LatencyMs", "Int!", "", func(w jwks.SLOWindow) any { return w.P95LatencyMs }),
+		field("errorBudgetRemaining", "Float!", "", func(w jwks.SLOWindow) any { return w.ErrorBudgetRemaining }),
+	}}
+
+	event := &graphql.Object{Name: "Event", Fields: []*graphql.Field{
+		field("id", "ID!", "", func(e events.Event) any { return e.ID }),
+		field("type", "String!", "key.added, key.removed, idp.failed or idp.recovered", func(e events.Event) any {
+			return strings.TrimPrefix(e.Type, events.TypePrefix)
+		}),
+		field("idp", "String!", "", func(e events.Event) any { return e.Data.IDP }),
+		field("time", "String!", "", func(e events.Event) any { return timeValue(e.Time) }),
+		field("kid", "String", "", func(e events.Event) any { return nullString(e.Data.Kid) }),
+		field("kty", "String", "", func(e events.Event) any { return nullString(e.Data.Kty) }),
+		field("alg", "String", "", func(e events.Event) any { return nullString(e.Data.Alg) }),
+		field("keyCount", "Int!", "Keys published after the change", func(e events.Event) any { return e.Data.KeyCount }),
+		field("error", "String", "", func(e events.Event) any { return nullString(e.Data.Error) }),
+	}}
+
+	schema, err := graphql.NewSchema(query, subscription,
+		[]*graphql.Object{idp, key, keyChange, fetchError, fetch, sloWindow, event}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}()
+
+// graphQLServerKey carries the *Server in the context, for resolvers of nested fields
+type graphQLServerKey struct{}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// handleGraphQL executes a query passed as JSON body or as query, operationName and variables parameters.
+// Subscriptions are streamed as server-sent events when the client accepts text/event-stream.
+// GET without a query returns the schema.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphQLRequestBytes))
+		if err != nil || json.Unmarshal(body, &req) != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		if !q.Has("query") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, graphQLSchema.SDL())
+			return
+		}
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "Invalid variables parameter", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	graphQLStats.Add("requests", 1)
+	op, err := graphQLSchema.Prepare(req)
+	if err != nil {
+		graphQLStats.Add("invalid", 1)
+		s.writeGraphQL(w, &graphql.Response{Errors: []*graphql.Error{err.(*graphql.Error)}})
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphQLServerKey{}, s)
+	if !op.Subscription() {
+		s.writeGraphQL(w, op.Execute(ctx, s))
+		return
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		http.Error(w, "Subscriptions are streamed as server-sent events, send Accept: text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+	s.streamGraphQL(ctx, w, op)
+}
+
+func (s *Server) writeGraphQL(w http.ResponseWriter, response *graphql.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode GraphQL response", "error", err)
+	}
+}
+
+// streamGraphQL sends a subscription's results as next events until the client disconnects or the
+// server shuts down, then a complete event
+func (s *Server) streamGraphQL(ctx context.Context, w http.ResponseWriter, op *graphql.Operation) {
+	if graphQLSubscriptions.Value() >= maxGraphQLSubscriptions {
+		http.Error(w, "Too many subscriptions", http.StatusServiceUnavailable)
+		return
+	}
+	graphQLSubscriptions.Add(1)
+	defer graphQLSubscriptions.Add(-1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.streams, cancel)
+	defer stop()
+
+	responses, err := op.Subscribe(ctx, s)
+	if err != nil {
+		s.writeGraphQL(w, &graphql.Response{Errors: []*graphql.Error{err.(*graphql.Error)}})
+		return
+	}
+
+	// The stream outlives the listener's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(graphQLHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case response, ok := <-responses:
+			if !ok {
+				io.WriteString(w, "event: complete\ndata:\n\n")
+				rc.Flush()
+				return
+			}
+			data, err := json.Marshal(response)
+			if err != nil {
+				s.logger.Error("Failed to encode GraphQL response", "error", err)
+				return
+			}
+			fmt.Fprintf(w, "event: next\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			io.WriteString(w, ":\n\n")
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
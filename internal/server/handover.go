@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// SetInherited sets listening sockets passed by a previous process, keyed by listen address.
+// Listeners with an inherited socket use it instead of binding. Must be called before Start.
+func (s *Server) SetInherited(files map[string]*os.File) {
+	s.inherited = files
+}
+
+// inheritedListener returns the inherited socket for a listen address, nil if there is none
+func (s *Server) inheritedListener(addr string) (net.Listener, error) {
+	f, ok := s.inherited[addr]
+	if !ok {
+		return nil, nil
+	}
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited socket for %s is not a listening socket: %w", addr, err)
+	}
+	s.logger.Info("Using inherited socket", "addr", addr)
+	return ln, nil
+}
+
+// ListenerFiles duplicates the listening sockets for a new process, keyed by listen address.
+// Unix sockets are no longer removed on shutdown, the new process keeps serving on them.
+func (s *Server) ListenerFiles() (map[string]*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := make(map[string]*os.File, len(s.listeners))
+	for addr, ln := range s.listeners {
+		var (
+			f   *os.File
+			err error
+		)
+		switch l := ln.(type) {
+		case *net.TCPListener:
+			f, err = l.File()
+		case *net.UnixListener:
+			l.SetUnlinkOnClose(false)
+			f, err = l.File()
+		default:
+			err = fmt.Errorf("unsupported listener type %T", ln)
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, fmt.Errorf("listener %s: %w", addr, err)
+		}
+		files[addr] = f
+	}
+	return files, nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// rejectedRequests counts requests refused before reaching the mux, by reason
+var rejectedRequests = expvar.NewMap("rejected_requests")
+
+// harden refuses requests no handler should see: methods no route is registered for,
+// too many header fields and bodies over the configured size. Accepted bodies are capped
+// so a missing or lying Content-Length can't get past the limit either.
+func (s *Server) harden(rt *router) http.Handler {
+	limits := s.config.Requests
+	maxBody, maxHeaders := limits.GetMaxBodyBytes(), limits.GetMaxHeaderCount()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rt.methods[r.Method] {
+			rejectedRequests.Add("method", 1)
+			http.Error(w, "method not implemented", http.StatusNotImplemented)
+			return
+		}
+
+		count := 0
+		for _, values := range r.Header {
+			count += len(values)
+		}
+		if count > maxHeaders {
+			rejectedRequests.Add("header_count", 1)
+			http.Error(w, "too many header fields", http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+
+		if r.ContentLength > maxBody {
+			rejectedRequests.Add("body_size", 1)
+			w.Header().Set("Connection", "close")
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+
+		rt.ServeHTTP(w, r)
+	})
+}
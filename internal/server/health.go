@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// started is when the process started, for the uptime in /health?verbose=true
+var started = time.Now()
+
+// idpHealth summarizes one IDP for /health?verbose=true and /ready?idps=
+type idpHealth struct {
+	Name                string     `json:"name"`
+	Ready               bool       `json:"ready"`
+	KeyCount            int        `json:"key_count"`
+	LastUpdated         *time.Time `json:"last_updated,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	SinceSuccess        *int64     `json:"seconds_since_success"` // null until a fetch succeeds
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	response := map[string]any{
+		"status": "healthy",
+		"time":   now.Format(time.RFC3339),
+	}
+
+	// Verbose output reports upstream state, but liveness never fails because of an IDP
+	if r.URL.Query().Get("verbose") == "true" {
+		response["uptime_seconds"] = int64(now.Sub(started).Seconds())
+		idps := s.idpHealth(idpFilter(r))
+		for _, h := range idps {
+			if !h.Ready || h.LastError != "" {
+				response["status"] = "degraded"
+				break
+			}
+		}
+		response["idps"] = idps
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode health response", "error", err)
+	}
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	status, code := "ready", http.StatusOK
+	switch {
+	case s.draining.Load():
+		status, code = "draining", http.StatusServiceUnavailable
+	case !s.ready.Load():
+		status, code = "starting", http.StatusServiceUnavailable
+	}
+
+	response := map[string]any{
+		"time": time.Now().Format(time.RFC3339),
+	}
+
+	// Fail closed: no traffic while any IDP has no keys, rather than answering with a partial key set
+	if s.features.FailClosed && code == http.StatusOK {
+		pending := s.manager.Pending()
+		for _, h := range s.idpHealth(nil) {
+			if !h.Ready {
+				pending = append(pending, h.Name)
+			}
+		}
+		if len(pending) > 0 {
+			status, code = "not_ready", http.StatusServiceUnavailable
+			response["missing_keys"] = pending
+		}
+	}
+
+	// With ?idps=a,b the listed IDPs must also have keys loaded
+	if names := idpFilter(r); len(names) > 0 {
+		idps := s.idpHealth(names)
+		for _, h := range idps {
+			if !h.Ready && code == http.StatusOK {
+				status, code = "not_ready", http.StatusServiceUnavailable
+			}
+		}
+		response["idps"] = idps
+	}
+	response["status"] = status
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode ready response", "error", err)
+	}
+}
+
+// idpFilter returns the IDP names from ?idps=a,b, nil when not set
+func idpFilter(r *http.Request) []string {
+	raw := r.URL.Query().Get("idps")
+	if raw == "" {
+		return nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// idpHealth reports the given IDPs, or all known IDPs when names is empty.
+// Unknown names are reported as not ready.
+func (s *Server) idpHealth(names []string) []idpHealth {
+	all := s.manager.GetAll()
+	now := time.Now()
+	if len(names) == 0 {
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	result := make([]idpHealth, 0, len(names))
+	for _, name := range names {
+		data, exists := all[name]
+		if !exists {
+			result = append(result, idpHealth{Name: name, LastError: "unknown IDP"})
+			continue
+		}
+		result = append(result, newIDPHealth(data, now))
+	}
+	return result
+}
+
+func newIDPHealth(data *jwks.IDPData, now time.Time) idpHealth {
+	h := idpHealth{
+		Name:                data.Name,
+		LastError:           data.LastError,
+		ConsecutiveFailures: data.Failures,
+	}
+	if !data.LastSuccess.IsZero() {
+		since := int64(now.Sub(data.LastSuccess).Seconds())
+		h.SinceSuccess = &since
+	}
+	if data.JWKS != nil {
+		h.KeyCount = len(data.JWKS.Keys)
+		h.Ready = h.KeyCount > 0
+	}
+	if !data.LastUpdated.IsZero() {
+		lastUpdated := data.LastUpdated
+		h.LastUpdated = &lastUpdated
+	}
+	return h
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// listen opens the listener for an address:
+//
+//	"host:port"         TCP
+//	"tcp://host:port"   TCP
+//	"unix:/path.sock"   Unix domain socket
+//	"systemd[:N]"       N-th socket (default 0) inherited from systemd socket activation
+func listen(addr string, socketMode os.FileMode, opts config.SocketOptions) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		return listenTCP(strings.TrimPrefix(addr, "tcp://"), opts)
+	case strings.HasPrefix(addr, "unix:"):
+		return listenUnix(strings.TrimPrefix(addr, "unix:"), socketMode)
+	case addr == "systemd":
+		return listenSystemd(0)
+	case strings.HasPrefix(addr, "systemd:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(addr, "systemd:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid systemd socket index in %q", addr)
+		}
+		return listenSystemd(n)
+	default:
+		return listenTCP(addr, opts)
+	}
+}
+
+// Listen opens a listener on an address of any form server.listen accepts, with default socket
+// options, for modes serving without a Server
+func Listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	return listen(addr, socketMode, config.SocketOptions{})
+}
+
+// listenTCP listens on a TCP address with the socket options applied
+func listenTCP(addr string, opts config.SocketOptions) (net.Listener, error) {
+	enabled, idle, interval, count := opts.GetKeepAlive()
+	lc := net.ListenConfig{
+		Control: setSockopts(opts),
+		KeepAliveConfig: net.KeepAliveConfig{
+			Enable:   enabled,
+			Idle:     idle,
+			Interval: interval,
+			Count:    count,
+		},
+	}
+	if !enabled {
+		lc.KeepAlive = -1
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// listenUnix listens on a Unix domain socket, removing a stale socket file left by a previous run
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("unix socket path is empty")
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to set socket mode: %w", err)
+		}
+	}
+
+	return l, nil
+}
+
+// listenSystemd returns the n-th socket passed via LISTEN_FDS (see sd_listen_fds(3))
+func listenSystemd(n int) (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets passed by systemd (LISTEN_PID not set for this process)")
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n >= count {
+		return nil, fmt.Errorf("systemd socket %d not passed (LISTEN_FDS=%q)", n, os.Getenv("LISTEN_FDS"))
+	}
+
+	fd := listenFDsStart + n
+	f := os.NewFile(uintptr(fd), "systemd-socket-"+strconv.Itoa(n))
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited fd %d is not a listening socket: %w", fd, err)
+	}
+	return l, nil
+}
+
+// tlsConfig builds the TLS settings of a listener
+func tlsConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.MinVersion == "1.3" {
+		tc.MinVersion = tls.VersionTLS13
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tc, nil
+}
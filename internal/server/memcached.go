@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/version"
+)
+
+// Keys served over memcached: the merged key set and one per IDP, named like the HTTP paths
+const (
+	memcachedMergedKey = "jwks"
+	memcachedIDPPrefix = "jwks/"
+)
+
+const (
+	memcachedMaxLine  = 2048
+	memcachedMaxKey   = 250 // longest key the protocol allows
+	memcachedMaxValue = 1 << 20
+)
+
+var (
+	memcachedStats       = expvar.NewMap("memcached")
+	memcachedHits        = new(expvar.Int)
+	memcachedMisses      = new(expvar.Int)
+	memcachedConnections = new(expvar.Int) // open right now
+	memcachedRefused     = new(expvar.Int) // over max_connections
+)
+
+func init() {
+	memcachedStats.Set("get_hits", memcachedHits)
+	memcachedStats.Set("get_misses", memcachedMisses)
+	memcachedStats.Set("curr_connections", memcachedConnections)
+	memcachedStats.Set("refused_connections", memcachedRefused)
+}
+
+// memcachedWriteCommands are the commands that would modify data, refused with "SERVER_ERROR read only";
+// storage commands carry a data block
+var memcachedWriteCommands = map[string]bool{
+	"set": true, "add": true, "replace": true, "append": true, "prepend": true, "cas": true,
+	"delete": true, "incr": true, "decr": true, "touch": true, "gat": true, "gats": true, "flush_all": true,
+}
+
+// startMemcached opens the memcached listener, on the previous process's socket after an upgrade
+func (s *Server) startMemcached(errCh chan<- error) error {
+	cfg := s.config.Memcached
+	ln, err := s.inheritedListener(cfg.Listen)
+	if err != nil {
+		return err
+	}
+	if ln == nil {
+		if ln, err = listen(cfg.Listen, cfg.GetSocketMode(), s.config.Socket); err != nil {
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	if s.listeners == nil {
+		s.listeners = make(map[string]net.Listener)
+	}
+	s.listeners[cfg.Listen] = ln
+	s.memcached = ln
+	s.memcachedConns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+
+	s.logger.Info("Starting memcached listener", "addr", ln.Addr().String(), "network", ln.Addr().Network())
+	go func() { errCh <- s.serveMemcached(ln) }()
+	return nil
+}
+
+// serveMemcached accepts connections until the listener is closed by Shutdown
+func (s *Server) serveMemcached(ln net.Listener) error {
+	limit := s.config.Memcached.GetMaxConnections()
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return http.ErrServerClosed
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return fmt.Errorf("memcached: %w", err)
+		}
+
+		s.mu.Lock()
+		full := len(s.memcachedConns) >= limit
+		if !full {
+			s.memcachedConns[conn] = struct{}{}
+		}
+		s.mu.Unlock()
+		if full {
+			memcachedRefused.Add(1)
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			io.WriteString(conn, "SERVER_ERROR too many connections\r\n")
+			conn.Close()
+			continue
+		}
+
+		memcachedConnections.Add(1)
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.memcachedConns, conn)
+				s.mu.Unlock()
+				memcachedConnections.Add(-1)
+				conn.Close()
+			}()
+			s.handleMemcached(conn)
+		}()
+	}
+}
+
+// handleMemcached answers the commands of one connection until it quits, idles out or errs
+func (s *Server) handleMemcached(conn net.Conn) {
+	idle := s.config.Memcached.GetIdleTimeout()
+	r := bufio.NewReaderSize(conn, memcachedMaxLine)
+	w := bufio.NewWriter(conn)
+
+	for {
+		conn.SetDeadline(time.Now().Add(idle))
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			io.WriteString(w, "CLIENT_ERROR line too long\r\n")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			io.WriteString(w, "ERROR\r\n")
+			w.Flush()
+			continue
+		}
+		switch cmd := fields[0]; {
+		case cmd == "get" || cmd == "gets":
+			if len(fields) < 2 {
+				io.WriteString(w, "ERROR\r\n")
+				break
+			}
+			s.memcachedGet(w, fields[1:], cmd == "gets")
+		case cmd == "version":
+			fmt.Fprintf(w, "VERSION %s\r\n", version.Get().Version)
+		case cmd == "stats" && len(fields) == 1:
+			s.memcachedStats(w)
+		case cmd == "quit":
+			w.Flush()
+			return
+		case memcachedWriteCommands[cmd]:
+			if !discardDataBlock(r, cmd, fields) {
+				io.WriteString(w, "CLIENT_ERROR bad data chunk\r\n")
+				w.Flush()
+				return
+			}
+			if fields[len(fields)-1] != "noreply" {
+				io.WriteString(w, "SERVER_ERROR read only\r\n")
+			}
+		default:
+			io.WriteString(w, "ERROR\r\n")
+		}
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// memcachedGet writes the values of the keys that exist, with a cas unique derived from the value when asked
+func (s *Server) memcachedGet(w *bufio.Writer, keys []string, withCAS bool) {
+	for _, key := range keys {
+		if len(key) > memcachedMaxKey {
+			io.WriteString(w, "CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+	}
+	for _, key := range keys {
+		value, ok := s.memcachedValue(key)
+		if !ok {
+			memcachedMisses.Add(1)
+			continue
+		}
+		memcachedHits.Add(1)
+		if withCAS {
+			sum := sha256.Sum256(value)
+			fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", key, len(value), binary.BigEndian.Uint64(sum[:8]))
+		} else {
+			fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(value))
+		}
+		w.Write(value)
+		io.WriteString(w, "\r\n")
+	}
+	io.WriteString(w, "END\r\n")
+}
+
+// memcachedValue returns the JSON served for a key, the same body as the HTTP endpoint
+func (s *Server) memcachedValue(key string) ([]byte, bool) {
+	var v any
+	if key == memcachedMergedKey {
+		merged, _, _ := s.mergedJWKS()
+		v = merged
+	} else if name, ok := strings.CutPrefix(key, memcachedIDPPrefix); ok {
+		data, exists := s.manager.Get(name)
+		if !exists || data.JWKS == nil || data.Drained(time.Now()) {
+			return nil, false
+		}
+		v = data.JWKS
+	} else {
+		return nil, false
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		s.logger.Error("Failed to encode memcached value", "key", key, "error", err)
+		return nil, false
+	}
+	return append(body, '\n'), true
+}
+
+func (s *Server) memcachedStats(w *bufio.Writer) {
+	fmt.Fprintf(w, "STAT pid %d\r\n", os.Getpid())
+	fmt.Fprintf(w, "STAT version %s\r\n", version.Get().Version)
+	fmt.Fprintf(w, "STAT curr_items %d\r\n", len(s.manager.GetAll())+1)
+	fmt.Fprintf(w, "STAT curr_connections %d\r\n", memcachedConnections.Value())
+	fmt.Fprintf(w, "STAT get_hits %d\r\n", memcachedHits.Value())
+	fmt.Fprintf(w, "STAT get_misses %d\r\n", memcachedMisses.Value())
+	io.WriteString(w, "END\r\n")
+}
+
+// discardDataBlock skips the data block following a storage command, so the next line is read as a
+// command again. Reports false when the block can't be skipped and the connection must be closed.
+func discardDataBlock(r *bufio.Reader, cmd string, fields []string) bool {
+	var sizeField int
+	switch cmd {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		sizeField = 4 // <cmd> <key> <flags> <exptime> <bytes>
+	default:
+		return true
+	}
+	if len(fields) <= sizeField {
+		return true
+	}
+	n, err := strconv.Atoi(fields[sizeField])
+	if err != nil || n < 0 || n > memcachedMaxValue {
+		return false
+	}
+	_, err = r.Discard(n + 2)
+	return err == nil
+}
+
+// closeMemcached stops accepting and closes the open connections; gets complete immediately,
+// so there is nothing to wait for
+func (s *Server) closeMemcached() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.memcached == nil {
+		return
+	}
+	s.memcached.Close()
+	for conn := range s.memcachedConns {
+		conn.Close()
+	}
+}
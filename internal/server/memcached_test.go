@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+func memcachedServer(t *testing.T, cfg *config.MemcachedConfig) *Server {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := jwks.NewManager(logger)
+	keySet := &jwks.JWKS{Keys: []jwks.JWK{{Kid: "k1", Kty: "RSA", N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4", E: "AQAB"}}}
+	manager.Update("corp", keySet, 10, 300, nil)
+	return New(config.ServerConfig{Memcached: cfg}, manager, logger)
+}
+
+// memcachedClient is one end of a pipe whose other end handleMemcached serves
+type memcachedClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	done chan struct{} // closed once handleMemcached returned
+}
+
+func newMemcachedClient(t *testing.T, s *Server) *memcachedClient {
+	t.Helper()
+	client, server := net.Pipe()
+	c := &memcachedClient{conn: client, r: bufio.NewReader(client), done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		s.handleMemcached(server)
+		server.Close()
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { client.Close() })
+	return c
+}
+
+// command sends line and returns the reply up to its last line, value blocks included
+func (c *memcachedClient) command(t *testing.T, line string) string {
+	t.Helper()
+	if _, err := io.WriteString(c.conn, line); err != nil {
+		t.Fatalf("writing %q: %v", line, err)
+	}
+	return c.reply(t)
+}
+
+func (c *memcachedClient) reply(t *testing.T) string {
+	t.Helper()
+	var reply strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading reply: %v after %q", err, reply.String())
+		}
+		reply.WriteString(line)
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 4 && fields[0] == "VALUE":
+			n, _ := strconv.Atoi(fields[3])
+			value := make([]byte, n+2)
+			if _, err := io.ReadFull(c.r, value); err != nil {
+				t.Fatalf("reading value: %v", err)
+			}
+			reply.Write(value)
+		case len(fields) > 0 && fields[0] == "STAT":
+		default:
+			return reply.String()
+		}
+	}
+}
+
+// memcachedEntry is a value as get returns it, with a cas unique for gets
+func memcachedEntry(s *Server, key string, withCAS bool) string {
+	value, _ := s.memcachedValue(key)
+	if withCAS {
+		sum := sha256.Sum256(value)
+		return fmt.Sprintf("VALUE %s 0 %d %d\r\n%s\r\n", key, len(value), binary.BigEndian.Uint64(sum[:8]), value)
+	}
+	return fmt.Sprintf("VALUE %s 0 %d\r\n%s\r\n", key, len(value), value)
+}
+
+func TestMemcachedGet(t *testing.T) {
+	s := memcachedServer(t, &config.MemcachedConfig{})
+	c := newMemcachedClient(t, s)
+
+	idp, _ := s.memcachedValue("jwks/corp")
+	var keySet jwks.JWKS
+	if err := json.Unmarshal(idp, &keySet); err != nil || len(keySet.Keys) != 1 || keySet.Keys[0].Kid != "k1" {
+		t.Fatalf("jwks/corp holds %q", idp)
+	}
+
+	tests := []struct {
+		name, command, want string
+	}{
+		{"hit", "get jwks/corp\r\n", memcachedEntry(s, "jwks/corp", false) + "END\r\n"},
+		{"merged", "get jwks\r\n", memcachedEntry(s, "jwks", false) + "END\r\n"},
+		{"miss", "get jwks/missing\r\n", "END\r\n"},
+		{"unknown key", "get corp\r\n", "END\r\n"},
+		{"gets hit", "gets jwks/corp\r\n", memcachedEntry(s, "jwks/corp", true) + "END\r\n"},
+		{"gets miss", "gets jwks/missing\r\n", "END\r\n"},
+		{"multi-key", "get jwks/corp jwks/missing jwks\r\n",
+			memcachedEntry(s, "jwks/corp", false) + memcachedEntry(s, "jwks", false) + "END\r\n"},
+		{"key too long", "get " + strings.Repeat("k", memcachedMaxKey+1) + "\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"no key", "get\r\n", "ERROR\r\n"},
+		{"empty line", "\r\n", "ERROR\r\n"},
+		{"unknown command", "verbosity 1\r\n", "ERROR\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.command(t, tt.command); got != tt.want {
+				t.Fatalf("%q answered %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+
+	if got := c.command(t, "stats\r\n"); !strings.Contains(got, "STAT curr_items 2\r\n") || !strings.HasSuffix(got, "END\r\n") {
+		t.Fatalf("stats answered %q", got)
+	}
+	if got := c.command(t, "version\r\n"); !strings.HasPrefix(got, "VERSION ") {
+		t.Fatalf("version answered %q", got)
+	}
+	io.WriteString(c.conn, "quit\r\n")
+	<-c.done
+}
+
+func TestMemcachedWriteCommands(t *testing.T) {
+	s := memcachedServer(t, &config.MemcachedConfig{})
+	c := newMemcachedClient(t, s)
+	hit := memcachedEntry(s, "jwks/corp", false) + "END\r\n"
+
+	tests := []struct {
+		name, command, want string
+	}{
+		{"set", "set jwks/corp 0 0 5\r\nhello\r\n", "SERVER_ERROR read only\r\n"},
+		{"cas", "cas jwks/corp 0 0 5 42\r\nhello\r\n", "SERVER_ERROR read only\r\n"},
+		{"append with data that looks like a command", "append jwks/corp 0 0 13\r\nget jwks/corp\r\n", "SERVER_ERROR read only\r\n"},
+		{"delete", "delete jwks/corp\r\n", "SERVER_ERROR read only\r\n"},
+		{"incr", "incr counter 1\r\n", "SERVER_ERROR read only\r\n"},
+		{"touch", "touch jwks/corp 60\r\n", "SERVER_ERROR read only\r\n"},
+		{"flush_all", "flush_all\r\n", "SERVER_ERROR read only\r\n"},
+		// Without a reply the next answer is the get's
+		{"set noreply", "set jwks/corp 0 0 5 noreply\r\nhello\r\nget jwks/corp\r\n", hit},
+		{"delete noreply", "delete jwks/corp noreply\r\nget jwks/corp\r\n", hit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.command(t, tt.command); got != tt.want {
+				t.Fatalf("%q answered %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+	// The data blocks were skipped, nothing is left to be read as a command
+	if got := c.command(t, "get jwks/corp\r\n"); got != hit {
+		t.Fatalf("get answered %q after the write commands", got)
+	}
+}
+
+func TestMemcachedBadDataBlock(t *testing.T) {
+	s := memcachedServer(t, &config.MemcachedConfig{})
+	c := newMemcachedClient(t, s)
+	command := fmt.Sprintf("set jwks/corp 0 0 %d\r\n", memcachedMaxValue+1)
+	if got := c.command(t, command); got != "CLIENT_ERROR bad data chunk\r\n" {
+		t.Fatalf("%q answered %q", command, got)
+	}
+	<-c.done
+}
+
+func TestMemcachedLineTooLong(t *testing.T) {
+	s := memcachedServer(t, &config.MemcachedConfig{})
+	c := newMemcachedClient(t, s)
+	// The server stops reading mid-line, so the write only returns once the connection is closed
+	go io.WriteString(c.conn, "get "+strings.Repeat("k", 2*memcachedMaxLine)+"\r\n")
+	if got := c.reply(t); got != "CLIENT_ERROR line too long\r\n" {
+		t.Fatalf("oversized line answered %q", got)
+	}
+	<-c.done
+}
+
+func TestMemcachedConnectionLimit(t *testing.T) {
+	s := memcachedServer(t, &config.MemcachedConfig{Listen: "127.0.0.1:0", MaxConnections: 1})
+	errCh := make(chan error, 1)
+	if err := s.startMemcached(errCh); err != nil {
+		t.Fatal(err)
+	}
+	defer s.closeMemcached()
+	addr := s.memcached.Addr().String()
+
+	dial := func() *memcachedClient {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		t.Cleanup(func() { conn.Close() })
+		return &memcachedClient{conn: conn, r: bufio.NewReader(conn)}
+	}
+
+	first := dial()
+	if got := first.command(t, "get jwks/missing\r\n"); got != "END\r\n" {
+		t.Fatalf("first connection answered %q", got)
+	}
+	second := dial()
+	if got := second.reply(t); got != "SERVER_ERROR too many connections\r\n" {
+		t.Fatalf("connection over the limit answered %q", got)
+	}
+	if _, err := second.r.ReadByte(); err != io.EOF {
+		t.Fatalf("connection over the limit left open: %v", err)
+	}
+
+	// Once the first connection is gone there is room again
+	io.WriteString(first.conn, "quit\r\n")
+	waitFor(t, "the first connection to close", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.memcachedConns) == 0
+	})
+	if got := dial().command(t, "get jwks/missing\r\n"); got != "END\r\n" {
+		t.Fatalf("connection after the first closed answered %q", got)
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+var (
+	dedupStats   = expvar.NewMap("merged_dedup")
+	dedupRemoved = new(expvar.Int) // duplicate keys left out of merged responses
+	dedupLast    = new(expvar.Int) // duplicates in the most recent merged response
+)
+
+func init() {
+	dedupStats.Set("removed_keys", dedupRemoved)
+	dedupStats.Set("last_removed", dedupLast)
+}
+
+// SetMerged sets how merged key sets are built, must be called before Start
+func (s *Server) SetMerged(cfg config.MergedConfig) {
+	s.merged = cfg
+}
+
+// mergeKeys returns the keys of the named IDPs in order, deduplicated as configured
+func (s *Server) mergeKeys(all map[string]*jwks.IDPData, names []string) *jwks.JWKS {
+	merged, removed := s.merge(all, names)
+	if s.merged.GetDedup() != config.DedupNone {
+		dedupRemoved.Add(int64(removed))
+		dedupLast.Set(int64(removed))
+	}
+	return merged
+}
+
+// merge is mergeKeys without counting the removed duplicates
+func (s *Server) merge(all map[string]*jwks.IDPData, names []string) (*jwks.JWKS, int) {
+	return jwks.Merge(all, names, s.merged, time.Now())
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+const (
+	defaultPerPage = 100
+	maxPerPage     = 1000
+)
+
+// paginate applies ?max_keys= or ?page= and ?per_page= to a merged key set.
+// Keys keep the merged order (IDP name, then upstream order), so pages are stable
+// between requests as long as no IDP's keys change. Pagination headers are set on w.
+func paginate(w http.ResponseWriter, r *http.Request, keySet *jwks.JWKS) (*jwks.JWKS, error) {
+	query := r.URL.Query()
+	total := len(keySet.Keys)
+
+	if v := query.Get("max_keys"); v != "" {
+		n, err := positiveParam("max_keys", v, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &jwks.JWKS{Keys: keySet.Keys[:min(n, total)]}, nil
+	}
+
+	if query.Get("page") == "" && query.Get("per_page") == "" {
+		return keySet, nil
+	}
+	page, err := positiveParam("page", query.Get("page"), 1)
+	if err != nil {
+		return nil, err
+	}
+	perPage, err := positiveParam("per_page", query.Get("per_page"), defaultPerPage)
+	if err != nil {
+		return nil, err
+	}
+	perPage = min(perPage, maxPerPage)
+
+	// A page past the end is empty; comparing before multiplying keeps huge pages from overflowing
+	start := total
+	if total > 0 && page-1 <= (total-1)/perPage {
+		start = (page - 1) * perPage
+	}
+	end := min(start+perPage, total)
+	pages := max(1, (total+perPage-1)/perPage)
+
+	w.Header().Set("X-Page", strconv.Itoa(page))
+	w.Header().Set("X-Per-Page", strconv.Itoa(perPage))
+	w.Header().Set("X-Total-Pages", strconv.Itoa(pages))
+	var links []string
+	if page > 1 {
+		links = append(links, pageLink(r, page-1, perPage, "prev"))
+	}
+	if page < pages {
+		links = append(links, pageLink(r, page+1, perPage, "next"))
+	}
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+
+	return &jwks.JWKS{Keys: keySet.Keys[start:end]}, nil
+}
+
+// positiveParam parses a positive integer query parameter, returning def when empty
+func positiveParam(name, v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return n, nil
+}
+
+func pageLink(r *http.Request, page, perPage int, rel string) string {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
+	return fmt.Sprintf("<%s?%s>; rel=%q", r.URL.Path, query.Encode(), rel)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+func TestPaginate(t *testing.T) {
+	keySet := &jwks.JWKS{}
+	for i := range 5 {
+		keySet.Keys = append(keySet.Keys, jwks.JWK{Kid: strconv.Itoa(i), Kty: "RSA"})
+	}
+	tests := []struct {
+		query string
+		kids  string
+	}{
+		{query: "", kids: "01234"},
+		{query: "max_keys=2", kids: "01"},
+		{query: "page=2&per_page=2", kids: "23"},
+		{query: "page=3&per_page=2", kids: "4"},
+		{query: "page=4&per_page=2", kids: ""},
+		{query: "page=9223372036854775807&per_page=1000", kids: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json?"+tt.query, nil)
+			page, err := paginate(httptest.NewRecorder(), r, keySet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kids := ""
+			for _, k := range page.Keys {
+				kids += k.Kid
+			}
+			if kids != tt.kids {
+				t.Fatalf("kids %q, want %q", kids, tt.kids)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// handleRefresh makes an IDP fetch its keys now instead of at its next refresh, e.g. right after it
+// rotated. The fetch runs in the background, its outcome shows in /status/{idp}.
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("idp")
+	err := s.supervisor.Refresh(name)
+	switch {
+	case errors.Is(err, jwks.ErrNotStarted):
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "IDPs are still being fetched for the first time, retry later", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, jwks.ErrUnknownIDP):
+		http.Error(w, fmt.Sprintf("IDP '%s' not found", name), http.StatusNotFound)
+		return
+	case errors.Is(err, jwks.ErrThrottled):
+		if data, ok := s.manager.Get(name); ok {
+			retry := max(int(time.Until(data.ThrottledUntil).Round(time.Second)/time.Second), 1)
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+		}
+		http.Error(w, fmt.Sprintf("IDP '%s' is throttling fetches, retry later", name), http.StatusTooManyRequests)
+		return
+	}
+
+	s.recordAudit(r, "idp.refresh", name, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+	response := struct {
+		Refreshing string `json:"refreshing"`
+	}{name}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode refresh response", "error", err)
+	}
+}
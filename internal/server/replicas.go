@@ -0,0 +1,20 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kiquetal/go-idp-caller/internal/replicas"
+)
+
+// SetReplicas enables GET /replicas with the checker's last report, must be called before Start
+func (s *Server) SetReplicas(checker *replicas.Checker) {
+	s.replicas = checker
+}
+
+func (s *Server) handleReplicas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.replicas.Report()); err != nil {
+		s.logger.Error("Failed to encode replicas response", "error", err)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+const testJWKS = `{"keys":[{"kty":"RSA","kid":"k1","use":"sig","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4","e":"AQAB"}]}`
+
+// operatorServer runs the admin routes for an IDP "corp" fetched from a counting upstream,
+// with the tokens "viewer-token", "operator-token" and "admin-token"
+func operatorServer(t *testing.T, upstream http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	idp := httptest.NewServer(upstream)
+	t.Cleanup(idp.Close)
+
+	tokens := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokens, []byte("viewer-token viewer\noperator-token operator\nadmin-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := jwks.NewManager(logger)
+	updater := jwks.NewUpdater(config.IDPConfig{Name: "corp", URL: idp.URL, RefreshInterval: 3600}, manager, logger,
+		jwks.WithHTTPClient(idp.Client()))
+	supervisor := jwks.NewSupervisor(manager, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	supervisor.Start(ctx, []*jwks.Updater{updater})
+
+	srv := New(config.ServerConfig{}, manager, logger)
+	srv.SetAdmin(config.AdminConfig{TokenFile: tokens})
+	srv.SetSupervisor(supervisor, nil)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, ts *httptest.Server, method, path, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOperatorRoutes(t *testing.T) {
+	var fetches atomic.Int32
+	ts := operatorServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, testJWKS)
+	})
+	waitFor(t, "the first fetch", func() bool { return fetches.Load() == 1 })
+
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodPost, "/admin/idps/corp/refresh", "viewer-token", http.StatusForbidden},
+		{http.MethodPost, "/admin/idps/corp/refresh", "operator-token", http.StatusAccepted},
+		{http.MethodPost, "/admin/idps/missing/refresh", "operator-token", http.StatusNotFound},
+		{http.MethodPost, "/admin/idps/corp/drain?grace=60", "viewer-token", http.StatusForbidden},
+		{http.MethodPost, "/admin/idps/corp/drain?grace=60", "operator-token", http.StatusOK},
+		{http.MethodDelete, "/admin/idps/corp/drain", "operator-token", http.StatusNoContent},
+		{http.MethodDelete, "/admin/idps/corp/staged", "operator-token", http.StatusNotFound},
+		{http.MethodPut, "/admin/state", "operator-token", http.StatusForbidden},
+		{http.MethodPost, "/admin/idps/corp/restore", "operator-token", http.StatusForbidden},
+		{http.MethodPost, "/admin/idps/corp/refresh", "admin-token", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if resp := do(t, ts, tt.method, tt.path, tt.token); resp.StatusCode != tt.want {
+			t.Errorf("%s %s as %s: status %d, want %d", tt.method, tt.path, tt.token, resp.StatusCode, tt.want)
+		}
+	}
+	waitFor(t, "the refresh", func() bool { return fetches.Load() >= 2 })
+}
+
+func TestRefreshThrottledIDP(t *testing.T) {
+	ts := operatorServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	})
+
+	var resp *http.Response
+	waitFor(t, "the throttled fetch", func() bool {
+		resp = do(t, ts, http.MethodPost, "/admin/idps/corp/refresh", "operator-token")
+		return resp.StatusCode == http.StatusTooManyRequests
+	})
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("no Retry-After on a refused refresh")
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import "net/http"
+
+// middleware wraps a handler, e.g. to require authentication
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+// router registers method-specific routes with path parameters on a ServeMux.
+// Patterns use the ServeMux syntax, e.g. /jwks/{idp}, read with r.PathValue("idp").
+// Requests with a method no route accepts get 405 with an Allow header.
+type router struct {
+	mux        *http.ServeMux
+	middleware []middleware
+	methods    map[string]bool // methods registered by any group, shared with the groups
+}
+
+func newRouter() *router {
+	return &router{mux: http.NewServeMux(), methods: make(map[string]bool)}
+}
+
+// group returns a router registering on the same mux with mw applied after the current middleware
+func (rt *router) group(mw ...middleware) *router {
+	chain := make([]middleware, 0, len(rt.middleware)+len(mw))
+	chain = append(chain, rt.middleware...)
+	return &router{mux: rt.mux, middleware: append(chain, mw...), methods: rt.methods}
+}
+
+// handle registers h for method and pattern; GET routes also answer HEAD
+func (rt *router) handle(method, pattern string, h http.HandlerFunc) {
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		h = rt.middleware[i](h)
+	}
+	rt.mux.HandleFunc(method+" "+pattern, h)
+	rt.methods[method] = true
+	if method == http.MethodGet {
+		rt.methods[http.MethodHead] = true
+	}
+}
+
+func (rt *router) get(pattern string, h http.HandlerFunc)  { rt.handle(http.MethodGet, pattern, h) }
+func (rt *router) post(pattern string, h http.HandlerFunc) { rt.handle(http.MethodPost, pattern, h) }
+func (rt *router) put(pattern string, h http.HandlerFunc)  { rt.handle(http.MethodPut, pattern, h) }
+func (rt *router) delete(pattern string, h http.HandlerFunc) {
+	rt.handle(http.MethodDelete, pattern, h)
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
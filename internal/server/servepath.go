@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// SetServePaths registers extra paths serving the keys of the given IDPs, must be called before Start
+func (s *Server) SetServePaths(paths map[string][]string) {
+	s.servePaths = paths
+}
+
+// handleServePath serves a serve_path alias: one IDP exactly like /jwks/{idp},
+// several IDPs sharing the path merged like /.well-known/jwks.json
+func (s *Server) handleServePath(path string, idps []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(idps) == 1 {
+			s.serveIDPJWKS(w, r, idps[0])
+			return
+		}
+		s.serveGroupJWKS(w, r, path, idps)
+	}
+}
+
+// serveGroupJWKS writes the merged keys of a group of IDPs, with the cache policy of path
+func (s *Server) serveGroupJWKS(w http.ResponseWriter, r *http.Request, path string, idps []string) {
+	all := s.manager.GetAll()
+	group := make(map[string]*jwks.IDPData, len(idps))
+	for _, name := range idps {
+		if data, ok := all[name]; ok {
+			group[name] = data
+		}
+	}
+	response := s.mergeKeys(group, idps)
+
+	w.Header().Set("Content-Type", "application/json")
+	s.setCacheHeaders(w, s.mergedFreshness(path, group))
+	w.Header().Set("X-Total-Keys", fmt.Sprintf("%d", len(response.Keys)))
+	if s.features.VerboseHeaders {
+		w.Header().Set("X-IDP-Count", fmt.Sprintf("%d", len(idps)))
+	}
+
+	if err := writeTagged(w, r, response); err != nil {
+		s.logger.Error("Failed to encode JWKS response", "error", err, "path", r.URL.Path)
+	}
+}
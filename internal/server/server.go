@@ -3,155 +3,396 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/kiquetal/go-idp-caller/internal/audit"
 	"github.com/kiquetal/go-idp-caller/internal/config"
 	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/internal/replicas"
+	"github.com/kiquetal/go-idp-caller/internal/signer"
+	"github.com/kiquetal/go-idp-caller/internal/token"
+	"github.com/kiquetal/go-idp-caller/internal/validate"
+	"github.com/kiquetal/go-idp-caller/internal/version"
 )
 
 type Server struct {
 	config  config.ServerConfig
 	manager *jwks.Manager
 	logger  *slog.Logger
-	server  *http.Server
+
+	mu        sync.Mutex
+	servers   []*http.Server          // one per listener
+	listeners map[string]net.Listener // by listen address, for handing over to a new process
+	inherited map[string]*os.File     // sockets passed by the previous process
+
+	memcached      net.Listener // nil unless server.memcached is configured
+	memcachedConns map[net.Conn]struct{}
+
+	ready    atomic.Bool // initial fetch completed
+	draining atomic.Bool // shutdown in progress, readiness fails
+
+	feed        *eventFeed      // change events for GraphQL
+	streams     context.Context // done on Shutdown, ending GraphQL subscriptions
+	stopStreams context.CancelFunc
+
+	tokens atomic.Pointer[map[string]*token.Client] // IDPs with client credentials
+	signer *signer.Signer                           // nil unless signing is configured
+	admin  config.AdminConfig
+	audit  *audit.Log // nil unless admin actions are recorded
+
+	validator  atomic.Pointer[validate.Validator]
+	slo        config.SLOConfig
+	servePaths map[string][]string                 // serve_path aliases to the IDPs served on them
+	audiences  atomic.Pointer[map[string][]string] // audiences to the IDPs whose tokens carry them
+	merged     config.MergedConfig
+	faults     *config.FaultsConfig // nil unless fault injection is enabled
+	replicas   *replicas.Checker    // nil unless replicas are compared
+	consumers  *consumerTracker     // nil unless server.consumers is configured
+
+	supervisor *jwks.Supervisor                         // runs the updaters, reconciled by PUT /admin/state
+	declare    func([]byte) ([]config.IDPConfig, error) // parses and validates a declared IDP list
+
+	effectiveConfig map[string]any // served on GET /admin/config, secrets redacted
+	features        config.Features
+	trustedProxies  []netip.Prefix // peers whose forwarding headers name the client
 }
 
+// New creates the server and subscribes it to manager changes, must be called before the updaters start
 func New(cfg config.ServerConfig, manager *jwks.Manager, logger *slog.Logger) *Server {
+	streams, stopStreams := context.WithCancel(context.Background())
 	return &Server{
-		config:  cfg,
-		manager: manager,
-		logger:  logger,
+		config:   cfg,
+		manager:  manager,
+		logger:   logger,
+		features: config.Features{DebugEndpoints: true, VerboseHeaders: true},
+
+		feed:        newEventFeed(manager),
+		streams:     streams,
+		stopStreams: stopStreams,
+
+		trustedProxies: cfg.GetTrustedProxies(),
+		consumers:      newConsumerTracker(cfg.Consumers),
 	}
 }
 
+// SetFeatures sets the behaviors selected by the configuration profile, must be called before Start
+func (s *Server) SetFeatures(features config.Features) {
+	s.features = features
+}
+
+// Handler returns a handler serving the default route groups, for embedding or httptest
+func (s *Server) Handler() http.Handler {
+	return s.loggingMiddleware("handler", s.routes(config.DefaultRoutes))
+}
+
+// Start opens every configured listener and serves until Shutdown is called.
+// It returns the first listener error; listeners already started are closed by Shutdown.
 func (s *Server) Start() error {
-	mux := http.NewServeMux()
+	listeners := s.config.GetListeners()
+	errCh := make(chan error, len(listeners)+1)
 
-	// Standard OIDC endpoint - merged JWKS from all IDPs
-	mux.HandleFunc("/.well-known/jwks.json", s.handleGetMergedJWKS)
+	for _, lc := range listeners {
+		srv, ln, err := s.newListener(lc)
+		if err != nil {
+			return fmt.Errorf("listener %q: %w", lc.GetName(), err)
+		}
 
-	// API endpoints
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/jwks", s.handleGetAllJWKS)
-	mux.HandleFunc("/jwks/", s.handleGetIDPJWKS)
-	mux.HandleFunc("/status", s.handleStatus)
-	mux.HandleFunc("/status/", s.handleIDPStatus)
+		s.mu.Lock()
+		s.servers = append(s.servers, srv)
+		if s.listeners == nil {
+			s.listeners = make(map[string]net.Listener)
+		}
+		s.listeners[lc.Listen] = ln
+		s.mu.Unlock()
+
+		s.logger.Info("Starting HTTP server",
+			"listener", lc.GetName(),
+			"addr", srv.Addr,
+			"network", ln.Addr().Network(),
+			"protocols", lc.GetProtocols(),
+			"routes", lc.GetRoutes(),
+			"tls", lc.TLS != nil,
+		)
 
-	// Wrap with logging middleware
-	handler := s.loggingMiddleware(mux)
+		go func() {
+			if srv.TLSConfig != nil {
+				errCh <- srv.ServeTLS(ln, "", "")
+				return
+			}
+			errCh <- srv.Serve(ln)
+		}()
+	}
 
-	s.server = &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", s.config.Host, s.config.Port),
-		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	if s.config.Memcached != nil {
+		if err := s.startMemcached(errCh); err != nil {
+			return fmt.Errorf("memcached: %w", err)
+		}
 	}
 
-	s.logger.Info("Starting HTTP server", "addr", s.server.Addr)
-	return s.server.ListenAndServe()
+	return <-errCh
 }
 
-func (s *Server) Shutdown(ctx context.Context) error {
-	s.logger.Info("Shutting down HTTP server")
-	return s.server.Shutdown(ctx)
+// newListener creates the http.Server and net.Listener for one listener config
+func (s *Server) newListener(lc config.ListenerConfig) (*http.Server, net.Listener, error) {
+	srv := &http.Server{
+		Handler:           s.loggingMiddleware(lc.GetName(), s.routes(lc.GetRoutes())),
+		ReadHeaderTimeout: s.config.Requests.GetReadHeaderTimeout(),
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    s.config.Requests.GetMaxHeaderBytes(),
+		Protocols:         protocols(lc.GetProtocols()),
+	}
+
+	if lc.TLS != nil {
+		tc, err := tlsConfig(lc.TLS)
+		if err != nil {
+			return nil, nil, err
+		}
+		srv.TLSConfig = tc
+	}
+
+	ln, err := s.inheritedListener(lc.Listen)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ln == nil {
+		ln, err = listen(lc.Listen, lc.GetSocketMode(), lc.Socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to listen: %w", err)
+		}
+	}
+	srv.Addr = ln.Addr().String()
+
+	return srv, ln, nil
 }
 
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// routes builds the router for a set of route groups
+func (s *Server) routes(groups []string) http.Handler {
+	rt := newRouter()
+	viewer := rt.group(s.requireRole(config.RoleViewer))
+	operator := rt.group(s.requireRole(config.RoleOperator))
+	admin := rt.group(s.requireRole(config.RoleAdmin))
+	client := rt.group(s.requireRole(config.RoleClient))
+	keys := rt
+	if s.faults != nil {
+		keys = rt.group(s.injectFaults)
+	}
+	if s.consumers != nil {
+		keys = keys.group(s.trackConsumers)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]string{
-		"status": "healthy",
-		"time":   time.Now().Format(time.RFC3339),
-	}); err != nil {
-		s.logger.Error("Failed to encode health response", "error", err)
+	for _, group := range groups {
+		switch group {
+		case config.RoutesJWKS:
+			// Standard OIDC endpoint - merged JWKS from all IDPs
+			keys.get("/.well-known/jwks.json", s.handleGetMergedJWKS)
+			keys.get("/jwks", s.handleGetAllJWKS)
+			keys.get("/jwks/{idp}", s.handleGetIDPJWKS)
+			keys.get("/jwks/{idp}/staged", s.handleGetStagedJWKS)
+			keys.get("/.well-known/webfinger", s.handleWebFinger)
+			keys.get("/export", s.handleExport)
+			keys.get("/audiences/{aud}/jwks.json", s.handleAudienceJWKS)
+			for path, idps := range s.servePaths {
+				keys.get(path, s.handleServePath(path, idps))
+			}
+		case config.RoutesStatus:
+			rt.get("/status", s.handleStatus)
+			rt.get("/status/{idp}", s.handleIDPStatus)
+			rt.get("/slo", s.handleSLO)
+			rt.get("/slo/{idp}", s.handleIDPSLO)
+			rt.get("/graphql", s.handleGraphQL)
+			rt.post("/graphql", s.handleGraphQL)
+			if s.features.DebugEndpoints {
+				rt.get("/dashboard", s.handleDashboard)
+			}
+			if s.replicas != nil {
+				rt.get("/replicas", s.handleReplicas)
+			}
+			if s.consumers != nil {
+				viewer.get("/status/consumers", s.handleConsumers)
+			}
+		case config.RoutesHealth:
+			rt.get("/health", s.handleHealth)
+			rt.get("/ready", s.handleReady)
+			rt.get("/version", s.handleVersion)
+		case config.RoutesToken:
+			client.post("/token/{idp}", s.handleToken)
+		case config.RoutesAdmin:
+			admin.post("/sign", s.handleSign)
+			if s.features.DebugEndpoints {
+				viewer.get("/debug/manager", s.handleDebugManager)
+			}
+			viewer.get("/admin/config", s.handleConfig)
+			viewer.get("/audit/admin", s.handleAudit)
+			if s.supervisor != nil {
+				admin.put("/admin/state", s.handlePutState)
+				admin.post("/admin/idps/{idp}/restore", s.handleRestore)
+				viewer.get("/admin/tombstones", s.handleTombstones)
+				operator.post("/admin/idps/{idp}/refresh", s.handleRefresh)
+			}
+			operator.post("/admin/idps/{idp}/staged", s.handleStage)
+			operator.delete("/admin/idps/{idp}/staged", s.handleUnstage)
+			operator.post("/admin/idps/{idp}/promote", s.handlePromote)
+			operator.post("/admin/idps/{idp}/drain", s.handleDrain)
+			operator.delete("/admin/idps/{idp}/drain", s.handleUndrain)
+		case config.RoutesValidate:
+			rt.post("/validate", s.handleValidate)
+			rt.post("/validate/batch", s.handleValidateBatch)
+		case config.RoutesMetrics:
+			if s.features.DebugEndpoints {
+				rt.get("/debug/vars", expvar.Handler().ServeHTTP)
+			}
+		}
 	}
+
+	return s.renderErrorPages(s.harden(rt))
 }
 
-func (s *Server) handleGetMergedJWKS(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// protocols converts the configured protocol names into http.Protocols.
+// h2c lets in-mesh clients (Envoy, gRPC gateways) multiplex requests over one cleartext connection.
+func protocols(names []string) *http.Protocols {
+	p := new(http.Protocols)
+	for _, name := range names {
+		switch name {
+		case "http1":
+			p.SetHTTP1(true)
+		case "h2c":
+			p.SetUnencryptedHTTP2(true)
+		case "http2":
+			p.SetHTTP2(true)
+		}
 	}
+	return p
+}
 
-	all := s.manager.GetAll()
+// MarkReady makes /ready succeed, called once the initial fetch has completed
+func (s *Server) MarkReady() {
+	s.ready.Store(true)
+}
+
+// Drain makes /ready fail so load balancers stop routing new requests,
+// while the server keeps serving until Shutdown is called
+func (s *Server) Drain() {
+	s.draining.Store(true)
+	s.logger.Info("Draining HTTP server, readiness now failing")
+}
 
-	// Merge all keys from all IDPs into a single array
-	mergedKeys := make([]jwks.JWK, 0)
-	minCacheDuration := 900 // Default 15 minutes
-	totalKeys := 0
+// Shutdown gracefully stops all listeners
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("Shutting down HTTP server")
+	s.closeMemcached()
+	s.stopStreams()
+
+	s.mu.Lock()
+	servers := s.servers
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+	for i, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
 
-	for _, data := range all {
-		if data.JWKS != nil && len(data.JWKS.Keys) > 0 {
-			mergedKeys = append(mergedKeys, data.JWKS.Keys...)
-			totalKeys += data.KeyCount
+	return errors.Join(errs...)
+}
 
-			// Use the minimum cache duration across all IDPs to be safe
-			if data.CacheDuration > 0 && data.CacheDuration < minCacheDuration {
-				minCacheDuration = data.CacheDuration
-			}
-		}
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(version.Get()); err != nil {
+		s.logger.Error("Failed to encode version response", "error", err)
 	}
+}
 
-	response := jwks.JWKS{
-		Keys: mergedKeys,
+func (s *Server) handleGetMergedJWKS(w http.ResponseWriter, r *http.Request) {
+	merged, fresh, idpCount := s.mergedJWKS()
+	response, err := paginate(w, r, merged)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", minCacheDuration))
-	w.Header().Set("X-Total-Keys", fmt.Sprintf("%d", totalKeys))
-	w.Header().Set("X-IDP-Count", fmt.Sprintf("%d", len(all)))
+	s.setCacheHeaders(w, fresh)
+	w.Header().Set("X-Total-Keys", fmt.Sprintf("%d", len(merged.Keys)))
+	if s.features.VerboseHeaders {
+		w.Header().Set("X-IDP-Count", fmt.Sprintf("%d", idpCount))
+	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := writeTagged(w, r, response); err != nil {
 		s.logger.Error("Failed to encode merged JWKS response", "error", err)
 	}
 }
 
-func (s *Server) handleGetAllJWKS(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// mergedJWKS merges the keys of all IDPs in name order, returning the smallest cache duration and the IDP count
+func (s *Server) mergedJWKS() (*jwks.JWKS, freshness, int) {
+	all := s.manager.GetAll()
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	return s.mergeKeys(all, names), s.mergedFreshness("/.well-known/jwks.json", all), len(all)
+}
 
+func (s *Server) handleGetAllJWKS(w http.ResponseWriter, r *http.Request) {
 	all := s.manager.GetAll()
 	result := make(map[string]*jwks.JWKS)
+	now := time.Now()
 	for name, data := range all {
-		if data.JWKS != nil {
+		if data.JWKS != nil && !data.Drained(now) {
 			result[name] = data.JWKS
 		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	s.setCacheHeaders(w, s.mergedFreshness("/jwks", all))
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		s.logger.Error("Failed to encode JWKS response", "error", err)
 	}
 }
 
 func (s *Server) handleGetIDPJWKS(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Extract IDP name from path
-	idpName := r.URL.Path[len("/jwks/"):]
-	if idpName == "" {
-		http.Error(w, "IDP name required", http.StatusBadRequest)
-		return
-	}
+	s.serveIDPJWKS(w, r, r.PathValue("idp"))
+}
 
+// serveIDPJWKS writes the key set of one IDP
+func (s *Server) serveIDPJWKS(w http.ResponseWriter, r *http.Request, idpName string) {
 	data, exists := s.manager.Get(idpName)
+	if !exists {
+		// Right after a deploy, wait briefly for the IDP's first fetch rather than having clients retry
+		if wait := s.config.GetColdStartWait(); wait > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), wait)
+			data, exists = s.manager.Wait(ctx, idpName)
+			cancel()
+		}
+	}
 	if !exists {
 		http.Error(w, fmt.Sprintf("IDP '%s' not found", idpName), http.StatusNotFound)
 		return
 	}
+	if !data.DrainingSince.IsZero() && s.drainingRequest(r, data) {
+		http.Error(w, fmt.Sprintf("IDP '%s' has been decommissioned", idpName), http.StatusNotFound)
+		return
+	}
 
 	keySet := data.JWKS
 	if keySet == nil {
@@ -160,39 +401,48 @@ func (s *Server) handleGetIDPJWKS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", data.CacheDuration))
-	w.Header().Set("X-Key-Count", fmt.Sprintf("%d", data.KeyCount))
-	w.Header().Set("X-Max-Keys", fmt.Sprintf("%d", data.MaxKeys))
-	w.Header().Set("X-Last-Updated", data.LastUpdated.Format(time.RFC3339))
+	s.setCacheHeaders(w, freshness{maxAge: data.CacheDuration, until: data.CacheUntil})
+	if s.features.VerboseHeaders {
+		w.Header().Set("X-Key-Count", fmt.Sprintf("%d", data.KeyCount))
+		w.Header().Set("X-Max-Keys", fmt.Sprintf("%d", data.MaxKeys))
+		w.Header().Set("X-Last-Updated", data.LastUpdated.Format(time.RFC3339))
+	}
 
 	if err := json.NewEncoder(w).Encode(keySet); err != nil {
 		s.logger.Error("Failed to encode JWKS response", "error", err, "idp", idpName)
 	}
 }
 
+// handleStatus writes the status of every IDP, without key sets unless ?include=jwks is given and
+// only the fields selected with ?fields= when given
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	sel, err := parseStatusSelection(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	all := s.manager.GetAll()
+	result := make(map[string]any, len(all))
+	for name, data := range all {
+		if result[name], err = sel.apply(data); err != nil {
+			s.logger.Error("Failed to encode status response", "error", err)
+			http.Error(w, "Failed to encode status", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(all); err != nil {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		s.logger.Error("Failed to encode status response", "error", err)
 	}
 }
 
 func (s *Server) handleIDPStatus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Extract IDP name from path
-	idpName := r.URL.Path[len("/status/"):]
-	if idpName == "" {
-		http.Error(w, "IDP name required", http.StatusBadRequest)
+	idpName := r.PathValue("idp")
+	sel, err := parseStatusSelection(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -201,20 +451,27 @@ func (s *Server) handleIDPStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("IDP '%s' not found", idpName), http.StatusNotFound)
 		return
 	}
+	result, err := sel.apply(data)
+	if err != nil {
+		s.logger.Error("Failed to encode status response", "error", err, "idp", idpName)
+		http.Error(w, "Failed to encode status", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(data); err != nil {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		s.logger.Error("Failed to encode status response", "error", err, "idp", idpName)
 	}
 }
 
-func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
+func (s *Server) loggingMiddleware(listener string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		// Create response writer wrapper to capture status code
 		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
+		r = s.withClientIP(r)
 		next.ServeHTTP(rw, r)
 
 		duration := time.Since(start)
@@ -225,6 +482,8 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 			"status", rw.statusCode,
 			"duration_ms", duration.Milliseconds(),
 			"remote_addr", r.RemoteAddr,
+			"client_ip", clientIP(r),
+			"listener", listener,
 		)
 	})
 }
@@ -238,3 +497,8 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap lets http.ResponseController reach the connection, to flush streams
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
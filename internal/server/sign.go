@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/signer"
+)
+
+// maxSignRequestBytes bounds the body of POST /sign
+const maxSignRequestBytes = 64 << 10
+
+// SetSigner enables POST /sign, must be called before Start
+func (s *Server) SetSigner(sg *signer.Signer) {
+	s.signer = sg
+}
+
+// handleSign mints a JWT with the configured signing key
+func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
+	if s.signer == nil {
+		http.Error(w, "Signing is not configured", http.StatusNotFound)
+		return
+	}
+
+	var req signer.Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSignRequestBytes)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, expiry, err := s.signer.Mint(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, signer.ErrInvalidRequest):
+			s.logger.Warn("Token request refused", "sub", req.Subject, "client_ip", clientIP(r), "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, signer.ErrSigningFailed):
+			s.logger.Error("Token signing failed", "sub", req.Subject, "client_ip", clientIP(r), "error", err)
+			http.Error(w, "Signing failed", http.StatusBadGateway)
+		default:
+			s.logger.Error("Token minting failed", "sub", req.Subject, "client_ip", clientIP(r), "error", err)
+			http.Error(w, "Token minting failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.logger.Info("Minted token",
+		"sub", req.Subject,
+		"aud", req.Audience,
+		"expires_at", expiry.Format(time.RFC3339),
+		"client_ip", clientIP(r),
+	)
+	s.recordAudit(r, "token.sign", req.Subject, nil, map[string]any{
+		"aud":        req.Audience,
+		"expires_at": expiry.Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	response := map[string]any{
+		"token":      token,
+		"token_type": "JWT",
+		"expires_in": int(time.Until(expiry).Seconds()),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode sign response", "error", err)
+	}
+}
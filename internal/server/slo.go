@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// sloResponse is the body of GET /slo
+type sloResponse struct {
+	Objective float64                     `json:"objective"`
+	IDPs      map[string][]jwks.SLOWindow `json:"idps"`
+}
+
+// SetSLO sets the objective and windows reported on /slo, must be called before Start
+func (s *Server) SetSLO(cfg config.SLOConfig) {
+	s.slo = cfg
+}
+
+// SLOReport returns the SLO windows of every IDP that has been fetched
+func (s *Server) SLOReport() map[string][]jwks.SLOWindow {
+	now := time.Now()
+	report := make(map[string][]jwks.SLOWindow)
+	for name := range s.manager.GetAll() {
+		if windows, ok := s.manager.SLOReport(name, s.slo.GetObjective(), s.slo.GetWindows(), now); ok {
+			report[name] = windows
+		}
+	}
+	return report
+}
+
+func (s *Server) handleSLO(w http.ResponseWriter, r *http.Request) {
+	response := sloResponse{Objective: s.slo.GetObjective(), IDPs: s.SLOReport()}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode SLO response", "error", err)
+	}
+}
+
+func (s *Server) handleIDPSLO(w http.ResponseWriter, r *http.Request) {
+	idpName := r.PathValue("idp")
+	windows, ok := s.manager.SLOReport(idpName, s.slo.GetObjective(), s.slo.GetWindows(), time.Now())
+	if !ok {
+		http.Error(w, fmt.Sprintf("IDP '%s' not found", idpName), http.StatusNotFound)
+		return
+	}
+
+	response := sloResponse{Objective: s.slo.GetObjective(), IDPs: map[string][]jwks.SLOWindow{idpName: windows}}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode SLO response", "error", err, "idp", idpName)
+	}
+}
@@ -0,0 +1,4 @@
+package server
+
+// soReusePort is SO_REUSEPORT, which package syscall doesn't define on linux
+const soReusePort = 0xf
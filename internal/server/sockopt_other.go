@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package server
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// setSockopts fails for options this platform doesn't support
+func setSockopts(opts config.SocketOptions) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if opts.ReusePort || !opts.GetDualStack() {
+			return errors.New("reuse_port and dual_stack are not supported on this platform")
+		}
+		return nil
+	}
+}
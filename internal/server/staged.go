@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// maxStagedKeysBytes bounds the body of POST /admin/idps/{idp}/staged
+const maxStagedKeysBytes = 1 << 20
+
+// stagedResponse describes a staged or promoted key set
+type stagedResponse struct {
+	IDP        string    `json:"idp"`
+	Kids       []string  `json:"kids"`
+	StagedAt   time.Time `json:"staged_at,omitzero"`
+	PromotedAt time.Time `json:"promoted_at,omitzero"`
+}
+
+// handleStage stores a candidate key set for an IDP, served on /jwks/{idp}/staged until promoted
+func (s *Server) handleStage(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("idp")
+	var keys jwks.JWKS
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStagedKeysBytes)).Decode(&keys); err != nil {
+		http.Error(w, "Invalid key set: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.manager.Stage(name, &keys); err != nil {
+		if errors.Is(err, jwks.ErrUnknownIDP) {
+			http.Error(w, fmt.Sprintf("IDP '%s' not found", name), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Invalid key set: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kids, _ := jwks.DiffKids(nil, &keys)
+	s.recordAudit(r, "idp.stage", name, nil, map[string]any{"kids": kids})
+	response := stagedResponse{IDP: name, Kids: kids}
+	if data, ok := s.manager.Get(name); ok {
+		response.StagedAt = data.StagedAt
+	}
+	s.writeStaged(w, response)
+}
+
+// handleUnstage discards the staged key set of an IDP
+func (s *Server) handleUnstage(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("idp")
+	if !s.manager.Unstage(name) {
+		http.Error(w, fmt.Sprintf("IDP '%s' has no staged key set", name), http.StatusNotFound)
+		return
+	}
+	s.recordAudit(r, "idp.unstage", name, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePromote makes the staged key set of an IDP its live keys
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("idp")
+	before, _ := s.manager.Get(name)
+	keys, err := s.manager.Promote(name)
+	switch {
+	case errors.Is(err, jwks.ErrUnknownIDP):
+		http.Error(w, fmt.Sprintf("IDP '%s' not found", name), http.StatusNotFound)
+		return
+	case errors.Is(err, jwks.ErrNothingStaged):
+		http.Error(w, fmt.Sprintf("IDP '%s' has no staged key set", name), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Promotion failed: "+err.Error(), http.StatusConflict)
+		return
+	}
+
+	var previous *jwks.JWKS
+	if before != nil {
+		previous = before.JWKS
+	}
+	previousKids, _ := jwks.DiffKids(nil, previous)
+	kids, _ := jwks.DiffKids(nil, keys)
+	s.recordAudit(r, "idp.promote", name, map[string]any{"kids": previousKids}, map[string]any{"kids": kids})
+
+	response := stagedResponse{IDP: name, Kids: kids}
+	if data, ok := s.manager.Get(name); ok {
+		response.PromotedAt = data.PromotedAt
+	}
+	s.writeStaged(w, response)
+}
+
+// handleGetStagedJWKS serves the staged key set of an IDP, so relying parties can be tested against
+// it before promotion. It is never cached.
+func (s *Server) handleGetStagedJWKS(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("idp")
+	data, exists := s.manager.Get(name)
+	if !exists || data.Staged == nil {
+		http.Error(w, fmt.Sprintf("IDP '%s' has no staged key set", name), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(data.Staged); err != nil {
+		s.logger.Error("Failed to encode staged JWKS", "error", err, "idp", name)
+	}
+}
+
+func (s *Server) writeStaged(w http.ResponseWriter, response stagedResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode staged key set response", "error", err)
+	}
+}
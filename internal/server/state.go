@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// maxStateRequestBytes bounds the body of PUT /admin/state
+const maxStateRequestBytes = 1 << 20
+
+// SetSupervisor enables PUT /admin/state; declare parses and validates the declared IDP list
+// against the rest of the configuration. Must be called before Start.
+func (s *Server) SetSupervisor(supervisor *jwks.Supervisor, declare func([]byte) ([]config.IDPConfig, error)) {
+	s.supervisor = supervisor
+	s.declare = declare
+}
+
+// handlePutState reconciles the running IDPs to a full declared list, so tools like Terraform or a
+// GitOps controller can manage IDPs idempotently. ?dry_run=true only reports what would change.
+func (s *Server) handlePutState(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStateRequestBytes))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	idps, err := s.declare(body)
+	if err != nil {
+		http.Error(w, "Invalid state: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	var result jwks.ReconcileResult
+	if dryRun {
+		result = s.supervisor.Plan(idps)
+	} else {
+		result, err = s.supervisor.Reconcile(idps)
+		if errors.Is(err, jwks.ErrNotStarted) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "IDPs are still being fetched for the first time, retry later", http.StatusServiceUnavailable)
+			return
+		}
+
+		s.logger.Info("IDP state reconciled",
+			"created", result.Created,
+			"updated", result.Updated,
+			"deleted", result.Deleted,
+			"unchanged", len(result.Unchanged),
+			"client_ip", clientIP(r),
+		)
+		if len(result.Created)+len(result.Updated)+len(result.Deleted) > 0 {
+			declared := make(map[string]config.IDPConfig, len(idps))
+			for _, idp := range idps {
+				declared[idp.Name] = idp
+			}
+			after := make(map[string]config.IDPConfig)
+			for _, name := range append(slices.Clone(result.Created), result.Updated...) {
+				after[name] = declared[name]
+			}
+			s.recordAudit(r, "idp.reconcile", "idps", map[string]any{
+				"idps": s.auditIDPs(result.Previous),
+			}, map[string]any{
+				"created": result.Created,
+				"updated": result.Updated,
+				"deleted": result.Deleted,
+				"idps":    s.auditIDPs(after),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	response := struct {
+		jwks.ReconcileResult
+		DryRun bool `json:"dry_run"`
+	}{result, dryRun}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode state response", "error", err)
+	}
+}
+
+// handleRestore restarts an IDP removed by PUT /admin/state within admin.tombstone_retention
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("idp")
+	tombstone, err := s.supervisor.Restore(name)
+	switch {
+	case errors.Is(err, jwks.ErrNotStarted):
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "IDPs are still being fetched for the first time, retry later", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, jwks.ErrNoTombstone):
+		http.Error(w, fmt.Sprintf("IDP '%s' has not been removed or can no longer be restored", name), http.StatusNotFound)
+		return
+	}
+
+	restored := s.auditIDPs(map[string]config.IDPConfig{name: tombstone.Config()})[name]
+	s.recordAudit(r, "idp.restore", name, map[string]any{
+		"removed_at": tombstone.RemovedAt,
+		"key_count":  tombstone.KeyCount,
+		"config":     restored,
+	}, map[string]any{"config": restored})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	response := struct {
+		Restored string `json:"restored"`
+	}{name}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode restore response", "error", err)
+	}
+}
+
+// auditIDPs returns IDP configurations by name with secrets redacted, as recorded in the audit log
+func (s *Server) auditIDPs(idps map[string]config.IDPConfig) map[string]any {
+	values := make(map[string]any, len(idps))
+	for name, idp := range idps {
+		redacted, err := idp.Redacted()
+		if err != nil {
+			s.logger.Error("Failed to record IDP configuration", "idp", name, "error", err)
+			continue
+		}
+		values[name] = redacted
+	}
+	return values
+}
+
+// handleTombstones lists the removed IDPs that can still be restored
+func (s *Server) handleTombstones(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(s.supervisor.Tombstones()); err != nil {
+		s.logger.Error("Failed to encode tombstones response", "error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kiquetal/go-idp-caller/internal/audit"
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+func TestStateAuditRecordsPreviousConfigs(t *testing.T) {
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, testJWKS)
+	}))
+	defer idp.Close()
+	tokens := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokens, []byte("admin-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager := jwks.NewManager(logger)
+	corp := config.IDPConfig{Name: "corp", URL: idp.URL + "/v1", RefreshInterval: 3600,
+		Headers: map[string]string{"Authorization": "Bearer s3cret"}}
+	supervisor := jwks.NewSupervisor(manager, logger, jwks.WithHTTPClient(idp.Client()))
+	supervisor.SetTombstoneRetention(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	supervisor.Start(ctx, []*jwks.Updater{jwks.NewUpdater(corp, manager, logger, jwks.WithHTTPClient(idp.Client()))})
+
+	auditLog, err := audit.New(config.AuditConfig{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(config.ServerConfig{}, manager, logger)
+	srv.SetAdmin(config.AdminConfig{TokenFile: tokens})
+	srv.SetAudit(auditLog)
+	srv.SetSupervisor(supervisor, func(body []byte) ([]config.IDPConfig, error) {
+		var idps []config.IDPConfig
+		err := yaml.Unmarshal(body, &idps)
+		return idps, err
+	})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	send := func(method, path, body string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer admin-token")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: status %d", method, path, resp.StatusCode)
+		}
+	}
+	// lastRecord returns the newest record of action with its before and after values as JSON
+	lastRecord := func(action string) (string, string) {
+		t.Helper()
+		records := auditLog.Query(audit.Query{Action: action, Limit: 1})
+		if len(records) == 0 {
+			t.Fatalf("no %s record", action)
+		}
+		before, _ := json.Marshal(records[0].Before)
+		after, _ := json.Marshal(records[0].After)
+		return string(before), string(after)
+	}
+	contains := func(what, got string, want ...string) {
+		t.Helper()
+		for _, w := range want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s %s doesn't contain %s", what, got, w)
+			}
+		}
+		if strings.Contains(got, "s3cret") {
+			t.Errorf("%s %s contains the secret header", what, got)
+		}
+	}
+
+	send(http.MethodPut, "/admin/state",
+		`[{"name":"corp","url":"`+idp.URL+`/v2","refresh_interval":3600},{"name":"hr","url":"`+idp.URL+`/hr","refresh_interval":3600}]`)
+	before, after := lastRecord("idp.reconcile")
+	contains("updated before", before, `"corp"`, idp.URL+`/v1`, `"Authorization":"[REDACTED]"`)
+	if strings.Contains(before, `"hr"`) {
+		t.Errorf("created IDP in before %s", before)
+	}
+	contains("updated after", after, `"created":["hr"]`, `"updated":["corp"]`, idp.URL+`/v2`, idp.URL+`/hr`)
+
+	send(http.MethodPut, "/admin/state", `[{"name":"hr","url":"`+idp.URL+`/hr","refresh_interval":3600}]`)
+	before, after = lastRecord("idp.reconcile")
+	contains("deleted before", before, `"corp"`, idp.URL+`/v2`)
+	contains("deleted after", after, `"deleted":["corp"]`)
+
+	send(http.MethodPost, "/admin/idps/corp/restore", "")
+	before, after = lastRecord("idp.restore")
+	contains("restored before", before, `"removed_at"`, idp.URL+`/v2`)
+	contains("restored after", after, idp.URL+`/v2`)
+}
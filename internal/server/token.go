@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kiquetal/go-idp-caller/internal/token"
+)
+
+// SetTokenClients enables POST /token/{idp} for the given IDPs. It can be called again while
+// serving, when PUT /admin/state changes the IDPs.
+func (s *Server) SetTokenClients(clients map[string]*token.Client) {
+	s.tokens.Store(&clients)
+}
+
+// tokenClient returns the client of an IDP with client credentials
+func (s *Server) tokenClient(idp string) (*token.Client, bool) {
+	clients := s.tokens.Load()
+	if clients == nil {
+		return nil, false
+	}
+	client, ok := (*clients)[idp]
+	return client, ok
+}
+
+// handleToken returns a client_credentials access token for the IDP.
+// Every issued token is logged so this endpoint serves as an audited egress point.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	idpName := r.PathValue("idp")
+
+	client, ok := s.tokenClient(idpName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("IDP '%s' has no client credentials", idpName), http.StatusNotFound)
+		return
+	}
+
+	tok, cached, err := client.Token(r.Context())
+	if err != nil {
+		s.logger.Error("Token request failed", "idp", idpName, "client_ip", clientIP(r), "error", err)
+		http.Error(w, "Token request failed", http.StatusBadGateway)
+		return
+	}
+
+	s.logger.Info("Issued access token",
+		"idp", idpName,
+		"client_ip", clientIP(r),
+		"cached", cached,
+		"expires_in", tok.ExpiresIn,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := json.NewEncoder(w).Encode(tok); err != nil {
+		s.logger.Error("Failed to encode token response", "error", err, "idp", idpName)
+	}
+}
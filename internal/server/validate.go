@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kiquetal/go-idp-caller/internal/validate"
+	"github.com/kiquetal/go-idp-caller/pkg/verify"
+)
+
+// maxValidateRequestBytes bounds the body of POST /validate
+const maxValidateRequestBytes = 64 << 10
+
+// SetValidator enables POST /validate. It can be called again while serving, when PUT
+// /admin/state changes the IDPs.
+func (s *Server) SetValidator(v *validate.Validator) {
+	s.validator.Store(v)
+}
+
+// validateRequest is the body of POST /validate. A sender-constrained token comes with the DPoP
+// proof, method and URL of the resource request, or the client certificate it was made with.
+type validateRequest struct {
+	Token      string `json:"token"`
+	DPoP       string `json:"dpop"`
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	ClientCert string `json:"client_cert"` // PEM or base64 DER
+}
+
+// handleValidate verifies a token passed as bearer token or as {"token": "..."}.
+// The IDP is resolved from the token's iss claim; with ?audience= it must be one of the audience's IDPs.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	validator := s.validator.Load()
+	if validator == nil {
+		http.Error(w, "Validation is not configured", http.StatusNotFound)
+		return
+	}
+	aud, ok := s.requestAudience(w, r)
+	if !ok {
+		return
+	}
+
+	// A gateway forwarding the resource request passes the proof and request in headers,
+	// as nginx auth_request does; otherwise everything comes in the body
+	body := validateRequest{
+		DPoP:       r.Header.Get("DPoP"),
+		Method:     r.Header.Get("X-Original-Method"),
+		URL:        r.Header.Get("X-Original-URL"),
+		ClientCert: r.Header.Get("X-Client-Cert"),
+	}
+	authorization := r.Header.Get("Authorization")
+	raw, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		raw, ok = strings.CutPrefix(authorization, "DPoP ")
+	}
+	if ok {
+		body.Token = raw
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValidateRequestBytes)).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Token == "" {
+		http.Error(w, "Token required", http.StatusBadRequest)
+		return
+	}
+	presentation, err := presentationOf(body)
+	if err != nil {
+		http.Error(w, "Invalid client certificate: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := validator.ValidatePresented(body.Token, presentation)
+	if aud != "" {
+		result = s.checkAudience(result, aud)
+	}
+	if !result.Valid {
+		s.logger.Debug("Token rejected", "idp", result.IDP, "error", result.Error)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !result.Valid {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		s.logger.Error("Failed to encode validate response", "error", err)
+	}
+}
+
+// presentationOf collects what the client presented with its token
+func presentationOf(body validateRequest) (verify.Presentation, error) {
+	p := verify.Presentation{DPoP: body.DPoP, Method: body.Method, URL: body.URL}
+	if body.ClientCert == "" {
+		return p, nil
+	}
+	cert, err := parseClientCert(body.ClientCert)
+	if err != nil {
+		return p, err
+	}
+	p.ClientCert = cert
+	return p, nil
+}
+
+// parseClientCert reads a certificate as PEM, URL-escaped PEM (nginx $ssl_client_escaped_cert)
+// or base64 DER
+func parseClientCert(value string) (*x509.Certificate, error) {
+	if strings.Contains(value, "%") {
+		unescaped, err := url.QueryUnescape(value)
+		if err != nil {
+			return nil, err
+		}
+		value = unescaped
+	}
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.New("expected a CERTIFICATE PEM block")
+		}
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("neither PEM nor base64 DER")
+	}
+	return x509.ParseCertificate(der)
+}
+
+// handleValidateBatch validates {"tokens": [...]} in one round trip.
+// It always answers 200; per-token outcomes are in results, in request order.
+func (s *Server) handleValidateBatch(w http.ResponseWriter, r *http.Request) {
+	validator := s.validator.Load()
+	if validator == nil {
+		http.Error(w, "Validation is not configured", http.StatusNotFound)
+		return
+	}
+	aud, ok := s.requestAudience(w, r)
+	if !ok {
+		return
+	}
+
+	limit := validator.MaxBatchSize()
+	var body struct {
+		Tokens []string `json:"tokens"`
+	}
+	maxBytes := int64(limit) * maxValidateRequestBytes / 4
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes)).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Tokens) > limit {
+		http.Error(w, fmt.Sprintf("Too many tokens, at most %d per request", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := validator.ValidateBatch(body.Tokens)
+	valid := 0
+	for i, res := range results {
+		if aud != "" {
+			res = s.checkAudience(res, aud)
+			results[i] = res
+		}
+		if res.Valid {
+			valid++
+		}
+	}
+
+	response := map[string]any{
+		"results": results,
+		"valid":   valid,
+		"invalid": len(results) - valid,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode batch validate response", "error", err)
+	}
+}
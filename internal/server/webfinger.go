@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// issuerRel is the WebFinger link relation for OpenID Connect issuer discovery
+const issuerRel = "http://openid.net/specs/connect/1.0/issuer"
+
+// webfingerLink is one link of a JSON Resource Descriptor (RFC 7033)
+type webfingerLink struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
+}
+
+// webfingerResponse is the JSON Resource Descriptor returned by /.well-known/webfinger
+type webfingerResponse struct {
+	Subject string          `json:"subject"`
+	Links   []webfingerLink `json:"links"`
+}
+
+// handleWebFinger resolves an acct: or URL resource to the issuer of the matching IDP
+// (OpenID Connect Discovery 1.0, section 2)
+func (s *Server) handleWebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	domain := webfingerDomain(resource)
+	if domain == "" {
+		http.Error(w, "resource parameter required (acct: or URL)", http.StatusBadRequest)
+		return
+	}
+
+	var issuer string
+	ok := false
+	if validator := s.validator.Load(); validator != nil {
+		issuer, ok = validator.IssuerFor(domain)
+	}
+	if !ok {
+		http.Error(w, "No issuer for resource", http.StatusNotFound)
+		return
+	}
+
+	// rel filters the links; an unrelated rel yields an empty descriptor
+	response := webfingerResponse{Subject: resource, Links: []webfingerLink{}}
+	rels := r.URL.Query()["rel"]
+	if len(rels) == 0 || slices.Contains(rels, issuerRel) {
+		response.Links = append(response.Links, webfingerLink{Rel: issuerRel, Href: issuer})
+	}
+
+	w.Header().Set("Content-Type", "application/jrd+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("Failed to encode webfinger response", "error", err)
+	}
+}
+
+// webfingerDomain extracts the host of an acct:user@host or URL resource
+func webfingerDomain(resource string) string {
+	if acct, ok := strings.CutPrefix(resource, "acct:"); ok {
+		if i := strings.LastIndex(acct, "@"); i >= 0 {
+			return acct[i+1:]
+		}
+		return ""
+	}
+	u, err := url.Parse(resource)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
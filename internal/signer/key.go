@@ -0,0 +1,265 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/awsauth"
+)
+
+// maxKMSResponseBytes bounds KMS responses, which hold at most a public key or a signature
+const maxKMSResponseBytes = 64 << 10
+
+// Key signs JWS signing inputs with one algorithm
+type Key interface {
+	Alg() string
+	Public() crypto.PublicKey
+	Sign(ctx context.Context, signingInput []byte) ([]byte, error)
+}
+
+// fileKey is a private key loaded from a PEM file
+type fileKey struct {
+	signer crypto.Signer
+	alg    string
+	hash   crypto.Hash
+}
+
+// loadFileKey reads a PEM private key and picks the algorithm from its type
+func loadFileKey(path string) (*fileKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	alg, hash, err := algorithm(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &fileKey{signer: signer, alg: alg, hash: hash}, nil
+}
+
+func (k *fileKey) Alg() string              { return k.alg }
+func (k *fileKey) Public() crypto.PublicKey { return k.signer.Public() }
+
+func (k *fileKey) Sign(_ context.Context, signingInput []byte) ([]byte, error) {
+	// Ed25519 signs the message itself
+	if k.hash == 0 {
+		return k.signer.Sign(rand.Reader, signingInput, crypto.Hash(0))
+	}
+
+	h := k.hash.New()
+	h.Write(signingInput)
+	sig, err := k.signer.Sign(rand.Reader, h.Sum(nil), k.hash)
+	if err != nil {
+		return nil, err
+	}
+	if pub, ok := k.signer.Public().(*ecdsa.PublicKey); ok {
+		return ecdsaRaw(sig, pub)
+	}
+	return sig, nil
+}
+
+// kmsKey signs through the AWS KMS Sign API, the private key never leaves KMS
+type kmsKey struct {
+	client   *http.Client
+	endpoint string
+	region   string
+	keyID    string
+	alg      string
+	hash     crypto.Hash
+	kmsAlg   string
+	public   crypto.PublicKey
+}
+
+// loadKMSKey fetches the public key of an AWS KMS key and picks the matching algorithm
+func loadKMSKey(ctx context.Context, keyID, region string) (*kmsKey, error) {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	k := &kmsKey{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: fmt.Sprintf("https://kms.%s.amazonaws.com/", region),
+		region:   region,
+		keyID:    keyID,
+	}
+
+	var resp struct {
+		PublicKey string
+		KeyUsage  string
+	}
+	if err := k.call(ctx, "GetPublicKey", map[string]string{"KeyId": keyID}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.KeyUsage != "SIGN_VERIFY" {
+		return nil, fmt.Errorf("kms key %s is not a signing key", keyID)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	k.public = pub
+
+	k.alg, k.hash, err = algorithm(pub)
+	if err != nil {
+		return nil, err
+	}
+	switch k.alg {
+	case "RS256":
+		k.kmsAlg = "RSASSA_PKCS1_V1_5_SHA_256"
+	case "ES256", "ES384", "ES512":
+		k.kmsAlg = "ECDSA_SHA_" + k.alg[2:]
+	default:
+		return nil, fmt.Errorf("kms key %s: unsupported algorithm %s", keyID, k.alg)
+	}
+	return k, nil
+}
+
+func (k *kmsKey) Alg() string              { return k.alg }
+func (k *kmsKey) Public() crypto.PublicKey { return k.public }
+
+func (k *kmsKey) Sign(ctx context.Context, signingInput []byte) ([]byte, error) {
+	h := k.hash.New()
+	h.Write(signingInput)
+
+	var resp struct {
+		Signature string
+	}
+	req := map[string]string{
+		"KeyId":            k.keyID,
+		"Message":          base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": k.kmsAlg,
+	}
+	if err := k.call(ctx, "Sign", req, &resp); err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(resp.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if pub, ok := k.public.(*ecdsa.PublicKey); ok {
+		return ecdsaRaw(sig, pub)
+	}
+	return sig, nil
+}
+
+// call performs a signed KMS JSON API request
+func (k *kmsKey) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	if err := awsauth.Sign(req, body, k.region, "kms", time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms %s failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kms %s: unexpected status code %d: %s", action, resp.StatusCode, string(msg))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKMSResponseBytes+1))
+	if err != nil {
+		return fmt.Errorf("kms %s: failed to read response: %w", action, err)
+	}
+	if len(data) > maxKMSResponseBytes {
+		return fmt.Errorf("kms %s: response exceeds limit of %d bytes", action, maxKMSResponseBytes)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("kms %s: failed to parse response: %w", action, err)
+	}
+	return nil
+}
+
+// algorithm returns the JWS algorithm and digest used for a public key
+func algorithm(pub crypto.PublicKey) (string, crypto.Hash, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			return "ES256", crypto.SHA256, nil
+		case 384:
+			return "ES384", crypto.SHA384, nil
+		case 521:
+			return "ES512", crypto.SHA512, nil
+		}
+		return "", 0, fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "EdDSA", 0, nil
+	}
+	return "", 0, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// ecdsaRaw converts an ASN.1 ECDSA signature into the fixed size r||s form JWS requires
+func ecdsaRaw(der []byte, pub *ecdsa.PublicKey) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("invalid ECDSA signature: %w", err)
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
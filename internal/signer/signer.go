@@ -0,0 +1,172 @@
+// Package signer mints short-lived JWTs whose verification keys are published with the IDP keys
+package signer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+var (
+	// ErrInvalidRequest is returned by Mint for requests it refuses, the message says why
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrSigningFailed is returned by Mint when the key, e.g. in KMS, failed to sign the token
+	ErrSigningFailed = errors.New("signing failed")
+)
+
+// reservedClaims are always set by the signer and can't be overridden by requests
+var reservedClaims = []string{"iss", "sub", "aud", "iat", "nbf", "exp", "jti"}
+
+// Request describes the token a caller wants minted
+type Request struct {
+	Subject  string         `json:"sub"`
+	Audience string         `json:"aud,omitempty"`
+	TTL      int            `json:"ttl,omitempty"` // seconds, capped at max_ttl
+	Claims   map[string]any `json:"claims,omitempty"`
+}
+
+// Signer mints JWTs with the first configured key
+type Signer struct {
+	config config.SigningConfig
+	keys   []Key
+	jwks   *jwks.JWKS
+}
+
+// New loads every configured key; KMS keys are looked up through the API
+func New(ctx context.Context, cfg config.SigningConfig) (*Signer, error) {
+	s := &Signer{config: cfg, jwks: &jwks.JWKS{}}
+
+	for i, kc := range cfg.Keys {
+		var key Key
+		var err error
+		if kc.File != "" {
+			key, err = loadFileKey(kc.File)
+		} else {
+			key, err = loadKMSKey(ctx, kc.KMSKey, kc.Region)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+
+		jwk, err := jwks.PublicKeyJWK(key.Public(), key.Alg())
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		s.keys = append(s.keys, key)
+		s.jwks.Keys = append(s.jwks.Keys, jwk)
+	}
+	return s, nil
+}
+
+// Name returns the entry name the public keys are published under
+func (s *Signer) Name() string {
+	return s.config.GetName()
+}
+
+// JWKS returns the public keys of every configured key
+func (s *Signer) JWKS() *jwks.JWKS {
+	return s.jwks
+}
+
+// Mint creates a signed JWT for the request and returns it with its expiry
+func (s *Signer) Mint(ctx context.Context, req Request) (string, time.Time, error) {
+	if req.Subject == "" {
+		return "", time.Time{}, fmt.Errorf("%w: sub is required", ErrInvalidRequest)
+	}
+
+	ttl := s.config.GetTTL()
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl > s.config.GetMaxTTL() {
+		return "", time.Time{}, fmt.Errorf("%w: ttl exceeds max_ttl of %d seconds", ErrInvalidRequest, int(s.config.GetMaxTTL().Seconds()))
+	}
+
+	audience := req.Audience
+	if audience == "" {
+		audience = s.config.Audience
+	}
+
+	claims := make(map[string]any)
+	for k, v := range s.config.Claims {
+		claims[k] = expand(v, req, audience)
+	}
+	for k, v := range req.Claims {
+		claims[k] = v
+	}
+	for _, k := range reservedClaims {
+		delete(claims, k)
+	}
+
+	now := time.Now()
+	expiry := now.Add(ttl)
+	claims["iss"] = s.config.Issuer
+	claims["sub"] = req.Subject
+	if audience != "" {
+		claims["aud"] = audience
+	}
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = expiry.Unix()
+	claims["jti"] = newJTI()
+
+	key := s.keys[0]
+	header := map[string]string{
+		"alg": key.Alg(),
+		"kid": s.jwks.Keys[0].Kid,
+		"typ": "JWT",
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid claims: %w", err)
+	}
+
+	b64 := base64.RawURLEncoding
+	signingInput := b64.EncodeToString(headerJSON) + "." + b64.EncodeToString(claimsJSON)
+	sig, err := key.Sign(ctx, []byte(signingInput))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: %w", ErrSigningFailed, err)
+	}
+
+	return signingInput + "." + b64.EncodeToString(sig), expiry, nil
+}
+
+// expand replaces ${sub}, ${aud} and ${claim} references in string claim templates
+func expand(v any, req Request, audience string) any {
+	tmpl, ok := v.(string)
+	if !ok {
+		return v
+	}
+	return os.Expand(tmpl, func(name string) string {
+		switch name {
+		case "sub":
+			return req.Subject
+		case "aud":
+			return audience
+		}
+		if value, ok := req.Claims[name]; ok {
+			return fmt.Sprint(value)
+		}
+		return ""
+	})
+}
+
+func newJTI() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
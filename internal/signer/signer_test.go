@@ -0,0 +1,279 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/pkg/verify"
+)
+
+// keyFile writes key as a PKCS#8 PEM file and returns its path
+func keyFile(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newSigner(t *testing.T, key crypto.Signer, cfg config.SigningConfig) *Signer {
+	t.Helper()
+	cfg.Keys = []config.SigningKeyConfig{{File: keyFile(t, key)}}
+	s, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMintVerifies(t *testing.T) {
+	ec256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	ec521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		alg     string
+		key     crypto.Signer
+		sigSize int
+	}{
+		{"ES256", ec256, 64},
+		{"ES384", ec384, 96},
+		{"ES512", ec521, 132},
+		{"RS256", rsaKey, 256},
+		{"EdDSA", edKey, ed25519.SignatureSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			s := newSigner(t, tt.key, config.SigningConfig{Issuer: "https://idp-caller.internal", Audience: "orders-api"})
+			raw, _, err := s.Mint(context.Background(), Request{Subject: "billing-job"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parts := strings.Split(raw, ".")
+			sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			// ECDSA signatures are the fixed size r||s of JWS, not ASN.1
+			if len(sig) != tt.sigSize {
+				t.Fatalf("signature is %d bytes, want %d", len(sig), tt.sigSize)
+			}
+
+			tok, err := verify.ParseUnverified(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tok.Alg != tt.alg || tok.Kid != s.JWKS().Keys[0].Kid {
+				t.Fatalf("header alg %q kid %q, want %q %q", tok.Alg, tok.Kid, tt.alg, s.JWKS().Keys[0].Kid)
+			}
+			claims, err := verify.Verify(raw, verify.KeysFromJWKS(s.JWKS()), verify.Policy{
+				Issuer:    "https://idp-caller.internal",
+				Audiences: []string{"orders-api"},
+			})
+			if err != nil {
+				t.Fatalf("minted token doesn't verify: %v", err)
+			}
+			if claims["sub"] != "billing-job" {
+				t.Fatalf("sub = %v", claims["sub"])
+			}
+		})
+	}
+}
+
+func TestMintClaims(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s := newSigner(t, key, config.SigningConfig{
+		Issuer:   "https://idp-caller.internal",
+		Audience: "orders-api",
+		TTL:      120,
+		MaxTTL:   600,
+		Claims:   map[string]any{"scope": "svc:${sub}:${tenant}", "env": "prod", "iss": "https://config.example"},
+	})
+
+	raw, expiry, err := s.Mint(context.Background(), Request{
+		Subject:  "billing-job",
+		Audience: "ledger-api",
+		TTL:      300,
+		Claims: map[string]any{
+			"tenant": "acme",
+			"env":    "staging",
+			"iss":    "https://evil.example",
+			"sub":    "admin",
+			"aud":    "everything",
+			"exp":    4102444800,
+			"jti":    "fixed",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verify.Verify(raw, verify.KeysFromJWKS(s.JWKS()), verify.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"iss":    "https://idp-caller.internal",
+		"sub":    "billing-job",
+		"aud":    "ledger-api",
+		"scope":  "svc:billing-job:acme",
+		"env":    "staging",
+		"tenant": "acme",
+	}
+	for name, value := range want {
+		if claims[name] != value {
+			t.Errorf("%s = %v, want %v", name, claims[name], value)
+		}
+	}
+	if claims["jti"] == "fixed" || claims["jti"] == "" {
+		t.Errorf("jti = %v, want a generated one", claims["jti"])
+	}
+
+	iat, _ := claims["iat"].(json.Number).Int64()
+	nbf, _ := claims["nbf"].(json.Number).Int64()
+	exp, _ := claims["exp"].(json.Number).Int64()
+	if nbf != iat || exp-iat != 300 {
+		t.Errorf("iat %d nbf %d exp %d, want nbf = iat and a 300 second lifetime", iat, nbf, exp)
+	}
+	if exp != expiry.Unix() {
+		t.Errorf("exp %d, Mint returned expiry %d", exp, expiry.Unix())
+	}
+	if d := time.Until(expiry); d < 290*time.Second || d > 300*time.Second {
+		t.Errorf("expiry in %v, want about 300s", d)
+	}
+
+	// Without a requested TTL and audience the configured ones apply
+	raw, _, err = s.Mint(context.Background(), Request{Subject: "billing-job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = verify.Verify(raw, verify.KeysFromJWKS(s.JWKS()), verify.Policy{Audiences: []string{"orders-api"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iat, _ = claims["iat"].(json.Number).Int64()
+	exp, _ = claims["exp"].(json.Number).Int64()
+	if exp-iat != 120 {
+		t.Errorf("default lifetime %d seconds, want 120", exp-iat)
+	}
+}
+
+func TestMintRefusesInvalidRequests(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s := newSigner(t, key, config.SigningConfig{MaxTTL: 600})
+
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"missing sub", Request{TTL: 60}, "sub is required"},
+		{"ttl above max", Request{Subject: "job", TTL: 601}, "ttl exceeds max_ttl of 600 seconds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := s.Mint(context.Background(), tt.req)
+			if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Mint() error = %v, want ErrInvalidRequest with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// failingKey stands in for a KMS key that can't be reached
+type failingKey struct{ Key }
+
+func (failingKey) Sign(context.Context, []byte) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestMintSigningFailure(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s := newSigner(t, key, config.SigningConfig{})
+	s.keys[0] = failingKey{s.keys[0]}
+
+	_, _, err := s.Mint(context.Background(), Request{Subject: "job"})
+	if !errors.Is(err, ErrSigningFailed) || errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("Mint() error = %v, want ErrSigningFailed", err)
+	}
+}
+
+func TestKMSResponseLimit(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"within limit", `{"Signature":"` + strings.Repeat("A", 1024) + `"}`, ""},
+		{"oversized", `{"Signature":"` + strings.Repeat("A", maxKMSResponseBytes) + `"}`, "exceeds limit"},
+		{"endless", "", "exceeds limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.body == "" {
+					io.Copy(w, io.LimitReader(infinite{}, 16*maxKMSResponseBytes))
+					return
+				}
+				io.WriteString(w, tt.body)
+			}))
+			defer upstream.Close()
+
+			k := &kmsKey{client: upstream.Client(), endpoint: upstream.URL, region: "us-east-1", keyID: "alias/signer"}
+			var resp struct {
+				Signature string
+			}
+			err := k.call(context.Background(), "Sign", map[string]string{"KeyId": k.keyID}, &resp)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("call() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// infinite reads as endless JSON whitespace, which a decoder keeps consuming
+type infinite struct{}
+
+func (infinite) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	return len(p), nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/awsauth"
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// dynamoPartition is the partition holding one item per IDP, sorted by IDP name,
+// so a single Query reads every key set
+const dynamoPartition = "jwks"
+
+// dynamoDB stores snapshots in a single table keyed by pk and sk. Saves are conditional puts
+// that only replace an older version. Credentials come from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type dynamoDB struct {
+	client   *http.Client
+	region   string
+	endpoint string
+	table    string
+}
+
+func newDynamoDB(cfg config.DynamoDBConfig) *dynamoDB {
+	region := cfg.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://dynamodb.%s.amazonaws.com/", region)
+	}
+	return &dynamoDB{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		region:   region,
+		endpoint: endpoint,
+		table:    cfg.Table,
+	}
+}
+
+func (d *dynamoDB) Name() string {
+	return config.StorageDynamoDB
+}
+
+// dynamoItem is a snapshot in the DynamoDB attribute value format
+type dynamoItem map[string]map[string]string
+
+func (d *dynamoDB) Save(ctx context.Context, s Snapshot) error {
+	keys, err := json.Marshal(s.JWKS)
+	if err != nil {
+		return err
+	}
+	in := map[string]any{
+		"TableName": d.table,
+		"Item": dynamoItem{
+			"pk":             {"S": dynamoPartition},
+			"sk":             {"S": s.IDP},
+			"version":        {"N": strconv.FormatInt(s.Version, 10)},
+			"updated_at":     {"S": s.UpdatedAt.UTC().Format(time.RFC3339Nano)},
+			"cache_duration": {"N": strconv.Itoa(s.CacheDuration)},
+			"writer":         {"S": s.Writer},
+			"jwks":           {"S": string(keys)},
+		},
+		"ConditionExpression":       "attribute_not_exists(pk) OR version < :version",
+		"ExpressionAttributeValues": dynamoItem{":version": {"N": strconv.FormatInt(s.Version, 10)}},
+	}
+	return d.call(ctx, "PutItem", in, nil)
+}
+
+func (d *dynamoDB) Load(ctx context.Context) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	var start dynamoItem
+	for {
+		in := map[string]any{
+			"TableName":                 d.table,
+			"KeyConditionExpression":    "pk = :pk",
+			"ExpressionAttributeValues": dynamoItem{":pk": {"S": dynamoPartition}},
+			"ConsistentRead":            true,
+		}
+		if start != nil {
+			in["ExclusiveStartKey"] = start
+		}
+		var out struct {
+			Items            []dynamoItem
+			LastEvaluatedKey dynamoItem
+		}
+		if err := d.call(ctx, "Query", in, &out); err != nil {
+			return nil, err
+		}
+
+		for _, item := range out.Items {
+			s, err := item.snapshot()
+			if err != nil {
+				return nil, fmt.Errorf("item %q: %w", item["sk"]["S"], err)
+			}
+			snapshots = append(snapshots, s)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return snapshots, nil
+		}
+		start = out.LastEvaluatedKey
+	}
+}
+
+func (d *dynamoDB) Close() error {
+	return nil
+}
+
+// snapshot decodes an item written by Save
+func (item dynamoItem) snapshot() (Snapshot, error) {
+	s := Snapshot{IDP: item["sk"]["S"], Writer: item["writer"]["S"]}
+	var err error
+	if s.Version, err = strconv.ParseInt(item["version"]["N"], 10, 64); err != nil {
+		return s, fmt.Errorf("invalid version: %w", err)
+	}
+	if s.CacheDuration, err = strconv.Atoi(item["cache_duration"]["N"]); err != nil {
+		return s, fmt.Errorf("invalid cache_duration: %w", err)
+	}
+	if s.UpdatedAt, err = time.Parse(time.RFC3339Nano, item["updated_at"]["S"]); err != nil {
+		return s, fmt.Errorf("invalid updated_at: %w", err)
+	}
+	var keySet jwks.JWKS
+	if err := json.Unmarshal([]byte(item["jwks"]["S"]), &keySet); err != nil {
+		return s, fmt.Errorf("invalid jwks: %w", err)
+	}
+	s.JWKS = &keySet
+	return s, nil
+}
+
+// call performs a signed DynamoDB JSON API request, mapping a failed put condition to ErrConflict
+func (d *dynamoDB) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+action)
+	if err := awsauth.Sign(req, body, d.region, "dynamodb", time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("dynamodb %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if json.Unmarshal(msg, &apiErr) == nil && strings.HasSuffix(apiErr.Type, "#ConditionalCheckFailedException") {
+			return ErrConflict
+		}
+		return fmt.Errorf("dynamodb %s: unexpected status code %d: %s", action, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("dynamodb %s: invalid response: %w", action, err)
+	}
+	return nil
+}
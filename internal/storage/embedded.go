@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/audit"
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/internal/kv"
+)
+
+// Key prefixes of the embedded store. Fetch and audit keys end in zero-padded unix nanoseconds,
+// so key order is time order.
+const (
+	embeddedJWKS  = "jwks/"  // + IDP name: a Snapshot
+	embeddedFetch = "fetch/" // + IDP name + "/" + time: a storedFetch
+	embeddedAudit = "audit/" // + time + "-" + sequence: an audit.Record
+)
+
+// Fetches are kept as long as the SLO report looks back, at most as many per IDP
+const (
+	fetchRetention = 30 * 24 * time.Hour
+	maxFetches     = 50000
+)
+
+// embeddedStats publishes the state of the open embedded store
+var embeddedStats = expvar.NewMap("embedded_storage")
+
+// storedFetch is a fetch outcome as stored
+type storedFetch struct {
+	Time      time.Time `json:"time"`
+	OK        bool      `json:"ok"`
+	LatencyMs int64     `json:"latency_ms"`
+}
+
+// Embedded is the storage of single-node deployments: a key-value store in a local directory holding the
+// key sets, the fetch history behind the SLO report and the audit log, so all of them survive restarts
+type Embedded struct {
+	db     *kv.DB
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	fetches  map[string][]string // stored fetch keys per IDP, oldest first
+	audits   []string            // stored audit keys, oldest first
+	retain   int                 // audit records kept
+	sequence int                 // distinguishes audit records of the same nanosecond
+}
+
+func newEmbedded(dir string, logger *slog.Logger) (*Embedded, error) {
+	db, err := kv.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	e := &Embedded{db: db, logger: logger, fetches: make(map[string][]string)}
+	if recovered := db.Stats().Recovered; recovered > 0 {
+		logger.Warn("Dropped the incomplete end of the embedded store, the last writes before a crash may be lost",
+			"directory", dir, "bytes", recovered)
+	}
+	embeddedStats.Set("log", expvar.Func(func() any { return db.Stats() }))
+	return e, nil
+}
+
+func (e *Embedded) Name() string {
+	return config.StorageEmbedded
+}
+
+// Save stores the snapshot unless the same or a newer version is stored
+func (e *Embedded) Save(ctx context.Context, s Snapshot) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if data, ok := e.db.Get(embeddedJWKS + s.IDP); ok {
+		var stored Snapshot
+		if json.Unmarshal(data, &stored) == nil && stored.Version >= s.Version {
+			return ErrConflict
+		}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return e.db.Put(embeddedJWKS+s.IDP, data)
+}
+
+func (e *Embedded) Load(ctx context.Context) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	var err error
+	e.db.Scan(embeddedJWKS, func(key string, value []byte) {
+		var s Snapshot
+		if jsonErr := json.Unmarshal(value, &s); jsonErr != nil && err == nil {
+			err = fmt.Errorf("%s: %w", key, jsonErr)
+		}
+		snapshots = append(snapshots, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// RecordFetches restores the stored fetch outcomes into the SLO history of the manager and stores the
+// new ones. Must be called before the updaters start.
+func (e *Embedded) RecordFetches(manager *jwks.Manager) {
+	cutoff := time.Now().Add(-fetchRetention)
+	var expired kv.Batch
+	e.db.Scan(embeddedFetch, func(key string, value []byte) {
+		idp, _, _ := strings.Cut(strings.TrimPrefix(key, embeddedFetch), "/")
+		var f storedFetch
+		if json.Unmarshal(value, &f) != nil || f.Time.Before(cutoff) {
+			expired.Delete(key)
+			return
+		}
+		manager.RecordFetch(idp, f.OK, time.Duration(f.LatencyMs)*time.Millisecond, f.Time)
+		e.fetches[idp] = append(e.fetches[idp], key)
+	})
+	if err := e.db.Write(&expired); err != nil {
+		e.logger.Warn("Failed to remove expired fetches", "error", err)
+	}
+	manager.OnFetch(e.saveFetch)
+}
+
+// saveFetch stores a fetch outcome and removes the ones past retention
+func (e *Embedded) saveFetch(f jwks.Fetch) {
+	data, err := json.Marshal(storedFetch{Time: f.Time, OK: f.OK, LatencyMs: f.Latency.Milliseconds()})
+	if err != nil {
+		return
+	}
+	key := fmt.Sprintf("%s%s/%020d", embeddedFetch, f.IDP, f.Time.UnixNano())
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var b kv.Batch
+	b.Put(key, data)
+	keys := append(e.fetches[f.IDP], key)
+	cutoff := fmt.Sprintf("%s%s/%020d", embeddedFetch, f.IDP, f.Time.Add(-fetchRetention).UnixNano())
+	for len(keys) > maxFetches || (len(keys) > 0 && keys[0] < cutoff) {
+		b.Delete(keys[0])
+		keys = keys[1:]
+	}
+	if err := e.db.Write(&b); err != nil {
+		e.logger.Error("Failed to store fetch", "idp", f.IDP, "error", err)
+		return
+	}
+	e.fetches[f.IDP] = keys
+}
+
+// RecordAudit stores the records of the audit log, keeping as many as it retains. The stored records
+// are restored into the log first unless restore is false, when the log was loaded from its own file.
+// Must be called before records are added.
+func (e *Embedded) RecordAudit(log *audit.Log, restore bool) {
+	e.retain = log.Retain()
+	var records []audit.Record
+	e.db.Scan(embeddedAudit, func(key string, value []byte) {
+		var rec audit.Record
+		if json.Unmarshal(value, &rec) == nil {
+			records = append(records, rec)
+		}
+		e.audits = append(e.audits, key)
+	})
+	if restore {
+		log.Restore(records)
+	}
+	log.OnRecord(e.saveAudit)
+}
+
+// saveAudit stores an audit record and removes the oldest beyond retain
+func (e *Embedded) saveAudit(rec audit.Record) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sequence++
+	key := fmt.Sprintf("%s%020d-%06d", embeddedAudit, rec.Time.UnixNano(), e.sequence%1000000)
+	var b kv.Batch
+	b.Put(key, data)
+	keys := append(e.audits, key)
+	for len(keys) > e.retain {
+		b.Delete(keys[0])
+		keys = keys[1:]
+	}
+	if err := e.db.Write(&b); err != nil {
+		e.logger.Error("Failed to store audit record", "action", rec.Action, "error", err)
+		return
+	}
+	e.audits = keys
+}
+
+func (e *Embedded) Close() error {
+	return e.db.Close()
+}
@@ -0,0 +1,484 @@
+package storage
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal PostgreSQL frontend, protocol 3.0: TLS, cleartext, MD5 and SCRAM-SHA-256 authentication,
+// the extended query protocol with text parameters and results, and asynchronous notifications
+
+const (
+	pgProtocol   = 3 << 16
+	pgSSLRequest = 80877103
+	pgTimeout    = 10 * time.Second
+	pgMaxMessage = 64 << 20
+)
+
+// pgError is an ErrorResponse from the server; the connection stays usable
+type pgError struct {
+	Code    string
+	Message string
+}
+
+func (e *pgError) Error() string {
+	return fmt.Sprintf("postgres: %s (SQLSTATE %s)", e.Message, e.Code)
+}
+
+// pgConn is one connection to the server, not safe for concurrent use
+type pgConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	pid  int32 // backend process, identifies the sender of notifications
+
+	// notify is called for notifications received on this connection, nil ignores them
+	notify func(channel, payload string, pid int32)
+}
+
+// pgDial connects and authenticates with a postgres:// URL. A non-empty password overrides the one in the URL.
+// sslmode is disable, prefer (default), require or verify-full; like libpq, only verify-full checks the certificate.
+func pgDial(ctx context.Context, rawURL, password string) (*pgConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: invalid url")
+	}
+	user := u.User.Username()
+	if password == "" {
+		password, _ = u.User.Password()
+	}
+	database := cmp.Or(strings.TrimPrefix(u.Path, "/"), user)
+	mode := cmp.Or(u.Query().Get("sslmode"), "prefer")
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pgTimeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), cmp.Or(u.Port(), "5432")))
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if mode != "disable" {
+		if conn, err = pgStartTLS(ctx, conn, u.Hostname(), mode); err != nil {
+			return nil, err
+		}
+	}
+	c := &pgConn{conn: conn, r: bufio.NewReader(conn)}
+	if err := c.startup(user, database, password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// pgStartTLS asks the server for TLS and performs the handshake, closing conn on failure
+func pgStartTLS(ctx context.Context, conn net.Conn, host, mode string) (net.Conn, error) {
+	request := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), pgSSLRequest)
+	var answer [1]byte
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	if _, err := io.ReadFull(conn, answer[:]); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+
+	switch {
+	case answer[0] == 'N' && mode == "prefer":
+		return conn, nil
+	case answer[0] != 'S':
+		conn.Close()
+		return nil, fmt.Errorf("postgres: the server does not support TLS (sslmode %s)", mode)
+	}
+	cfg := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12, InsecureSkipVerify: mode != "verify-full"}
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("postgres: tls: %w", err)
+	}
+	return tc, nil
+}
+
+// startup sends the startup message and answers the authentication requests until the server is ready
+func (c *pgConn) startup(user, database, password string) error {
+	m := binary.BigEndian.AppendUint32(make([]byte, 4), pgProtocol)
+	for _, param := range [][2]string{{"user", user}, {"database", database}, {"application_name", "idp-caller"}} {
+		m = pgString(pgString(m, param[0]), param[1])
+	}
+	m = append(m, 0)
+	binary.BigEndian.PutUint32(m, uint32(len(m)))
+	if _, err := c.conn.Write(m); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	var scram *scramClient
+	for {
+		typ, body, err := c.receive()
+		if err != nil {
+			return err
+		}
+		r := pgReader(body)
+		switch typ {
+		case 'R':
+			if err := c.authenticate(&r, user, password, &scram); err != nil {
+				return err
+			}
+		case 'K':
+			c.pid = r.int32()
+		case 'E':
+			return parsePGError(body)
+		case 'Z':
+			if scram != nil && !scram.verified {
+				return fmt.Errorf("postgres: the server did not complete SCRAM authentication")
+			}
+			return nil
+		}
+	}
+}
+
+// authenticate answers one authentication request
+func (c *pgConn) authenticate(r *pgReader, user, password string, scram **scramClient) error {
+	code := r.int32()
+	if code != 0 && password == "" {
+		return fmt.Errorf("postgres: the server requires a password")
+	}
+	switch code {
+	case 0: // AuthenticationOk
+		return nil
+	case 3: // cleartext
+		return c.send(pgString(pgMsg('p'), password))
+	case 5: // MD5 with a 4 byte salt
+		inner := md5.Sum([]byte(password + user))
+		outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), r.bytes(4)...))
+		return c.send(pgString(pgMsg('p'), "md5"+hex.EncodeToString(outer[:])))
+	case 10: // SASL, the mechanisms follow
+		var mechanisms []string
+		for m := r.string(); m != ""; m = r.string() {
+			mechanisms = append(mechanisms, m)
+		}
+		if !slices.Contains(mechanisms, "SCRAM-SHA-256") {
+			return fmt.Errorf("postgres: unsupported SASL mechanisms %v", mechanisms)
+		}
+		*scram = &scramClient{password: password}
+		first, err := (*scram).first()
+		if err != nil {
+			return err
+		}
+		m := pgString(pgMsg('p'), "SCRAM-SHA-256")
+		m = append(binary.BigEndian.AppendUint32(m, uint32(len(first))), first...)
+		return c.send(m)
+	case 11: // SASL continue
+		if *scram == nil {
+			return fmt.Errorf("postgres: unexpected SASL message")
+		}
+		final, err := (*scram).final(string(r.rest()))
+		if err != nil {
+			return err
+		}
+		return c.send(append(pgMsg('p'), final...))
+	case 12: // SASL final
+		if *scram == nil {
+			return fmt.Errorf("postgres: unexpected SASL message")
+		}
+		return (*scram).verify(string(r.rest()))
+	}
+	return fmt.Errorf("postgres: unsupported authentication method %d", code)
+}
+
+// query runs one statement with text parameters and returns its rows as text, NULL as empty strings.
+// Notifications arriving meanwhile are passed to notify.
+func (c *pgConn) query(ctx context.Context, sql string, args ...string) ([][]string, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(pgTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	bind := pgString(pgString(pgMsg('B'), ""), "")
+	bind = binary.BigEndian.AppendUint16(bind, 0) // all parameters in text format
+	bind = binary.BigEndian.AppendUint16(bind, uint16(len(args)))
+	for _, arg := range args {
+		bind = append(binary.BigEndian.AppendUint32(bind, uint32(len(arg))), arg...)
+	}
+	bind = binary.BigEndian.AppendUint16(bind, 0) // all results in text format
+	err := c.send(
+		binary.BigEndian.AppendUint16(pgString(pgString(pgMsg('P'), ""), sql), 0),
+		bind,
+		pgString(append(pgMsg('D'), 'P'), ""),
+		binary.BigEndian.AppendUint32(pgString(pgMsg('E'), ""), 0),
+		pgMsg('S'),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	var queryErr error
+	for {
+		typ, body, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		r := pgReader(body)
+		switch typ {
+		case 'D':
+			row := make([]string, r.int16())
+			for i := range row {
+				if n := r.int32(); n > 0 {
+					row[i] = string(r.bytes(int(n)))
+				}
+			}
+			rows = append(rows, row)
+		case 'E':
+			queryErr = parsePGError(body)
+		case 'A':
+			c.notification(&r)
+		case 'Z':
+			return rows, queryErr
+		}
+	}
+}
+
+// wait passes notifications to notify until ctx is done or the connection fails
+func (c *pgConn) wait(ctx context.Context) error {
+	c.conn.SetDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	for {
+		typ, body, err := c.receive()
+		if err != nil {
+			return err
+		}
+		r := pgReader(body)
+		switch typ {
+		case 'A':
+			c.notification(&r)
+		case 'E':
+			return parsePGError(body)
+		}
+	}
+}
+
+func (c *pgConn) notification(r *pgReader) {
+	pid := r.int32()
+	channel := r.string()
+	payload := r.string()
+	if c.notify != nil {
+		c.notify(channel, payload, pid)
+	}
+}
+
+// Close terminates the session
+func (c *pgConn) Close() error {
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.send(pgMsg('X'))
+	return c.conn.Close()
+}
+
+// send writes messages started with pgMsg, filling in their lengths
+func (c *pgConn) send(msgs ...[]byte) error {
+	var out []byte
+	for _, m := range msgs {
+		binary.BigEndian.PutUint32(m[1:5], uint32(len(m)-1))
+		out = append(out, m...)
+	}
+	if _, err := c.conn.Write(out); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	return nil
+}
+
+// receive reads one message, skipping notices and parameter changes
+func (c *pgConn) receive() (byte, []byte, error) {
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return 0, nil, fmt.Errorf("postgres: %w", err)
+		}
+		n := int(binary.BigEndian.Uint32(header[1:])) - 4
+		if n < 0 || n > pgMaxMessage {
+			return 0, nil, fmt.Errorf("postgres: invalid message length %d", n)
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return 0, nil, fmt.Errorf("postgres: %w", err)
+		}
+		if header[0] != 'N' && header[0] != 'S' {
+			return header[0], body, nil
+		}
+	}
+}
+
+// pgMsg starts a message of the given type, send fills in the length
+func pgMsg(typ byte) []byte {
+	return []byte{typ, 0, 0, 0, 0}
+}
+
+func pgString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+// parsePGError reads the severity, code and message fields of an ErrorResponse
+func parsePGError(body []byte) error {
+	e := &pgError{}
+	r := pgReader(body)
+	for field := r.byte(); field != 0; field = r.byte() {
+		value := r.string()
+		switch field {
+		case 'C':
+			e.Code = value
+		case 'M':
+			e.Message = value
+		}
+	}
+	return e
+}
+
+// pgReader decodes message fields, returning zero values past the end
+type pgReader []byte
+
+func (r *pgReader) bytes(n int) []byte {
+	if n > len(*r) {
+		n = len(*r)
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b
+}
+
+func (r *pgReader) byte() byte {
+	if b := r.bytes(1); len(b) == 1 {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *pgReader) int16() int16 {
+	if b := r.bytes(2); len(b) == 2 {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *pgReader) int32() int32 {
+	if b := r.bytes(4); len(b) == 4 {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *pgReader) string() string {
+	i := slices.Index(*r, 0)
+	if i < 0 {
+		return string(r.bytes(len(*r)))
+	}
+	s := string((*r)[:i])
+	*r = (*r)[i+1:]
+	return s
+}
+
+func (r *pgReader) rest() []byte {
+	return r.bytes(len(*r))
+}
+
+// scramClient performs SCRAM-SHA-256 (RFC 7677) without channel binding. The user name is
+// left empty as the server takes it from the startup message.
+type scramClient struct {
+	password        string
+	clientFirstBare string
+	nonce           string
+	saltedPassword  []byte
+	authMessage     string
+	verified        bool
+}
+
+func (s *scramClient) first() (string, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	s.nonce = base64.StdEncoding.EncodeToString(nonce)
+	s.clientFirstBare = "n=,r=" + s.nonce
+	return "n,," + s.clientFirstBare, nil
+}
+
+// final answers the server-first message with the client proof
+func (s *scramClient) final(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce := attrs["r"]
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	iterations, _ := strconv.Atoi(attrs["i"])
+	if !strings.HasPrefix(nonce, s.nonce) || len(nonce) == len(s.nonce) || err != nil || iterations <= 0 {
+		return "", fmt.Errorf("postgres: invalid SCRAM server message")
+	}
+
+	if s.saltedPassword, err = pbkdf2.Key(sha256.New, s.password, salt, iterations, sha256.Size); err != nil {
+		return "", err
+	}
+	clientKey := hmacSHA256(s.saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=biws,r=" + nonce
+	s.authMessage = s.clientFirstBare + "," + serverFirst + "," + withoutProof
+	proof := hmacSHA256(storedKey[:], s.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify checks the server signature, proving the server knows the password as well
+func (s *scramClient) verify(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("postgres: SCRAM authentication failed: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	expected := hmacSHA256(hmacSHA256(s.saltedPassword, "Server Key"), s.authMessage)
+	if err != nil || !hmac.Equal(signature, expected) {
+		return fmt.Errorf("postgres: invalid SCRAM server signature")
+	}
+	s.verified = true
+	return nil
+}
+
+func scramAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for attr := range strings.SplitSeq(msg, ",") {
+		if k, v, ok := strings.Cut(attr, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,439 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Authentication request codes of the fake backend
+const (
+	authTrust     = 0
+	authCleartext = 3
+	authMD5       = 5
+	authSASL      = 10
+)
+
+// fakeBackend is a PostgreSQL server speaking just enough of the protocol for pgConn.
+// Queries answer with a notice, a notification and one row of their parameters followed by a NULL;
+// "SELECT broken" fails.
+type fakeBackend struct {
+	ln       net.Listener
+	wg       sync.WaitGroup
+	auth     int
+	user     string
+	password string
+	tls      *tls.Config // accepts SSLRequest when set
+	scram    string      // "forged" signs the SCRAM exchange without the password, "skipped" leaves it unfinished
+
+	mu      sync.Mutex
+	startup map[string]string
+	queries [][]string // the SQL followed by the parameters
+}
+
+// startFakeBackend serves b for user app with password s3cret until the end of the test
+func startFakeBackend(t *testing.T, b *fakeBackend) *fakeBackend {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.ln, b.user, b.password = ln, "app", "s3cret"
+	b.wg.Add(1)
+	go b.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		b.wg.Wait()
+	})
+	return b
+}
+
+func (b *fakeBackend) url(password, sslmode string) string {
+	return fmt.Sprintf("postgres://app:%s@%s/platform?sslmode=%s", password, b.ln.Addr(), sslmode)
+}
+
+func (b *fakeBackend) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			b.handle(conn)
+		}()
+	}
+}
+
+// handle serves one connection until the client terminates it or an exchange fails
+func (b *fakeBackend) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	msg, err := readStartup(r)
+	if err != nil {
+		return
+	}
+	if binary.BigEndian.Uint32(msg) == pgSSLRequest {
+		if b.tls == nil {
+			conn.Write([]byte{'N'})
+		} else {
+			conn.Write([]byte{'S'})
+			conn = tls.Server(conn, b.tls)
+			r = bufio.NewReader(conn)
+		}
+		if msg, err = readStartup(r); err != nil {
+			return
+		}
+	}
+	params := make(map[string]string)
+	for p := pgReader(msg[4:]); len(p) > 1; {
+		key := p.string()
+		params[key] = p.string()
+	}
+	b.mu.Lock()
+	b.startup = params
+	b.mu.Unlock()
+
+	srv := &pgConn{conn: conn, r: r}
+	if err := b.authenticate(srv, params["user"]); err != nil {
+		srv.send(errorResponse("FATAL", "28P01", err.Error()))
+		return
+	}
+	srv.send(
+		binary.BigEndian.AppendUint32(pgMsg('R'), 0),
+		pgString(pgString(pgMsg('S'), "server_version"), "17.0"),
+		binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(pgMsg('K'), 42), 7),
+		append(pgMsg('Z'), 'I'),
+	)
+
+	var query []string
+	for {
+		typ, body, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 'P':
+			body.string() // statement name
+			query = []string{body.string()}
+		case 'B':
+			body.string() // portal
+			body.string() // statement
+			body.int16()  // parameter formats, all text
+			for range body.int16() {
+				query = append(query, string(body.bytes(int(body.int32()))))
+			}
+		case 'S':
+			b.mu.Lock()
+			b.queries = append(b.queries, query)
+			b.mu.Unlock()
+			if query[0] == "SELECT broken" {
+				srv.send(errorResponse("ERROR", "42P01", `relation "broken" does not exist`), append(pgMsg('Z'), 'I'))
+				continue
+			}
+			row := binary.BigEndian.AppendUint16(pgMsg('D'), uint16(len(query)))
+			for _, arg := range query[1:] {
+				row = append(binary.BigEndian.AppendUint32(row, uint32(len(arg))), arg...)
+			}
+			row = binary.BigEndian.AppendUint32(row, 0xffffffff) // NULL
+			srv.send(
+				pgMsg('1'),
+				pgMsg('2'),
+				pgString(append(pgString(pgMsg('N'), "SNOTICE"), 'M'), "just so you know"),
+				pgString(pgString(binary.BigEndian.AppendUint32(pgMsg('A'), 99), "idp_caller_jwks"), "corp"),
+				row,
+				pgString(pgMsg('C'), "SELECT 1"),
+				append(pgMsg('Z'), 'I'),
+			)
+		case 'X':
+			return
+		}
+	}
+}
+
+// authenticate runs the configured authentication exchange, failing when the password is wrong
+func (b *fakeBackend) authenticate(srv *pgConn, user string) error {
+	switch b.auth {
+	case authCleartext:
+		srv.send(binary.BigEndian.AppendUint32(pgMsg('R'), authCleartext))
+		password, err := readPassword(srv.r)
+		if err != nil || password.string() != b.password {
+			return fmt.Errorf("password authentication failed for user %q", user)
+		}
+	case authMD5:
+		salt := []byte{0x01, 0x02, 0x03, 0x04}
+		srv.send(append(binary.BigEndian.AppendUint32(pgMsg('R'), authMD5), salt...))
+		inner := md5.Sum([]byte(b.password + user))
+		outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+		password, err := readPassword(srv.r)
+		if err != nil || password.string() != "md5"+hex.EncodeToString(outer[:]) {
+			return fmt.Errorf("password authentication failed for user %q", user)
+		}
+	case authSASL:
+		return b.scramExchange(srv)
+	}
+	return nil
+}
+
+// scramExchange is the server side of SCRAM-SHA-256
+func (b *fakeBackend) scramExchange(srv *pgConn) error {
+	srv.send(pgString(pgString(binary.BigEndian.AppendUint32(pgMsg('R'), authSASL), "SCRAM-SHA-256"), ""))
+	initial, err := readPassword(srv.r)
+	if err != nil || initial.string() != "SCRAM-SHA-256" {
+		return fmt.Errorf("expected SCRAM-SHA-256")
+	}
+	clientFirst := string(initial.bytes(int(initial.int32())))
+	clientFirstBare, ok := strings.CutPrefix(clientFirst, "n,,")
+	if !ok {
+		return fmt.Errorf("unexpected channel binding in %q", clientFirst)
+	}
+
+	salt := []byte("fake backend salt")
+	nonce := scramAttributes(clientFirstBare)["r"] + "server-nonce"
+	serverFirst := fmt.Sprintf("r=%s,s=%s,i=4096", nonce, base64.StdEncoding.EncodeToString(salt))
+	srv.send(append(binary.BigEndian.AppendUint32(pgMsg('R'), 11), serverFirst...))
+
+	final, err := readPassword(srv.r)
+	if err != nil {
+		return err
+	}
+	clientFinal := string(final.rest())
+	withoutProof, _, _ := strings.Cut(clientFinal, ",p=")
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+	salted, _ := pbkdf2.Key(sha256.New, b.password, salt, 4096, sha256.Size)
+	storedKey := sha256.Sum256(hmacSHA256(salted, "Client Key"))
+	proof, _ := base64.StdEncoding.DecodeString(scramAttributes(clientFinal)["p"])
+	signature := hmacSHA256(storedKey[:], authMessage)
+	if scramAttributes(clientFinal)["r"] != nonce || len(proof) != len(signature) {
+		return fmt.Errorf("invalid SCRAM client message")
+	}
+	for i := range proof {
+		proof[i] ^= signature[i]
+	}
+	if sha256.Sum256(proof) != storedKey {
+		return fmt.Errorf("password authentication failed")
+	}
+
+	switch b.scram {
+	case "skipped":
+		return nil
+	case "forged":
+		salted, _ = pbkdf2.Key(sha256.New, "guessed", salt, 4096, sha256.Size)
+	}
+	serverSignature := hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage)
+	return srv.send(append(binary.BigEndian.AppendUint32(pgMsg('R'), 12), "v="+base64.StdEncoding.EncodeToString(serverSignature)...))
+}
+
+func readStartup(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(length[:])-4)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+func readMessage(r io.Reader) (byte, pgReader, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err := io.ReadFull(r, body)
+	return header[0], body, err
+}
+
+// readPassword reads a PasswordMessage, which also carries the SASL responses
+func readPassword(r io.Reader) (pgReader, error) {
+	typ, body, err := readMessage(r)
+	if err == nil && typ != 'p' {
+		err = fmt.Errorf("expected a password message, got %q", typ)
+	}
+	return body, err
+}
+
+func errorResponse(severity, code, message string) []byte {
+	m := pgString(append(pgMsg('E'), 'S'), severity)
+	m = pgString(append(m, 'C'), code)
+	m = pgString(append(m, 'M'), message)
+	return append(m, 0)
+}
+
+func TestPGAuthentication(t *testing.T) {
+	methods := map[string]int{"trust": authTrust, "cleartext": authCleartext, "md5": authMD5, "scram-sha-256": authSASL}
+	for name, auth := range methods {
+		t.Run(name, func(t *testing.T) {
+			b := startFakeBackend(t, &fakeBackend{auth: auth})
+			c, err := pgDial(context.Background(), b.url("s3cret", "disable"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if c.pid != 42 {
+				t.Fatalf("backend pid %d, want 42", c.pid)
+			}
+			b.mu.Lock()
+			startup := b.startup
+			b.mu.Unlock()
+			if startup["user"] != "app" || startup["database"] != "platform" || startup["application_name"] != "idp-caller" {
+				t.Fatalf("startup parameters %v", startup)
+			}
+
+			// The password argument, read from password_file, overrides the URL
+			c, err = pgDial(context.Background(), b.url("outdated", "disable"), "s3cret")
+			if err != nil {
+				t.Fatalf("password override: %v", err)
+			}
+			c.Close()
+
+			if auth == authTrust {
+				return
+			}
+			_, err = pgDial(context.Background(), b.url("wrong", "disable"), "")
+			var pgErr *pgError
+			if !errors.As(err, &pgErr) || pgErr.Code != "28P01" {
+				t.Fatalf("expected an invalid password error, got %v", err)
+			}
+			if _, err := pgDial(context.Background(), "postgres://app@"+b.ln.Addr().String()+"/platform?sslmode=disable", ""); err == nil || !strings.Contains(err.Error(), "requires a password") {
+				t.Fatalf("expected a missing password error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPGSCRAMAuthenticatesServer(t *testing.T) {
+	tests := map[string]string{
+		"forged":  "invalid SCRAM server signature",
+		"skipped": "did not complete SCRAM authentication",
+	}
+	for scram, want := range tests {
+		t.Run(scram, func(t *testing.T) {
+			b := startFakeBackend(t, &fakeBackend{auth: authSASL, scram: scram})
+			_, err := pgDial(context.Background(), b.url("s3cret", "disable"), "")
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("expected %q, got %v", want, err)
+			}
+		})
+	}
+}
+
+func TestPGQuery(t *testing.T) {
+	b := startFakeBackend(t, &fakeBackend{auth: authSASL})
+	c, err := pgDial(context.Background(), b.url("s3cret", "disable"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var notifications []string
+	c.notify = func(channel, payload string, pid int32) {
+		notifications = append(notifications, fmt.Sprintf("%s:%s:%d", channel, payload, pid))
+	}
+
+	rows, err := c.query(context.Background(), "SELECT $1, $2", "corp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || !slices.Equal(rows[0], []string{"corp", "", ""}) {
+		t.Fatalf("rows %q", rows)
+	}
+	if !slices.Equal(notifications, []string{"idp_caller_jwks:corp:99"}) {
+		t.Fatalf("notifications %v", notifications)
+	}
+
+	// A failed statement leaves the connection usable
+	_, err = c.query(context.Background(), "SELECT broken")
+	var pgErr *pgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "42P01" {
+		t.Fatalf("expected an undefined table error, got %v", err)
+	}
+	if _, err := c.query(context.Background(), "SELECT $1", "again"); err != nil {
+		t.Fatalf("query after an error: %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	want := [][]string{{"SELECT $1, $2", "corp", ""}, {"SELECT broken"}, {"SELECT $1", "again"}}
+	if !slices.EqualFunc(b.queries, want, slices.Equal) {
+		t.Fatalf("server received %q", b.queries)
+	}
+}
+
+func TestPGSSLMode(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfSigned := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+
+	tests := []struct {
+		name    string
+		tls     bool
+		sslmode string
+		want    string // error, empty when the connection succeeds
+	}{
+		{name: "prefer without TLS", sslmode: "prefer"},
+		{name: "disable", sslmode: "disable"},
+		{name: "require without TLS", sslmode: "require", want: "does not support TLS"},
+		{name: "prefer", tls: true, sslmode: "prefer"},
+		// require encrypts but accepts any certificate, as libpq does
+		{name: "require", tls: true, sslmode: "require"},
+		{name: "verify-full", tls: true, sslmode: "verify-full", want: "certificate signed by unknown authority"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &fakeBackend{auth: authSASL}
+			if tt.tls {
+				b.tls = selfSigned
+			}
+			startFakeBackend(t, b)
+			c, err := pgDial(context.Background(), b.url("s3cret", tt.sslmode), "")
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("expected %q, got %v", tt.want, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if _, encrypted := c.conn.(*tls.Conn); encrypted != tt.tls {
+				t.Fatalf("encrypted %v, want %v", encrypted, tt.tls)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// postgresSchema creates the snapshot table, one row per IDP, and the history of every saved snapshot
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS %[1]s (
+	idp            text PRIMARY KEY,
+	version        bigint NOT NULL,
+	updated_at     timestamptz NOT NULL,
+	cache_duration integer NOT NULL,
+	writer         text NOT NULL,
+	jwks           jsonb NOT NULL
+);
+CREATE TABLE IF NOT EXISTS %[1]s_history (
+	id         bigserial PRIMARY KEY,
+	idp        text NOT NULL,
+	version    bigint NOT NULL,
+	updated_at timestamptz NOT NULL,
+	writer     text NOT NULL,
+	jwks       jsonb NOT NULL
+);
+CREATE INDEX IF NOT EXISTS %[1]s_history_idp ON %[1]s_history (idp, id)`
+
+// postgresSave replaces an older snapshot, records it in the history, trims the history of the IDP
+// and notifies the other replicas, all in one statement. No row is returned when a newer version is stored.
+const postgresSave = `
+WITH saved AS (
+	INSERT INTO %[1]s AS stored (idp, version, updated_at, cache_duration, writer, jwks)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (idp) DO UPDATE SET version = EXCLUDED.version, updated_at = EXCLUDED.updated_at,
+		cache_duration = EXCLUDED.cache_duration, writer = EXCLUDED.writer, jwks = EXCLUDED.jwks
+	WHERE stored.version < EXCLUDED.version
+	RETURNING idp, version, updated_at, writer, jwks
+), recorded AS (
+	INSERT INTO %[1]s_history (idp, version, updated_at, writer, jwks)
+	SELECT idp, version, updated_at, writer, jwks FROM saved
+), trimmed AS (
+	DELETE FROM %[1]s_history WHERE idp = $1 AND EXISTS (SELECT 1 FROM saved) AND id <= (
+		SELECT id FROM %[1]s_history WHERE idp = $1 ORDER BY id DESC OFFSET $7 LIMIT 1)
+)
+SELECT pg_notify('%[1]s', idp) FROM saved`
+
+// postgres stores snapshots in a table and keeps a bounded history of them. Every save is announced with
+// NOTIFY on a channel named after the table, which a dedicated connection of each replica listens on.
+type postgres struct {
+	config config.PostgresConfig
+	table  string
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	conn *pgConn // for loads and saves, opened on first use and dropped after a connection error
+	pid  atomic.Int32
+}
+
+func newPostgres(cfg config.PostgresConfig, logger *slog.Logger) *postgres {
+	// Like libpq, prefer and require encrypt without checking who is at the other end
+	if u, err := url.Parse(cfg.URL); err == nil {
+		if mode := cmp.Or(u.Query().Get("sslmode"), "prefer"); mode == "prefer" || mode == "require" {
+			logger.Warn("Postgres server certificate is not verified, use sslmode=verify-full to prevent interception",
+				"sslmode", mode)
+		}
+	}
+	return &postgres{config: cfg, table: cfg.GetTable(), logger: logger}
+}
+
+func (p *postgres) Name() string {
+	return config.StoragePostgres
+}
+
+func (p *postgres) Save(ctx context.Context, s Snapshot) error {
+	keys, err := json.Marshal(s.JWKS)
+	if err != nil {
+		return err
+	}
+	// The statement's own history row is not visible to the trim, so one less is kept from before
+	keep := p.config.GetHistory() - 1
+	var rows [][]string
+	err = p.do(ctx, func(c *pgConn) error {
+		rows, err = c.query(ctx, fmt.Sprintf(postgresSave, p.table),
+			s.IDP,
+			strconv.FormatInt(s.Version, 10),
+			s.UpdatedAt.UTC().Format(time.RFC3339Nano),
+			strconv.Itoa(s.CacheDuration),
+			s.Writer,
+			string(keys),
+			strconv.Itoa(keep),
+		)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (p *postgres) Load(ctx context.Context) ([]Snapshot, error) {
+	var rows [][]string
+	err := p.do(ctx, func(c *pgConn) error {
+		var err error
+		rows, err = c.query(ctx, fmt.Sprintf("SELECT idp, version, cache_duration, writer, jwks FROM %s", p.table))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]Snapshot, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 5 {
+			return nil, fmt.Errorf("postgres: unexpected row of %d columns", len(row))
+		}
+		s := Snapshot{IDP: row[0], Writer: row[3]}
+		if s.Version, err = strconv.ParseInt(row[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("row %q: invalid version: %w", s.IDP, err)
+		}
+		// The version is the update time, which spares parsing the server's timestamp format
+		s.UpdatedAt = time.Unix(0, s.Version).UTC()
+		if s.CacheDuration, err = strconv.Atoi(row[2]); err != nil {
+			return nil, fmt.Errorf("row %q: invalid cache_duration: %w", s.IDP, err)
+		}
+		var keySet jwks.JWKS
+		if err := json.Unmarshal([]byte(row[4]), &keySet); err != nil {
+			return nil, fmt.Errorf("row %q: invalid jwks: %w", s.IDP, err)
+		}
+		s.JWKS = &keySet
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, nil
+}
+
+// Watch listens for the saves of other replicas until ctx is done, reconnecting with backoff.
+// A reconnect is announced as well, saves may have been missed meanwhile.
+func (p *postgres) Watch(ctx context.Context) <-chan struct{} {
+	announced := make(chan struct{}, 1)
+	announce := func() {
+		select {
+		case announced <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		backoff := time.Second
+		reconnect := false
+		for {
+			c, err := p.connect(ctx)
+			if err == nil {
+				c.notify = func(_, _ string, pid int32) {
+					// Saves of this replica come back on its own channel
+					if pid != p.pid.Load() {
+						announce()
+					}
+				}
+				if _, err = c.query(ctx, "LISTEN "+p.table); err == nil {
+					backoff = time.Second
+					if reconnect {
+						announce()
+					}
+					err = c.wait(ctx)
+				}
+				c.Close()
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			reconnect = true
+			p.logger.Warn("Postgres notifications interrupted", "error", err, "retry_in", backoff.String())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, time.Minute)
+		}
+	}()
+	return announced
+}
+
+func (p *postgres) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// do runs fn on the shared connection, opening it and creating the tables first if needed.
+// The connection is dropped after any error other than one reported by the server.
+func (p *postgres) do(ctx context.Context, fn func(c *pgConn) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		c, err := p.connect(ctx)
+		if err != nil {
+			return err
+		}
+		for stmt := range strings.SplitSeq(fmt.Sprintf(postgresSchema, p.table), ";") {
+			if _, err := c.query(ctx, stmt); err != nil {
+				c.Close()
+				return fmt.Errorf("create tables: %w", err)
+			}
+		}
+		p.conn = c
+		p.pid.Store(c.pid)
+	}
+
+	err := fn(p.conn)
+	var serverErr *pgError
+	if err != nil && !errors.As(err, &serverErr) {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+// connect opens a connection, reading the password file each time so a rotated password is picked up
+func (p *postgres) connect(ctx context.Context) (*pgConn, error) {
+	var password string
+	if p.config.PasswordFile != "" {
+		data, err := os.ReadFile(p.config.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: read password file: %w", err)
+		}
+		password = strings.TrimSpace(string(data))
+	}
+	return pgDial(ctx, p.config.URL, password)
+}
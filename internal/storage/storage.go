@@ -0,0 +1,56 @@
+// Package storage shares the key sets fetched by one replica with the other replicas through a common store,
+// and restores them on startup so a restarted replica serves keys before its first fetch completes.
+// Single-node deployments use the embedded store, which keeps the fetch history and audit log as well.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// Snapshot is the key set of one IDP as stored
+type Snapshot struct {
+	IDP           string     `json:"idp"`
+	JWKS          *jwks.JWKS `json:"jwks"`
+	Version       int64      `json:"version"` // unix nanoseconds of the update, the highest version wins
+	UpdatedAt     time.Time  `json:"updated_at"`
+	CacheDuration int        `json:"cache_duration"`
+	Writer        string     `json:"writer"` // host name of the replica that fetched the keys
+}
+
+// ErrConflict is returned by Save when the storage already holds the same or a newer version
+var ErrConflict = errors.New("storage holds a newer version")
+
+// Storage keeps the latest snapshot of every IDP. Saves are conditional on the version,
+// so concurrent replicas converge on the newest fetch whatever order their writes arrive in.
+type Storage interface {
+	Name() string
+	Load(ctx context.Context) ([]Snapshot, error)
+	Save(ctx context.Context, s Snapshot) error
+	Close() error
+}
+
+// Watcher is implemented by storages that announce the saves of other replicas,
+// so they are read right away instead of at the next interval
+type Watcher interface {
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// Open creates the configured storage
+func Open(ctx context.Context, cfg config.StorageConfig, logger *slog.Logger) (Storage, error) {
+	switch cfg.Type {
+	case config.StorageDynamoDB:
+		return newDynamoDB(*cfg.DynamoDB), nil
+	case config.StoragePostgres:
+		return newPostgres(*cfg.Postgres, logger), nil
+	case config.StorageEmbedded:
+		return newEmbedded(cfg.GetDirectory(), logger)
+	}
+	return nil, fmt.Errorf("unsupported storage type %q", cfg.Type)
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+var (
+	stats      = expvar.NewMap("storage")
+	saves      = new(expvar.Int) // key sets written
+	conflicts  = new(expvar.Int) // writes refused because another replica stored a newer version
+	saveErrors = new(expvar.Int)
+	loadErrors = new(expvar.Int)
+	applied    = new(expvar.Int) // key sets taken over from other replicas
+)
+
+func init() {
+	stats.Set("saves", saves)
+	stats.Set("conflicts", conflicts)
+	stats.Set("save_errors", saveErrors)
+	stats.Set("load_errors", loadErrors)
+	stats.Set("applied", applied)
+}
+
+// Syncer saves the key sets this replica fetches and applies the newer ones other replicas stored
+type Syncer struct {
+	storage  Storage
+	manager  *jwks.Manager
+	interval time.Duration
+	writer   string
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	idps    map[string]config.IDPConfig // synced IDPs by name, replaced by SetIDPs
+	known   map[string]Snapshot         // newest version read from or written to the storage, per IDP
+	pending map[string]Snapshot         // fetched key sets waiting to be saved
+	saved   chan struct{}
+}
+
+// NewSyncer creates a syncer for the configured IDPs and subscribes it to key changes.
+// Must be called before the updaters start.
+func NewSyncer(storage Storage, manager *jwks.Manager, idps []config.IDPConfig, interval time.Duration, logger *slog.Logger) *Syncer {
+	writer, _ := os.Hostname()
+	s := &Syncer{
+		storage:  storage,
+		manager:  manager,
+		interval: interval,
+		writer:   writer,
+		logger:   logger,
+		known:    make(map[string]Snapshot),
+		pending:  make(map[string]Snapshot),
+		saved:    make(chan struct{}, 1),
+	}
+	s.SetIDPs(idps)
+	manager.OnChange(s.onChange)
+	return s
+}
+
+// SetIDPs replaces the synced IDPs, when PUT /admin/state changes them
+func (s *Syncer) SetIDPs(idps []config.IDPConfig) {
+	byName := make(map[string]config.IDPConfig, len(idps))
+	for _, idp := range idps {
+		byName[idp.Name] = idp
+	}
+	s.mu.Lock()
+	s.idps = byName
+	s.mu.Unlock()
+}
+
+// onChange queues a fetched key set for saving unless it is the one the storage already holds
+func (s *Syncer) onChange(c jwks.Change) {
+	if c.Error != "" || c.JWKS == nil || !c.KeysChanged() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.idps[c.IDP]; !ok {
+		return
+	}
+	if known, ok := s.known[c.IDP]; ok && reflect.DeepEqual(known.JWKS.Keys, c.JWKS.Keys) {
+		return
+	}
+	s.pending[c.IDP] = Snapshot{
+		IDP:       c.IDP,
+		JWKS:      c.JWKS,
+		Version:   c.Time.UnixNano(),
+		UpdatedAt: c.Time,
+		Writer:    s.writer,
+	}
+	select {
+	case s.saved <- struct{}{}:
+	default:
+	}
+}
+
+// Restore applies the stored key sets, called before the initial fetch
+func (s *Syncer) Restore(ctx context.Context) error {
+	return s.pull(ctx)
+}
+
+// Run saves fetched key sets as they change and reads the storage every interval, or when it
+// announces a save, until ctx is done
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var announced <-chan struct{}
+	if w, ok := s.storage.(Watcher); ok {
+		announced = w.Watch(ctx)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.saved:
+			s.push(ctx)
+		case <-announced:
+			s.pullLogged(ctx)
+		case <-ticker.C:
+			// Failed saves are retried with the next read
+			s.push(ctx)
+			s.pullLogged(ctx)
+		}
+	}
+}
+
+// push saves the pending key sets
+func (s *Syncer) push(ctx context.Context) {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]Snapshot)
+	s.mu.Unlock()
+
+	for name, snap := range pending {
+		if data, ok := s.manager.Get(name); ok {
+			snap.CacheDuration = data.CacheDuration
+		}
+		err := s.storage.Save(ctx, snap)
+
+		s.mu.Lock()
+		switch {
+		case err == nil:
+			saves.Add(1)
+			if snap.Version > s.known[name].Version {
+				s.known[name] = snap
+			}
+		case errors.Is(err, ErrConflict):
+			conflicts.Add(1)
+			s.logger.Debug("Storage holds newer keys", "idp", name, "storage", s.storage.Name())
+		default:
+			saveErrors.Add(1)
+			s.logger.Error("Failed to save keys", "idp", name, "storage", s.storage.Name(), "error", err)
+			if _, superseded := s.pending[name]; !superseded {
+				s.pending[name] = snap
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Syncer) pullLogged(ctx context.Context) {
+	if err := s.pull(ctx); err != nil {
+		s.logger.Error("Failed to read stored keys", "storage", s.storage.Name(), "error", err)
+	}
+}
+
+// pull applies the stored key sets newer than the ones this replica has
+func (s *Syncer) pull(ctx context.Context) error {
+	snapshots, err := s.storage.Load(ctx)
+	if err != nil {
+		loadErrors.Add(1)
+		return err
+	}
+
+	var apply []Snapshot
+	configs := make(map[string]config.IDPConfig)
+	s.mu.Lock()
+	for _, snap := range snapshots {
+		idp, ok := s.idps[snap.IDP]
+		if !ok || snap.JWKS == nil {
+			continue
+		}
+		if snap.Version <= s.known[snap.IDP].Version || snap.Version <= s.pending[snap.IDP].Version {
+			continue
+		}
+		s.known[snap.IDP] = snap
+		if current, ok := s.manager.GetJWKS(snap.IDP); ok && reflect.DeepEqual(current.Keys, snap.JWKS.Keys) {
+			continue
+		}
+		apply = append(apply, snap)
+		configs[snap.IDP] = idp
+	}
+	s.mu.Unlock()
+
+	// Applied outside the lock, the manager calls onChange synchronously
+	for _, snap := range apply {
+		idp := configs[snap.IDP]
+		cacheDuration := cmp.Or(snap.CacheDuration, idp.GetCacheDuration())
+		s.manager.UpdateWithIDPCache(snap.IDP, snap.JWKS, idp.GetMaxKeys(), cacheDuration, 0, idp.RefreshInterval, nil)
+		applied.Add(1)
+		s.logger.Info("Applied stored keys",
+			"idp", snap.IDP,
+			"storage", s.storage.Name(),
+			"writer", snap.Writer,
+			"updated_at", snap.UpdatedAt.Format(time.RFC3339),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// newIDP starts an upstream closed at the end of the test
+func newIDP(t *testing.T, body string) *IDP {
+	t.Helper()
+	idp := NewIDP(body)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// newHarness starts a harness for the upstreams, keyed by IDP name, closed at the end of the test
+func newHarness(t *testing.T, idps map[string]*IDP) *Harness {
+	t.Helper()
+	var configs []config.IDPConfig
+	for name, idp := range idps {
+		configs = append(configs, IDPConfig(name, idp.JWKSURL()))
+	}
+	h := NewHarness(configs, nil)
+	t.Cleanup(h.Close)
+	return h
+}
+
+// getJSON fetches path from the harness server and decodes the body into v, returning the response
+func getJSON(t *testing.T, h *Harness, path string, v any) *http.Response {
+	t.Helper()
+	resp, err := h.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return resp
+}
+
+// servedKids returns the sorted kids served for an IDP, nil when it has none
+func servedKids(t *testing.T, h *Harness, idp string) []string {
+	t.Helper()
+	var set jwks.JWKS
+	if resp := getJSON(t, h, "/jwks/"+idp, &set); resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var kids []string
+	for _, k := range set.Keys {
+		kids = append(kids, k.Kid)
+	}
+	slices.Sort(kids)
+	return kids
+}
+
+func idpStatus(t *testing.T, h *Harness, idp string) jwks.IDPData {
+	t.Helper()
+	var status jwks.IDPData
+	if resp := getJSON(t, h, "/status/"+idp, &status); resp.StatusCode != http.StatusOK {
+		t.Fatalf("/status/%s: status %d", idp, resp.StatusCode)
+	}
+	return status
+}
+
+func TestEndToEndServesUpstreamKeySets(t *testing.T) {
+	h := newHarness(t, map[string]*IDP{
+		"azure":    newIDP(t, AzureADJWKS),
+		"keycloak": newIDP(t, KeycloakJWKS),
+		"auth0":    newIDP(t, Auth0JWKS),
+	})
+	h.Refresh(context.Background())
+
+	want := map[string][]string{
+		"azure":    {"l3sQ-50cCH4xBVZLHTGwnSR7680", "nOo3ZDrODXEK1jKWhXslHR_KXEg"},
+		"keycloak": {"2v1R1mLRbWqWHgr_bQ1Y9rNYpcXNQP-I6ntJvXqqYz8", "FJ86GcF3jTbNLOco4NvZkUCIUmfYCqoqtOQeMfbhNlE"},
+		"auth0":    {"QzBCMDkyNUQ0QjEzQjk0RTk1OEQzQ0RFNzMwNzk5RkZERUUwQTdFRg"},
+	}
+	var all []string
+	for idp, kids := range want {
+		if got := servedKids(t, h, idp); !slices.Equal(got, kids) {
+			t.Errorf("%s: served %v, want %v", idp, got, kids)
+		}
+		all = append(all, kids...)
+	}
+
+	var merged jwks.JWKS
+	if resp := getJSON(t, h, "/.well-known/jwks.json", &merged); resp.StatusCode != http.StatusOK {
+		t.Fatalf("merged key set: status %d", resp.StatusCode)
+	}
+	for _, k := range merged.Keys {
+		if !slices.Contains(all, k.Kid) {
+			t.Errorf("merged key set has unexpected kid %q", k.Kid)
+		}
+	}
+	if len(merged.Keys) == 0 {
+		t.Fatal("merged key set is empty")
+	}
+}
+
+func TestEndToEndKeyRotation(t *testing.T) {
+	upstream := newIDP(t, Auth0JWKS)
+	h := newHarness(t, map[string]*IDP{"auth0": upstream})
+	h.Refresh(context.Background())
+
+	upstream.SetBody(strings.ReplaceAll(Auth0JWKS, "QzBCMDkyNUQ0QjEzQjk0RTk1OEQzQ0RFNzMwNzk5RkZERUUwQTdFRg", "rotated"))
+	h.Refresh(context.Background())
+
+	if got := servedKids(t, h, "auth0"); !slices.Equal(got, []string{"rotated"}) {
+		t.Fatalf("served %v after the rotation", got)
+	}
+	status := idpStatus(t, h, "auth0")
+	if status.UpdateCount != 2 || len(status.KeyHistory) == 0 {
+		t.Fatalf("rotation not recorded: update_count %d, key_history %v", status.UpdateCount, status.KeyHistory)
+	}
+	if last := status.KeyHistory[len(status.KeyHistory)-1]; !slices.Equal(last.Added, []string{"rotated"}) {
+		t.Fatalf("last key change %+v", last)
+	}
+	if n := upstream.Requests(); n != 2 {
+		t.Fatalf("expected 2 upstream requests, got %d", n)
+	}
+}
+
+func TestEndToEndKeepsKeysOnBadResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(*IDP)
+	}{
+		{name: "server error", fail: func(i *IDP) { i.FailWith(http.StatusInternalServerError) }},
+		{name: "login page", fail: func(i *IDP) {
+			i.SetContentType("text/html; charset=utf-8")
+			i.SetBody(HTMLLoginPage)
+		}},
+		{name: "empty key set", fail: func(i *IDP) { i.SetBody(EmptyJWKS) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newIDP(t, AzureADJWKS)
+			h := newHarness(t, map[string]*IDP{"azure": upstream})
+			h.Refresh(context.Background())
+			before := servedKids(t, h, "azure")
+
+			tt.fail(upstream)
+			h.Refresh(context.Background())
+
+			if got := servedKids(t, h, "azure"); len(before) != 2 || !slices.Equal(got, before) {
+				t.Fatalf("served %v, want the previous keys %v", got, before)
+			}
+			status := idpStatus(t, h, "azure")
+			if status.LastError == "" || status.Failures != 1 {
+				t.Fatalf("failure not recorded: last_error %q, consecutive_failures %d", status.LastError, status.Failures)
+			}
+		})
+	}
+}
+
+func TestEndToEndThrottled(t *testing.T) {
+	upstream := newIDP(t, KeycloakJWKS)
+	h := newHarness(t, map[string]*IDP{"keycloak": upstream})
+	upstream.Throttle(1, "120")
+
+	h.Refresh(context.Background())
+	if got := servedKids(t, h, "keycloak"); got != nil {
+		t.Fatalf("served %v before a successful fetch", got)
+	}
+	if status := idpStatus(t, h, "keycloak"); status.ThrottledUntil.IsZero() {
+		t.Fatal("Retry-After not recorded")
+	}
+
+	h.Refresh(context.Background())
+	if got := servedKids(t, h, "keycloak"); len(got) != 2 {
+		t.Fatalf("served %v after the throttling ended", got)
+	}
+	if status := idpStatus(t, h, "keycloak"); !status.ThrottledUntil.IsZero() || status.Failures != 0 {
+		t.Fatalf("throttling not cleared: throttled_until %s, consecutive_failures %d", status.ThrottledUntil, status.Failures)
+	}
+}
+
+func TestEndToEndHonorsLongerCacheControl(t *testing.T) {
+	upstream := newIDP(t, Auth0JWKS)
+	upstream.SetCacheControl("public, max-age=86400")
+	h := newHarness(t, map[string]*IDP{"auth0": upstream})
+	h.Refresh(context.Background())
+
+	status := idpStatus(t, h, "auth0")
+	if status.IDPSuggestedCache != 86400 || status.CacheDuration != 86400 {
+		t.Fatalf("idp_suggested_cache %d, cache_duration %d, want 86400", status.IDPSuggestedCache, status.CacheDuration)
+	}
+	resp := getJSON(t, h, "/jwks/auth0", nil)
+	if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "max-age=86400") {
+		t.Fatalf("Cache-Control %q doesn't pass on the upstream max-age", cc)
+	}
+}
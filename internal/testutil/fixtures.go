@@ -0,0 +1,79 @@
+// Package testutil provides upstream IDP fixtures, an in-process harness wiring updaters,
+// the manager and the HTTP server together, and a log capture to check for leaked key material.
+package testutil
+
+// rsaModulus is the RSA public key from RFC 7517 appendix A.1, shared by the fixtures
+const rsaModulus = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+
+// AzureADJWKS mimics login.microsoftonline.com: x5t, x5c and a per-key issuer template
+const AzureADJWKS = `{
+  "keys": [
+    {
+      "kty": "RSA",
+      "use": "sig",
+      "kid": "nOo3ZDrODXEK1jKWhXslHR_KXEg",
+      "x5t": "nOo3ZDrODXEK1jKWhXslHR_KXEg",
+      "n": "` + rsaModulus + `",
+      "e": "AQAB",
+      "x5c": ["MIIDBTCCAe2gAwIBAgIQN33ROaIJ6bJBWDCxtmJEbjANBgkqhkiG9w0BAQsFADAtMSswKQYDVQQDEyJhY2NvdW50cy5hY2Nlc3Njb250cm9sLndpbmRvd3MubmV0"],
+      "issuer": "https://login.microsoftonline.com/{tenantid}/v2.0"
+    },
+    {
+      "kty": "RSA",
+      "use": "sig",
+      "kid": "l3sQ-50cCH4xBVZLHTGwnSR7680",
+      "x5t": "l3sQ-50cCH4xBVZLHTGwnSR7680",
+      "n": "` + rsaModulus + `",
+      "e": "AQAB",
+      "x5c": ["MIIDBTCCAe2gAwIBAgIQWPB1ofOpA7FFlOBk5iPaNTANBgkqhkiG9w0BAQsFADAtMSswKQYDVQQDEyJhY2NvdW50cy5hY2Nlc3Njb250cm9sLndpbmRvd3MubmV0"],
+      "issuer": "https://login.microsoftonline.com/{tenantid}/v2.0"
+    }
+  ]
+}`
+
+// KeycloakJWKS mimics a Keycloak realm: a signing key plus an encryption key with x5t#S256
+const KeycloakJWKS = `{
+  "keys": [
+    {
+      "kid": "FJ86GcF3jTbNLOco4NvZkUCIUmfYCqoqtOQeMfbhNlE",
+      "kty": "RSA",
+      "alg": "RS256",
+      "use": "sig",
+      "n": "` + rsaModulus + `",
+      "e": "AQAB",
+      "x5c": ["MIICmzCCAYMCBgGMSQrfBDANBgkqhkiG9w0BAQsFADARMQ8wDQYDVQQDDAZtYXN0ZXI"],
+      "x5t": "aP7zyIbOsvJ2hZqVZRzw1-NSLEo",
+      "x5t#S256": "2eWhgjXVLtCyA5qzx_WjfAMhSYQw9Wc0ZgDswJGUXdc"
+    },
+    {
+      "kid": "2v1R1mLRbWqWHgr_bQ1Y9rNYpcXNQP-I6ntJvXqqYz8",
+      "kty": "RSA",
+      "alg": "RSA-OAEP",
+      "use": "enc",
+      "n": "` + rsaModulus + `",
+      "e": "AQAB"
+    }
+  ]
+}`
+
+// Auth0JWKS mimics an Auth0 tenant: RS256 keys with x5c and no alg-specific extras
+const Auth0JWKS = `{
+  "keys": [
+    {
+      "alg": "RS256",
+      "kty": "RSA",
+      "use": "sig",
+      "n": "` + rsaModulus + `",
+      "e": "AQAB",
+      "kid": "QzBCMDkyNUQ0QjEzQjk0RTk1OEQzQ0RFNzMwNzk5RkZERUUwQTdFRg",
+      "x5t": "QzBCMDkyNUQ0QjEzQjk0RTk1OEQzQ0RFNzMwNzk5RkZERUUwQTdFRg",
+      "x5c": ["MIIDBzCCAe+gAwIBAgIJakoPho0MJr56MA0GCSqGSIb3DQEBCwUAMCExHzAdBgNVBAMTFmRldi1leGFtcGxlLmF1dGgwLmNvbQ"]
+    }
+  ]
+}`
+
+// EmptyJWKS is a well-formed key set without keys
+const EmptyJWKS = `{"keys":[]}`
+
+// HTMLLoginPage is what misconfigured upstreams serve instead of JSON
+const HTMLLoginPage = `<!DOCTYPE html><html><head><title>Sign in</title></head><body><form action="/login"></form></body></html>`
@@ -0,0 +1,69 @@
+package testutil
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/internal/server"
+)
+
+// Harness wires updaters, a manager and the HTTP handlers for end-to-end flows
+// without timers: fetches happen only when Refresh is called.
+type Harness struct {
+	Manager  *jwks.Manager
+	Updaters []*jwks.Updater
+	Server   *httptest.Server
+	Logger   *slog.Logger
+}
+
+// IDPConfig returns a minimal IDP configuration pointing at url
+func IDPConfig(name, url string) config.IDPConfig {
+	return config.IDPConfig{
+		Name:            name,
+		URL:             url,
+		RefreshInterval: 3600,
+	}
+}
+
+// NewHarness creates the stack for the given IDPs. A nil logger discards output.
+// Call Close when done.
+func NewHarness(idps []config.IDPConfig, logger *slog.Logger) *Harness {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	manager := jwks.NewManager(logger)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	h := &Harness{Manager: manager, Logger: logger}
+	for _, idp := range idps {
+		h.Updaters = append(h.Updaters, jwks.NewUpdater(idp, manager, logger, jwks.WithHTTPClient(client)))
+	}
+
+	srv := server.New(config.ServerConfig{}, manager, logger)
+	srv.MarkReady()
+	h.Server = httptest.NewServer(srv.Handler())
+
+	return h
+}
+
+// Refresh fetches every IDP once
+func (h *Harness) Refresh(ctx context.Context) {
+	jwks.FetchAll(ctx, h.Updaters, len(h.Updaters), 10*time.Second, h.Logger)
+}
+
+// Get performs a GET against the harness server
+func (h *Harness) Get(path string) (*http.Response, error) {
+	return h.Server.Client().Get(h.Server.URL + path)
+}
+
+// Close stops the harness server
+func (h *Harness) Close() {
+	h.Server.Close()
+}
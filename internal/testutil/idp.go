@@ -0,0 +1,110 @@
+package testutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// IDP is an httptest upstream serving a JWKS fixture at /.well-known/jwks.json.
+// It supports Cache-Control, forced failures and 429 throttling.
+type IDP struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	body         []byte
+	contentType  string
+	cacheControl string
+	failStatus   int
+	throttled    int
+	retryAfter   string
+	requests     int
+}
+
+// NewIDP starts an upstream serving body as application/json
+func NewIDP(body string) *IDP {
+	idp := &IDP{body: []byte(body), contentType: "application/json"}
+	idp.Server = httptest.NewServer(http.HandlerFunc(idp.serve))
+	return idp
+}
+
+// JWKSURL returns the URL to configure as the IDP url
+func (i *IDP) JWKSURL() string {
+	return i.URL + "/.well-known/jwks.json"
+}
+
+// SetBody replaces the served document, e.g. to simulate a key rotation
+func (i *IDP) SetBody(body string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.body = []byte(body)
+}
+
+// SetContentType changes the Content-Type of the response
+func (i *IDP) SetContentType(contentType string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.contentType = contentType
+}
+
+// SetCacheControl sets the Cache-Control header, empty removes it
+func (i *IDP) SetCacheControl(value string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cacheControl = value
+}
+
+// FailWith answers every request with status until called again with 0
+func (i *IDP) FailWith(status int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.failStatus = status
+}
+
+// Throttle answers the next n requests with 429 and the given Retry-After value
+func (i *IDP) Throttle(n int, retryAfter string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.throttled = n
+	i.retryAfter = retryAfter
+}
+
+// Requests returns the number of JWKS requests received
+func (i *IDP) Requests() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.requests
+}
+
+func (i *IDP) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/.well-known/jwks.json" {
+		http.NotFound(w, r)
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.requests++
+
+	if i.throttled > 0 {
+		i.throttled--
+		if i.retryAfter != "" {
+			w.Header().Set("Retry-After", i.retryAfter)
+		}
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+
+	if i.failStatus != 0 {
+		http.Error(w, http.StatusText(i.failStatus), i.failStatus)
+		return
+	}
+
+	if i.contentType != "" {
+		w.Header().Set("Content-Type", i.contentType)
+	}
+	if i.cacheControl != "" {
+		w.Header().Set("Cache-Control", i.cacheControl)
+	}
+	w.Write(i.body)
+}
@@ -0,0 +1,83 @@
+package testutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// LogCapture collects structured log output the way the service writes it, key material redaction included
+type LogCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewLogCapture returns a capture and a debug level JSON logger writing to it
+func NewLogCapture() (*LogCapture, *slog.Logger) {
+	c := &LogCapture{}
+	handler := slog.NewJSONHandler(c, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: config.RedactKeyMaterial})
+	return c, slog.New(handler)
+}
+
+func (c *LogCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+// keyMembers are the JWK members holding key material, see config.RedactKeyMaterial
+var keyMembers = map[string]bool{
+	"n": true, "x": true, "y": true, "k": true, "x5c": true,
+	"d": true, "p": true, "q": true, "dp": true, "dq": true, "qi": true, "oth": true,
+}
+
+// embeddedKey matches an unredacted key material member inside JSON text, e.g. a quoted response body
+var embeddedKey = regexp.MustCompile(`"(n|x|y|k|x5c|d|p|q|dp|dq|qi|oth)"\s*:\s*(\[|"[^"\[])`)
+
+// KeyMaterialLeaks returns the captured log lines that contain JWK key material: an attribute named
+// like a key member that isn't redacted, or a key set embedded in a message or value
+func (c *LogCapture) KeyMaterialLeaks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var leaks []string
+	scanner := bufio.NewScanner(bytes.NewReader(c.buf.Bytes()))
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			leaks = append(leaks, fmt.Sprintf("line %d: not a JSON log record", line))
+			continue
+		}
+		if containsKeyMaterial(record) {
+			leaks = append(leaks, fmt.Sprintf("line %d: %s", line, scanner.Text()))
+		}
+	}
+	return leaks
+}
+
+func containsKeyMaterial(v any) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		for name, value := range v {
+			if keyMembers[name] && value != "[REDACTED]" {
+				return true
+			}
+			if containsKeyMaterial(value) {
+				return true
+			}
+		}
+	case []any:
+		return slices.ContainsFunc(v, containsKeyMaterial)
+	case string:
+		return embeddedKey.MatchString(v)
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package testutil
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+func TestFetchLogsNoKeyMaterial(t *testing.T) {
+	capture, logger := NewLogCapture()
+	azure, keycloak, auth0 := newIDP(t, AzureADJWKS), newIDP(t, KeycloakJWKS), newIDP(t, Auth0JWKS)
+	h := NewHarness([]config.IDPConfig{
+		IDPConfig("azure", azure.JWKSURL()),
+		IDPConfig("keycloak", keycloak.JWKSURL()),
+		IDPConfig("auth0", auth0.JWKSURL()),
+	}, logger)
+	t.Cleanup(h.Close)
+	h.Refresh(context.Background())
+
+	// Rotations and upstream errors whose body is a key set, the paths that log response excerpts
+	azure.SetBody(strings.ReplaceAll(AzureADJWKS, "nOo3ZDrODXEK1jKWhXslHR_KXEg", "rotated"))
+	keycloak.SetContentType("text/html")
+	auth0.FailWith(http.StatusBadGateway)
+	h.Refresh(context.Background())
+
+	for _, path := range []string{"/jwks/azure", "/.well-known/jwks.json", "/status", "/jwks/missing"} {
+		getJSON(t, h, path, nil)
+	}
+
+	if leaks := capture.KeyMaterialLeaks(); len(leaks) > 0 {
+		t.Fatalf("key material in the logs:\n%s", strings.Join(leaks, "\n"))
+	}
+	if !strings.Contains(capture.buf.String(), `"level":"DEBUG"`) {
+		t.Fatal("debug records weren't captured")
+	}
+}
+
+func TestKeyMaterialLeaksFindsKeys(t *testing.T) {
+	capture, logger := NewLogCapture()
+	logger.Info(`upstream sent {"kty":"EC","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU"}`)
+	logger.Warn("unexpected response", "body", Auth0JWKS)
+	logger.Info("redacted by the handler", "n", "AQAB", "jwk", map[string]any{"kty": "RSA", "x5c": []string{"MIIC"}})
+	logger.Debug("key", "key", jwks.JWK{Kid: "a", Kty: "RSA", N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4", E: "AQAB"})
+	if leaks := capture.KeyMaterialLeaks(); len(leaks) != 0 {
+		t.Fatalf("redacted records reported: %v", leaks)
+	}
+
+	// A handler without the redaction, as a new log sink set up by mistake would be
+	raw := slog.New(slog.NewJSONHandler(capture, nil))
+	raw.Info(`upstream sent {"kty":"EC","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU"}`)
+	raw.Warn("unexpected response", "body", Auth0JWKS)
+	raw.Info("key", "d", "secret")
+	if leaks := capture.KeyMaterialLeaks(); len(leaks) != 3 {
+		t.Fatalf("expected 3 leaks, got %d: %v", len(leaks), leaks)
+	}
+}
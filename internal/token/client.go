@@ -0,0 +1,189 @@
+// Package token obtains access tokens from IDPs on behalf of internal callers
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/version"
+)
+
+// errorBodyLimit is how much of an error response is kept for the error message
+const errorBodyLimit = 1024
+
+// Token is an access token as returned to callers
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // seconds left at the time the token is handed out
+	Scope       string `json:"scope,omitempty"`
+
+	expiry time.Time
+}
+
+// Client performs the client_credentials grant for one IDP and caches the token until shortly
+// before it expires. Concurrent callers share a single upstream request.
+type Client struct {
+	config config.ClientCredentialsConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	cached   *Token
+	inflight *call // upstream request in progress, nil when none
+}
+
+// call is an upstream request shared by the callers that arrived while it runs
+type call struct {
+	done chan struct{} // closed once tok or err is set
+	tok  *Token
+	err  error
+}
+
+// NewClient creates a client for the IDP's client credentials
+func NewClient(cfg config.ClientCredentialsConfig) *Client {
+	return &Client{
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Config returns the client credentials the client was created with
+func (c *Client) Config() config.ClientCredentialsConfig {
+	return c.config
+}
+
+// Token returns a valid access token, from cache when possible.
+// The second return value reports whether the token came from cache.
+// The lock is never held during the upstream request, a caller whose ctx ends stops waiting for it.
+func (c *Client) Token(ctx context.Context) (*Token, bool, error) {
+	c.mu.Lock()
+	if c.cached != nil && time.Now().Before(c.cached.expiry) {
+		tok := handOut(c.cached, time.Now())
+		c.mu.Unlock()
+		return tok, true, nil
+	}
+	pending := c.inflight
+	if pending == nil {
+		pending = &call{done: make(chan struct{})}
+		c.inflight = pending
+		go c.fetch(pending)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-pending.done:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	if pending.err != nil {
+		return nil, false, pending.err
+	}
+	return handOut(pending.tok, time.Now()), false, nil
+}
+
+// fetch runs the shared upstream request. It isn't bound to any caller's context, so one caller
+// giving up doesn't fail the others; the HTTP client timeout bounds it.
+func (c *Client) fetch(pending *call) {
+	tok, err := c.request(context.Background())
+
+	c.mu.Lock()
+	pending.tok, pending.err = tok, err
+	if err == nil {
+		c.cached = tok
+	}
+	c.inflight = nil
+	c.mu.Unlock()
+	close(pending.done)
+}
+
+// handOut copies a token with expires_in reduced to the time left
+func handOut(cached *Token, now time.Time) *Token {
+	tok := *cached
+	tok.ExpiresIn = int(tok.expiry.Sub(now).Seconds())
+	return &tok
+}
+
+// request performs the client_credentials grant against the token endpoint
+func (c *Client) request(ctx context.Context) (*Token, error) {
+	secret, err := c.secret()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.config.Scopes, " "))
+	}
+	if c.config.Audience != "" {
+		form.Set("audience", c.config.Audience)
+	}
+	if c.config.Resource != "" {
+		form.Set("resource", c.config.Resource)
+	}
+	if c.config.GetAuthMethod() == config.AuthMethodPost {
+		form.Set("client_id", c.config.ClientID)
+		form.Set("client_secret", secret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	if c.config.GetAuthMethod() == config.AuthMethodBasic {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(secret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tok Token
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return nil, fmt.Errorf("token response contains no access_token")
+	}
+	if tok.TokenType == "" {
+		tok.TokenType = "Bearer"
+	}
+
+	// Stop handing the token out a little before it expires so callers have time to use it
+	lifetime := time.Duration(tok.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+	tok.expiry = time.Now().Add(lifetime - min(lifetime/10, time.Minute))
+	return &tok, nil
+}
+
+// secret returns the client secret, re-reading client_secret_file so rotations are picked up
+func (c *Client) secret() (string, error) {
+	if c.config.ClientSecretFile == "" {
+		return c.config.ClientSecret, nil
+	}
+	data, err := os.ReadFile(c.config.ClientSecretFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read client secret: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,97 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+)
+
+// slowTokenServer answers token requests once release is closed, counting them
+func slowTokenServer(t *testing.T, release <-chan struct{}, requests *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"abc","expires_in":3600}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestClient(url string) *Client {
+	return NewClient(config.ClientCredentialsConfig{TokenURL: url, ClientID: "id", ClientSecret: "secret"})
+}
+
+func TestTokenSharesUpstreamRequest(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	c := newTestClient(slowTokenServer(t, release, &requests).URL)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, _, err := c.Token(context.Background())
+			if err == nil && tok.AccessToken != "abc" {
+				err = errors.New("unexpected token " + tok.AccessToken)
+			}
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", n)
+	}
+	if _, cached, err := c.Token(context.Background()); err != nil || !cached {
+		t.Fatalf("expected a cached token, got cached=%v err=%v", cached, err)
+	}
+}
+
+func TestTokenCallerStopsWaitingOnCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var requests atomic.Int32
+	c := newTestClient(slowTokenServer(t, release, &requests).URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := c.Token(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("caller waited %s for a slow upstream", waited)
+	}
+
+	// The lock isn't held during the upstream request
+	locked := make(chan struct{})
+	go func() {
+		c.mu.Lock()
+		c.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("client lock held during the upstream request")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package upgrade
+
+import "os"
+
+// Notify does nothing, upgrades need SIGUSR2 and inherited descriptors
+func Notify(c chan<- os.Signal) {}
+
+// IsSignal reports whether sig requests an upgrade, never on this platform
+func IsSignal(sig os.Signal) bool {
+	return false
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package upgrade
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Notify relays the upgrade signal (SIGUSR2) to c
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// IsSignal reports whether sig requests an upgrade
+func IsSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
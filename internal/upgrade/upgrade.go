@@ -0,0 +1,123 @@
+// Package upgrade replaces the running binary without closing its listening sockets:
+// the new process inherits the sockets, reports ready once serving, and the old one drains
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// envListeners lists the addresses of the inherited sockets, in file descriptor order.
+// The readiness pipe is fd 3, the sockets follow from fd 4.
+const envListeners = "IDP_CALLER_UPGRADE_LISTENERS"
+
+const (
+	readyFD          = 3
+	firstListenerFD  = 4
+	notifiedReadyMsg = "ready"
+)
+
+// ready is the write end of the readiness pipe of a process started by Spawn
+var ready *os.File
+
+// Inherited returns the sockets passed by the process that started this one, keyed by listen address.
+// It returns nil when this process wasn't started by Spawn.
+func Inherited() map[string]*os.File {
+	value, ok := os.LookupEnv(envListeners)
+	if !ok {
+		return nil
+	}
+	// Not passed on to processes we start ourselves
+	os.Unsetenv(envListeners)
+
+	ready = os.NewFile(readyFD, "upgrade-ready")
+	files := make(map[string]*os.File)
+	if value == "" {
+		return files
+	}
+	for i, addr := range strings.Split(value, ",") {
+		files[addr] = os.NewFile(uintptr(firstListenerFD+i), "upgrade-"+addr)
+	}
+	return files
+}
+
+// Ready tells the process that started this one to drain and exit, a no-op when not started by Spawn
+func Ready() error {
+	if ready == nil {
+		return nil
+	}
+	defer ready.Close()
+	_, err := ready.WriteString(notifiedReadyMsg)
+	ready = nil
+	return err
+}
+
+// Spawn starts the current executable with the same arguments, passing it the listening sockets,
+// and waits up to timeout for it to call Ready. On failure the new process is killed and the
+// caller keeps serving. Addresses must not contain commas.
+func Spawn(listeners map[string]*os.File, timeout time.Duration) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer r.Close()
+
+	addrs := make([]string, 0, len(listeners))
+	files := []*os.File{w}
+	for addr, f := range listeners {
+		if strings.Contains(addr, ",") {
+			w.Close()
+			return nil, fmt.Errorf("listen address %q can't be handed over", addr)
+		}
+		addrs = append(addrs, addr)
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envListeners+"="+strings.Join(addrs, ","))
+	cmd.ExtraFiles = files
+
+	err = cmd.Start()
+	// Only the new process keeps the write end, so a crash shows up as EOF
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		msg := make([]byte, len(notifiedReadyMsg))
+		_, err := io.ReadFull(r, msg)
+		if err != nil || string(msg) != notifiedReadyMsg {
+			err = errors.New("new process exited before becoming ready")
+		}
+		result <- err
+	}()
+
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return nil, err
+	}
+	// The new process outlives us, don't keep its exit status around
+	go cmd.Wait()
+	return cmd.Process, nil
+}
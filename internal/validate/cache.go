@@ -0,0 +1,114 @@
+package validate
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Cache statistics, served at /debug/vars under "validation_cache"
+var (
+	cacheStats     = expvar.NewMap("validation_cache")
+	cacheHits      = new(expvar.Int)
+	cacheMisses    = new(expvar.Int)
+	cacheEvictions = new(expvar.Int)
+	cacheSize      = new(expvar.Int)
+)
+
+func init() {
+	cacheStats.Set("hits", cacheHits)
+	cacheStats.Set("misses", cacheMisses)
+	cacheStats.Set("evictions", cacheEvictions)
+	cacheStats.Set("size", cacheSize)
+	cacheStats.Set("hit_rate", expvar.Func(func() any {
+		hits, misses := cacheHits.Value(), cacheMisses.Value()
+		if hits+misses == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(hits+misses)
+	}))
+}
+
+// resultCache is a bounded LRU of successful validations keyed by token hash.
+// Entries expire at the token's exp or after ttl, whichever comes first, so a key
+// removed upstream stops validating cached tokens within ttl.
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List // front is most recently used
+}
+
+type cacheEntry struct {
+	key     [sha256.Size]byte
+	result  Result
+	expires time.Time
+}
+
+func newResultCache(size int, ttl time.Duration) *resultCache {
+	return &resultCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// get returns a cached result that hasn't expired
+func (c *resultCache) get(raw string, now time.Time) (Result, bool) {
+	key := sha256.Sum256([]byte(raw))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		cacheMisses.Add(1)
+		return Result{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if now.After(entry.expires) {
+		c.remove(el)
+		cacheMisses.Add(1)
+		return Result{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	cacheHits.Add(1)
+	return entry.result, true
+}
+
+// put stores a valid result until min(exp, now+ttl), evicting the least recently used entry when full
+func (c *resultCache) put(raw string, result Result, exp, now time.Time) {
+	expires := now.Add(c.ttl)
+	if exp.Before(expires) {
+		expires = exp
+	}
+	if !expires.After(now) {
+		return
+	}
+	key := sha256.Sum256([]byte(raw))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+		cacheEvictions.Add(1)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, result: result, expires: expires})
+	cacheSize.Add(1)
+}
+
+func (c *resultCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	cacheSize.Add(-1)
+}
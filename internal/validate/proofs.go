@@ -0,0 +1,46 @@
+package validate
+
+import (
+	"sync"
+	"time"
+)
+
+// maxProofs bounds the remembered DPoP proof ids; when full, expired ids are dropped first
+const maxProofs = 100000
+
+// proofCache remembers the jti of accepted DPoP proofs until they are too old to be accepted
+// anyway, so a captured proof can't be replayed against this replica
+type proofCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // jti -> expiry
+}
+
+func newProofCache() *proofCache {
+	return &proofCache{seen: make(map[string]time.Time)}
+}
+
+// Seen reports whether jti was used before and remembers it until expires
+func (c *proofCache) Seen(jti string, expires time.Time) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until, ok := c.seen[jti]; ok && now.Before(until) {
+		return true
+	}
+	if len(c.seen) >= maxProofs {
+		for id, until := range c.seen {
+			if !now.Before(until) {
+				delete(c.seen, id)
+			}
+		}
+	}
+	if len(c.seen) >= maxProofs {
+		// Flooded with live proofs: forget an arbitrary one rather than grow without bound
+		for id := range c.seen {
+			delete(c.seen, id)
+			break
+		}
+	}
+	c.seen[jti] = expires
+	return false
+}
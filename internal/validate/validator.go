@@ -0,0 +1,269 @@
+// Package validate verifies JWTs against the cached IDP key sets
+package validate
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/pkg/verify"
+)
+
+// tenantPlaceholder is the Azure AD issuer template filled from the tid claim
+const tenantPlaceholder = "{tenantid}"
+
+// maxCacheLifetime bounds tokens without exp, the cache TTL applies on top
+const maxCacheLifetime = 24 * time.Hour
+
+// Result is the outcome of validating one token
+type Result struct {
+	Valid  bool           `json:"valid"`
+	IDP    string         `json:"idp,omitempty"`
+	Claims map[string]any `json:"claims,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// trustedIDP is an IDP tokens can be validated against
+type trustedIDP struct {
+	name      string
+	issuer    string
+	policy    verify.Policy
+	transform *config.TransformConfig // renames kids, nil when the IDP has no transforms
+}
+
+// Validator resolves a token's IDP from its iss claim and verifies it with that IDP's keys
+type Validator struct {
+	manager      *jwks.Manager
+	maxBatchSize int
+	issuers      map[string][]trustedIDP // exact issuer -> IDPs
+	templated    []trustedIDP            // IDPs whose issuer contains {tenantid}
+	ordered      []trustedIDP            // IDPs with an exact issuer, in config order
+	cache        *resultCache            // nil when disabled
+	proofs       *proofCache             // DPoP proof ids seen, against replays
+	clockSkew    time.Duration           // tolerance for DPoP proofs issued in the future
+}
+
+// New creates a validator for the IDPs that have an issuer configured,
+// with the validation block providing defaults for per-IDP settings
+func New(manager *jwks.Manager, idps []config.IDPConfig, defaults config.ValidationConfig) *Validator {
+	v := &Validator{
+		manager:      manager,
+		maxBatchSize: defaults.GetMaxBatchSize(),
+		issuers:      make(map[string][]trustedIDP),
+		proofs:       newProofCache(),
+		clockSkew:    defaults.GetClockSkew(),
+	}
+	if size := defaults.GetCacheSize(); size > 0 {
+		v.cache = newResultCache(size, defaults.GetCacheTTL())
+	}
+	for _, idp := range idps {
+		if idp.Issuer == "" {
+			continue
+		}
+		t := trustedIDP{name: idp.Name, issuer: idp.Issuer, policy: policyFor(idp, defaults), transform: idp.Transform}
+		if strings.Contains(idp.Issuer, tenantPlaceholder) {
+			v.templated = append(v.templated, t)
+		} else {
+			v.issuers[idp.Issuer] = append(v.issuers[idp.Issuer], t)
+			v.ordered = append(v.ordered, t)
+		}
+	}
+	return v
+}
+
+// policyFor merges the IDP's validation settings over the global defaults.
+// The issuer itself is checked during resolution, so the policy doesn't repeat it.
+func policyFor(idp config.IDPConfig, defaults config.ValidationConfig) verify.Policy {
+	p := verify.Policy{
+		Audiences:      defaults.Audiences,
+		ClockSkew:      defaults.GetClockSkew(),
+		RequiredClaims: defaults.RequiredClaims,
+		MaxTokenAge:    defaults.GetMaxTokenAge(),
+	}
+	if jwks.FIPS() {
+		p.Algorithms = jwks.FIPSAlgorithms
+	}
+	if len(idp.Audiences) > 0 {
+		p.Audiences = idp.Audiences
+	}
+	if idp.ClockSkew > 0 {
+		p.ClockSkew = idp.GetClockSkew()
+	}
+	if len(idp.RequiredClaims) > 0 {
+		p.RequiredClaims = idp.RequiredClaims
+	}
+	if idp.MaxTokenAge > 0 {
+		p.MaxTokenAge = idp.GetMaxTokenAge()
+	}
+	return p
+}
+
+// Validate verifies the token and returns its claims when valid. Sender-constrained tokens are
+// rejected, they need the proof or certificate their holder presented, see ValidatePresented.
+func (v *Validator) Validate(raw string) Result {
+	return v.ValidatePresented(raw, verify.Presentation{})
+}
+
+// ValidatePresented verifies the token and, when it is bound to a DPoP key (cnf.jkt) or a client
+// certificate (cnf.x5t#S256), that p proves possession of it.
+func (v *Validator) ValidatePresented(raw string, p verify.Presentation) Result {
+	result := v.validateCached(raw)
+	if !result.Valid {
+		return result
+	}
+	// The proof differs on every request, so it is checked after the cache
+	p.ClockSkew = v.clockSkew
+	p.Seen = v.proofs.Seen
+	if err := verify.CheckBinding(raw, result.Claims, p); err != nil {
+		return Result{IDP: result.IDP, Error: err.Error()}
+	}
+	return result
+}
+
+// validateCached verifies the token signature and policy.
+// Valid results are cached so repeated validation of a token skips signature verification.
+func (v *Validator) validateCached(raw string) Result {
+	if v.cache == nil {
+		return v.validate(raw)
+	}
+
+	now := time.Now()
+	if result, ok := v.cache.get(raw, now); ok {
+		// A cached token of an IDP drained since is verified again, and refused
+		if _, served := v.manager.ServedJWKS(result.IDP, now); served {
+			return result
+		}
+	}
+	result := v.validate(raw)
+	if result.Valid {
+		v.cache.put(raw, result, expiry(result.Claims, now), now)
+	}
+	return result
+}
+
+// expiry returns when the token expires, tokens without exp are bounded by maxCacheLifetime
+func expiry(claims verify.Claims, now time.Time) time.Time {
+	exp, ok := claims["exp"].(json.Number)
+	if !ok {
+		return now.Add(maxCacheLifetime)
+	}
+	f, err := exp.Float64()
+	if err != nil {
+		return now
+	}
+	return time.Unix(int64(f), 0)
+}
+
+func (v *Validator) validate(raw string) Result {
+	tok, err := verify.ParseUnverified(raw)
+	if err != nil {
+		return Result{Error: err.Error()}
+	}
+
+	iss, _ := tok.Claims["iss"].(string)
+	if iss == "" {
+		return Result{Error: "token has no iss claim"}
+	}
+	candidates := v.resolve(iss, tok.Claims)
+	if len(candidates) == 0 {
+		return Result{Error: fmt.Sprintf("unknown issuer %q", iss)}
+	}
+
+	// Several IDPs may share an issuer (e.g. Azure tenants by domain), any of them may hold the key
+	var lastErr error
+	for _, idp := range candidates {
+		if err := tok.Verify(v.keyfunc(idp), idp.policy); err != nil {
+			lastErr = err
+			continue
+		}
+		return Result{Valid: true, IDP: idp.name, Claims: tok.Claims}
+	}
+	return Result{IDP: candidates[0].name, Error: lastErr.Error()}
+}
+
+// resolve returns the IDPs whose issuer matches iss
+func (v *Validator) resolve(iss string, claims verify.Claims) []trustedIDP {
+	candidates := append([]trustedIDP(nil), v.issuers[iss]...)
+
+	if tid, _ := claims["tid"].(string); tid != "" {
+		for _, idp := range v.templated {
+			if strings.ReplaceAll(idp.issuer, tenantPlaceholder, tid) == iss {
+				candidates = append(candidates, idp)
+			}
+		}
+	}
+	return candidates
+}
+
+// IssuerFor returns the issuer serving a WebFinger domain, matched against the issuer's host
+// or one of its path segments (e.g. an Azure AD tenant domain). The first IDP in config order wins.
+func (v *Validator) IssuerFor(domain string) (string, bool) {
+	for _, idp := range v.ordered {
+		u, err := url.Parse(idp.issuer)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(u.Host, domain) {
+			return idp.issuer, true
+		}
+		for _, segment := range strings.Split(u.Path, "/") {
+			if segment != "" && strings.EqualFold(segment, domain) {
+				return idp.issuer, true
+			}
+		}
+	}
+	return "", false
+}
+
+// keyfunc serves the IDP's current keys from the manager, none once the IDP is drained.
+// Tokens carry the upstream kid, so it is renamed like the stored keys before the lookup.
+func (v *Validator) keyfunc(idp trustedIDP) verify.Keyfunc {
+	return func(kid, alg string) ([]crypto.PublicKey, error) {
+		keySet, ok := v.manager.ServedJWKS(idp.name, time.Now())
+		if !ok {
+			return nil, fmt.Errorf("no keys available for IDP %q", idp.name)
+		}
+		if idp.transform != nil {
+			kid = idp.transform.Kid(kid)
+		}
+		return verify.KeysFromJWKS(keySet)(kid, alg)
+	}
+}
+
+// MaxBatchSize returns how many tokens ValidateBatch accepts
+func (v *Validator) MaxBatchSize() int {
+	return v.maxBatchSize
+}
+
+// ValidateBatch validates tokens in parallel, results are in input order.
+// No proofs come with a batch, so sender-constrained tokens are rejected.
+func (v *Validator) ValidateBatch(tokens []string) []Result {
+	results := make([]Result, len(tokens))
+
+	workers := min(runtime.GOMAXPROCS(0), len(tokens))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = v.Validate(tokens[i])
+			}
+		}()
+	}
+	for i := range tokens {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
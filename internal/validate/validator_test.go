@@ -0,0 +1,149 @@
+package validate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/pkg/verify"
+)
+
+const testIssuer = "https://issuer.test"
+
+var b64 = base64.RawURLEncoding
+
+// sign builds an ES256 compact JWS
+func sign(t *testing.T, key *ecdsa.PrivateKey, header, claims map[string]any) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + b64.EncodeToString(sig)
+}
+
+func newKey(t *testing.T) (*ecdsa.PrivateKey, jwks.JWK) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := jwks.PublicKeyJWK(key.Public(), "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, jwk
+}
+
+// newValidator serves the IDP signing key from a manager, like the running service
+func newValidator(t *testing.T, signing jwks.JWK) *Validator {
+	t.Helper()
+	manager := jwks.NewManager(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	manager.Update("corp", &jwks.JWKS{Keys: []jwks.JWK{signing}}, 10, 900, nil)
+	return New(manager, []config.IDPConfig{{Name: "corp", Issuer: testIssuer}}, config.ValidationConfig{})
+}
+
+func TestValidateDPoPBoundToken(t *testing.T) {
+	idpKey, idpJWK := newKey(t)
+	v := newValidator(t, idpJWK)
+
+	clientKey, clientJWK := newKey(t)
+	clientJWK.Kid, clientJWK.Use, clientJWK.Alg = "", "", ""
+	jkt, err := clientJWK.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	token := sign(t, idpKey, map[string]any{"alg": "ES256", "kid": idpJWK.Kid}, map[string]any{
+		"iss": testIssuer,
+		"exp": now.Add(time.Hour).Unix(),
+		"cnf": map[string]any{"jkt": jkt},
+	})
+	ath := sha256.Sum256([]byte(token))
+	proof := func(jti string) string {
+		return sign(t, clientKey, map[string]any{"typ": "dpop+jwt", "alg": "ES256", "jwk": clientJWK}, map[string]any{
+			"htm": "POST",
+			"htu": "https://api.example.com/orders",
+			"iat": now.Unix(),
+			"jti": jti,
+			"ath": b64.EncodeToString(ath[:]),
+		})
+	}
+	presented := func(jti string) verify.Presentation {
+		return verify.Presentation{DPoP: proof(jti), Method: "POST", URL: "https://api.example.com/orders"}
+	}
+
+	if result := v.ValidatePresented(token, presented("a")); !result.Valid {
+		t.Fatalf("expected valid, got %q", result.Error)
+	}
+	// A cached result must not skip the proof
+	if result := v.ValidatePresented(token, presented("a")); result.Valid || !strings.Contains(result.Error, "replayed") {
+		t.Fatalf("expected replay rejection, got %+v", result)
+	}
+	if result := v.ValidatePresented(token, presented("b")); !result.Valid {
+		t.Fatalf("expected valid with a fresh proof, got %q", result.Error)
+	}
+	if result := v.Validate(token); result.Valid {
+		t.Fatal("bound token accepted without a proof")
+	}
+	if results := v.ValidateBatch([]string{token}); results[0].Valid {
+		t.Fatal("bound token accepted in a batch")
+	}
+}
+
+func TestValidateBearerToken(t *testing.T) {
+	idpKey, idpJWK := newKey(t)
+	v := newValidator(t, idpJWK)
+
+	token := sign(t, idpKey, map[string]any{"alg": "ES256", "kid": idpJWK.Kid}, map[string]any{
+		"iss": testIssuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	if result := v.Validate(token); !result.Valid {
+		t.Fatalf("expected valid, got %q", result.Error)
+	}
+}
+
+func TestValidateRefusesDrainedIDP(t *testing.T) {
+	idpKey, idpJWK := newKey(t)
+	v := newValidator(t, idpJWK)
+
+	token := sign(t, idpKey, map[string]any{"alg": "ES256", "kid": idpJWK.Kid}, map[string]any{
+		"iss": testIssuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	// Cached while the IDP is trusted
+	if result := v.Validate(token); !result.Valid {
+		t.Fatalf("expected valid, got %q", result.Error)
+	}
+
+	if _, err := v.manager.Drain("corp", 0); err != nil {
+		t.Fatal(err)
+	}
+	if result := v.Validate(token); result.Valid {
+		t.Fatal("token of a drained IDP validated")
+	}
+
+	v.manager.Undrain("corp")
+	if result := v.Validate(token); !result.Valid {
+		t.Fatalf("expected valid once undrained, got %q", result.Error)
+	}
+}
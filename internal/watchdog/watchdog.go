@@ -0,0 +1,121 @@
+// Package watchdog samples goroutine counts and heap statistics to catch leaks during soak tests
+package watchdog
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+var (
+	stats       = expvar.NewMap("watchdog")
+	goroutines  = new(expvar.Int)
+	updaters    = new(expvar.Int)
+	heapAlloc   = new(expvar.Int)
+	heapObjects = new(expvar.Int)
+	leaks       = new(expvar.Int) // samples with more updaters running than IDPs supervised
+)
+
+func init() {
+	stats.Set("goroutines", goroutines)
+	stats.Set("updaters", updaters)
+	stats.Set("heap_alloc_bytes", heapAlloc)
+	stats.Set("heap_objects", heapObjects)
+	stats.Set("updater_leaks", leaks)
+}
+
+// sample is one reading of the runtime
+type sample struct {
+	goroutines  int
+	updaters    int
+	heapAlloc   uint64
+	heapObjects uint64
+}
+
+func read() sample {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return sample{
+		goroutines:  runtime.NumGoroutine(),
+		updaters:    jwks.RunningUpdaters(),
+		heapAlloc:   mem.HeapAlloc,
+		heapObjects: mem.HeapObjects,
+	}
+}
+
+// Watchdog logs the change of every sample against the previous one and the first one
+type Watchdog struct {
+	config   config.WatchdogConfig
+	expected func() int // updaters that should be running, one per IDP currently supervised
+	logger   *slog.Logger
+}
+
+// New creates a watchdog expecting as many updaters as expected returns at each sample, so IDPs
+// added or removed at runtime are accounted for
+func New(cfg config.WatchdogConfig, expected func() int, logger *slog.Logger) *Watchdog {
+	return &Watchdog{config: cfg, expected: expected, logger: logger}
+}
+
+// Run samples every interval until ctx is cancelled
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.config.GetInterval())
+	defer ticker.Stop()
+
+	baseline := read()
+	previous := baseline
+	w.publish(baseline)
+	w.logger.Info("Watchdog started",
+		"interval", w.config.GetInterval().String(),
+		"goroutines", baseline.goroutines,
+		"heap_alloc_bytes", baseline.heapAlloc,
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// IDPs added or removed while sampling count either way
+		before := w.expected()
+		s := read()
+		expected := max(before, w.expected())
+		w.publish(s)
+		w.logger.Info("Watchdog sample",
+			"goroutines", s.goroutines,
+			"goroutines_delta", s.goroutines-previous.goroutines,
+			"goroutines_since_start", s.goroutines-baseline.goroutines,
+			"updaters", s.updaters,
+			"heap_alloc_bytes", s.heapAlloc,
+			"heap_alloc_delta", int64(s.heapAlloc)-int64(previous.heapAlloc),
+			"heap_objects", s.heapObjects,
+			"heap_objects_delta", int64(s.heapObjects)-int64(previous.heapObjects),
+		)
+
+		// More updaters than IDPs means an updater wasn't stopped when it was replaced or removed
+		if s.updaters > expected {
+			leaks.Add(1)
+			w.logger.Error("More updaters running than IDPs supervised, updater goroutines are leaking",
+				"updaters", s.updaters,
+				"idps", expected,
+			)
+		}
+		if limit := w.config.GoroutineLimit; limit > 0 && s.goroutines > limit {
+			w.logger.Error("Goroutine count above limit", "goroutines", s.goroutines, "limit", limit)
+		}
+		previous = s
+	}
+}
+
+func (w *Watchdog) publish(s sample) {
+	goroutines.Set(int64(s.goroutines))
+	updaters.Set(int64(s.updaters))
+	heapAlloc.Set(int64(s.heapAlloc))
+	heapObjects.Set(int64(s.heapObjects))
+}
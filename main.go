@@ -2,66 +2,306 @@ package main
 
 import (
 	"context"
+	"crypto/fips140"
+	"errors"
+	"expvar"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"slices"
 	"syscall"
 	"time"
 
+	"github.com/kiquetal/go-idp-caller/internal/alert"
+	"github.com/kiquetal/go-idp-caller/internal/audit"
 	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/events"
 	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/internal/publish"
+	"github.com/kiquetal/go-idp-caller/internal/replicas"
 	"github.com/kiquetal/go-idp-caller/internal/server"
+	"github.com/kiquetal/go-idp-caller/internal/signer"
+	"github.com/kiquetal/go-idp-caller/internal/storage"
+	"github.com/kiquetal/go-idp-caller/internal/token"
+	"github.com/kiquetal/go-idp-caller/internal/upgrade"
+	"github.com/kiquetal/go-idp-caller/internal/validate"
+	"github.com/kiquetal/go-idp-caller/internal/version"
+	"github.com/kiquetal/go-idp-caller/internal/watchdog"
 )
 
 func main() {
-	// Load configuration from environment variable or default
-	configPath := os.Getenv("CONFIG_PATH")
-	if configPath == "" {
-		configPath = "config.yaml"
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "selftest":
+			os.Exit(runSelftest(os.Args[2:]))
+		case "conformance":
+			os.Exit(runConformance(os.Args[2:]))
+		case "healthcheck":
+			os.Exit(runHealthcheck(os.Args[2:]))
+		case "warmup":
+			os.Exit(runWarmup(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "--minimal", "-minimal":
+			os.Exit(runMinimal(os.Args[2:]))
+		}
 	}
 
+	configPath := defaultConfigPath()
 	cfg, err := config.Load(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Initialize logger
-	logger := config.InitLogger(cfg.Logging)
-	logger.Info("Starting IDP JWS caller service")
+	// Initialize logger; its settings are reapplied from the config file on SIGHUP
+	logHandler, err := config.NewLogHandler(cfg.Logging)
+	if err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	logger := slog.New(logHandler)
+	build := version.Get()
+	logger.Info("Starting IDP JWS caller service",
+		"version", build.Version,
+		"commit", build.Commit,
+		"build_date", build.BuildDate,
+		"go_version", build.GoVersion,
+	)
+
+	// Log what was actually loaded, so "which config did this pod get" is answered by the first log lines
+	effective, err := cfg.Effective()
+	if err != nil {
+		logger.Error("Failed to render effective configuration", "error", err)
+	} else {
+		logger.Info("Effective configuration", config.LogAttrs(effective)...)
+	}
+
+	// The FIPS policy applies to every key set and validation, so it's set before anything is fetched
+	jwks.SetFIPS(cfg.FIPS)
+	if jwks.FIPS() {
+		logger.Info("FIPS mode enabled, non-approved keys and algorithms are refused", "fips140_module", fips140.Enabled())
+	}
 
 	// Create JWKS manager
 	manager := jwks.NewManager(logger)
+	limits := jwks.Limits{
+		MaxTotalKeys:     cfg.Limits.MaxTotalKeys,
+		MaxTotalKeyBytes: cfg.Limits.MaxTotalKeyBytes,
+		MaxKeyBytes:      make(map[string]int, len(cfg.IDPs)),
+	}
+	for _, idp := range cfg.IDPs {
+		limits.MaxKeyBytes[idp.Name] = idp.GetMaxKeyBytes()
+	}
+	manager.SetLimits(limits)
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start JWKS updaters for each IDP
+	// Create JWKS updaters for each IDP
+	var updaterOpts []jwks.UpdaterOption
+	if cfg.Recording != nil {
+		logger.Warn("Upstream recording enabled", "mode", cfg.Recording.Mode, "directory", cfg.Recording.GetDirectory())
+		updaterOpts = append(updaterOpts, jwks.WithRecording(*cfg.Recording))
+	}
+	if limiter := jwks.NewOutboundLimiter(cfg.Outbound); limiter != nil {
+		logger.Info("Outbound fetches limited",
+			"max_concurrent_fetches", cfg.Outbound.MaxConcurrentFetches,
+			"max_fetches_per_second", cfg.Outbound.MaxFetchesPerSecond,
+		)
+		updaterOpts = append(updaterOpts, jwks.WithOutboundLimiter(limiter))
+	}
+	supervisor := jwks.NewSupervisor(manager, logger, updaterOpts...)
+	supervisor.SetTombstoneRetention(cfg.Admin.GetTombstoneRetention())
+	updaters := make([]*jwks.Updater, 0, len(cfg.IDPs))
 	for _, idp := range cfg.IDPs {
-		logger.Info("Starting updater for IDP", "name", idp.Name, "url", idp.URL, "interval", idp.RefreshInterval)
-		updater := jwks.NewUpdater(idp, manager, logger)
-		go updater.Start(ctx)
+		updaters = append(updaters, jwks.NewUpdater(idp, manager, logger, updaterOpts...))
+	}
+
+	// Publish key sets and change events; subscribed before the first fetch so it's included
+	if cfg.Publish != nil {
+		go publish.New(*cfg.Publish, cfg.Merged, manager, logger).Run(ctx)
+	}
+	if cfg.Events != nil {
+		go events.New(*cfg.Events, manager, logger).Run(ctx)
+	}
+	if cfg.Alerts != nil {
+		go alert.New(*cfg.Alerts, manager, logger).Run(ctx)
+	}
+	if cfg.Watchdog != nil {
+		go watchdog.New(*cfg.Watchdog, supervisor.Running, logger).Run(ctx)
+	}
+
+	// Share fetched keys with the other replicas, starting from what they stored
+	var store storage.Storage
+	var syncer *storage.Syncer
+	if cfg.Storage != nil {
+		store, err = storage.Open(ctx, *cfg.Storage, logger)
+		if err != nil {
+			logger.Error("Failed to open storage", "type", cfg.Storage.Type, "error", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+
+		syncer = storage.NewSyncer(store, manager, cfg.IDPs, cfg.Storage.GetInterval(), logger)
+		if err := syncer.Restore(ctx); err != nil {
+			logger.Warn("Failed to restore stored keys, waiting for the initial fetch", "type", cfg.Storage.Type, "error", err)
+		}
+		go syncer.Run(ctx)
+	}
+
+	// Record admin actions, including the ones triggered by signals
+	auditLog, err := audit.New(cfg.Admin.Audit, logger)
+	if err != nil {
+		logger.Error("Failed to open audit log", "error", err)
+		os.Exit(1)
 	}
+	defer auditLog.Close()
 
-	// Create and start HTTP server
+	// The embedded store of single-node deployments keeps the fetch history and the audit log as well
+	if embedded, ok := store.(*storage.Embedded); ok {
+		embedded.RecordFetches(manager)
+		embedded.RecordAudit(auditLog, cfg.Admin.Audit.File == "")
+	}
+
+	// Create and start HTTP server, on the sockets of the previous process after an upgrade
 	srv := server.New(cfg.Server, manager, logger)
+	srv.SetInherited(upgrade.Inherited())
+	srv.SetAudit(auditLog)
+
+	tokenClients := newTokenClients(cfg.IDPs, nil)
+	srv.SetTokenClients(tokenClients)
+	if len(tokenClients) > 0 && !servesTokens(cfg.Server) {
+		logger.Warn("IDPs have client_credentials but no listener serves the token route group", "hint", "add token to the routes of a listener")
+	}
+	if len(tokenClients) > 0 && !cfg.Admin.Enabled() {
+		logger.Warn("POST /token/{idp} needs a client role, but admin authentication is not configured")
+	}
+	srv.SetAdmin(cfg.Admin)
+	srv.SetSLO(cfg.SLO)
+	srv.SetServePaths(cfg.ServePaths())
+	srv.SetAudiences(cfg.AudienceIDPs())
+	srv.SetMerged(cfg.Merged)
+	srv.SetConfig(effective)
+	srv.SetFeatures(cfg.GetFeatures())
+	srv.SetSupervisor(supervisor, cfg.DeclareIDPs)
+	if cfg.Faults != nil {
+		logger.Warn("Fault injection enabled on JWKS endpoints, never use this in production",
+			"latency_ms", cfg.Faults.Latency,
+			"error_rate", cfg.Faults.ErrorRate,
+			"truncate_rate", cfg.Faults.TruncateRate,
+		)
+		srv.SetFaults(cfg.Faults)
+	}
+	expvar.Publish("slo", expvar.Func(func() any { return srv.SLOReport() }))
+	if cfg.Replicas != nil {
+		checker := replicas.New(*cfg.Replicas, srv.MergedETag, logger)
+		srv.SetReplicas(checker)
+		go checker.Run(ctx)
+	}
+
+	// Load signing keys and publish their public part next to the IDP keys
+	if cfg.Signing != nil {
+		loadCtx, loadCancel := context.WithTimeout(ctx, 30*time.Second)
+		sg, err := signer.New(loadCtx, *cfg.Signing)
+		loadCancel()
+		if err != nil {
+			logger.Error("Failed to load signing keys", "error", err)
+			os.Exit(1)
+		}
+		keySet := sg.JWKS()
+		if jwks.FIPS() {
+			for _, k := range keySet.Keys {
+				if err := jwks.FIPSKeyError(k); err != nil {
+					logger.Error("Signing key isn't FIPS compliant", "kid", k.Kid, "error", err)
+					os.Exit(1)
+				}
+			}
+		}
+		manager.Update(sg.Name(), keySet, len(keySet.Keys), 900, nil)
+		srv.SetSigner(sg)
+		logger.Info("Signing enabled", "name", sg.Name(), "issuer", cfg.Signing.Issuer, "keys", len(keySet.Keys))
+	}
+
+	// Tokens are matched to IDPs by issuer, including the ones we mint ourselves
+	srv.SetValidator(validate.New(manager, trustedIDPs(cfg), cfg.Validation))
+
+	// What was built from the IDP list above follows the IDPs PUT /admin/state declares.
+	// serve_path routes are registered once, so declarations can't change them.
+	supervisor.OnChange(func(idps []config.IDPConfig) {
+		declared := *cfg
+		declared.IDPs = idps
+		tokenClients = newTokenClients(idps, tokenClients)
+		srv.SetTokenClients(tokenClients)
+		srv.SetAudiences(declared.AudienceIDPs())
+		srv.SetValidator(validate.New(manager, trustedIDPs(&declared), cfg.Validation))
+		if syncer != nil {
+			syncer.SetIDPs(idps)
+		}
+	})
+
 	go func() {
-		if err := srv.Start(); err != nil {
+		if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("Server failed", "error", err)
 			cancel()
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Fetch all IDPs once through a bounded pool, then start the periodic updaters
+	go func() {
+		jwks.FetchAll(ctx, updaters, cfg.Startup.GetConcurrency(), cfg.Startup.GetTimeout(), logger)
+		srv.MarkReady()
+		if err := upgrade.Ready(); err != nil {
+			logger.Error("Failed to notify the previous process", "error", err)
+		}
+
+		supervisor.Start(ctx, updaters)
+	}()
+
+	// Wait for interrupt signal, or an upgrade signal handing our sockets to a new binary
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	upgrade.Notify(sigChan)
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
 
-	<-sigChan
-	logger.Info("Received shutdown signal")
+	handedOver := false
+wait:
+	for {
+		select {
+		case sig := <-sigChan:
+			if upgrade.IsSignal(sig) {
+				if handedOver = handOver(srv, cfg.Startup.GetTimeout()+30*time.Second, auditLog, logger); !handedOver {
+					continue
+				}
+			} else {
+				logger.Info("Received shutdown signal")
+			}
+			break wait
+		case <-reloadChan:
+			reloadLogging(configPath, logHandler, auditLog, logger)
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	// Fail readiness first and keep serving, so load balancers stop routing to us before connections are refused.
+	// Not needed after a handover, the new process already accepts on the same sockets.
+	if delay := cfg.Server.GetShutdownDelay(); delay > 0 && !handedOver {
+		srv.Drain()
+		logger.Info("Waiting before shutdown", "delay", delay.String())
+		select {
+		case <-time.After(delay):
+		case <-sigChan:
+			logger.Info("Received second signal, skipping shutdown delay")
+		}
+	}
 
 	// Graceful shutdown
 	cancel()
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Server.GetShutdownTimeout())
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
@@ -70,3 +310,106 @@ func main() {
 
 	logger.Info("Service stopped")
 }
+
+// handOver starts the new binary on our listening sockets and reports whether it took over.
+// The new process has until timeout to finish its initial fetch.
+func handOver(srv *server.Server, timeout time.Duration, auditLog *audit.Log, logger *slog.Logger) bool {
+	logger.Info("Received upgrade signal, starting new process")
+
+	files, err := srv.ListenerFiles()
+	if err != nil {
+		logger.Error("Upgrade failed, keeping this process", "error", err)
+		return false
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	proc, err := upgrade.Spawn(files, timeout)
+	if err != nil {
+		logger.Error("Upgrade failed, keeping this process", "error", err)
+		return false
+	}
+	logger.Info("New process is serving, draining", "pid", proc.Pid)
+	auditLog.Record(audit.Record{
+		Actor:  "signal:SIGUSR2",
+		Action: "process.upgrade",
+		Before: map[string]any{"pid": os.Getpid()},
+		After:  map[string]any{"pid": proc.Pid},
+	})
+	return true
+}
+
+// reloadLogging applies the logging settings of the config file without a restart.
+// The rest of the file is only read at startup.
+func reloadLogging(path string, handler *config.LogHandler, auditLog *audit.Log, logger *slog.Logger) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		logger.Error("Failed to reload logging configuration", "error", err)
+		return
+	}
+	before := handler.Config()
+	if err := handler.Reconfigure(cfg.Logging); err != nil {
+		logger.Error("Failed to reload logging configuration", "error", err)
+		return
+	}
+	auditLog.Record(audit.Record{
+		Actor:  "signal:SIGHUP",
+		Action: "logging.reload",
+		Before: loggingFields(before),
+		After:  loggingFields(cfg.Logging),
+	})
+	logger.Info("Logging configuration reloaded", "level", cfg.Logging.Level, "format", cfg.Logging.Format, "output", cfg.Logging.Output)
+}
+
+// loggingFields is the logging configuration as recorded in the audit log
+func loggingFields(c config.LoggingConfig) map[string]string {
+	return map[string]string{"level": c.Level, "format": c.Format, "output": c.Output}
+}
+
+// defaultConfigPath returns CONFIG_PATH or config.yaml
+func defaultConfigPath() string {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		return path
+	}
+	return "config.yaml"
+}
+
+// newTokenClients creates a token client per IDP with client credentials, keeping the client of
+// previous, and the token it cached, for IDPs whose credentials didn't change
+func newTokenClients(idps []config.IDPConfig, previous map[string]*token.Client) map[string]*token.Client {
+	clients := make(map[string]*token.Client)
+	for _, idp := range idps {
+		if idp.ClientCredentials == nil {
+			continue
+		}
+		if client, ok := previous[idp.Name]; ok && reflect.DeepEqual(client.Config(), *idp.ClientCredentials) {
+			clients[idp.Name] = client
+			continue
+		}
+		clients[idp.Name] = token.NewClient(*idp.ClientCredentials)
+	}
+	return clients
+}
+
+// trustedIDPs returns the IDPs tokens are validated against: the configured ones and, when
+// signing is configured, our own issuer
+func trustedIDPs(cfg *config.Config) []config.IDPConfig {
+	trusted := cfg.IDPs
+	if cfg.Signing != nil {
+		trusted = append(trusted[:len(trusted):len(trusted)], config.IDPConfig{Name: cfg.Signing.GetName(), Issuer: cfg.Signing.Issuer})
+	}
+	return trusted
+}
+
+// servesTokens reports whether a listener serves POST /token/{idp}
+func servesTokens(c config.ServerConfig) bool {
+	for _, l := range c.GetListeners() {
+		if slices.Contains(l.GetRoutes(), config.RoutesToken) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/internal/publish"
+	"github.com/kiquetal/go-idp-caller/internal/server"
+)
+
+// runMinimal fetches a single IDP and serves only its key set on /.well-known/jwks.json, plus
+// /health and /ready for probes: a localhost JWKS cache running as a sidecar next to each app.
+// No admin, status, metrics or other endpoints are served and nothing else is kept in memory.
+// The key set can also be written to a shared directory, for apps to read while the listener restarts.
+func runMinimal(args []string) int {
+	fs := flag.NewFlagSet("minimal", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "configuration with a single IDP, not read when -url is set")
+	url := fs.String("url", "", "JWKS URL of the IDP, instead of a configuration file")
+	listen := fs.String("listen", "127.0.0.1:8080", "address to serve on, host:port or unix:/path")
+	socketMode := fs.String("socket-mode", "0666", "octal permissions of a unix socket")
+	publishDir := fs.String("publish-dir", "", "directory receiving jwks.json on every key change, e.g. a shared emptyDir")
+	refresh := fs.Int("refresh-interval", 3600, "seconds between fetches, with -url")
+	cacheDuration := fs.Int("cache-duration", 900, "seconds clients may cache the keys, with -url")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimal: invalid socket mode %q\n", *socketMode)
+		return 2
+	}
+
+	cfg, err := minimalConfig(*configPath, *url, *refresh, *cacheDuration)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimal: %v\n", err)
+		return 2
+	}
+	logHandler, err := config.NewLogHandler(cfg.Logging)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimal: failed to initialize logging: %v\n", err)
+		return 2
+	}
+	logger := slog.New(logHandler)
+	jwks.SetFIPS(cfg.FIPS)
+	idp := cfg.IDPs[0]
+
+	// The response is encoded once per key change instead of per request
+	manager := jwks.NewManager(logger)
+	var body atomic.Pointer[[]byte]
+	manager.OnChange(func(c jwks.Change) {
+		if c.JWKS == nil {
+			return
+		}
+		if data, err := json.Marshal(c.JWKS); err == nil {
+			body.Store(&data)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	publishCfg := cfg.Publish
+	if *publishDir != "" {
+		publishCfg = &config.PublishConfig{Directory: *publishDir}
+	}
+	if publishCfg != nil {
+		go publish.New(*publishCfg, cfg.Merged, manager, logger).Run(ctx)
+	}
+	go jwks.NewUpdater(idp, manager, logger).Start(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		data := body.Load()
+		if data == nil {
+			http.Error(w, "Keys not loaded yet", http.StatusServiceUnavailable)
+			return
+		}
+		maxAge := idp.GetCacheDuration()
+		if current, ok := manager.Get(idp.Name); ok && current.CacheDuration > 0 {
+			maxAge = current.CacheDuration
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+		w.Write(*data)
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		if body.Load() == nil {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ready\n"))
+	})
+
+	ln, err := server.Listen(*listen, os.FileMode(mode))
+	if err != nil {
+		logger.Error("Failed to listen", "addr", *listen, "error", err)
+		return 1
+	}
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    8 << 10,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+	logger.Info("Serving JWKS in minimal mode", "idp", idp.Name, "addr", *listen, "publish_dir", *publishDir)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errCh:
+		logger.Error("Server failed", "error", err)
+		return 1
+	case <-sigChan:
+	}
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Shutdown failed", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// minimalConfig returns the configuration of minimal mode: a single IDP from the file, or one
+// built from the flags when url is set
+func minimalConfig(path, url string, refresh, cacheDuration int) (*config.Config, error) {
+	if url == "" {
+		cfg, err := config.Load(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration: %w", err)
+		}
+		if len(cfg.IDPs) != 1 {
+			return nil, fmt.Errorf("minimal mode serves a single IDP, %s has %d", path, len(cfg.IDPs))
+		}
+		return cfg, nil
+	}
+
+	cfg := &config.Config{IDPs: []config.IDPConfig{{
+		Name:            "idp",
+		URL:             url,
+		RefreshInterval: refresh,
+		CacheDuration:   cacheDuration,
+	}}}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
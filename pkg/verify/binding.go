@@ -0,0 +1,185 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultProofMaxAge is used when a Presentation leaves ProofMaxAge at zero
+const DefaultProofMaxAge = 5 * time.Minute
+
+// Presentation is what a client presented along with a sender-constrained token:
+// a DPoP proof (RFC 9449) or its mTLS client certificate (RFC 8705)
+type Presentation struct {
+	DPoP       string            // DPoP header of the resource request
+	Method     string            // method of the resource request, matched against htm
+	URL        string            // URL of the resource request, matched against htu
+	ClientCert *x509.Certificate // certificate the client authenticated the resource request with
+
+	ProofMaxAge time.Duration // how old a proof's iat may be (default: DefaultProofMaxAge)
+	ClockSkew   time.Duration // tolerance for proofs issued in the future (default: DefaultClockSkew)
+	Now         func() time.Time
+
+	// Seen reports whether a proof's jti was already used, remembering it until it expires.
+	// Nil skips replay detection.
+	Seen func(jti string, expires time.Time) bool
+}
+
+// CheckBinding checks that a verified token bound through its cnf claim is presented by its
+// holder: cnf.jkt requires a DPoP proof signed with the bound key and cnf.x5t#S256 the bound
+// client certificate. Tokens without cnf are bearer tokens and pass.
+func CheckBinding(raw string, claims Claims, p Presentation) error {
+	cnfClaim, ok := claims["cnf"]
+	if !ok {
+		return nil
+	}
+	cnf, ok := cnfClaim.(map[string]any)
+	if !ok {
+		return errors.New("malformed cnf claim")
+	}
+	jkt, dpopBound := cnf["jkt"].(string)
+	x5t, certBound := cnf["x5t#S256"].(string)
+	if !dpopBound && !certBound {
+		return errors.New("unsupported confirmation method in cnf claim")
+	}
+
+	if dpopBound {
+		if p.DPoP == "" {
+			return errors.New("token is DPoP-bound but no DPoP proof was presented")
+		}
+		thumbprint, err := verifyProof(raw, p)
+		if err != nil {
+			return fmt.Errorf("invalid DPoP proof: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(jkt)) != 1 {
+			return errors.New("DPoP proof key doesn't match the token's cnf.jkt")
+		}
+	}
+	if certBound {
+		if p.ClientCert == nil {
+			return errors.New("token is certificate-bound but no client certificate was presented")
+		}
+		sum := sha256.Sum256(p.ClientCert.Raw)
+		if subtle.ConstantTimeCompare([]byte(b64.EncodeToString(sum[:])), []byte(x5t)) != 1 {
+			return errors.New("client certificate doesn't match the token's cnf.x5t#S256")
+		}
+	}
+	return nil
+}
+
+// verifyProof checks a DPoP proof for the access token and request and returns the thumbprint of its key
+func verifyProof(accessToken string, p Presentation) (string, error) {
+	proof, err := parse(p.DPoP)
+	if err != nil {
+		return "", err
+	}
+	if proof.header.Typ != "dpop+jwt" {
+		return "", errors.New("typ must be dpop+jwt")
+	}
+	alg := proof.header.Alg
+	if alg == "" || alg == "none" || strings.HasPrefix(alg, "HS") {
+		return "", fmt.Errorf("algorithm %q is not accepted", alg)
+	}
+	jwk := proof.header.JWK
+	if jwk == nil {
+		return "", errors.New("no jwk header")
+	}
+	if jwk.D != "" || jwk.K != "" {
+		return "", errors.New("jwk header must be a public key")
+	}
+	key, err := jwk.PublicKey()
+	if err != nil {
+		return "", fmt.Errorf("jwk header: %w", err)
+	}
+	if err := verifySignature(alg, key, proof.signingInput, proof.signature); err != nil {
+		return "", err
+	}
+
+	claims := Claims(proof.claims)
+	if htm, _ := claims["htm"].(string); htm == "" || htm != p.Method {
+		return "", errors.New("htm doesn't match the request method")
+	}
+	htu, _ := claims["htu"].(string)
+	if !sameTarget(htu, p.URL) {
+		return "", errors.New("htu doesn't match the request URL")
+	}
+	sum := sha256.Sum256([]byte(accessToken))
+	if ath, _ := claims["ath"].(string); subtle.ConstantTimeCompare([]byte(ath), []byte(b64.EncodeToString(sum[:]))) != 1 {
+		return "", errors.New("ath doesn't match the access token")
+	}
+
+	now := time.Now()
+	if p.Now != nil {
+		now = p.Now()
+	}
+	maxAge := p.ProofMaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultProofMaxAge
+	}
+	skew := p.ClockSkew
+	if skew <= 0 {
+		skew = DefaultClockSkew
+	}
+	iat, ok := numericClaim(claims, "iat")
+	if !ok {
+		return "", errors.New("no iat claim")
+	}
+	if now.Add(skew).Before(iat) {
+		return "", errors.New("issued in the future")
+	}
+	if now.Sub(iat) > maxAge {
+		return "", errors.New("too old")
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", errors.New("no jti claim")
+	}
+	if p.Seen != nil && p.Seen(jti, iat.Add(maxAge)) {
+		return "", errors.New("replayed")
+	}
+
+	return jwk.Thumbprint()
+}
+
+// sameTarget compares the htu of a proof with the request URL, ignoring query and fragment
+// and normalizing case and default ports as RFC 9449 requires
+func sameTarget(htu, requestURL string) bool {
+	a, err := normalizeTarget(htu)
+	if err != nil {
+		return false
+	}
+	b, err := normalizeTarget(requestURL)
+	if err != nil {
+		return false
+	}
+	return a == b
+}
+
+func normalizeTarget(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", errors.New("not an absolute URL")
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && !(scheme == "https" && port == "443") && !(scheme == "http" && port == "80") {
+		host += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path, nil
+}
@@ -0,0 +1,223 @@
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+const (
+	testMethod = "GET"
+	testURL    = "https://api.example.com/orders?page=2"
+	testToken  = "header.payload.signature"
+)
+
+// signES256 builds a compact JWS signed with key
+func signES256(t *testing.T, key *ecdsa.PrivateKey, header, claims map[string]any) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + b64.EncodeToString(sig)
+}
+
+// proofKey returns a DPoP key with its public JWK and thumbprint
+func proofKey(t *testing.T) (*ecdsa.PrivateKey, JWK, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := jwks.PublicKeyJWK(key.Public(), "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.Kid, jwk.Use, jwk.Alg = "", "", ""
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, jwk, jkt
+}
+
+// proofClaims are the claims of a valid proof for testMethod, testURL and testToken
+func proofClaims(now time.Time) map[string]any {
+	ath := sha256.Sum256([]byte(testToken))
+	return map[string]any{
+		"htm": testMethod,
+		"htu": "https://API.example.com:443/orders",
+		"iat": now.Unix(),
+		"jti": "proof-1",
+		"ath": b64.EncodeToString(ath[:]),
+	}
+}
+
+func TestCheckBindingDPoP(t *testing.T) {
+	now := time.Now()
+	key, jwk, jkt := proofKey(t)
+	header := map[string]any{"typ": "dpop+jwt", "alg": "ES256", "jwk": jwk}
+	bound := Claims{"cnf": map[string]any{"jkt": jkt}}
+
+	tests := []struct {
+		name    string
+		mutate  func(header, claims map[string]any)
+		claims  Claims
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "wrong method", mutate: func(_, c map[string]any) { c["htm"] = "POST" }, wantErr: "htm"},
+		{name: "wrong url", mutate: func(_, c map[string]any) { c["htu"] = "https://api.example.com/other" }, wantErr: "htu"},
+		{name: "wrong access token", mutate: func(_, c map[string]any) { c["ath"] = "AAAA" }, wantErr: "ath"},
+		{name: "missing ath", mutate: func(_, c map[string]any) { delete(c, "ath") }, wantErr: "ath"},
+		{name: "too old", mutate: func(_, c map[string]any) { c["iat"] = now.Add(-time.Hour).Unix() }, wantErr: "too old"},
+		{name: "from the future", mutate: func(_, c map[string]any) { c["iat"] = now.Add(time.Hour).Unix() }, wantErr: "future"},
+		{name: "no jti", mutate: func(_, c map[string]any) { delete(c, "jti") }, wantErr: "jti"},
+		{name: "wrong typ", mutate: func(h, _ map[string]any) { h["typ"] = "JWT" }, wantErr: "typ"},
+		{name: "no jwk", mutate: func(h, _ map[string]any) { delete(h, "jwk") }, wantErr: "jwk"},
+		{name: "other key bound", claims: Claims{"cnf": map[string]any{"jkt": "not-this-key"}}, wantErr: "cnf.jkt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, c := map[string]any{}, proofClaims(now)
+			for k, v := range header {
+				h[k] = v
+			}
+			if tt.mutate != nil {
+				tt.mutate(h, c)
+			}
+			claims := bound
+			if tt.claims != nil {
+				claims = tt.claims
+			}
+			err := CheckBinding(testToken, claims, Presentation{
+				DPoP:   signES256(t, key, h, c),
+				Method: testMethod,
+				URL:    testURL,
+				Now:    func() time.Time { return now },
+			})
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCheckBindingDPoPSignedByOtherKey(t *testing.T) {
+	now := time.Now()
+	_, jwk, jkt := proofKey(t)
+	other, _, _ := proofKey(t)
+	proof := signES256(t, other, map[string]any{"typ": "dpop+jwt", "alg": "ES256", "jwk": jwk}, proofClaims(now))
+
+	err := CheckBinding(testToken, Claims{"cnf": map[string]any{"jkt": jkt}}, Presentation{DPoP: proof, Method: testMethod, URL: testURL})
+	checkErr(t, err, "invalid signature")
+}
+
+func TestCheckBindingDPoPReplay(t *testing.T) {
+	now := time.Now()
+	key, jwk, jkt := proofKey(t)
+	proof := signES256(t, key, map[string]any{"typ": "dpop+jwt", "alg": "ES256", "jwk": jwk}, proofClaims(now))
+	seen := map[string]bool{}
+	p := Presentation{DPoP: proof, Method: testMethod, URL: testURL, Seen: func(jti string, _ time.Time) bool {
+		replayed := seen[jti]
+		seen[jti] = true
+		return replayed
+	}}
+	claims := Claims{"cnf": map[string]any{"jkt": jkt}}
+
+	checkErr(t, CheckBinding(testToken, claims, p), "")
+	checkErr(t, CheckBinding(testToken, claims, p), "replayed")
+}
+
+func TestCheckBindingCertificate(t *testing.T) {
+	cert := selfSigned(t)
+	sum := sha256.Sum256(cert.Raw)
+	bound := Claims{"cnf": map[string]any{"x5t#S256": b64.EncodeToString(sum[:])}}
+
+	checkErr(t, CheckBinding(testToken, bound, Presentation{ClientCert: cert}), "")
+	checkErr(t, CheckBinding(testToken, bound, Presentation{}), "no client certificate")
+	checkErr(t, CheckBinding(testToken, bound, Presentation{ClientCert: selfSigned(t)}), "cnf.x5t#S256")
+}
+
+func TestCheckBindingUnbound(t *testing.T) {
+	checkErr(t, CheckBinding(testToken, Claims{"sub": "a"}, Presentation{}), "")
+	checkErr(t, CheckBinding(testToken, Claims{"cnf": map[string]any{"jwk": map[string]any{}}}, Presentation{}), "unsupported confirmation method")
+	checkErr(t, CheckBinding(testToken, Claims{"cnf": map[string]any{"jkt": "x"}}, Presentation{}), "no DPoP proof")
+}
+
+func TestSameTarget(t *testing.T) {
+	tests := []struct {
+		htu, url string
+		want     bool
+	}{
+		{"https://api.example.com/a", "https://api.example.com/a?x=1#f", true},
+		{"https://API.example.com:443/a", "https://api.example.com/a", true},
+		{"http://api.example.com:80/a", "http://api.example.com/a", true},
+		{"https://api.example.com:8443/a", "https://api.example.com/a", false},
+		{"https://api.example.com/a", "https://api.example.com/A", false},
+		{"https://api.example.com", "https://api.example.com/", true},
+		{"/a", "/a", false},
+	}
+	for _, tt := range tests {
+		if got := sameTarget(tt.htu, tt.url); got != tt.want {
+			t.Errorf("sameTarget(%q, %q) = %v, want %v", tt.htu, tt.url, got, tt.want)
+		}
+	}
+}
+
+func selfSigned(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// checkErr fails unless err is nil when want is empty, or contains want
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("expected error containing %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("error %q doesn't contain %q", err, want)
+	}
+}
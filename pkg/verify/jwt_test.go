@@ -0,0 +1,41 @@
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+func TestVerifySignatureECDSACurve(t *testing.T) {
+	curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for name, curve := range curves {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = key
+	}
+
+	input := []byte("header.claims")
+	for _, alg := range []string{"ES256", "ES384", "ES512"} {
+		hash, _ := hashFor(alg)
+		for name, key := range keys {
+			// A well-formed signature with the algorithm's hash and the key's own size
+			h := hash.New()
+			h.Write(input)
+			r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := (key.Curve.Params().BitSize + 7) / 8
+			sig := append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+
+			err = verifySignature(alg, &key.PublicKey, input, sig)
+			if want := esCurves[alg] == name; (err == nil) != want {
+				t.Errorf("%s with a %s key: accepted %v, want %v (%v)", alg, name, err == nil, want, err)
+			}
+		}
+	}
+}
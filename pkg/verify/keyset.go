@@ -0,0 +1,147 @@
+package verify
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// JWK and JWKS are the key types served by idp-caller
+type (
+	JWK  = jwks.JWK
+	JWKS = jwks.JWKS
+)
+
+// KeysFromJWKS returns a Keyfunc selecting keys of set by kid and alg
+func KeysFromJWKS(set *JWKS) Keyfunc {
+	return func(kid, alg string) ([]crypto.PublicKey, error) {
+		return selectKeys(set, kid, alg), nil
+	}
+}
+
+// selectKeys returns the signing keys matching kid (when set) and alg (when the key declares one)
+func selectKeys(set *JWKS, kid, alg string) []crypto.PublicKey {
+	if set == nil {
+		return nil
+	}
+	var keys []crypto.PublicKey
+	for _, k := range set.Keys {
+		if kid != "" && k.Kid != kid {
+			continue
+		}
+		if (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != alg) {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, pub)
+	}
+	return keys
+}
+
+// RemoteKeySet fetches a JWKS over HTTP, refreshing it when it gets old or a token
+// carries an unknown kid (rate limited so bogus kids can't hammer the endpoint)
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+
+	// MaxAge is how long a fetched set is used before it is refreshed (default: 15 minutes)
+	MaxAge time.Duration
+	// MinRefreshInterval limits refreshes triggered by unknown kids, and is how long a failed
+	// fetch is not retried (default: 30 seconds)
+	MinRefreshInterval time.Duration
+
+	mu        sync.Mutex
+	set       *JWKS
+	fetchedAt time.Time
+	retryAt   time.Time // no fetch before, set when one failed
+	lastErr   error     // error of the failed fetch, returned until retryAt
+}
+
+// NewRemoteKeySet creates a key set for url; a nil client uses a client with a 10 second timeout
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteKeySet{
+		url:                url,
+		client:             client,
+		MaxAge:             15 * time.Minute,
+		MinRefreshInterval: 30 * time.Second,
+	}
+}
+
+// Keyfunc returns a Keyfunc that fetches the key set with ctx when needed
+func (r *RemoteKeySet) Keyfunc(ctx context.Context) Keyfunc {
+	return func(kid, alg string) ([]crypto.PublicKey, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.set == nil || time.Since(r.fetchedAt) > r.MaxAge {
+			if err := r.refresh(ctx); err != nil && r.set == nil {
+				return nil, err
+			}
+		}
+
+		keys := selectKeys(r.set, kid, alg)
+		if len(keys) == 0 && time.Since(r.fetchedAt) > r.MinRefreshInterval {
+			// The key may have been rotated in since the last fetch
+			if err := r.refresh(ctx); err != nil {
+				return nil, err
+			}
+			keys = selectKeys(r.set, kid, alg)
+		}
+		return keys, nil
+	}
+}
+
+// refresh fetches the key set, keeping the previous one on failure. After a failure it returns the
+// same error without fetching for MinRefreshInterval, so validations don't queue up behind a fetch
+// each while the endpoint is down.
+func (r *RemoteKeySet) refresh(ctx context.Context) error {
+	if time.Now().Before(r.retryAt) {
+		return r.lastErr
+	}
+	err := r.fetch(ctx)
+	if err != nil && ctx.Err() == nil {
+		r.retryAt = time.Now().Add(r.MinRefreshInterval)
+		r.lastErr = err
+	}
+	return err
+}
+
+// fetch downloads and decodes the key set
+func (r *RemoteKeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: unexpected status code %d", resp.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 5<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	r.set = &set
+	r.fetchedAt = time.Now()
+	return nil
+}
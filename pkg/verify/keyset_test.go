@@ -0,0 +1,97 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteKeySetBacksOffAfterFailure(t *testing.T) {
+	_, jwk, _ := proofKey(t)
+	jwk.Kid = "k1"
+	var requests atomic.Int32
+	var down atomic.Bool
+	down.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{jwk}})
+	}))
+	defer upstream.Close()
+
+	keys := NewRemoteKeySet(upstream.URL, upstream.Client())
+	keys.MinRefreshInterval = time.Hour
+	keyfunc := keys.Keyfunc(context.Background())
+
+	// Without any key set every validation fails, but only the first one fetches
+	for range 10 {
+		if _, err := keyfunc("k1", "ES256"); err == nil {
+			t.Fatal("no error while the endpoint is down")
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("%d requests while the endpoint is down, want 1", n)
+	}
+
+	// Once the backoff has passed the next validation fetches again
+	down.Store(false)
+	keys.retryAt = time.Time{}
+	if found, err := keyfunc("k1", "ES256"); err != nil || len(found) != 1 {
+		t.Fatalf("keyfunc() = %d keys, %v after the endpoint recovered", len(found), err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("%d requests after recovery, want 2", n)
+	}
+
+	// A stale key set stays in use while refreshing it fails, without a fetch per validation
+	down.Store(true)
+	keys.MaxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	for range 10 {
+		if found, err := keyfunc("k1", "ES256"); err != nil || len(found) != 1 {
+			t.Fatalf("keyfunc() = %d keys, %v with a stale key set", len(found), err)
+		}
+	}
+	// Unknown kids don't get around the backoff either
+	for range 10 {
+		if found, _ := keyfunc("rotated", "ES256"); len(found) != 0 {
+			t.Fatalf("%d keys for an unknown kid", len(found))
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("%d requests while refreshing a stale key set fails, want 3", n)
+	}
+}
+
+func TestRemoteKeySetCanceledFetchDoesNotBackOff(t *testing.T) {
+	_, jwk, _ := proofKey(t)
+	jwk.Kid = "k1"
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{jwk}})
+	}))
+	defer upstream.Close()
+
+	keys := NewRemoteKeySet(upstream.URL, upstream.Client())
+	keys.MinRefreshInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := keys.Keyfunc(ctx)("k1", "ES256"); err == nil {
+		t.Fatal("no error with a canceled context")
+	}
+	// The caller gave up, which says nothing about the endpoint
+	if found, err := keys.Keyfunc(context.Background())("k1", "ES256"); err != nil || len(found) != 1 {
+		t.Fatalf("keyfunc() = %d keys, %v after a canceled fetch", len(found), err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("%d requests, want 1", n)
+	}
+}
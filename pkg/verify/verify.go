@@ -0,0 +1,189 @@
+// Package verify checks JWTs against JWKS key sets and a validation policy.
+//
+// It is used by the /validate endpoint and can be imported by services that
+// verify tokens locally against the merged JWKS served by idp-caller:
+//
+//	keys := verify.NewRemoteKeySet("http://idp-caller:8080/.well-known/jwks.json", nil)
+//	claims, err := verify.Verify(raw, keys.Keyfunc(ctx), verify.Policy{
+//		Issuer:    "https://tenant.eu.auth0.com/",
+//		Audiences: []string{"https://api.example.com"},
+//	})
+package verify
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultClockSkew is used when a Policy leaves ClockSkew at zero
+const DefaultClockSkew = time.Minute
+
+// Policy is what a token must satisfy besides a valid signature
+type Policy struct {
+	Issuer         string        // required iss, empty skips the check
+	Audiences      []string      // accepted aud values, empty accepts any
+	ClockSkew      time.Duration // tolerance for exp, nbf and iat (default: DefaultClockSkew)
+	RequiredClaims []string      // claims that must be present
+	MaxTokenAge    time.Duration // maximum time since iat, zero disables the check
+	Algorithms     []string      // accepted alg values, empty accepts every supported asymmetric one
+
+	Now func() time.Time // clock, time.Now when nil
+}
+
+// Keyfunc returns the candidate keys for a token's kid and alg
+type Keyfunc func(kid, alg string) ([]crypto.PublicKey, error)
+
+// Claims are the decoded claims of a token; numbers are json.Number
+type Claims map[string]any
+
+// Token is a parsed but unverified JWT
+type Token struct {
+	Alg    string
+	Kid    string
+	Claims Claims
+
+	parsed *token
+}
+
+// ParseUnverified decodes a token without checking it, e.g. to route it by iss before verification
+func ParseUnverified(raw string) (*Token, error) {
+	tok, err := parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{Alg: tok.header.Alg, Kid: tok.header.Kid, Claims: tok.claims, parsed: tok}, nil
+}
+
+// Verify parses raw, verifies its signature with a key from keyfunc and checks it against policy
+func Verify(raw string, keyfunc Keyfunc, policy Policy) (Claims, error) {
+	tok, err := ParseUnverified(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := tok.Verify(keyfunc, policy); err != nil {
+		return nil, err
+	}
+	return tok.Claims, nil
+}
+
+// Verify checks the signature and the policy
+func (t *Token) Verify(keyfunc Keyfunc, policy Policy) error {
+	alg := t.Alg
+	if alg == "" || alg == "none" || strings.HasPrefix(alg, "HS") {
+		return fmt.Errorf("algorithm %q is not accepted", alg)
+	}
+	if len(policy.Algorithms) > 0 && !slices.Contains(policy.Algorithms, alg) {
+		return fmt.Errorf("algorithm %q is not accepted", alg)
+	}
+
+	keys, err := keyfunc(t.Kid, alg)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no key matches kid %q", t.Kid)
+	}
+
+	verified := false
+	for _, key := range keys {
+		if verifySignature(alg, key, t.parsed.signingInput, t.parsed.signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("invalid signature")
+	}
+
+	return policy.check(t.Claims)
+}
+
+// check applies the policy to verified claims
+func (p Policy) check(claims Claims) error {
+	now := time.Now()
+	if p.Now != nil {
+		now = p.Now()
+	}
+	skew := p.ClockSkew
+	if skew <= 0 {
+		skew = DefaultClockSkew
+	}
+
+	if p.Issuer != "" && claims["iss"] != p.Issuer {
+		return errors.New("token issuer is not accepted")
+	}
+
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(exp.Add(skew)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(skew).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+
+	if p.MaxTokenAge > 0 {
+		iat, ok := numericClaim(claims, "iat")
+		if !ok {
+			return errors.New("token has no iat claim")
+		}
+		if now.Sub(iat) > p.MaxTokenAge+skew {
+			return errors.New("token is too old")
+		}
+	}
+
+	for _, name := range p.RequiredClaims {
+		if _, ok := claims[name]; !ok {
+			return fmt.Errorf("token has no %s claim", name)
+		}
+	}
+
+	if len(p.Audiences) > 0 && !audienceMatches(claims["aud"], p.Audiences) {
+		return errors.New("token audience is not accepted")
+	}
+	return nil
+}
+
+// numericClaim reads a NumericDate claim
+func numericClaim(claims Claims, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// audienceMatches reports whether the aud claim (string or array) contains an accepted audience
+func audienceMatches(aud any, accepted []string) bool {
+	var values []string
+	switch a := aud.(type) {
+	case string:
+		values = []string{a}
+	case []any:
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	for _, v := range values {
+		for _, want := range accepted {
+			if v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
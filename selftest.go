@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+)
+
+// selftestResult is the outcome of fetching one IDP
+type selftestResult struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	OK         bool   `json:"ok"`
+	KeyCount   int    `json:"key_count"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// runSelftest fetches every configured IDP once and reports the result.
+// Exit codes: 0 all IDPs ok, 1 at least one IDP failed, 2 invalid usage or configuration.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to the configuration file")
+	timeout := fs.Duration("timeout", 10*time.Second, "per-IDP fetch timeout")
+	format := fs.String("format", "text", "report format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: failed to load configuration: %v\n", err)
+		return 2
+	}
+
+	// Keep updater logs out of the report
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	results := make([]selftestResult, len(cfg.IDPs))
+	var wg sync.WaitGroup
+	for i, idp := range cfg.IDPs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = selftestIDP(idp, *timeout, logger)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+		}
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]any{
+			"ok":      failed == 0,
+			"failed":  failed,
+			"results": results,
+		})
+	default:
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "IDP\tRESULT\tKEYS\tDURATION\tERROR")
+		for _, r := range results {
+			result := "ok"
+			if !r.OK {
+				result = "FAIL"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%dms\t%s\n", r.Name, result, r.KeyCount, r.DurationMs, r.Error)
+		}
+		tw.Flush()
+		fmt.Printf("\n%d/%d IDPs ok\n", len(results)-failed, len(results))
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func selftestIDP(idp config.IDPConfig, timeout time.Duration, logger *slog.Logger) selftestResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	keySet, err := jwks.NewUpdater(idp, nil, logger).Fetch(ctx)
+
+	result := selftestResult{
+		Name:       idp.Name,
+		URL:        idp.URL,
+		OK:         err == nil,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.KeyCount = len(keySet.Keys)
+	}
+	return result
+}
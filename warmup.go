@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kiquetal/go-idp-caller/internal/config"
+	"github.com/kiquetal/go-idp-caller/internal/jwks"
+	"github.com/kiquetal/go-idp-caller/internal/storage"
+)
+
+// warmupResult is the outcome of fetching and storing one IDP
+type warmupResult struct {
+	Name     string
+	KeyCount int
+	Stored   bool // false when the directory already held newer keys
+	Error    string
+	keys     *jwks.JWKS
+}
+
+// runWarmup fetches every configured IDP once and stores the key sets in an embedded store, so a
+// deployment started on that directory serves keys before its first fetch completes.
+// Exit codes: 0 all IDPs stored, 1 at least one failed, 2 invalid usage or configuration.
+func runWarmup(args []string) int {
+	fs := flag.NewFlagSet("warmup", flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to the configuration file")
+	output := fs.String("output", "", "directory of the embedded store (default: storage.embedded.directory when configured)")
+	timeout := fs.Duration("timeout", 10*time.Second, "per-IDP fetch timeout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warmup: failed to load configuration: %v\n", err)
+		return 2
+	}
+	dir := *output
+	if dir == "" {
+		if cfg.Storage == nil || cfg.Storage.Type != config.StorageEmbedded {
+			fmt.Fprintln(os.Stderr, "warmup: -output is required unless embedded storage is configured")
+			return 2
+		}
+		dir = cfg.Storage.GetDirectory()
+	}
+
+	// Keep updater logs out of the report
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	jwks.SetFIPS(cfg.FIPS)
+
+	results := make([]warmupResult, len(cfg.IDPs))
+	var wg sync.WaitGroup
+	for i, idp := range cfg.IDPs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			results[i] = warmupResult{Name: idp.Name}
+			keySet, err := jwks.NewUpdater(idp, nil, logger).Fetch(ctx)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].keys = keySet
+			results[i].KeyCount = len(keySet.Keys)
+		}()
+	}
+	wg.Wait()
+
+	store, err := storage.Open(context.Background(), config.StorageConfig{
+		Type:     config.StorageEmbedded,
+		Embedded: &config.EmbeddedConfig{Directory: dir},
+	}, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warmup: failed to open %s: %v\n", dir, err)
+		return 1
+	}
+	defer store.Close()
+
+	writer := "warmup"
+	if host, err := os.Hostname(); err == nil {
+		writer += "@" + host
+	}
+	failed := 0
+	for i, idp := range cfg.IDPs {
+		r := &results[i]
+		if r.keys != nil {
+			now := time.Now()
+			err := store.Save(context.Background(), storage.Snapshot{
+				IDP:           idp.Name,
+				JWKS:          r.keys,
+				Version:       now.UnixNano(),
+				UpdatedAt:     now,
+				CacheDuration: idp.GetCacheDuration(),
+				Writer:        writer,
+			})
+			switch {
+			case err == nil:
+				r.Stored = true
+			case !errors.Is(err, storage.ErrConflict):
+				r.Error = fmt.Sprintf("failed to store keys: %v", err)
+			}
+		}
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IDP\tRESULT\tKEYS\tERROR")
+	for _, r := range results {
+		result := "stored"
+		switch {
+		case r.Error != "":
+			result = "FAIL"
+		case !r.Stored:
+			result = "newer kept"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", r.Name, result, r.KeyCount, r.Error)
+	}
+	tw.Flush()
+	fmt.Printf("\n%d/%d IDPs primed in %s\n", len(results)-failed, len(results), dir)
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}